@@ -0,0 +1,143 @@
+package ipfslite
+
+import (
+	"context"
+	"io"
+	"sync"
+
+	ipld "github.com/ipfs/go-ipld-format"
+)
+
+// Limits of the batches of nodes written by AddFile.
+const (
+	addBatchMaxNodes = 128
+	addBatchMaxSize  = 8 << 20
+)
+
+// addBatch buffers the nodes added by a single AddFile call and writes them
+// in batches. Batches are written in the background, using the workers
+// shared by all AddFile calls (Config.AddWorkers), so that concurrent calls
+// do not contend on a single batch while the number of concurrent writes
+// stays bounded. Nodes are only guaranteed to be stored once Commit
+// returns.
+type addBatch struct {
+	ipld.DAGService
+	workers chan struct{}
+
+	nodes []ipld.Node
+	size  int
+
+	wg  sync.WaitGroup
+	mu  sync.Mutex
+	err error
+}
+
+func newAddBatch(ds ipld.DAGService, workers chan struct{}) *addBatch {
+	return &addBatch{
+		DAGService: ds,
+		workers:    workers,
+	}
+}
+
+func (b *addBatch) Add(ctx context.Context, n ipld.Node) error {
+	return b.AddMany(ctx, []ipld.Node{n})
+}
+
+func (b *addBatch) AddMany(ctx context.Context, nds []ipld.Node) error {
+	if err := b.error(); err != nil {
+		return err
+	}
+	for _, n := range nds {
+		b.nodes = append(b.nodes, n)
+		b.size += len(n.RawData())
+	}
+	if len(b.nodes) >= addBatchMaxNodes || b.size >= addBatchMaxSize {
+		return b.flush(ctx)
+	}
+	return nil
+}
+
+// flush starts writing the buffered nodes once a worker is available.
+func (b *addBatch) flush(ctx context.Context) error {
+	if len(b.nodes) == 0 {
+		return nil
+	}
+	nodes := b.nodes
+	b.nodes = nil
+	b.size = 0
+
+	select {
+	case b.workers <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	b.wg.Add(1)
+	go func() {
+		defer func() {
+			<-b.workers
+			b.wg.Done()
+		}()
+		if err := b.DAGService.AddMany(ctx, nodes); err != nil {
+			b.mu.Lock()
+			if b.err == nil {
+				b.err = err
+			}
+			b.mu.Unlock()
+		}
+	}()
+	return nil
+}
+
+func (b *addBatch) error() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.err
+}
+
+// Commit writes the remaining nodes and waits for all the batches to be
+// written, returning the first error.
+func (b *addBatch) Commit(ctx context.Context) error {
+	err := b.flush(ctx)
+	b.wg.Wait()
+	if err != nil {
+		return err
+	}
+	return b.error()
+}
+
+// AddStream is like AddFile, for content produced incrementally (i.e. by a
+// capture device or a transcoding pipeline) and sent as byte slices on the
+// given channel, which the producer closes once the content is complete.
+// Slices are consumed as fast as their blocks are written: when the
+// datastore falls behind (see Config.AddWorkers), AddStream stops
+// receiving from the channel, so that a producer blocked on sending is
+// slowed down rather than content buffered in memory. Slices must not be
+// modified once sent. When ctx is cancelled, AddStream returns its error
+// without draining the channel.
+func (p *Peer) AddStream(ctx context.Context, data <-chan []byte, params *AddParams) (ipld.Node, error) {
+	return p.AddFile(ctx, &chanReader{ctx: ctx, data: data}, params)
+}
+
+// chanReader reads the byte slices received from a channel.
+type chanReader struct {
+	ctx  context.Context
+	data <-chan []byte
+	buf  []byte
+}
+
+func (r *chanReader) Read(b []byte) (int, error) {
+	for len(r.buf) == 0 {
+		select {
+		case buf, ok := <-r.data:
+			if !ok {
+				return 0, io.EOF
+			}
+			r.buf = buf
+		case <-r.ctx.Done():
+			return 0, r.ctx.Err()
+		}
+	}
+	n := copy(b, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
@@ -0,0 +1,150 @@
+package ipfslite
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/ipfs/boxo/ipld/merkledag"
+	ipld "github.com/ipfs/go-ipld-format"
+	"golang.org/x/sync/errgroup"
+)
+
+func TestAddFileConcurrent(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	p, err := New(ctx, NewInMemoryDatastore(), nil, nil, nil, &Config{Offline: true, AddWorkers: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	contents := make([][]byte, 8)
+	for i := range contents {
+		contents[i] = make([]byte, 1<<20)
+		if _, err := rand.Read(contents[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var g errgroup.Group
+	for _, content := range contents {
+		content := content
+		g.Go(func() error {
+			n, err := p.AddFile(ctx, bytes.NewReader(content), &AddParams{Chunker: "size-4096"})
+			if err != nil {
+				return err
+			}
+			r, err := p.GetFile(ctx, n.Cid())
+			if err != nil {
+				return err
+			}
+			defer r.Close()
+			got, err := io.ReadAll(r)
+			if err != nil {
+				return err
+			}
+			if !bytes.Equal(got, content) {
+				return errors.New("content mismatch")
+			}
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+type failingDAGService struct {
+	ipld.DAGService
+}
+
+func (failingDAGService) AddMany(context.Context, []ipld.Node) error {
+	return errors.New("write failed")
+}
+
+func TestAddBatchError(t *testing.T) {
+	ctx := context.Background()
+	b := newAddBatch(failingDAGService{}, make(chan struct{}, 1))
+	for i := 0; i < addBatchMaxNodes+1; i++ {
+		// Errors are reported by later calls or by Commit.
+		_ = b.Add(ctx, merkledag.NodeWithData([]byte{byte(i)}))
+	}
+	if err := b.Commit(ctx); err == nil {
+		t.Fatal("expected an error")
+	}
+}
+
+func TestHashOnly(t *testing.T) {
+	ctx := context.Background()
+	p, err := New(ctx, NewInMemoryDatastore(), nil, nil, nil, &Config{Offline: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	content := bytes.Repeat([]byte("hash only "), 10000)
+	params := &AddParams{Chunker: "size-4096", RawLeaves: true}
+
+	c, err := p.HashOnly(ctx, bytes.NewReader(content), params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if has, _ := p.HasBlock(ctx, c); has {
+		t.Error("HashOnly should not store blocks")
+	}
+	n, err := p.AddFile(ctx, bytes.NewReader(content), params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !n.Cid().Equals(c) {
+		t.Errorf("expected %s, got %s", n.Cid(), c)
+	}
+}
+
+func TestAddStream(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	p, err := New(ctx, NewInMemoryDatastore(), nil, nil, nil, &Config{Offline: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	content := make([]byte, 1<<20)
+	if _, err := rand.Read(content); err != nil {
+		t.Fatal(err)
+	}
+	data := make(chan []byte)
+	go func() {
+		defer close(data)
+		for rest := content; len(rest) > 0; {
+			n := 1000
+			if n > len(rest) {
+				n = len(rest)
+			}
+			data <- rest[:n]
+			rest = rest[n:]
+		}
+	}()
+	n, err := p.AddStream(ctx, data, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected, err := p.HashOnly(ctx, bytes.NewReader(content), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !n.Cid().Equals(expected) {
+		t.Error("the stream should be added like a file")
+	}
+
+	// Cancelling stops the add.
+	sctx, scancel := context.WithCancel(ctx)
+	scancel()
+	_, err = p.AddStream(sctx, make(chan []byte), nil)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected a cancellation error, got %v", err)
+	}
+}
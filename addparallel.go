@@ -0,0 +1,225 @@
+package ipfslite
+
+import (
+	"bytes"
+	"io"
+	"io/fs"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+
+	chunker "github.com/ipfs/boxo/chunker"
+	"github.com/ipfs/boxo/ipld/unixfs/importer/helpers"
+	pb "github.com/ipfs/boxo/ipld/unixfs/pb"
+	"github.com/ipfs/go-cid"
+)
+
+// maxPendingLeaves bounds how many prehashed leaves wait to be matched by
+// the DAG builder.
+const maxPendingLeaves = 4
+
+// prehashedLeaf is a chunk whose leaf node was built and hashed ahead of
+// the DAG builder.
+type prehashedLeaf struct {
+	data    []byte
+	encoded []byte
+	cid     cid.Cid
+	err     error
+}
+
+// leafQueue holds the prehashed leaves returned by a parallelSplitter, until
+// the DAG builder computes their CIDs.
+type leafQueue struct {
+	mu      sync.Mutex
+	pending []*prehashedLeaf
+}
+
+func (q *leafQueue) push(leaf *prehashedLeaf) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.pending) == maxPendingLeaves {
+		q.pending = q.pending[1:]
+	}
+	q.pending = append(q.pending, leaf)
+}
+
+// match returns the CID of the prehashed leaf with the given encoding, if
+// any. Comparing the encodings is much cheaper than hashing them.
+func (q *leafQueue) match(codec uint64, data []byte) (cid.Cid, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for i, leaf := range q.pending {
+		if leaf.cid.Type() == codec && bytes.Equal(leaf.encoded, data) {
+			q.pending = append(q.pending[:i], q.pending[i+1:]...)
+			return leaf.cid, true
+		}
+	}
+	return cid.Undef, false
+}
+
+// prehashingBuilder is a cid.Builder which returns the CIDs of prehashed
+// leaves instead of hashing them again, and hashes other nodes normally.
+type prehashingBuilder struct {
+	cid.Builder
+	q *leafQueue
+}
+
+func (b prehashingBuilder) Sum(data []byte) (cid.Cid, error) {
+	if c, ok := b.q.match(b.GetCodec(), data); ok {
+		return c, nil
+	}
+	return b.Builder.Sum(data)
+}
+
+func (b prehashingBuilder) WithCodec(codec uint64) cid.Builder {
+	return prehashingBuilder{Builder: b.Builder.WithCodec(codec), q: b.q}
+}
+
+// parallelSplitter is a fixed-size chunker.Splitter for io.ReaderAt sources.
+// Chunks are read, and their leaf nodes built and hashed, by several
+// workers ahead of the DAG builder, which otherwise hashes everything in a
+// single goroutine. Chunks are still returned in order.
+type parallelSplitter struct {
+	r       io.Reader
+	results chan chan *prehashedLeaf
+	q       *leafQueue
+	done    chan struct{}
+}
+
+type leafJob struct {
+	off    int64
+	size   int64
+	result chan *prehashedLeaf
+}
+
+func newParallelSplitter(r io.Reader, ra io.ReaderAt, start, size, chunkSize int64, leaves *helpers.DagBuilderHelper, leafType pb.Data_DataType) *parallelSplitter {
+	workers := runtime.NumCPU()
+	s := &parallelSplitter{
+		r:       r,
+		results: make(chan chan *prehashedLeaf, 4*workers),
+		q:       &leafQueue{},
+		done:    make(chan struct{}),
+	}
+
+	jobs := make(chan leafJob)
+	for i := 0; i < workers; i++ {
+		go func() {
+			for j := range jobs {
+				j.result <- buildLeaf(ra, j.off, j.size, leaves, leafType)
+			}
+		}()
+	}
+	go func() {
+		defer close(s.results)
+		defer close(jobs)
+		for off := start; off < start+size; off += chunkSize {
+			j := leafJob{
+				off:    off,
+				size:   chunkSize,
+				result: make(chan *prehashedLeaf, 1),
+			}
+			if rest := start + size - off; rest < chunkSize {
+				j.size = rest
+			}
+			select {
+			case s.results <- j.result:
+			case <-s.done:
+				return
+			}
+			select {
+			case jobs <- j:
+			case <-s.done:
+				return
+			}
+		}
+	}()
+	return s
+}
+
+func buildLeaf(ra io.ReaderAt, off, size int64, leaves *helpers.DagBuilderHelper, leafType pb.Data_DataType) *prehashedLeaf {
+	data := make([]byte, size)
+	n, err := ra.ReadAt(data, off)
+	if n == len(data) {
+		err = nil
+	}
+	if err != nil {
+		return &prehashedLeaf{err: err}
+	}
+	nd, err := leaves.NewLeafNode(data, leafType)
+	if err != nil {
+		return &prehashedLeaf{err: err}
+	}
+	return &prehashedLeaf{data: data, encoded: nd.RawData(), cid: nd.Cid()}
+}
+
+func (s *parallelSplitter) Reader() io.Reader {
+	return s.r
+}
+
+func (s *parallelSplitter) NextBytes() ([]byte, error) {
+	result, ok := <-s.results
+	if !ok {
+		return nil, io.EOF
+	}
+	leaf := <-result
+	if leaf.err != nil {
+		return nil, leaf.err
+	}
+	s.q.push(leaf)
+	return leaf.data, nil
+}
+
+// Close stops the workers.
+func (s *parallelSplitter) Close() {
+	close(s.done)
+}
+
+// readerAtSource returns the io.ReaderAt of the given reader, along with the
+// offset it is at and the size of its content, when it supports random
+// access and has a known size (i.e. files and bytes.Readers).
+func readerAtSource(r io.Reader) (ra io.ReaderAt, start, size int64, ok bool) {
+	ra, ok = r.(io.ReaderAt)
+	if !ok {
+		return nil, 0, 0, false
+	}
+	switch s := r.(type) {
+	case interface{ Size() int64 }:
+		size = s.Size()
+	case interface{ Stat() (fs.FileInfo, error) }:
+		fi, err := s.Stat()
+		if err != nil || !fi.Mode().IsRegular() {
+			return nil, 0, 0, false
+		}
+		size = fi.Size()
+	default:
+		return nil, 0, 0, false
+	}
+	if s, ok := r.(io.Seeker); ok {
+		var err error
+		start, err = s.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return nil, 0, 0, false
+		}
+	}
+	if start > size {
+		return nil, 0, 0, false
+	}
+	return ra, start, size - start, true
+}
+
+// fixedChunkSize returns the chunk size of fixed-size chunkers.
+func fixedChunkSize(chunkerStr string) (int64, bool) {
+	switch {
+	case chunkerStr == "" || chunkerStr == "default":
+		return chunker.DefaultBlockSize, true
+	case strings.HasPrefix(chunkerStr, "size-"):
+		size, err := strconv.ParseInt(strings.TrimPrefix(chunkerStr, "size-"), 10, 64)
+		if err != nil || size <= 0 || size > int64(chunker.ChunkSizeLimit) {
+			return 0, false
+		}
+		return size, true
+	default:
+		return 0, false
+	}
+}
@@ -0,0 +1,69 @@
+package ipfslite
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"io"
+	"testing"
+)
+
+// sequentialReader hides the io.ReaderAt implementation of a reader.
+type sequentialReader struct {
+	io.Reader
+}
+
+func TestAddFileReaderAt(t *testing.T) {
+	ctx := context.Background()
+	p, err := New(ctx, NewInMemoryDatastore(), nil, nil, nil, &Config{Offline: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	content := make([]byte, 1<<20+123)
+	if _, err := rand.Read(content); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, params := range []AddParams{
+		{},
+		{Chunker: "size-4096"},
+		{Chunker: "size-1000", RawLeaves: true},
+		{Chunker: "size-4096", Layout: "trickle"},
+		{Chunker: "size-4096", Layout: "trickle", RawLeaves: true},
+	} {
+		params := params
+		seq, err := p.HashOnly(ctx, sequentialReader{bytes.NewReader(content)}, &params)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Start from an offset, like a partially read file.
+		r := bytes.NewReader(append([]byte("skipped"), content...))
+		if _, err := r.Seek(7, io.SeekStart); err != nil {
+			t.Fatal(err)
+		}
+		n, err := p.AddFile(ctx, r, &params)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !n.Cid().Equals(seq) {
+			t.Errorf("%+v: expected %s, got %s", params, seq, n.Cid())
+		}
+		if r.Len() != 0 {
+			t.Error("the reader should have been read to the end")
+		}
+
+		rc, err := p.GetFile(ctx, n.Cid())
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, content) {
+			t.Errorf("%+v: content mismatch", params)
+		}
+	}
+}
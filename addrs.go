@@ -0,0 +1,180 @@
+package ipfslite
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	libp2p "github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
+)
+
+// ExternalAddrs returns a libp2p option which advertises the given
+// multiaddresses (i.e. a static public IP or a DNS name mapped by a load
+// balancer) in addition to the listen addresses. It can be passed to
+// SetupLibp2p. libp2p only supports a single address factory, so this option
+// cannot be combined with the options returned by Config.Libp2pOptions when
+// any of the announce settings are used. Use Config.AppendAnnounce then.
+func ExternalAddrs(addrs ...multiaddr.Multiaddr) libp2p.Option {
+	return libp2p.AddrsFactory(func(listenAddrs []multiaddr.Multiaddr) []multiaddr.Multiaddr {
+		return appendUniqueAddrs(listenAddrs, addrs)
+	})
+}
+
+// newAnnounceFactory returns an address factory which replaces the listen
+// addresses with the announce ones (when given), adds the appendAnnounce
+// ones and removes any address matching the noAnnounce list. noAnnounce
+// entries can be multiaddresses, multiaddress masks
+// (/ip4/10.0.0.0/ipcidr/8) or CIDRs (10.0.0.0/8).
+func newAnnounceFactory(announce, appendAnnounce, noAnnounce []string) (func([]multiaddr.Multiaddr) []multiaddr.Multiaddr, error) {
+	announceAddrs, err := parseAddrs(announce)
+	if err != nil {
+		return nil, fmt.Errorf("bad announce address: %w", err)
+	}
+	appendAddrs, err := parseAddrs(appendAnnounce)
+	if err != nil {
+		return nil, fmt.Errorf("bad append-announce address: %w", err)
+	}
+
+	filters := multiaddr.NewFilters()
+	exact := make(map[string]struct{})
+	for _, a := range noAnnounce {
+		switch {
+		case strings.Contains(a, "/ipcidr/"):
+			maddr, err := multiaddr.NewMultiaddr(a)
+			if err != nil {
+				return nil, fmt.Errorf("bad no-announce mask %q: %w", a, err)
+			}
+			ipnet, err := manet.MultiaddrToIPNet(maddr)
+			if err != nil {
+				return nil, fmt.Errorf("bad no-announce mask %q: %w", a, err)
+			}
+			filters.AddFilter(*ipnet, multiaddr.ActionDeny)
+		case strings.HasPrefix(a, "/"):
+			maddr, err := multiaddr.NewMultiaddr(a)
+			if err != nil {
+				return nil, fmt.Errorf("bad no-announce address %q: %w", a, err)
+			}
+			exact[string(maddr.Bytes())] = struct{}{}
+		default:
+			_, ipnet, err := net.ParseCIDR(a)
+			if err != nil {
+				return nil, fmt.Errorf("bad no-announce CIDR %q: %w", a, err)
+			}
+			filters.AddFilter(*ipnet, multiaddr.ActionDeny)
+		}
+	}
+
+	return func(addrs []multiaddr.Multiaddr) []multiaddr.Multiaddr {
+		if len(announceAddrs) > 0 {
+			addrs = announceAddrs
+		}
+		addrs = appendUniqueAddrs(addrs, appendAddrs)
+		out := make([]multiaddr.Multiaddr, 0, len(addrs))
+		for _, a := range addrs {
+			if _, ok := exact[string(a.Bytes())]; ok {
+				continue
+			}
+			if filters.AddrBlocked(a) {
+				continue
+			}
+			out = append(out, a)
+		}
+		return out
+	}, nil
+}
+
+func parseAddrs(addrs []string) ([]multiaddr.Multiaddr, error) {
+	maddrs := make([]multiaddr.Multiaddr, 0, len(addrs))
+	for _, a := range addrs {
+		maddr, err := multiaddr.NewMultiaddr(a)
+		if err != nil {
+			return nil, fmt.Errorf("%q: %w", a, err)
+		}
+		maddrs = append(maddrs, maddr)
+	}
+	return maddrs, nil
+}
+
+// appendUniqueAddrs returns a new slice with the addresses in extra which are
+// not already part of addrs appended to it.
+func appendUniqueAddrs(addrs, extra []multiaddr.Multiaddr) []multiaddr.Multiaddr {
+	out := make([]multiaddr.Multiaddr, 0, len(addrs)+len(extra))
+	out = append(out, addrs...)
+	for _, e := range extra {
+		if !multiaddr.Contains(out, e) {
+			out = append(out, e)
+		}
+	}
+	return out
+}
+
+// ListenAddrs returns listen multiaddresses on all IPv4 and IPv6 interfaces
+// for TCP, QUIC and WebSocket on the given ports. A port of 0 lets the system
+// pick a random port, and a negative port disables the corresponding
+// transport. Note that QUIC is not available when using a private network
+// (PSK).
+func ListenAddrs(tcpPort, quicPort, wsPort int) ([]multiaddr.Multiaddr, error) {
+	for _, p := range []int{tcpPort, quicPort, wsPort} {
+		if p > 65535 {
+			return nil, fmt.Errorf("invalid port: %d", p)
+		}
+	}
+	if tcpPort > 0 && tcpPort == wsPort {
+		return nil, fmt.Errorf("TCP and WebSocket cannot share port %d", tcpPort)
+	}
+
+	var addrs []string
+	for _, ip := range []string{"/ip4/0.0.0.0", "/ip6/::"} {
+		if tcpPort >= 0 {
+			addrs = append(addrs, fmt.Sprintf("%s/tcp/%d", ip, tcpPort))
+		}
+		if quicPort >= 0 {
+			addrs = append(addrs, fmt.Sprintf("%s/udp/%d/quic-v1", ip, quicPort))
+		}
+		if wsPort >= 0 {
+			addrs = append(addrs, fmt.Sprintf("%s/tcp/%d/ws", ip, wsPort))
+		}
+	}
+	return parseAddrs(addrs)
+}
+
+// RandomPorts returns TCP and QUIC listen multiaddresses on all IPv4 and
+// IPv6 interfaces using random ports. It is useful for tests and short-lived
+// peers.
+func RandomPorts() []multiaddr.Multiaddr {
+	addrs, _ := ListenAddrs(0, 0, -1)
+	return addrs
+}
+
+// ID returns the peer ID of the Peer's host, or an empty ID when the Peer
+// has no host (offline).
+func (p *Peer) ID() peer.ID {
+	if p.host == nil {
+		return ""
+	}
+	return p.host.ID()
+}
+
+// Addrs returns the addresses advertised by the Peer's host, that is, its
+// listen addresses as modified by the announce settings, and the external
+// addresses it has discovered.
+func (p *Peer) Addrs() []multiaddr.Multiaddr {
+	if p.host == nil {
+		return nil
+	}
+	return p.host.Addrs()
+}
+
+// P2PAddrs returns the addresses of the Peer including its peer ID (i.e.
+// /ip4/1.2.3.4/tcp/4001/p2p/12D3KooW...), which other peers can use to
+// connect to it. They can be shared as strings and parsed with
+// peer.AddrInfoFromString.
+func (p *Peer) P2PAddrs() ([]multiaddr.Multiaddr, error) {
+	if p.host == nil {
+		return nil, nil
+	}
+	return peer.AddrInfoToP2pAddrs(&peer.AddrInfo{ID: p.ID(), Addrs: p.Addrs()})
+}
@@ -0,0 +1,122 @@
+package ipfslite
+
+import (
+	"context"
+	"testing"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multiaddr"
+)
+
+func TestAnnounceFactory(t *testing.T) {
+	addrs := []multiaddr.Multiaddr{
+		multiaddr.StringCast("/ip4/127.0.0.1/tcp/4001"),
+		multiaddr.StringCast("/ip4/192.168.1.10/tcp/4001"),
+		multiaddr.StringCast("/ip4/10.1.2.3/tcp/4001"),
+		multiaddr.StringCast("/ip4/1.2.3.4/tcp/4001"),
+	}
+
+	factory, err := newAnnounceFactory(nil, nil, []string{
+		"/ip4/127.0.0.1/tcp/4001",
+		"/ip4/10.0.0.0/ipcidr/8",
+		"192.168.0.0/16",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := factory(addrs)
+	if len(out) != 1 || !out[0].Equal(addrs[3]) {
+		t.Errorf("unexpected announced addresses: %s", out)
+	}
+
+	factory, err = newAnnounceFactory([]string{"/dns4/example.com/tcp/4001"}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out = factory(addrs)
+	if len(out) != 1 || out[0].String() != "/dns4/example.com/tcp/4001" {
+		t.Errorf("unexpected announced addresses: %s", out)
+	}
+
+	factory, err = newAnnounceFactory(nil, []string{"/ip4/1.2.3.4/tcp/4001", "/dns4/example.com/tcp/443/wss"}, []string{"/ip4/10.0.0.0/ipcidr/8"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	out = factory(addrs)
+	if len(out) != 4 || out[3].String() != "/dns4/example.com/tcp/443/wss" {
+		t.Errorf("unexpected announced addresses: %s", out)
+	}
+
+	_, err = newAnnounceFactory(nil, nil, []string{"not-a-cidr"})
+	if err == nil {
+		t.Error("expected an error for a bad no-announce entry")
+	}
+}
+
+func TestListenAddrs(t *testing.T) {
+	addrs, err := ListenAddrs(4001, 4001, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		"/ip4/0.0.0.0/tcp/4001",
+		"/ip4/0.0.0.0/udp/4001/quic-v1",
+		"/ip6/::/tcp/4001",
+		"/ip6/::/udp/4001/quic-v1",
+	}
+	if len(addrs) != len(expected) {
+		t.Fatalf("expected %d addresses, got %s", len(expected), addrs)
+	}
+	for i, a := range addrs {
+		if a.String() != expected[i] {
+			t.Errorf("expected %s, got %s", expected[i], a)
+		}
+	}
+
+	if _, err := ListenAddrs(4001, -1, 4001); err == nil {
+		t.Error("expected an error when TCP and WebSocket share a port")
+	}
+	if _, err := ListenAddrs(70000, -1, -1); err == nil {
+		t.Error("expected an error for an invalid port")
+	}
+	if len(RandomPorts()) != 4 {
+		t.Error("expected 4 random port addresses")
+	}
+}
+
+func TestPeerAddrs(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	p := setupPeer(t, ctx, nil)
+
+	if p.ID() != p.host.ID() {
+		t.Error("unexpected peer ID")
+	}
+	if len(p.Addrs()) == 0 {
+		t.Fatal("no addresses")
+	}
+	addrs, err := p.P2PAddrs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(addrs) != len(p.Addrs()) {
+		t.Fatalf("expected %d addresses, got %d", len(p.Addrs()), len(addrs))
+	}
+	for _, a := range addrs {
+		ai, err := peer.AddrInfoFromString(a.String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ai.ID != p.ID() {
+			t.Errorf("unexpected peer ID in %s", a)
+		}
+	}
+
+	offline, err := New(ctx, NewInMemoryDatastore(), nil, nil, nil, &Config{Offline: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if offline.ID() != "" || offline.Addrs() != nil {
+		t.Error("offline peer should have no ID nor addresses")
+	}
+}
@@ -0,0 +1,201 @@
+package ipfslite
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+)
+
+// AdminProtocol is the libp2p protocol used to administer Peers with
+// Config.Admin set.
+const AdminProtocol protocol.ID = "/ipfs-lite/admin/1.0.0"
+
+var (
+	defaultAdminTimeout = 10 * time.Minute
+	// adminMaxMessageSize bounds the size of admin requests and
+	// responses.
+	adminMaxMessageSize int64 = 1 << 20
+)
+
+// ErrAdminDenied is returned by remote administration calls to Peers which
+// do not accept the caller as a controller.
+var ErrAdminDenied = errors.New("remote administration denied")
+
+// Remote administration operations.
+const (
+	adminOpStatus = "status"
+	adminOpGC     = "gc"
+	adminOpPin    = "pin"
+	adminOpUnpin  = "unpin"
+)
+
+// AdminConfig enables the remote administration of a Peer over libp2p
+// (see AdminProtocol), so that fleets of headless devices can be managed
+// from a central controller without exposing HTTP ports.
+type AdminConfig struct {
+	// Controllers lists the peers allowed to administer the Peer, using
+	// Peer.RemoteStatus, Peer.RemoteGC, Peer.RemotePin and
+	// Peer.RemoteUnpin. Controllers are authenticated by the libp2p
+	// secure channel: requests from other peers are denied.
+	Controllers []peer.ID
+	// Timeout bounds how long each request may take, including fetching
+	// the content to pin. Defaults to 10 minutes.
+	Timeout time.Duration
+}
+
+type adminRequest struct {
+	Op        string  `json:"op"`
+	Cid       cid.Cid `json:"cid,omitempty"`
+	Recursive bool    `json:"recursive,omitempty"`
+}
+
+type adminResponse struct {
+	Error   string         `json:"error,omitempty"`
+	Denied  bool           `json:"denied,omitempty"`
+	Status  *StatsSnapshot `json:"status,omitempty"`
+	Removed int            `json:"removed,omitempty"`
+}
+
+// adminProtocol returns the remote administration protocol of the Peer.
+func (p *Peer) adminProtocol() protocol.ID {
+	return p.protocolPrefix() + AdminProtocol
+}
+
+func (p *Peer) setupAdmin() {
+	p.adminTimeout = p.cfg.Admin.Timeout
+	if p.adminTimeout <= 0 {
+		p.adminTimeout = defaultAdminTimeout
+	}
+	p.host.SetStreamHandler(p.adminProtocol(), p.handleAdmin)
+}
+
+func (p *Peer) isController(pid peer.ID) bool {
+	for _, c := range p.cfg.Admin.Controllers {
+		if c == pid {
+			return true
+		}
+	}
+	return false
+}
+
+// handleAdmin serves a remote administration request.
+func (p *Peer) handleAdmin(s network.Stream) {
+	defer s.Close()
+	remote := s.Conn().RemotePeer()
+	s.SetDeadline(time.Now().Add(p.adminTimeout))
+
+	var resp adminResponse
+	var req adminRequest
+	if !p.isController(remote) {
+		logger.Warnf("remote administration request from %s denied", remote)
+		resp.Denied = true
+	} else if err := json.NewDecoder(io.LimitReader(s, adminMaxMessageSize)).Decode(&req); err != nil {
+		resp.Error = fmt.Sprintf("invalid request: %s", err)
+	} else {
+		logger.Infof("remote administration request from %s: %s", remote, req.Op)
+		ctx, cancel := context.WithTimeout(p.ctx, p.adminTimeout)
+		resp = p.serveAdmin(ctx, req)
+		cancel()
+	}
+	if err := json.NewEncoder(s).Encode(resp); err != nil {
+		logger.Debugf("admin response to %s: %s", remote, err)
+		s.Reset()
+	}
+}
+
+func (p *Peer) serveAdmin(ctx context.Context, req adminRequest) adminResponse {
+	var resp adminResponse
+	var err error
+	switch req.Op {
+	case adminOpStatus:
+		s := p.StatsSnapshot()
+		resp.Status = &s
+	case adminOpGC:
+		resp.Removed, err = p.GC(ctx)
+	case adminOpPin:
+		err = p.Pin(ctx, req.Cid, req.Recursive)
+	case adminOpUnpin:
+		err = p.Unpin(ctx, req.Cid, req.Recursive)
+	default:
+		err = fmt.Errorf("unknown operation: %s", req.Op)
+	}
+	if err != nil {
+		resp.Error = err.Error()
+	}
+	return resp
+}
+
+// admin sends a remote administration request to the given peer.
+func (p *Peer) admin(ctx context.Context, pid peer.ID, req adminRequest) (adminResponse, error) {
+	if p.host == nil {
+		return adminResponse{}, errStreamsOffline
+	}
+	s, err := p.host.NewStream(ctx, pid, p.adminProtocol())
+	if err != nil {
+		return adminResponse{}, err
+	}
+	defer s.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		s.SetDeadline(deadline)
+	}
+	if err := json.NewEncoder(s).Encode(req); err != nil {
+		s.Reset()
+		return adminResponse{}, err
+	}
+	if err := s.CloseWrite(); err != nil {
+		s.Reset()
+		return adminResponse{}, err
+	}
+	var resp adminResponse
+	if err := json.NewDecoder(io.LimitReader(s, adminMaxMessageSize)).Decode(&resp); err != nil {
+		return adminResponse{}, err
+	}
+	if resp.Denied {
+		return resp, ErrAdminDenied
+	}
+	if resp.Error != "" {
+		return resp, errors.New(resp.Error)
+	}
+	return resp, nil
+}
+
+// RemoteStatus returns the statistics of the given peer, which must have
+// Config.Admin set and accept the Peer as a controller.
+func (p *Peer) RemoteStatus(ctx context.Context, pid peer.ID) (StatsSnapshot, error) {
+	resp, err := p.admin(ctx, pid, adminRequest{Op: adminOpStatus})
+	if err != nil {
+		return StatsSnapshot{}, err
+	}
+	if resp.Status == nil {
+		return StatsSnapshot{}, errors.New("empty status response")
+	}
+	return *resp.Status, nil
+}
+
+// RemoteGC runs Peer.GC on the given peer, and returns the number of
+// removed blocks.
+func (p *Peer) RemoteGC(ctx context.Context, pid peer.ID) (int, error) {
+	resp, err := p.admin(ctx, pid, adminRequest{Op: adminOpGC})
+	return resp.Removed, err
+}
+
+// RemotePin pins the given CID on the given peer, which fetches it from
+// the network when needed. It returns once the content is pinned.
+func (p *Peer) RemotePin(ctx context.Context, pid peer.ID, c cid.Cid, recursive bool) error {
+	_, err := p.admin(ctx, pid, adminRequest{Op: adminOpPin, Cid: c, Recursive: recursive})
+	return err
+}
+
+// RemoteUnpin unpins the given CID on the given peer.
+func (p *Peer) RemoteUnpin(ctx context.Context, pid peer.ID, c cid.Cid, recursive bool) error {
+	_, err := p.admin(ctx, pid, adminRequest{Op: adminOpUnpin, Cid: c, Recursive: recursive})
+	return err
+}
@@ -0,0 +1,101 @@
+package ipfslite
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+func TestRemoteAdmin(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	controller := setupPeer(t, ctx, nil)
+	stranger := setupPeer(t, ctx, nil)
+	admin := &AdminConfig{Controllers: []peer.ID{controller.host.ID()}}
+	device := setupPeer(t, ctx, &Config{Admin: admin})
+	if admin.Timeout != 0 {
+		t.Error("the admin config should not be modified")
+	}
+	deviceInfo := peer.AddrInfo{ID: device.host.ID(), Addrs: device.host.Addrs()}
+	for _, p := range []*Peer{controller, stranger} {
+		if err := p.host.Connect(ctx, deviceInfo); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	status, err := controller.RemoteStatus(ctx, device.host.ID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Peer != device.host.ID() {
+		t.Errorf("unexpected status: %+v", status)
+	}
+	if _, err := stranger.RemoteStatus(ctx, device.host.ID()); !errors.Is(err, ErrAdminDenied) {
+		t.Errorf("expected ErrAdminDenied, got %v", err)
+	}
+
+	n, err := controller.AddFile(ctx, strings.NewReader("remotely pinned"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := controller.RemotePin(ctx, device.host.ID(), n.Cid(), true); err != nil {
+		t.Fatal(err)
+	}
+	if pinned, _ := device.IsPinned(ctx, n.Cid()); !pinned {
+		t.Error("the content should be pinned on the device")
+	}
+	if err := controller.RemoteUnpin(ctx, device.host.ID(), n.Cid(), true); err != nil {
+		t.Fatal(err)
+	}
+	removed, err := controller.RemoteGC(ctx, device.host.ID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if removed == 0 {
+		t.Error("the unpinned content should be removed")
+	}
+	if has, _ := device.HasBlock(ctx, n.Cid()); has {
+		t.Error("the unpinned content should not be stored anymore")
+	}
+	if err := stranger.RemotePin(ctx, device.host.ID(), n.Cid(), true); !errors.Is(err, ErrAdminDenied) {
+		t.Errorf("expected ErrAdminDenied, got %v", err)
+	}
+}
+
+func TestGC(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	p, err := New(ctx, NewInMemoryDatastore(), nil, nil, nil, &Config{Offline: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pinned, err := p.AddFile(ctx, strings.NewReader("pinned"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Pin(ctx, pinned.Cid(), true); err != nil {
+		t.Fatal(err)
+	}
+	unpinned, err := p.AddFile(ctx, strings.NewReader("unpinned"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	removed, err := p.GC(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if removed != 1 {
+		t.Errorf("unexpected number of removed blocks: %d", removed)
+	}
+	if has, _ := p.HasBlock(ctx, pinned.Cid()); !has {
+		t.Error("pinned blocks should be kept")
+	}
+	if has, _ := p.HasBlock(ctx, unpinned.Cid()); has {
+		t.Error("unpinned blocks should be removed")
+	}
+}
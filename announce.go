@@ -0,0 +1,224 @@
+package ipfslite
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+
+	"github.com/ipfs/go-cid"
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+var (
+	defaultAnnounceTopic   = "/ipfs-lite/announce/1.0.0"
+	defaultAnnounceWorkers = 4
+)
+
+// Announcement tells the peers of a swarm about new content.
+type Announcement struct {
+	Cid cid.Cid `json:"cid"`
+	// Path is an optional name for the content (i.e. the path of an
+	// added file, see AddParams.Path).
+	Path string `json:"path,omitempty"`
+	// Size is the size of the content, when known.
+	Size int64 `json:"size,omitempty"`
+}
+
+// AnnounceConfig configures an Announcer.
+type AnnounceConfig struct {
+	// Topic is the pubsub topic on which announcements are sent and
+	// received.
+	Topic string
+	// Prefetch selects the announced content which is fetched. When nil,
+	// all announced content is fetched, so the pubsub topic should only
+	// accept messages from trusted authors (see NewPubSub and
+	// AllowedAuthors).
+	Prefetch func(from peer.ID, ann Announcement) bool
+	// Pin pins the prefetched content, so that it is not garbage
+	// collected.
+	Pin bool
+	// AutoAnnounce announces every file added with AddFile.
+	AutoAnnounce bool
+	// Workers is the number of announcements that can be fetched
+	// concurrently.
+	Workers int
+}
+
+func (cfg *AnnounceConfig) setDefaults() {
+	if cfg.Topic == "" {
+		cfg.Topic = defaultAnnounceTopic
+	}
+	if cfg.Workers <= 0 {
+		cfg.Workers = defaultAnnounceWorkers
+	}
+}
+
+type receivedAnnouncement struct {
+	from peer.ID
+	ann  Announcement
+}
+
+// Announcer distributes content within an application swarm: peers
+// announce new roots over pubsub, and the peers receiving the
+// announcements prefetch the content they are interested in, so that it is
+// available locally (push-style distribution).
+type Announcer struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	cfg   *AnnounceConfig
+	peer  *Peer
+	topic *pubsub.Topic
+	sub   *pubsub.Subscription
+
+	received chan receivedAnnouncement
+	added    chan Announcement
+	// removeHook removes the ingest hook registered with
+	// AnnounceConfig.AutoAnnounce.
+	removeHook func()
+	wg         sync.WaitGroup
+}
+
+// NewAnnouncer creates an Announcer for the given Peer using the given
+// PubSub instance. It joins the configured topic and starts prefetching
+// announced content right away.
+func NewAnnouncer(ctx context.Context, p *Peer, ps *pubsub.PubSub, cfg *AnnounceConfig) (*Announcer, error) {
+	if ps == nil {
+		return nil, errors.New("pubsub is required for announcements")
+	}
+	if cfg == nil {
+		cfg = &AnnounceConfig{}
+	}
+	cfg.setDefaults()
+
+	topic, err := ps.Join(cfg.Topic)
+	if err != nil {
+		return nil, err
+	}
+	sub, err := topic.Subscribe()
+	if err != nil {
+		topic.Close()
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	a := &Announcer{
+		ctx:      ctx,
+		cancel:   cancel,
+		cfg:      cfg,
+		peer:     p,
+		topic:    topic,
+		sub:      sub,
+		received: make(chan receivedAnnouncement, cfg.Workers),
+		added:    make(chan Announcement, 64),
+	}
+
+	a.wg.Add(1)
+	go a.readLoop()
+	for i := 0; i < cfg.Workers; i++ {
+		a.wg.Add(1)
+		go a.worker()
+	}
+	if cfg.AutoAnnounce {
+		a.wg.Add(1)
+		go a.announceLoop()
+		a.removeHook = p.AddIngestHook(a.ingestHook)
+	}
+	return a, nil
+}
+
+// Announce announces the given content to the swarm.
+func (a *Announcer) Announce(ctx context.Context, ann Announcement) error {
+	data, err := json.Marshal(ann)
+	if err != nil {
+		return err
+	}
+	return a.topic.Publish(ctx, data)
+}
+
+// Close stops the Announcer and leaves the topic.
+func (a *Announcer) Close() error {
+	if a.removeHook != nil {
+		a.removeHook()
+	}
+	a.cancel()
+	a.sub.Cancel()
+	a.wg.Wait()
+	return a.topic.Close()
+}
+
+// ingestHook queues the files added locally for announcement.
+func (a *Announcer) ingestHook(_ context.Context, ev IngestEvent) {
+	if ev.Kind != IngestFileAdded || a.ctx.Err() != nil {
+		return
+	}
+	select {
+	case a.added <- Announcement{Cid: ev.Cid, Path: ev.Path, Size: ev.Size}:
+	default:
+		logger.Warnf("announcement queue full: not announcing %s", ev.Cid)
+	}
+}
+
+func (a *Announcer) announceLoop() {
+	defer a.wg.Done()
+	for {
+		select {
+		case <-a.ctx.Done():
+			return
+		case ann := <-a.added:
+			if err := a.Announce(a.ctx, ann); err != nil {
+				logger.Warnf("announcing %s: %s", ann.Cid, err)
+			}
+		}
+	}
+}
+
+func (a *Announcer) readLoop() {
+	defer a.wg.Done()
+	self := a.peer.host.ID()
+	for {
+		msg, err := a.sub.Next(a.ctx)
+		if err != nil {
+			return
+		}
+		from := msg.GetFrom()
+		if from == self {
+			continue
+		}
+		var ann Announcement
+		if err := json.Unmarshal(msg.Data, &ann); err != nil || !ann.Cid.Defined() {
+			logger.Warnf("bad announcement from %s", from)
+			continue
+		}
+		if a.cfg.Prefetch != nil && !a.cfg.Prefetch(from, ann) {
+			continue
+		}
+		select {
+		case a.received <- receivedAnnouncement{from: from, ann: ann}:
+		case <-a.ctx.Done():
+			return
+		}
+	}
+}
+
+func (a *Announcer) worker() {
+	defer a.wg.Done()
+	for {
+		select {
+		case <-a.ctx.Done():
+			return
+		case r := <-a.received:
+			// Content is most likely available from the announcer.
+			providers := []peer.AddrInfo{a.peer.host.Peerstore().PeerInfo(r.from)}
+			err := a.peer.FetchDAG(a.ctx, r.ann.Cid, WithProviders(providers), WithPriority(FetchPriorityBackground))
+			if err == nil && a.cfg.Pin {
+				err = a.peer.Pin(a.ctx, r.ann.Cid, true)
+			}
+			if err != nil {
+				logger.Errorf("prefetching %s announced by %s: %s", r.ann.Cid, r.from, err)
+			}
+		}
+	}
+}
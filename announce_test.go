@@ -0,0 +1,70 @@
+package ipfslite
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+func TestAnnouncer(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	p1, p2, closer := setupPeers(t)
+	defer closer(t)
+
+	ps1, err := NewPubSub(ctx, p1, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ps2, err := NewPubSub(ctx, p2, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a1, err := NewAnnouncer(ctx, p1, ps1, &AnnounceConfig{AutoAnnounce: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a1.Close()
+	a2, err := NewAnnouncer(ctx, p2, ps2, &AnnounceConfig{
+		Prefetch: func(from peer.ID, ann Announcement) bool {
+			return from == p1.ID() && strings.HasPrefix(ann.Path, "docs/")
+		},
+		Pin: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a2.Close()
+	time.Sleep(time.Second)
+
+	wanted, err := p1.AddFile(ctx, strings.NewReader("wanted"), &AddParams{Path: "docs/a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ignored, err := p1.AddFile(ctx, strings.NewReader("ignored"), &AddParams{Path: "other/b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		pinned, err := p2.IsPinned(ctx, wanted.Cid())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if pinned {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("announced content was not prefetched")
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	if has, _ := p2.HasBlock(ctx, ignored.Cid()); has {
+		t.Error("content rejected by the policy was prefetched")
+	}
+}
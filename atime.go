@@ -0,0 +1,123 @@
+package ipfslite
+
+import (
+	"context"
+	"encoding/binary"
+	"math/rand"
+	"time"
+
+	blockstore "github.com/ipfs/boxo/blockstore"
+	"github.com/ipfs/boxo/datastore/dshelp"
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/namespace"
+	"github.com/ipfs/go-datastore/query"
+)
+
+var accessTimePrefix = datastore.NewKey("/atime")
+
+// accessTimeBlockstore records the last time each block was read or written
+// in a datastore namespace.
+type accessTimeBlockstore struct {
+	blockstore.Blockstore
+	ds         datastore.Datastore
+	sampleRate float64
+}
+
+func newAccessTimeBlockstore(bs blockstore.Blockstore, ds datastore.Datastore, sampleRate float64) *accessTimeBlockstore {
+	if sampleRate <= 0 || sampleRate > 1 {
+		sampleRate = 1
+	}
+	return &accessTimeBlockstore{
+		Blockstore: bs,
+		ds:         namespace.Wrap(ds, accessTimePrefix),
+		sampleRate: sampleRate,
+	}
+}
+
+func (bs *accessTimeBlockstore) record(ctx context.Context, c cid.Cid) {
+	if bs.sampleRate < 1 && rand.Float64() >= bs.sampleRate {
+		return
+	}
+	buf := make([]byte, binary.MaxVarintLen64)
+	n := binary.PutVarint(buf, time.Now().UnixNano())
+	err := bs.ds.Put(ctx, dshelp.MultihashToDsKey(c.Hash()), buf[:n])
+	if err != nil {
+		logger.Warnf("error recording access time for %s: %s", c, err)
+	}
+}
+
+func (bs *accessTimeBlockstore) Get(ctx context.Context, c cid.Cid) (blocks.Block, error) {
+	blk, err := bs.Blockstore.Get(ctx, c)
+	if err == nil {
+		bs.record(ctx, c)
+	}
+	return blk, err
+}
+
+func (bs *accessTimeBlockstore) Put(ctx context.Context, blk blocks.Block) error {
+	err := bs.Blockstore.Put(ctx, blk)
+	if err == nil {
+		bs.record(ctx, blk.Cid())
+	}
+	return err
+}
+
+func (bs *accessTimeBlockstore) PutMany(ctx context.Context, blks []blocks.Block) error {
+	err := bs.Blockstore.PutMany(ctx, blks)
+	if err == nil {
+		for _, blk := range blks {
+			bs.record(ctx, blk.Cid())
+		}
+	}
+	return err
+}
+
+func (bs *accessTimeBlockstore) DeleteBlock(ctx context.Context, c cid.Cid) error {
+	err := bs.Blockstore.DeleteBlock(ctx, c)
+	if err == nil {
+		err = bs.ds.Delete(ctx, dshelp.MultihashToDsKey(c.Hash()))
+	}
+	return err
+}
+
+// ColdBlocks returns the blocks which have not been accessed in the given
+// duration, as raw CIDs. It requires Config.TrackAccessTime. Blocks which
+// have not been accessed at all since access-time tracking was enabled are
+// not returned. With sampling (Config.AccessTimeSampleRate) the recorded
+// times are approximate.
+func (p *Peer) ColdBlocks(ctx context.Context, olderThan time.Duration) ([]cid.Cid, error) {
+	if p.atime == nil {
+		return nil, nil
+	}
+
+	threshold := time.Now().Add(-olderThan).UnixNano()
+	res, err := p.atime.ds.Query(ctx, query.Query{})
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+
+	var cold []cid.Cid
+	for r := range res.Next() {
+		if r.Error != nil {
+			return nil, r.Error
+		}
+		t, n := binary.Varint(r.Value)
+		if n <= 0 {
+			logger.Warnf("bad access time entry: %s", r.Key)
+			continue
+		}
+		if t >= threshold {
+			continue
+		}
+		mh, err := dshelp.DsKeyToMultihash(datastore.RawKey(r.Key))
+		if err != nil {
+			logger.Warnf("bad access time entry: %s", r.Key)
+			continue
+		}
+		cold = append(cold, cid.NewCidV1(cid.Raw, mh))
+	}
+	return cold, nil
+}
@@ -0,0 +1,52 @@
+package ipfslite
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ipfs/boxo/ipld/merkledag"
+	ipld "github.com/ipfs/go-ipld-format"
+)
+
+func TestColdBlocks(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	p, err := New(ctx, NewInMemoryDatastore(), nil, nil, nil, &Config{
+		Offline:         true,
+		TrackAccessTime: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cold := merkledag.NewRawNode([]byte("cold"))
+	hot := merkledag.NewRawNode([]byte("hot"))
+	if err := p.AddMany(ctx, []ipld.Node{cold, hot}); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if _, err := p.Get(ctx, hot.Cid()); err != nil {
+		t.Fatal(err)
+	}
+
+	blocks, err := p.ColdBlocks(ctx, 50*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(blocks) != 1 || !blocks[0].Equals(cold.Cid()) {
+		t.Errorf("unexpected cold blocks: %v", blocks)
+	}
+
+	if err := p.Remove(ctx, cold.Cid()); err != nil {
+		t.Fatal(err)
+	}
+	blocks, err = p.ColdBlocks(ctx, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(blocks) != 1 || !blocks[0].Equals(hot.Cid()) {
+		t.Errorf("unexpected cold blocks after removal: %v", blocks)
+	}
+}
@@ -0,0 +1,171 @@
+package ipfslite
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Scopes granted by tokens to HTTP clients. ScopeAdmin includes ScopeRead.
+const (
+	// ScopeRead allows retrieving content (i.e. gateway requests).
+	ScopeRead = "read"
+	// ScopeAdmin allows modifying the node (adding, pinning...).
+	ScopeAdmin = "admin"
+)
+
+var (
+	errNoToken      = errors.New("missing bearer token")
+	errInvalidToken = errors.New("invalid token")
+	errExpiredToken = errors.New("token expired")
+)
+
+// AuthConfig configures the bearer token authentication of HTTP handlers
+// (see RequireScope).
+type AuthConfig struct {
+	// Tokens maps static bearer tokens to the scopes they grant.
+	Tokens map[string][]string
+	// JWTSecret, when set, makes HS256 JSON Web Tokens signed with it
+	// valid bearer tokens. Their "scope" claim lists the granted scopes,
+	// separated by spaces, and their "exp" and "nbf" claims are honored.
+	// See NewJWT.
+	JWTSecret []byte
+}
+
+// RequireScope wraps an HTTP handler so that it can only be used by
+// clients sending an "Authorization: Bearer <token>" header with a token
+// granting the given scope. Other requests get a 401 (no or invalid token)
+// or a 403 (insufficient scope) response. Handlers for different routes
+// can be wrapped with different scopes, i.e. ScopeRead for the gateway and
+// ScopeAdmin for endpoints which add or pin content.
+func RequireScope(cfg *AuthConfig, scope string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		scopes, err := cfg.authenticate(r)
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		if !hasScope(scopes, scope) {
+			w.Header().Set("WWW-Authenticate", `Bearer error="insufficient_scope", scope="`+scope+`"`)
+			http.Error(w, "insufficient scope", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func hasScope(scopes []string, scope string) bool {
+	for _, s := range scopes {
+		if s == scope || s == ScopeAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+// authenticate returns the scopes granted to the request.
+func (cfg *AuthConfig) authenticate(r *http.Request) ([]string, error) {
+	auth := r.Header.Get("Authorization")
+	token, ok := strings.CutPrefix(auth, "Bearer ")
+	if !ok || token == "" {
+		return nil, errNoToken
+	}
+
+	for t, scopes := range cfg.Tokens {
+		if subtle.ConstantTimeCompare([]byte(t), []byte(token)) == 1 {
+			return scopes, nil
+		}
+	}
+	if len(cfg.JWTSecret) > 0 && strings.Count(token, ".") == 2 {
+		return verifyJWT(cfg.JWTSecret, token, time.Now())
+	}
+	return nil, errInvalidToken
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Typ string `json:"typ,omitempty"`
+}
+
+type jwtClaims struct {
+	Scope     string `json:"scope"`
+	Subject   string `json:"sub,omitempty"`
+	ExpiresAt int64  `json:"exp,omitempty"`
+	NotBefore int64  `json:"nbf,omitempty"`
+	IssuedAt  int64  `json:"iat,omitempty"`
+}
+
+var jwtEncoding = base64.RawURLEncoding
+
+func signJWT(secret []byte, signingInput string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(signingInput))
+	return jwtEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// NewJWT issues an HS256 JSON Web Token for the given subject, granting the
+// given scopes, which is accepted by handlers wrapped with RequireScope
+// using the same secret. A zero ttl issues a token that does not expire.
+func NewJWT(secret []byte, subject string, scopes []string, ttl time.Duration) (string, error) {
+	header, err := json.Marshal(jwtHeader{Alg: "HS256", Typ: "JWT"})
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+	claims := jwtClaims{
+		Scope:    strings.Join(scopes, " "),
+		Subject:  subject,
+		IssuedAt: now.Unix(),
+	}
+	if ttl != 0 {
+		claims.ExpiresAt = now.Add(ttl).Unix()
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	signingInput := jwtEncoding.EncodeToString(header) + "." + jwtEncoding.EncodeToString(payload)
+	return signingInput + "." + signJWT(secret, signingInput), nil
+}
+
+func verifyJWT(secret []byte, token string, now time.Time) ([]string, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errInvalidToken
+	}
+	signingInput := parts[0] + "." + parts[1]
+	if !hmac.Equal([]byte(signJWT(secret, signingInput)), []byte(parts[2])) {
+		return nil, errInvalidToken
+	}
+
+	var header jwtHeader
+	if err := decodeJWTPart(parts[0], &header); err != nil || header.Alg != "HS256" {
+		return nil, errInvalidToken
+	}
+	var claims jwtClaims
+	if err := decodeJWTPart(parts[1], &claims); err != nil {
+		return nil, errInvalidToken
+	}
+	if claims.ExpiresAt != 0 && now.Unix() >= claims.ExpiresAt {
+		return nil, errExpiredToken
+	}
+	if claims.NotBefore != 0 && now.Unix() < claims.NotBefore {
+		return nil, errInvalidToken
+	}
+	return strings.Fields(claims.Scope), nil
+}
+
+func decodeJWTPart(part string, v interface{}) error {
+	data, err := jwtEncoding.DecodeString(part)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
@@ -0,0 +1,91 @@
+package ipfslite
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRequireScope(t *testing.T) {
+	secret := []byte("secret")
+	cfg := &AuthConfig{
+		Tokens:    map[string][]string{"reader": {ScopeRead}, "admin": {ScopeAdmin}},
+		JWTSecret: secret,
+	}
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	read := RequireScope(cfg, ScopeRead, ok)
+	admin := RequireScope(cfg, ScopeAdmin, ok)
+
+	jwtRead, err := NewJWT(secret, "app", []string{ScopeRead}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwtExpired, err := NewJWT(secret, "app", []string{ScopeAdmin}, -time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwtOther, err := NewJWT([]byte("other"), "app", []string{ScopeAdmin}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		h     http.Handler
+		token string
+		code  int
+	}{
+		{read, "", http.StatusUnauthorized},
+		{read, "bad", http.StatusUnauthorized},
+		{read, "reader", http.StatusOK},
+		{admin, "reader", http.StatusForbidden},
+		{read, "admin", http.StatusOK},
+		{admin, "admin", http.StatusOK},
+		{read, jwtRead, http.StatusOK},
+		{admin, jwtRead, http.StatusForbidden},
+		{read, jwtOther, http.StatusUnauthorized},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		if tc.token != "" {
+			req.Header.Set("Authorization", "Bearer "+tc.token)
+		}
+		rec := httptest.NewRecorder()
+		tc.h.ServeHTTP(rec, req)
+		if rec.Code != tc.code {
+			t.Errorf("token %q: expected %d, got %d", tc.token, tc.code, rec.Code)
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Authorization", "Bearer "+jwtExpired)
+	rec := httptest.NewRecorder()
+	read.ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("expired token accepted: %d", rec.Code)
+	}
+}
+
+func TestGatewayAuth(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	p, c := setupGatewayPeer(t, ctx, []byte("secret content"))
+	h, err := p.Gateway(&GatewayConfig{Auth: &AuthConfig{Tokens: map[string][]string{"t": {ScopeRead}}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rec := gatewayGet(t, h, "localhost", "/ipfs/"+c.String())
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("expected 401, got %d", rec.Code)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/ipfs/"+c.String(), nil)
+	req.Header.Set("Authorization", "Bearer t")
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Errorf("expected 200, got %d", rec.Code)
+	}
+}
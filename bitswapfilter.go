@@ -0,0 +1,149 @@
+package ipfslite
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	bsnet "github.com/ipfs/boxo/bitswap/network"
+	"github.com/libp2p/go-libp2p/core/event"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+)
+
+// BitswapPeerFilter decides whether bitswap exchanges wants with a
+// connected peer (see Config.BitswapPeerFilter).
+type BitswapPeerFilter func(h host.Host, p peer.ID) bool
+
+// AllowPeers returns a BitswapPeerFilter accepting the given peers only.
+func AllowPeers(peers ...peer.ID) BitswapPeerFilter {
+	allowed := make(map[peer.ID]struct{}, len(peers))
+	for _, p := range peers {
+		allowed[p] = struct{}{}
+	}
+	return func(_ host.Host, p peer.ID) bool {
+		_, ok := allowed[p]
+		return ok
+	}
+}
+
+// AllowProtocolPrefix returns a BitswapPeerFilter accepting the peers which
+// support a protocol starting with the given prefix, i.e. a protocol
+// registered by all the peers of a private swarm. Peers are accepted once
+// the libp2p identify exchange has told their protocols.
+func AllowProtocolPrefix(prefix protocol.ID) BitswapPeerFilter {
+	return func(h host.Host, p peer.ID) bool {
+		protos, err := h.Peerstore().GetProtocols(p)
+		if err != nil {
+			return false
+		}
+		for _, proto := range protos {
+			if strings.HasPrefix(string(proto), string(prefix)) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// filteredNetwork is a bitswap network which hides the connected peers
+// rejected by a filter from bitswap. As bitswap only sends wants to the
+// peers it knows as connected, rejected peers are neither sent broadcast
+// wants, nor wants for the blocks they provide. They can still request
+// blocks.
+type filteredNetwork struct {
+	bsnet.BitSwapNetwork
+	host   host.Host
+	filter BitswapPeerFilter
+
+	mu         sync.Mutex
+	receivers  []bsnet.Receiver
+	suppressed map[peer.ID]struct{}
+}
+
+func newFilteredNetwork(n bsnet.BitSwapNetwork, h host.Host, filter BitswapPeerFilter) *filteredNetwork {
+	return &filteredNetwork{
+		BitSwapNetwork: n,
+		host:           h,
+		filter:         filter,
+		suppressed:     make(map[peer.ID]struct{}),
+	}
+}
+
+func (n *filteredNetwork) Start(receivers ...bsnet.Receiver) {
+	n.mu.Lock()
+	n.receivers = receivers
+	n.mu.Unlock()
+
+	wrapped := make([]bsnet.Receiver, len(receivers))
+	for i, r := range receivers {
+		wrapped[i] = &filteredReceiver{Receiver: r, net: n}
+	}
+	n.BitSwapNetwork.Start(wrapped...)
+}
+
+// watchIdentify re-evaluates the filter for rejected peers once their
+// protocols are known.
+func (n *filteredNetwork) watchIdentify(ctx context.Context) error {
+	sub, err := n.host.EventBus().Subscribe(new(event.EvtPeerIdentificationCompleted))
+	if err != nil {
+		return err
+	}
+	go func() {
+		defer sub.Close()
+		for {
+			select {
+			case e, ok := <-sub.Out():
+				if !ok {
+					return
+				}
+				n.recheck(e.(event.EvtPeerIdentificationCompleted).Peer)
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+func (n *filteredNetwork) recheck(p peer.ID) {
+	n.mu.Lock()
+	_, ok := n.suppressed[p]
+	if !ok || !n.filter(n.host, p) {
+		n.mu.Unlock()
+		return
+	}
+	delete(n.suppressed, p)
+	receivers := n.receivers
+	n.mu.Unlock()
+
+	for _, r := range receivers {
+		r.PeerConnected(p)
+	}
+}
+
+type filteredReceiver struct {
+	bsnet.Receiver
+	net *filteredNetwork
+}
+
+func (r *filteredReceiver) PeerConnected(p peer.ID) {
+	if !r.net.filter(r.net.host, p) {
+		r.net.mu.Lock()
+		r.net.suppressed[p] = struct{}{}
+		r.net.mu.Unlock()
+		return
+	}
+	r.Receiver.PeerConnected(p)
+}
+
+func (r *filteredReceiver) PeerDisconnected(p peer.ID) {
+	r.net.mu.Lock()
+	_, suppressed := r.net.suppressed[p]
+	delete(r.net.suppressed, p)
+	r.net.mu.Unlock()
+	if !suppressed {
+		r.Receiver.PeerDisconnected(p)
+	}
+}
@@ -0,0 +1,69 @@
+package ipfslite
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ipfs/boxo/ipld/merkledag"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+func TestBitswapPeerFilter(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	allowed := setupPeer(t, ctx, nil)
+	other := setupPeer(t, ctx, nil)
+	p := setupPeer(t, ctx, &Config{BitswapPeerFilter: AllowPeers(allowed.ID())})
+
+	for _, q := range []*Peer{allowed, other} {
+		err := p.host.Connect(ctx, peer.AddrInfo{ID: q.ID(), Addrs: q.Addrs()})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	fromAllowed := merkledag.NodeWithData([]byte("allowed"))
+	fromOther := merkledag.NodeWithData([]byte("other"))
+	local := merkledag.NodeWithData([]byte("local"))
+	for _, a := range []struct {
+		p *Peer
+		n *merkledag.ProtoNode
+	}{{allowed, fromAllowed}, {other, fromOther}, {p, local}} {
+		if err := a.p.Add(ctx, a.n); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := p.Fetch(ctx, fromAllowed.Cid(), WithTimeout(5*time.Second)); err != nil {
+		t.Fatalf("fetching from the allowed peer: %s", err)
+	}
+	if _, err := p.Fetch(ctx, fromOther.Cid(), WithTimeout(time.Second)); err == nil {
+		t.Error("wants should not be sent to other peers")
+	}
+	// Other peers are still served.
+	if _, err := other.Fetch(ctx, local.Cid(), WithTimeout(5*time.Second)); err != nil {
+		t.Errorf("other peers should be served: %s", err)
+	}
+}
+
+func TestAllowProtocolPrefix(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	p1 := setupPeer(t, ctx, nil)
+	p2 := setupPeer(t, ctx, nil)
+	p2.host.SetStreamHandler("/myswarm/1.0.0", func(s network.Stream) { s.Close() })
+
+	err := p1.host.Connect(ctx, peer.AddrInfo{ID: p2.ID(), Addrs: p2.Addrs()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	filter := AllowProtocolPrefix("/myswarm/")
+	if !filter(p1.host, p2.ID()) {
+		t.Error("peer with the protocol should be allowed")
+	}
+	if AllowProtocolPrefix("/otherswarm/")(p1.host, p2.ID()) {
+		t.Error("peer without the protocol should not be allowed")
+	}
+}
@@ -0,0 +1,231 @@
+package ipfslite
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	blockstore "github.com/ipfs/boxo/blockstore"
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	ipld "github.com/ipfs/go-ipld-format"
+)
+
+// blockstoreLatencyBuckets are the upper bounds of the latency histogram
+// buckets of the blockstore operations.
+var blockstoreLatencyBuckets = [...]time.Duration{
+	100 * time.Microsecond,
+	time.Millisecond,
+	10 * time.Millisecond,
+	100 * time.Millisecond,
+	time.Second,
+}
+
+// BlockstoreLatencyBuckets returns the upper bounds of the latency
+// histogram buckets in BlockstoreOpStats.
+func BlockstoreLatencyBuckets() []time.Duration {
+	return append([]time.Duration(nil), blockstoreLatencyBuckets[:]...)
+}
+
+// BlockstoreOpStats are the statistics of one kind of blockstore operation.
+type BlockstoreOpStats struct {
+	Count uint64
+	// Errors counts the failed operations. Blocks which are not found
+	// are not errors.
+	Errors uint64
+	// Rate is the average number of operations per second since the
+	// metrics started.
+	Rate float64
+	// MeanLatency is the average duration of the operations.
+	MeanLatency time.Duration
+	// Histogram counts the operations by duration: Histogram[i] counts
+	// those shorter than BlockstoreLatencyBuckets()[i] (and longer than
+	// the previous bound), and the last entry the longer ones.
+	Histogram []uint64
+}
+
+// BlockstoreMetrics are the statistics of the blockstore of a Peer, when
+// Config.BlockstoreMetrics is set.
+type BlockstoreMetrics struct {
+	// Since is the time the metrics started, when the Peer was created.
+	Since time.Time
+	Get   BlockstoreOpStats
+	// Put counts the calls to Put and to PutMany.
+	Put     BlockstoreOpStats
+	Has     BlockstoreOpStats
+	GetSize BlockstoreOpStats
+	Delete  BlockstoreOpStats
+	// CacheHits counts the Get and Has calls answered by the blockstore
+	// cache (see Config.UncachedBlockstore) without reaching the
+	// datastore, and CacheMisses the others.
+	CacheHits   uint64
+	CacheMisses uint64
+}
+
+// CacheHitRatio returns the ratio of the Get and Has calls answered by the
+// blockstore cache, or zero when there were none.
+func (m BlockstoreMetrics) CacheHitRatio() float64 {
+	total := m.CacheHits + m.CacheMisses
+	if total == 0 {
+		return 0
+	}
+	return float64(m.CacheHits) / float64(total)
+}
+
+type opMetrics struct {
+	count     atomic.Uint64
+	errors    atomic.Uint64
+	latency   atomic.Int64
+	histogram [len(blockstoreLatencyBuckets) + 1]atomic.Uint64
+}
+
+func (m *opMetrics) observe(start time.Time, err error) {
+	d := time.Since(start)
+	m.count.Add(1)
+	if err != nil && !ipld.IsNotFound(err) {
+		m.errors.Add(1)
+	}
+	m.latency.Add(int64(d))
+	i := 0
+	for i < len(blockstoreLatencyBuckets) && d >= blockstoreLatencyBuckets[i] {
+		i++
+	}
+	m.histogram[i].Add(1)
+}
+
+func (m *opMetrics) stats(elapsed time.Duration) BlockstoreOpStats {
+	s := BlockstoreOpStats{
+		Count:     m.count.Load(),
+		Errors:    m.errors.Load(),
+		Histogram: make([]uint64, len(m.histogram)),
+	}
+	for i := range m.histogram {
+		s.Histogram[i] = m.histogram[i].Load()
+	}
+	if s.Count > 0 {
+		s.MeanLatency = time.Duration(m.latency.Load() / int64(s.Count))
+	}
+	if elapsed > 0 {
+		s.Rate = float64(s.Count) / elapsed.Seconds()
+	}
+	return s
+}
+
+// blockstoreMetrics collects the metrics of a metricsBlockstore, and of the
+// uncachedMetricsBlockstore below its cache.
+type blockstoreMetrics struct {
+	since                     time.Time
+	get, put, has, size, del  opMetrics
+	uncachedGets, uncachedHas atomic.Uint64
+}
+
+func newBlockstoreMetrics() *blockstoreMetrics {
+	return &blockstoreMetrics{since: time.Now()}
+}
+
+func (m *blockstoreMetrics) snapshot() BlockstoreMetrics {
+	elapsed := time.Since(m.since)
+	s := BlockstoreMetrics{
+		Since:   m.since,
+		Get:     m.get.stats(elapsed),
+		Put:     m.put.stats(elapsed),
+		Has:     m.has.stats(elapsed),
+		GetSize: m.size.stats(elapsed),
+		Delete:  m.del.stats(elapsed),
+	}
+	lookups := s.Get.Count + s.Has.Count
+	s.CacheMisses = m.uncachedGets.Load() + m.uncachedHas.Load()
+	if s.CacheMisses > lookups {
+		s.CacheMisses = lookups
+	}
+	s.CacheHits = lookups - s.CacheMisses
+	return s
+}
+
+// metricsBlockstore measures the operations of a blockstore.
+type metricsBlockstore struct {
+	blockstore.Blockstore
+	m *blockstoreMetrics
+}
+
+func (bs *metricsBlockstore) DeleteBlock(ctx context.Context, c cid.Cid) error {
+	start := time.Now()
+	err := bs.Blockstore.DeleteBlock(ctx, c)
+	bs.m.del.observe(start, err)
+	return err
+}
+
+func (bs *metricsBlockstore) Has(ctx context.Context, c cid.Cid) (bool, error) {
+	start := time.Now()
+	has, err := bs.Blockstore.Has(ctx, c)
+	bs.m.has.observe(start, err)
+	return has, err
+}
+
+func (bs *metricsBlockstore) Get(ctx context.Context, c cid.Cid) (blocks.Block, error) {
+	start := time.Now()
+	blk, err := bs.Blockstore.Get(ctx, c)
+	bs.m.get.observe(start, err)
+	return blk, err
+}
+
+// View is measured as a Get.
+func (bs *metricsBlockstore) View(ctx context.Context, c cid.Cid, f func([]byte) error) error {
+	start := time.Now()
+	err := viewBlock(ctx, bs.Blockstore, c, f)
+	bs.m.get.observe(start, err)
+	return err
+}
+
+func (bs *metricsBlockstore) GetSize(ctx context.Context, c cid.Cid) (int, error) {
+	start := time.Now()
+	size, err := bs.Blockstore.GetSize(ctx, c)
+	bs.m.size.observe(start, err)
+	return size, err
+}
+
+func (bs *metricsBlockstore) Put(ctx context.Context, blk blocks.Block) error {
+	start := time.Now()
+	err := bs.Blockstore.Put(ctx, blk)
+	bs.m.put.observe(start, err)
+	return err
+}
+
+func (bs *metricsBlockstore) PutMany(ctx context.Context, blks []blocks.Block) error {
+	start := time.Now()
+	err := bs.Blockstore.PutMany(ctx, blks)
+	bs.m.put.observe(start, err)
+	return err
+}
+
+// uncachedMetricsBlockstore counts the lookups which reach the blockstore
+// below the cache.
+type uncachedMetricsBlockstore struct {
+	blockstore.Blockstore
+	m *blockstoreMetrics
+}
+
+func (bs *uncachedMetricsBlockstore) Has(ctx context.Context, c cid.Cid) (bool, error) {
+	bs.m.uncachedHas.Add(1)
+	return bs.Blockstore.Has(ctx, c)
+}
+
+func (bs *uncachedMetricsBlockstore) Get(ctx context.Context, c cid.Cid) (blocks.Block, error) {
+	bs.m.uncachedGets.Add(1)
+	return bs.Blockstore.Get(ctx, c)
+}
+
+func (bs *uncachedMetricsBlockstore) View(ctx context.Context, c cid.Cid, f func([]byte) error) error {
+	bs.m.uncachedGets.Add(1)
+	return viewBlock(ctx, bs.Blockstore, c, f)
+}
+
+// BlockstoreMetrics returns the statistics of the operations on the
+// blockstore of the Peer. It returns false unless Config.BlockstoreMetrics
+// is set.
+func (p *Peer) BlockstoreMetrics() (BlockstoreMetrics, bool) {
+	if p.bsMetrics == nil {
+		return BlockstoreMetrics{}, false
+	}
+	return p.bsMetrics.snapshot(), true
+}
@@ -0,0 +1,106 @@
+package ipfslite
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	blockstore "github.com/ipfs/boxo/blockstore"
+	blocks "github.com/ipfs/go-block-format"
+)
+
+func TestBlockstoreMetrics(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	p, err := New(ctx, NewInMemoryDatastore(), nil, nil, nil, &Config{Offline: true, BlockstoreMetrics: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	blk := blocks.NewBlock([]byte("metrics"))
+	if err := p.bstore.Put(ctx, blk); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if _, err := p.bstore.Get(ctx, blk.Cid()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 3; i++ {
+		if has, err := p.bstore.Has(ctx, blk.Cid()); err != nil || !has {
+			t.Fatal("the block should be found", err)
+		}
+	}
+	missing := blocks.NewBlock([]byte("missing"))
+	if _, err := p.bstore.Get(ctx, missing.Cid()); err == nil {
+		t.Fatal("expected a missing block")
+	}
+	if err := p.bstore.DeleteBlock(ctx, blk.Cid()); err != nil {
+		t.Fatal(err)
+	}
+
+	m, ok := p.BlockstoreMetrics()
+	if !ok {
+		t.Fatal("metrics should be enabled")
+	}
+	if m.Put.Count != 1 || m.Get.Count != 4 || m.Delete.Count != 1 {
+		t.Errorf("unexpected counts: %+v", m)
+	}
+	if m.Get.Errors != 0 {
+		t.Errorf("missing blocks should not be errors: %+v", m.Get)
+	}
+	if len(m.Get.Histogram) != len(BlockstoreLatencyBuckets())+1 {
+		t.Fatalf("unexpected histogram: %v", m.Get.Histogram)
+	}
+	var total uint64
+	for _, n := range m.Get.Histogram {
+		total += n
+	}
+	if total != m.Get.Count || m.Get.Rate <= 0 || m.Get.MeanLatency <= 0 {
+		t.Errorf("unexpected stats: %+v", m.Get)
+	}
+	// The existence of the block is cached when it is written.
+	if m.Has.Count != 3 || m.CacheHits < 3 || m.CacheHits+m.CacheMisses != 7 {
+		t.Errorf("unexpected cache stats: %d hits, %d misses", m.CacheHits, m.CacheMisses)
+	}
+	if m.Since.After(time.Now()) {
+		t.Error("invalid start time")
+	}
+
+	p2, err := New(ctx, NewInMemoryDatastore(), nil, nil, nil, &Config{Offline: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := p2.BlockstoreMetrics(); ok {
+		t.Error("metrics should only be enabled with Config.BlockstoreMetrics")
+	}
+}
+
+func TestBlockstoreMetricsView(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ds := NewInMemoryDatastore()
+	bs := &viewerBlockstore{Blockstore: blockstore.NewBlockstore(ds)}
+	p, err := New(ctx, ds, bs, nil, nil, &Config{Offline: true, BlockstoreMetrics: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err := p.AddFile(ctx, strings.NewReader("measured"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bs.gets = 0
+	data, err := p.GetBlockInto(ctx, n.Cid(), nil)
+	if err != nil || !bytes.Equal(data, n.RawData()) {
+		t.Fatalf("unexpected block data: %v", err)
+	}
+	if bs.views != 1 || bs.gets != 0 {
+		t.Errorf("the block should be viewed: %d views, %d gets", bs.views, bs.gets)
+	}
+	m, _ := p.BlockstoreMetrics()
+	if m.Get.Count != 1 {
+		t.Errorf("the view should be measured as a get: %+v", m.Get)
+	}
+}
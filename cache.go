@@ -0,0 +1,346 @@
+package ipfslite
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+
+	"github.com/ipfs/boxo/blockservice"
+	blockstore "github.com/ipfs/boxo/blockstore"
+	offline "github.com/ipfs/boxo/exchange/offline"
+	"github.com/ipfs/boxo/ipld/merkledag"
+	pin "github.com/ipfs/boxo/pinning/pinner"
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	ipld "github.com/ipfs/go-ipld-format"
+)
+
+var defaultCacheGCInterval = time.Minute
+
+// lruBlockstore tracks which blocks in the wrapped blockstore are used least
+// recently, along with the total size of the blocks, so that they can be
+// evicted when running as a bounded content cache.
+type lruBlockstore struct {
+	blockstore.Blockstore
+
+	mu    sync.Mutex
+	ll    *list.List // front is most recently used
+	items map[string]*list.Element
+	size  int64
+}
+
+type lruEntry struct {
+	c    cid.Cid
+	size int
+}
+
+func newLRUBlockstore(bs blockstore.Blockstore) *lruBlockstore {
+	return &lruBlockstore{
+		Blockstore: bs,
+		ll:         list.New(),
+		items:      make(map[string]*list.Element),
+	}
+}
+
+// load adds all the blocks in the blockstore to the tracker. Blocks are
+// added as least recently used, without affecting the ones already tracked.
+func (bs *lruBlockstore) load(ctx context.Context) error {
+	keys, err := bs.Blockstore.AllKeysChan(ctx)
+	if err != nil {
+		return err
+	}
+	for c := range keys {
+		size, err := bs.Blockstore.GetSize(ctx, c)
+		if err != nil {
+			continue
+		}
+		bs.mu.Lock()
+		if _, ok := bs.items[string(c.Hash())]; !ok {
+			bs.items[string(c.Hash())] = bs.ll.PushBack(&lruEntry{c: c, size: size})
+			bs.size += int64(size)
+		}
+		bs.mu.Unlock()
+	}
+	return ctx.Err()
+}
+
+func (bs *lruBlockstore) touch(c cid.Cid, size int) {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+
+	k := string(c.Hash())
+	if e, ok := bs.items[k]; ok {
+		bs.ll.MoveToFront(e)
+		return
+	}
+	bs.items[k] = bs.ll.PushFront(&lruEntry{c: c, size: size})
+	bs.size += int64(size)
+}
+
+func (bs *lruBlockstore) forget(c cid.Cid) {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+
+	k := string(c.Hash())
+	if e, ok := bs.items[k]; ok {
+		bs.size -= int64(e.Value.(*lruEntry).size)
+		bs.ll.Remove(e)
+		delete(bs.items, k)
+	}
+}
+
+// Size returns the total size of the tracked blocks.
+func (bs *lruBlockstore) Size() int64 {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+	return bs.size
+}
+
+func (bs *lruBlockstore) Get(ctx context.Context, c cid.Cid) (blocks.Block, error) {
+	blk, err := bs.Blockstore.Get(ctx, c)
+	if err == nil {
+		bs.touch(c, len(blk.RawData()))
+	}
+	return blk, err
+}
+
+func (bs *lruBlockstore) Put(ctx context.Context, blk blocks.Block) error {
+	err := bs.Blockstore.Put(ctx, blk)
+	if err == nil {
+		bs.touch(blk.Cid(), len(blk.RawData()))
+	}
+	return err
+}
+
+func (bs *lruBlockstore) PutMany(ctx context.Context, blks []blocks.Block) error {
+	err := bs.Blockstore.PutMany(ctx, blks)
+	if err == nil {
+		for _, blk := range blks {
+			bs.touch(blk.Cid(), len(blk.RawData()))
+		}
+	}
+	return err
+}
+
+func (bs *lruBlockstore) DeleteBlock(ctx context.Context, c cid.Cid) error {
+	err := bs.Blockstore.DeleteBlock(ctx, c)
+	if err == nil {
+		bs.forget(c)
+	}
+	return err
+}
+
+// evict deletes least recently used blocks which are not in the keep set
+// (indexed by multihash) until the total size is below budget. It returns
+// the number of deleted blocks.
+func (bs *lruBlockstore) evict(ctx context.Context, budget int64, keep map[string]struct{}) (int, error) {
+	bs.mu.Lock()
+	var candidates []cid.Cid
+	excess := bs.size - budget
+	for e := bs.ll.Back(); e != nil && excess > 0; e = e.Prev() {
+		entry := e.Value.(*lruEntry)
+		if _, ok := keep[string(entry.c.Hash())]; ok {
+			continue
+		}
+		candidates = append(candidates, entry.c)
+		excess -= int64(entry.size)
+	}
+	bs.mu.Unlock()
+
+	evicted := 0
+	for _, c := range candidates {
+		if err := bs.DeleteBlock(ctx, c); err != nil {
+			return evicted, err
+		}
+		evicted++
+	}
+	return evicted, nil
+}
+
+// pinnedRoots returns the pinned CIDs, with whether they are pinned
+// recursively. Internal pins are recursive.
+func (p *Peer) pinnedRoots(ctx context.Context) (map[cid.Cid]bool, error) {
+	roots := make(map[cid.Cid]bool)
+	for sc := range p.pinner.DirectKeys(ctx) {
+		if sc.Err != nil {
+			return nil, sc.Err
+		}
+		roots[sc.C] = false
+	}
+	for _, pins := range []<-chan pin.StreamedCid{p.pinner.RecursiveKeys(ctx), p.pinner.InternalPins(ctx)} {
+		for sc := range pins {
+			if sc.Err != nil {
+				return nil, sc.Err
+			}
+			roots[sc.C] = true
+		}
+	}
+	return roots, ctx.Err()
+}
+
+// pinnedSet returns the multihashes of all the blocks which are pinned,
+// directly, recursively or indirectly. Only blocks available locally are
+// considered.
+func (p *Peer) pinnedSet(ctx context.Context) (map[string]struct{}, error) {
+	roots, err := p.pinnedRoots(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return p.walkPinned(ctx, roots)
+}
+
+// walkPinned returns the multihashes of the given roots and, for the
+// recursive ones, of the blocks of their DAGs available locally.
+func (p *Peer) walkPinned(ctx context.Context, roots map[cid.Cid]bool) (map[string]struct{}, error) {
+	set := make(map[string]struct{})
+	visit := func(c cid.Cid) bool {
+		k := string(c.Hash())
+		if _, ok := set[k]; ok {
+			return false
+		}
+		set[k] = struct{}{}
+		return true
+	}
+
+	offlineDAG := merkledag.NewDAGService(blockservice.New(p.bstore, offline.Exchange(p.bstore)))
+	getLinks := merkledag.GetLinksWithDAG(offlineDAG)
+	for c, recursive := range roots {
+		if !recursive {
+			visit(c)
+			continue
+		}
+		err := merkledag.Walk(ctx, getLinks, c, visit)
+		if err != nil {
+			logger.Warnf("error walking pinned DAG %s: %s", c, err)
+		}
+	}
+	return set, ctx.Err()
+}
+
+// cachedPinnedSet is the pinned set kept by EvictCache between runs, with
+// the pinned roots it was computed from. The pinned DAGs are only walked
+// again when the roots change.
+type cachedPinnedSet struct {
+	mu    sync.Mutex
+	roots map[cid.Cid]bool
+	set   map[string]struct{}
+}
+
+// get returns the pinned set of the Peer, walking the pinned DAGs only when
+// the pins changed since the last call.
+func (s *cachedPinnedSet) get(ctx context.Context, p *Peer) (map[string]struct{}, error) {
+	roots, err := p.pinnedRoots(ctx)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.set != nil && sameRoots(s.roots, roots) {
+		return s.set, nil
+	}
+	set, err := p.walkPinned(ctx, roots)
+	if err != nil {
+		return nil, err
+	}
+	s.roots = roots
+	s.set = set
+	return set, nil
+}
+
+func sameRoots(a, b map[cid.Cid]bool) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for c, recursive := range a {
+		if r, ok := b[c]; !ok || r != recursive {
+			return false
+		}
+	}
+	return true
+}
+
+// EvictCache removes least recently used blocks which are not pinned until
+// the blockstore size is within Config.CacheSize. It returns the number of
+// evicted blocks. It is only available when the Peer runs in cache mode
+// (Config.CacheSize > 0), and runs automatically every
+// Config.CacheGCInterval. The pinned DAGs are only walked again when the
+// pins changed since the last run.
+func (p *Peer) EvictCache(ctx context.Context) (int, error) {
+	if p.lru == nil {
+		return 0, nil
+	}
+	if p.lru.Size() <= p.cfg.CacheSize {
+		return 0, nil
+	}
+	keep, err := p.cachePinned.get(ctx, p)
+	if err != nil {
+		return 0, err
+	}
+	return p.lru.evict(ctx, p.cfg.CacheSize, keep)
+}
+
+// GC removes the blocks which are not pinned (directly, recursively or
+// indirectly) from the blockstore, and returns the number of removed
+// blocks. Blocks of content which is being added, and has not been pinned
+// yet, are removed as well, so it should not run concurrently with such
+// adds.
+func (p *Peer) GC(ctx context.Context) (int, error) {
+	if p.cfg.ReadOnly {
+		return 0, ErrReadOnly
+	}
+	keep, err := p.pinnedSet(ctx)
+	if err != nil {
+		return 0, err
+	}
+	keys, err := p.bstore.AllKeysChan(ctx)
+	if err != nil {
+		return 0, err
+	}
+	var candidates []cid.Cid
+	for c := range keys {
+		if _, ok := keep[string(c.Hash())]; !ok {
+			candidates = append(candidates, c)
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	removed := 0
+	for _, c := range candidates {
+		err := p.bstore.DeleteBlock(ctx, c)
+		if ipld.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return removed, err
+		}
+		removed++
+	}
+	return removed, nil
+}
+
+func (p *Peer) cacheGC() {
+	err := p.lru.load(p.ctx)
+	if err != nil && p.ctx.Err() == nil {
+		logger.Errorf("error loading blockstore into cache tracker: %s", err)
+	}
+
+	ticker := time.NewTicker(p.cfg.CacheGCInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.ctx.Done():
+			return
+		case <-ticker.C:
+			n, err := p.EvictCache(p.ctx)
+			if err != nil {
+				logger.Errorf("error evicting cached blocks: %s", err)
+			}
+			if n > 0 {
+				logger.Infof("evicted %d blocks from the cache", n)
+			}
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package ipfslite
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ipfs/boxo/ipld/merkledag"
+)
+
+func TestCacheEviction(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	p, err := New(ctx, NewInMemoryDatastore(), nil, nil, nil, &Config{
+		Offline:   true,
+		CacheSize: 16,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var nodes []*merkledag.RawNode
+	for _, data := range []string{"aaaaaaaa", "bbbbbbbb", "cccccccc"} {
+		n := merkledag.NewRawNode([]byte(data))
+		if err := p.Add(ctx, n); err != nil {
+			t.Fatal(err)
+		}
+		nodes = append(nodes, n)
+	}
+
+	// Pin the oldest node and access the second one, so the third one is
+	// the least recently used unpinned block.
+	if err := p.Pin(ctx, nodes[0].Cid(), false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.Get(ctx, nodes[1].Cid()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.Get(ctx, nodes[1].Cid()); err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := p.EvictCache(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("expected 1 evicted block, got %d", n)
+	}
+	if ok, _ := p.HasBlock(ctx, nodes[0].Cid()); !ok {
+		t.Error("pinned block should not be evicted")
+	}
+	if ok, _ := p.HasBlock(ctx, nodes[1].Cid()); !ok {
+		t.Error("recently used block should not be evicted")
+	}
+	if ok, _ := p.HasBlock(ctx, nodes[2].Cid()); ok {
+		t.Error("unpinned block should have been evicted")
+	}
+}
+
+func TestCachedPinnedSet(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	p, err := New(ctx, NewInMemoryDatastore(), nil, nil, nil, &Config{Offline: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a := merkledag.NewRawNode([]byte("a"))
+	b := merkledag.NewRawNode([]byte("b"))
+	for _, n := range []*merkledag.RawNode{a, b} {
+		if err := p.Add(ctx, n); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := p.Pin(ctx, a.Cid(), true); err != nil {
+		t.Fatal(err)
+	}
+
+	var cache cachedPinnedSet
+	set, err := cache.get(ctx, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := set[string(a.Cid().Hash())]; !ok {
+		t.Fatal("a should be pinned")
+	}
+	// The set is reused while the pins do not change.
+	set["marker"] = struct{}{}
+	set, err = cache.get(ctx, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := set["marker"]; !ok {
+		t.Error("the pinned set should be reused")
+	}
+
+	if err := p.Pin(ctx, b.Cid(), false); err != nil {
+		t.Fatal(err)
+	}
+	set, err = cache.get(ctx, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := set["marker"]; ok {
+		t.Error("the pinned set should be recomputed after pinning")
+	}
+	if _, ok := set[string(b.Cid().Hash())]; !ok {
+		t.Error("b should be pinned")
+	}
+}
@@ -0,0 +1,148 @@
+package ipfslite
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	blockstore "github.com/ipfs/boxo/blockstore"
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	ipld "github.com/ipfs/go-ipld-format"
+	"github.com/multiformats/go-multicodec"
+)
+
+// ErrCIDNotAllowed is returned when storing blocks whose codec or hash
+// function is not allowed by Config.AllowedCodecs and Config.AllowedHashes.
+var ErrCIDNotAllowed = errors.New("CID not allowed")
+
+// cidPolicy restricts the codecs and hash functions of CIDs. Empty sets
+// allow everything.
+type cidPolicy struct {
+	codecs map[uint64]bool
+	hashes map[uint64]bool
+}
+
+func newCIDPolicy(codecs, hashes []uint64) *cidPolicy {
+	if len(codecs) == 0 && len(hashes) == 0 {
+		return nil
+	}
+	pol := &cidPolicy{}
+	if len(codecs) > 0 {
+		pol.codecs = make(map[uint64]bool)
+		for _, c := range codecs {
+			pol.codecs[c] = true
+		}
+	}
+	if len(hashes) > 0 {
+		pol.hashes = make(map[uint64]bool)
+		for _, h := range hashes {
+			pol.hashes[h] = true
+		}
+	}
+	return pol
+}
+
+// IsAllowed implements verifcid.Allowlist for hash functions, so that the
+// block service refuses to fetch CIDs with other hash functions.
+func (pol *cidPolicy) IsAllowed(code uint64) bool {
+	return pol.hashes == nil || pol.hashes[code]
+}
+
+// check returns an error wrapping ErrCIDNotAllowed when the CID is not
+// allowed.
+func (pol *cidPolicy) check(c cid.Cid) error {
+	prefix := c.Prefix()
+	if pol.codecs != nil && !pol.codecs[prefix.Codec] {
+		return fmt.Errorf("%w: %s uses codec %s", ErrCIDNotAllowed, c, multicodec.Code(prefix.Codec))
+	}
+	return pol.checkHash(c)
+}
+
+// checkHash is like check, only checking the hash function. Unlike the
+// codec, it is preserved in the keys of the blockstore, which are
+// multihashes listed as CIDv1 raw.
+func (pol *cidPolicy) checkHash(c cid.Cid) error {
+	if mhType := c.Prefix().MhType; !pol.IsAllowed(mhType) {
+		return fmt.Errorf("%w: %s uses hash function %s", ErrCIDNotAllowed, c, multicodec.Code(mhType))
+	}
+	return nil
+}
+
+// cidPolicyBlockstore refuses to store the blocks which are not allowed by
+// a cidPolicy, whether they are added locally or received from other
+// peers, and hides the ones stored before, so that they are not served.
+type cidPolicyBlockstore struct {
+	blockstore.Blockstore
+	policy *cidPolicy
+}
+
+func (bs *cidPolicyBlockstore) Put(ctx context.Context, blk blocks.Block) error {
+	if err := bs.policy.check(blk.Cid()); err != nil {
+		return err
+	}
+	return bs.Blockstore.Put(ctx, blk)
+}
+
+func (bs *cidPolicyBlockstore) PutMany(ctx context.Context, blks []blocks.Block) error {
+	for _, blk := range blks {
+		if err := bs.policy.check(blk.Cid()); err != nil {
+			return err
+		}
+	}
+	return bs.Blockstore.PutMany(ctx, blks)
+}
+
+func (bs *cidPolicyBlockstore) Has(ctx context.Context, c cid.Cid) (bool, error) {
+	if bs.policy.check(c) != nil {
+		return false, nil
+	}
+	return bs.Blockstore.Has(ctx, c)
+}
+
+func (bs *cidPolicyBlockstore) Get(ctx context.Context, c cid.Cid) (blocks.Block, error) {
+	if bs.policy.check(c) != nil {
+		return nil, ipld.ErrNotFound{Cid: c}
+	}
+	return bs.Blockstore.Get(ctx, c)
+}
+
+func (bs *cidPolicyBlockstore) View(ctx context.Context, c cid.Cid, f func([]byte) error) error {
+	if bs.policy.check(c) != nil {
+		return ipld.ErrNotFound{Cid: c}
+	}
+	return viewBlock(ctx, bs.Blockstore, c, f)
+}
+
+func (bs *cidPolicyBlockstore) GetSize(ctx context.Context, c cid.Cid) (int, error) {
+	if bs.policy.check(c) != nil {
+		return -1, ipld.ErrNotFound{Cid: c}
+	}
+	return bs.Blockstore.GetSize(ctx, c)
+}
+
+// AllKeysChan skips the blocks whose hash function is not allowed, so that
+// they are not announced either. Keys are not filtered by codec: the
+// blockstore only knows the multihashes of the blocks, and lists them as
+// CIDv1 raw whatever the codec they were stored with.
+func (bs *cidPolicyBlockstore) AllKeysChan(ctx context.Context) (<-chan cid.Cid, error) {
+	keys, err := bs.Blockstore.AllKeysChan(ctx)
+	if err != nil {
+		return nil, err
+	}
+	out := make(chan cid.Cid)
+	go func() {
+		defer close(out)
+		for c := range keys {
+			if bs.policy.checkHash(c) != nil {
+				continue
+			}
+			select {
+			case out <- c:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out, nil
+}
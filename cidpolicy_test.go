@@ -0,0 +1,95 @@
+package ipfslite
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	cbor "github.com/ipfs/go-ipld-cbor"
+	"github.com/multiformats/go-multihash"
+)
+
+func TestCIDPolicy(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ds := NewInMemoryDatastore()
+	open, err := New(ctx, ds, nil, nil, nil, &Config{Offline: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	node, err := cbor.WrapObject(map[string]string{"akey": "avalue"}, multihash.SHA2_256, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = open.Add(ctx, node)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := New(ctx, ds, nil, nil, nil, &Config{
+		Offline:       true,
+		AllowedCodecs: []uint64{cid.Raw, cid.DagProtobuf},
+		AllowedHashes: []uint64{multihash.SHA2_256},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p2, err := New(ctx, NewInMemoryDatastore(), nil, nil, nil, &Config{
+		Offline:       true,
+		AllowedCodecs: []uint64{cid.DagProtobuf},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The keys of the blockstore are listed whatever their codec.
+	root, err := p2.AddFile(ctx, strings.NewReader("allowed"), &AddParams{RawLeaves: false})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if has, _ := p2.HasBlock(ctx, root.Cid()); !has {
+		t.Error("the dag-pb block should be stored")
+	}
+	keys, err := p2.BlockStore().AllKeysChan(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := 0
+	for range keys {
+		n++
+	}
+	if n != 1 {
+		t.Errorf("expected 1 key, got %d", n)
+	}
+
+	if _, err := p.AddFile(ctx, strings.NewReader("allowed"), nil); err != nil {
+		t.Fatal(err)
+	}
+	err = p.Add(ctx, node)
+	if !errors.Is(err, ErrCIDNotAllowed) {
+		t.Errorf("expected ErrCIDNotAllowed for a codec, got %v", err)
+	}
+	// Blocks stored before are not served.
+	if has, _ := p.HasBlock(ctx, node.Cid()); has {
+		t.Error("the dag-cbor block should be hidden")
+	}
+
+	mh, err := multihash.Sum([]byte("data"), multihash.SHA2_512, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	blk, err := blocks.NewBlockWithCid([]byte("data"), cid.NewCidV1(cid.Raw, mh))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = p.BlockStore().Put(ctx, blk)
+	if !errors.Is(err, ErrCIDNotAllowed) {
+		t.Errorf("expected ErrCIDNotAllowed for a hash function, got %v", err)
+	}
+	if _, err := p.Get(ctx, blk.Cid()); err == nil {
+		t.Error("blocks with other hash functions should not be fetched")
+	}
+}
@@ -0,0 +1,119 @@
+package ipfslite
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p/core/peer"
+	mbase "github.com/multiformats/go-multibase"
+	mc "github.com/multiformats/go-multicodec"
+	"github.com/multiformats/go-multihash"
+)
+
+// ErrInvalidCID is returned by ParseCID for strings which are not CIDs.
+var ErrInvalidCID = errors.New("invalid CID")
+
+// dnsLabelMaxLength is the maximum length of a DNS label, which limits the
+// CIDs usable as subdomains.
+const dnsLabelMaxLength = 63
+
+// ParseCID parses a CID supplied by a user, in any multibase. Surrounding
+// spaces and /ipfs/ or ipfs:// prefixes are ignored. The errors wrap
+// ErrInvalidCID and tell what is wrong with the string, i.e. when it is a
+// peer ID or when it was truncated.
+func ParseCID(s string) (cid.Cid, error) {
+	s = strings.TrimSpace(s)
+	for _, prefix := range []string{"ipfs://", "/ipfs/"} {
+		s = strings.TrimPrefix(s, prefix)
+	}
+	s = strings.TrimSuffix(s, "/")
+	if s == "" {
+		return cid.Undef, fmt.Errorf("%w: empty string", ErrInvalidCID)
+	}
+	if strings.Contains(s, "/") {
+		return cid.Undef, fmt.Errorf("%w: %q is a path, not a CID", ErrInvalidCID, s)
+	}
+
+	c, err := cid.Decode(s)
+	if err == nil {
+		return c, nil
+	}
+	switch {
+	case strings.HasPrefix(s, "Qm") && len(s) != 46:
+		return cid.Undef, fmt.Errorf("%w: %q is a CIDv0 of %d characters instead of 46, it may be truncated", ErrInvalidCID, s, len(s))
+	case strings.HasPrefix(s, "12D3Koo"):
+		if _, perr := peer.Decode(s); perr == nil {
+			return cid.Undef, fmt.Errorf("%w: %q is a peer ID, use /ipns/ paths for IPNS names", ErrInvalidCID, s)
+		}
+	}
+	if len(s) < 2 {
+		return cid.Undef, fmt.Errorf("%w: %q is too short", ErrInvalidCID, s)
+	}
+	if _, ok := mbase.EncodingToStr[mbase.Encoding(s[0])]; !ok && !strings.HasPrefix(s, "Qm") {
+		return cid.Undef, fmt.Errorf("%w: %q does not start with a known multibase prefix", ErrInvalidCID, s)
+	}
+	return cid.Undef, fmt.Errorf("%w: %q: %s", ErrInvalidCID, s, err)
+}
+
+// CIDToV1 returns the CIDv1 of a CID, which is the CID itself when it is a
+// CIDv1 already.
+func CIDToV1(c cid.Cid) cid.Cid {
+	if c.Version() == 1 {
+		return c
+	}
+	return cid.NewCidV1(c.Type(), c.Hash())
+}
+
+// CIDToV0 returns the CIDv0 of a CID. Only dag-pb CIDs with sha2-256
+// multihashes have one.
+func CIDToV0(c cid.Cid) (cid.Cid, error) {
+	if c.Version() == 0 {
+		return c, nil
+	}
+	pref := c.Prefix()
+	if pref.Codec != cid.DagProtobuf || pref.MhType != multihash.SHA2_256 || pref.MhLength != 32 {
+		return cid.Undef, fmt.Errorf("%s cannot be a CIDv0: only dag-pb CIDs with sha2-256 hashes can", c)
+	}
+	return cid.NewCidV0(c.Hash()), nil
+}
+
+// FormatCID encodes a CID in the given multibase (i.e. mbase.Base32,
+// mbase.Base36 or mbase.Base58BTC). CIDv0 can only be encoded in base58btc,
+// so they are converted to CIDv1 for the other bases.
+func FormatCID(c cid.Cid, base mbase.Encoding) (string, error) {
+	if c.Version() == 0 {
+		if base == mbase.Base58BTC {
+			return c.String(), nil
+		}
+		c = CIDToV1(c)
+	}
+	return c.StringOfBase(base)
+}
+
+// SubdomainCID returns the form of a CID usable as a DNS label, i.e. for
+// subdomain gateways (<cid>.ipfs.example.com): a case-insensitive CIDv1 in
+// base32, or in base36 when too long for a label (and for libp2p-key CIDs,
+// like IPNS names). It fails when the CID does not fit in a DNS label.
+func SubdomainCID(c cid.Cid) (string, error) {
+	c = CIDToV1(c)
+	base := mbase.Encoding(mbase.Base32)
+	if c.Type() == uint64(mc.Libp2pKey) {
+		base = mbase.Base36
+	}
+	s, err := c.StringOfBase(base)
+	if err != nil {
+		return "", err
+	}
+	if len(s) > dnsLabelMaxLength && base != mbase.Base36 {
+		s, err = c.StringOfBase(mbase.Base36)
+		if err != nil {
+			return "", err
+		}
+	}
+	if len(s) > dnsLabelMaxLength {
+		return "", fmt.Errorf("%s is too long for a DNS label (%d characters)", c, len(s))
+	}
+	return s, nil
+}
@@ -0,0 +1,87 @@
+package ipfslite
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	mbase "github.com/multiformats/go-multibase"
+	"github.com/multiformats/go-multihash"
+)
+
+func TestParseCID(t *testing.T) {
+	v0 := "QmYwAPJzv5CZsnA625s3Xf2nemtYgPpHdWEz79ojWnPbdG"
+	for _, s := range []string{v0, " " + v0 + "\n", "/ipfs/" + v0, "ipfs://" + v0 + "/"} {
+		c, err := ParseCID(s)
+		if err != nil || c.String() != v0 {
+			t.Errorf("%q: unexpected result %s %v", s, c, err)
+		}
+	}
+
+	priv, _, err := crypto.GenerateEd25519Key(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pid, err := peer.IDFromPrivateKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for s, msg := range map[string]string{
+		"":                      "empty",
+		v0[:40]:                 "truncated",
+		v0 + "/file.txt":        "path",
+		pid.String():            "peer ID",
+		"!bafybeigdyrzt5sfp7ud": "multibase",
+	} {
+		_, err := ParseCID(s)
+		if !errors.Is(err, ErrInvalidCID) || !strings.Contains(err.Error(), msg) {
+			t.Errorf("%q: unexpected error %v", s, err)
+		}
+	}
+}
+
+func TestCIDConversions(t *testing.T) {
+	v0, err := cid.Decode("QmYwAPJzv5CZsnA625s3Xf2nemtYgPpHdWEz79ojWnPbdG")
+	if err != nil {
+		t.Fatal(err)
+	}
+	v1 := CIDToV1(v0)
+	if v1.Version() != 1 || !v1.Equals(cid.NewCidV1(cid.DagProtobuf, v0.Hash())) {
+		t.Errorf("unexpected CIDv1: %s", v1)
+	}
+	back, err := CIDToV0(v1)
+	if err != nil || !back.Equals(v0) {
+		t.Errorf("unexpected CIDv0: %s %v", back, err)
+	}
+	if _, err := CIDToV0(cid.NewCidV1(cid.Raw, v0.Hash())); err == nil {
+		t.Error("raw CIDs have no CIDv0")
+	}
+
+	s, err := FormatCID(v0, mbase.Base36)
+	if err != nil || !strings.HasPrefix(s, "k") {
+		t.Errorf("unexpected base36 CID: %s %v", s, err)
+	}
+	if c, err := cid.Decode(s); err != nil || !c.Equals(v1) {
+		t.Errorf("the base36 CID does not decode: %v", err)
+	}
+	if s, _ := FormatCID(v0, mbase.Base58BTC); s != v0.String() {
+		t.Errorf("unexpected base58 CID: %s", s)
+	}
+
+	s, err = SubdomainCID(v0)
+	if err != nil || s != v1.String() {
+		t.Errorf("unexpected subdomain CID: %s %v", s, err)
+	}
+	// Longer CIDs do not fit in a DNS label in base32.
+	mh, err := multihash.Sum([]byte(strings.Repeat("x", 35)), multihash.IDENTITY, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err = SubdomainCID(cid.NewCidV1(cid.DagCBOR, mh))
+	if err != nil || len(s) > 63 || !strings.HasPrefix(s, "k") {
+		t.Errorf("unexpected subdomain CID: %s %v", s, err)
+	}
+}
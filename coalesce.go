@@ -0,0 +1,181 @@
+package ipfslite
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	ipld "github.com/ipfs/go-ipld-format"
+)
+
+// FetchProgress reports a network fetch shared by concurrent callers (see
+// Config.CoalesceFetches).
+type FetchProgress struct {
+	// Root is the requested CID.
+	Root cid.Cid
+	// DAG is set for FetchDAG calls, and unset for Fetch calls.
+	DAG bool
+	// Nodes is the number of nodes of the DAG retrieved so far.
+	Nodes int
+	// Callers is the number of callers waiting for the fetch.
+	Callers int
+	Started time.Time
+}
+
+type fetchKey struct {
+	c   cid.Cid
+	dag bool
+}
+
+// coalescedFetch is a fetch shared by its callers. It runs until it
+// completes, or until all its callers gave up.
+type coalescedFetch struct {
+	started time.Time
+	cancel  context.CancelFunc
+	done    chan struct{}
+	nodes   int64
+	callers int
+
+	node ipld.Node
+	err  error
+}
+
+// fetchGroup coalesces concurrent identical fetches into one.
+type fetchGroup struct {
+	mu      sync.Mutex
+	fetches map[fetchKey]*coalescedFetch
+}
+
+// do runs fetch, or joins the identical fetch in progress, and waits until
+// it completes or ctx is cancelled. The fetch runs with a context derived
+// from parent, which is cancelled once no caller waits for it anymore.
+func (g *fetchGroup) do(ctx, parent context.Context, key fetchKey, fetch func(context.Context, *coalescedFetch) (ipld.Node, error)) (ipld.Node, error) {
+	g.mu.Lock()
+	if g.fetches == nil {
+		g.fetches = make(map[fetchKey]*coalescedFetch)
+	}
+	f, ok := g.fetches[key]
+	if !ok {
+		fctx, cancel := context.WithCancel(parent)
+		f = &coalescedFetch{
+			started: time.Now(),
+			cancel:  cancel,
+			done:    make(chan struct{}),
+		}
+		g.fetches[key] = f
+		go func() {
+			node, err := fetch(fctx, f)
+			g.mu.Lock()
+			f.node, f.err = node, err
+			if g.fetches[key] == f {
+				delete(g.fetches, key)
+			}
+			g.mu.Unlock()
+			cancel()
+			close(f.done)
+		}()
+	}
+	f.callers++
+	g.mu.Unlock()
+
+	select {
+	case <-f.done:
+		if f.node != nil {
+			// Nodes may be modified by callers.
+			return f.node.Copy(), f.err
+		}
+		return nil, f.err
+	case <-ctx.Done():
+		g.mu.Lock()
+		f.callers--
+		if f.callers == 0 {
+			if g.fetches[key] == f {
+				delete(g.fetches, key)
+			}
+			f.cancel()
+		}
+		g.mu.Unlock()
+		return nil, ctx.Err()
+	}
+}
+
+func (g *fetchGroup) progress() []FetchProgress {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	progress := make([]FetchProgress, 0, len(g.fetches))
+	for key, f := range g.fetches {
+		progress = append(progress, FetchProgress{
+			Root:    key.c,
+			DAG:     key.dag,
+			Nodes:   int(atomic.LoadInt64(&f.nodes)),
+			Callers: f.callers,
+			Started: f.started,
+		})
+	}
+	sort.Slice(progress, func(i, j int) bool {
+		return progress[i].Started.Before(progress[j].Started)
+	})
+	return progress
+}
+
+// coalesces returns whether a fetch with the given options may be shared
+// with other callers. Fetches limited by WithMaxBlocks are not, as their
+// budget applies to each caller.
+func (p *Peer) coalesces(opts *fetchOptions) bool {
+	return p.cfg.CoalesceFetches && opts.maxBlocks <= 0
+}
+
+// joinFetch waits for the shared fetch of the given key. The providers
+// given by the caller are connected, so that the shared bitswap session
+// asks them for blocks too.
+func (p *Peer) joinFetch(ctx context.Context, key fetchKey, opts *fetchOptions, fetch func(context.Context, *coalescedFetch) (ipld.Node, error)) (ipld.Node, error) {
+	p.fetches.mu.Lock()
+	_, joining := p.fetches.fetches[key]
+	p.fetches.mu.Unlock()
+	if joining {
+		p.connectProviders(ctx, p.orderProviders(opts.providers))
+	}
+	return p.fetches.do(ctx, p.ctx, key, fetch)
+}
+
+// FetchesInProgress returns the fetches currently shared by their callers,
+// oldest first, when Config.CoalesceFetches is set.
+func (p *Peer) FetchesInProgress() []FetchProgress {
+	return p.fetches.progress()
+}
+
+// countingNodeGetter counts the nodes retrieved for a shared fetch.
+type countingNodeGetter struct {
+	ipld.NodeGetter
+	nodes *int64
+}
+
+func (ng *countingNodeGetter) Get(ctx context.Context, c cid.Cid) (ipld.Node, error) {
+	n, err := ng.NodeGetter.Get(ctx, c)
+	if err == nil {
+		atomic.AddInt64(ng.nodes, 1)
+	}
+	return n, err
+}
+
+func (ng *countingNodeGetter) GetMany(ctx context.Context, cids []cid.Cid) <-chan *ipld.NodeOption {
+	in := ng.NodeGetter.GetMany(ctx, cids)
+	out := make(chan *ipld.NodeOption, len(cids))
+	go func() {
+		defer close(out)
+		for opt := range in {
+			if opt.Err == nil {
+				atomic.AddInt64(ng.nodes, 1)
+			}
+			select {
+			case out <- opt:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out
+}
@@ -0,0 +1,105 @@
+package ipfslite
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ipfs/boxo/ipld/merkledag"
+	ipld "github.com/ipfs/go-ipld-format"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+func TestFetchGroup(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var g fetchGroup
+	node := merkledag.NewRawNode([]byte("coalesced"))
+	key := fetchKey{c: node.Cid()}
+	var calls int32
+	release := make(chan struct{})
+	fetch := func(ctx context.Context, f *coalescedFetch) (ipld.Node, error) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return node, nil
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			n, err := g.do(ctx, ctx, key, fetch)
+			if err != nil || !n.Cid().Equals(node.Cid()) {
+				t.Errorf("unexpected result: %v, %v", n, err)
+			}
+		}()
+	}
+	for deadline := time.Now().Add(5 * time.Second); ; {
+		progress := g.progress()
+		if len(progress) == 1 && progress[0].Callers == 3 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("unexpected progress: %+v", progress)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	close(release)
+	wg.Wait()
+	if calls != 1 {
+		t.Errorf("the fetch ran %d times", calls)
+	}
+	if len(g.progress()) != 0 {
+		t.Error("completed fetches should be forgotten")
+	}
+
+	// The fetch is cancelled once all its callers gave up.
+	cancelled := make(chan struct{})
+	waitCtx, waitCancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer waitCancel()
+	_, err := g.do(waitCtx, ctx, key, func(ctx context.Context, f *coalescedFetch) (ipld.Node, error) {
+		<-ctx.Done()
+		close(cancelled)
+		return nil, ctx.Err()
+	})
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	select {
+	case <-cancelled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the abandoned fetch should be cancelled")
+	}
+}
+
+func TestCoalesceFetches(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	p1 := setupPeer(t, ctx, &Config{CoalesceFetches: true})
+	p2 := setupPeer(t, ctx, nil)
+
+	root := addTestDir(t, ctx, p2, map[string]string{"a.txt": "a", "sub/b.txt": "b"})
+	providers := []peer.AddrInfo{{ID: p2.host.ID(), Addrs: p2.host.Addrs()}}
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := p1.FetchDAG(ctx, root, WithProviders(providers)); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if len(p1.FetchesInProgress()) != 0 {
+		t.Error("no fetch should be in progress")
+	}
+	n, err := p1.Fetch(ctx, root, WithProviders(providers))
+	if err != nil || !n.Cid().Equals(root) {
+		t.Fatalf("unexpected fetch result: %v, %v", n, err)
+	}
+}
@@ -0,0 +1,369 @@
+package ipfslite
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"time"
+
+	bsmsg "github.com/ipfs/boxo/bitswap/message"
+	"github.com/ipfs/boxo/exchange"
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	ipld "github.com/ipfs/go-ipld-format"
+	"github.com/klauspost/compress/zstd"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+)
+
+// CompressedBlocksProtocol is the libp2p protocol used by Peers with
+// Config.CompressedTransfers to exchange zstd-compressed blocks.
+const CompressedBlocksProtocol protocol.ID = "/ipfs-lite/blocks/zstd/1.0.0"
+
+// Limits of the compressed blocks protocol.
+const (
+	compressedMaxCids      = 256
+	compressedMaxBlockSize = 4 << 20
+	compressedTimeout      = 30 * time.Second
+)
+
+// compressedLookupTimeout bounds the time spent waiting for the first
+// responses of the compressed peers, so that the blocks they do not have
+// are soon requested with bitswap instead.
+var compressedLookupTimeout = 5 * time.Second
+
+// handleCompressedBlocks serves the requested blocks which are available
+// locally. A request is a varint count followed by varint-prefixed CIDs.
+// The response is a zstd stream with, for every requested CID in order, a
+// byte set to 1 when the block follows as varint-prefixed data, and to 0
+// when it is not available.
+func (p *Peer) handleCompressedBlocks(s network.Stream) {
+	defer s.Close()
+	s.SetDeadline(time.Now().Add(compressedTimeout))
+	ctx, cancel := context.WithTimeout(p.ctx, compressedTimeout)
+	defer cancel()
+
+	cids, err := readCompressedRequest(bufio.NewReader(s))
+	if err != nil {
+		logger.Debugf("compressed blocks request from %s: %s", s.Conn().RemotePeer(), err)
+		s.Reset()
+		return
+	}
+
+	zw, err := zstd.NewWriter(s)
+	if err != nil {
+		s.Reset()
+		return
+	}
+	pid := s.Conn().RemotePeer()
+	for _, c := range cids {
+		// Blocks are filtered, and accounted for as they are sent, like
+		// with bitswap (serve policies, quotas and statistics).
+		var blk blocks.Block
+		if p.serveFilter != nil && !p.serveFilter(pid, c) {
+			err = ipld.ErrNotFound{Cid: c}
+		} else {
+			blk, err = p.bstore.Get(ctx, c)
+		}
+		if err != nil {
+			_, err = zw.Write([]byte{0})
+		} else {
+			err = writeCompressedBlock(zw, blk.RawData())
+			if err == nil {
+				p.tracers.MessageSent(pid, blockMessage(blk))
+			}
+		}
+		if err != nil {
+			s.Reset()
+			return
+		}
+	}
+	if err := zw.Close(); err != nil {
+		s.Reset()
+	}
+}
+
+func readCompressedRequest(r *bufio.Reader) ([]cid.Cid, error) {
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	if n > compressedMaxCids {
+		return nil, fmt.Errorf("too many CIDs: %d", n)
+	}
+	cids := make([]cid.Cid, 0, n)
+	for i := uint64(0); i < n; i++ {
+		data, err := readCompressedBytes(r, 128)
+		if err != nil {
+			return nil, err
+		}
+		c, err := cid.Cast(data)
+		if err != nil {
+			return nil, err
+		}
+		cids = append(cids, c)
+	}
+	return cids, nil
+}
+
+func readCompressedBytes(r *bufio.Reader, max uint64) ([]byte, error) {
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	if n > max {
+		return nil, fmt.Errorf("data too large: %d bytes", n)
+	}
+	data := make([]byte, n)
+	_, err = io.ReadFull(r, data)
+	return data, err
+}
+
+func writeCompressedBlock(w io.Writer, data []byte) error {
+	buf := make([]byte, 1+binary.MaxVarintLen64)
+	buf[0] = 1
+	n := binary.PutUvarint(buf[1:], uint64(len(data)))
+	if _, err := w.Write(buf[:1+n]); err != nil {
+		return err
+	}
+	_, err := w.Write(data)
+	return err
+}
+
+// compressedPeers returns the connected peers which support the compressed
+// blocks protocol and pass the bitswap peer filter.
+func (p *Peer) compressedPeers() []peer.ID {
+	var peers []peer.ID
+	for _, pid := range p.host.Network().Peers() {
+		protos, err := p.host.Peerstore().SupportsProtocols(pid, p.compressedProtocol())
+		if err != nil || len(protos) == 0 {
+			continue
+		}
+		if p.cfg.BitswapPeerFilter != nil && !p.cfg.BitswapPeerFilter(p.host, pid) {
+			continue
+		}
+		peers = append(peers, pid)
+	}
+	return peers
+}
+
+// blockMessage wraps blocks exchanged with the compressed blocks protocol
+// in a bitswap message, for the bitswap tracers.
+func blockMessage(blks ...blocks.Block) bsmsg.BitSwapMessage {
+	msg := bsmsg.New(false)
+	for _, blk := range blks {
+		msg.AddBlock(blk)
+	}
+	return msg
+}
+
+// getCompressed requests the given blocks from the connected peers
+// supporting the compressed blocks protocol, and returns those found. Peers
+// are tried in turn until compressedLookupTimeout: past it, no other peer
+// is tried, and the first response of a peer is no longer waited for.
+func (p *Peer) getCompressed(ctx context.Context, cids []cid.Cid) []blocks.Block {
+	var found []blocks.Block
+	missing := cids
+	lookupDeadline := time.Now().Add(compressedLookupTimeout)
+	for _, pid := range p.compressedPeers() {
+		if len(missing) == 0 || ctx.Err() != nil || time.Now().After(lookupDeadline) {
+			break
+		}
+		var still []cid.Cid
+		// The first request waits for the peer until the lookup
+		// deadline, the next ones only for compressedTimeout, as the
+		// peer is known to respond.
+		responseDeadline := lookupDeadline
+		for len(missing) > 0 {
+			n := len(missing)
+			if n > compressedMaxCids {
+				n = compressedMaxCids
+			}
+			blks, notFound, err := p.requestCompressed(ctx, pid, missing[:n], responseDeadline)
+			if err != nil {
+				logger.Debugf("compressed blocks request to %s: %s", pid, err)
+				still = append(still, missing...)
+				break
+			}
+			responseDeadline = time.Time{}
+			if len(blks) > 0 {
+				p.tracers.MessageReceived(pid, blockMessage(blks...))
+			}
+			found = append(found, blks...)
+			still = append(still, notFound...)
+			missing = missing[n:]
+		}
+		missing = still
+	}
+	return found
+}
+
+// requestCompressed requests the given blocks from a peer. Unless
+// responseDeadline is zero, the peer must start responding before it.
+func (p *Peer) requestCompressed(ctx context.Context, pid peer.ID, cids []cid.Cid, responseDeadline time.Time) ([]blocks.Block, []cid.Cid, error) {
+	ctx, cancel := context.WithTimeout(ctx, compressedTimeout)
+	defer cancel()
+	openCtx := ctx
+	if !responseDeadline.IsZero() {
+		var openCancel context.CancelFunc
+		openCtx, openCancel = context.WithDeadline(ctx, responseDeadline)
+		defer openCancel()
+	}
+	s, err := p.host.NewStream(openCtx, pid, p.compressedProtocol())
+	if err != nil {
+		return nil, nil, err
+	}
+	defer s.Close()
+	deadline, _ := ctx.Deadline()
+	if !responseDeadline.IsZero() && responseDeadline.Before(deadline) {
+		s.SetDeadline(responseDeadline)
+	} else {
+		s.SetDeadline(deadline)
+	}
+
+	w := bufio.NewWriter(s)
+	buf := make([]byte, binary.MaxVarintLen64)
+	w.Write(buf[:binary.PutUvarint(buf, uint64(len(cids)))])
+	for _, c := range cids {
+		w.Write(buf[:binary.PutUvarint(buf, uint64(c.ByteLen()))])
+		w.Write(c.Bytes())
+	}
+	if err := w.Flush(); err != nil {
+		s.Reset()
+		return nil, nil, err
+	}
+	s.CloseWrite()
+
+	zr, err := zstd.NewReader(s, zstd.WithDecoderMaxMemory(compressedMaxBlockSize*2))
+	if err != nil {
+		s.Reset()
+		return nil, nil, err
+	}
+	defer zr.Close()
+	r := bufio.NewReader(zr)
+
+	var found []blocks.Block
+	var missing []cid.Cid
+	for i, c := range cids {
+		flag, err := r.ReadByte()
+		if err != nil {
+			s.Reset()
+			return nil, nil, err
+		}
+		if i == 0 {
+			// The peer responds: the transfer may take longer.
+			s.SetDeadline(deadline)
+		}
+		if flag == 0 {
+			missing = append(missing, c)
+			continue
+		}
+		data, err := readCompressedBytes(r, compressedMaxBlockSize)
+		if err != nil {
+			s.Reset()
+			return nil, nil, err
+		}
+		// Blocks from other peers must match their CID.
+		sum, err := c.Prefix().Sum(data)
+		if err != nil || !sum.Equals(c) {
+			s.Reset()
+			return nil, nil, fmt.Errorf("invalid block %s from %s", c, pid)
+		}
+		blk, err := blocks.NewBlockWithCid(data, c)
+		if err != nil {
+			s.Reset()
+			return nil, nil, err
+		}
+		found = append(found, blk)
+	}
+	return found, missing, nil
+}
+
+// getBlocksCompressed returns a channel with the given blocks, requested
+// first with the compressed blocks protocol, then from the fetcher.
+func (p *Peer) getBlocksCompressed(ctx context.Context, f exchange.Fetcher, cids []cid.Cid) (<-chan blocks.Block, error) {
+	found := p.getCompressed(ctx, cids)
+	have := make(map[cid.Cid]struct{}, len(found))
+	for _, blk := range found {
+		have[blk.Cid()] = struct{}{}
+	}
+	var missing []cid.Cid
+	for _, c := range cids {
+		if _, ok := have[c]; !ok {
+			missing = append(missing, c)
+		}
+	}
+	var rest <-chan blocks.Block
+	if len(missing) > 0 {
+		var err error
+		rest, err = f.GetBlocks(ctx, missing)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	out := make(chan blocks.Block)
+	go func() {
+		defer close(out)
+		for _, blk := range found {
+			select {
+			case out <- blk:
+			case <-ctx.Done():
+				return
+			}
+		}
+		if rest == nil {
+			return
+		}
+		for blk := range rest {
+			select {
+			case out <- blk:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out, nil
+}
+
+// compressedExchange tries the compressed blocks protocol before
+// bitswap.
+type compressedExchange struct {
+	exchange.SessionExchange
+	p *Peer
+}
+
+func (e *compressedExchange) GetBlock(ctx context.Context, c cid.Cid) (blocks.Block, error) {
+	return getBlockCompressed(ctx, e.p, e.SessionExchange, c)
+}
+
+func (e *compressedExchange) GetBlocks(ctx context.Context, cids []cid.Cid) (<-chan blocks.Block, error) {
+	return e.p.getBlocksCompressed(ctx, e.SessionExchange, cids)
+}
+
+func (e *compressedExchange) NewSession(ctx context.Context) exchange.Fetcher {
+	return &compressedFetcher{Fetcher: e.SessionExchange.NewSession(ctx), p: e.p}
+}
+
+type compressedFetcher struct {
+	exchange.Fetcher
+	p *Peer
+}
+
+func (f *compressedFetcher) GetBlock(ctx context.Context, c cid.Cid) (blocks.Block, error) {
+	return getBlockCompressed(ctx, f.p, f.Fetcher, c)
+}
+
+func (f *compressedFetcher) GetBlocks(ctx context.Context, cids []cid.Cid) (<-chan blocks.Block, error) {
+	return f.p.getBlocksCompressed(ctx, f.Fetcher, cids)
+}
+
+func getBlockCompressed(ctx context.Context, p *Peer, f exchange.Fetcher, c cid.Cid) (blocks.Block, error) {
+	if found := p.getCompressed(ctx, []cid.Cid{c}); len(found) > 0 {
+		return found[0], nil
+	}
+	return f.GetBlock(ctx, c)
+}
@@ -0,0 +1,127 @@
+package ipfslite
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+func TestCompressedTransfers(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	p1 := setupPeer(t, ctx, &Config{CompressedTransfers: true})
+	p2 := setupPeer(t, ctx, &Config{CompressedTransfers: true})
+	p3 := setupPeer(t, ctx, nil)
+	for _, p := range []*Peer{p2, p3} {
+		err := p.host.Connect(ctx, peer.AddrInfo{ID: p1.ID(), Addrs: p1.Addrs()})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	content := []byte(strings.Repeat(`{"key": "value", "list": [1, 2, 3]}`+"\n", 10000))
+	n, err := p1.AddFile(ctx, bytes.NewReader(content), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	found := p2.getCompressed(ctx, []cid.Cid{n.Cid(), testCid(t, "missing")})
+	if len(found) != 1 || !found[0].Cid().Equals(n.Cid()) {
+		t.Fatalf("unexpected blocks: %v", found)
+	}
+
+	for _, p := range []*Peer{p2, p3} {
+		r, err := p.GetFile(ctx, n.Cid(), WithTimeout(10*time.Second))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := io.ReadAll(r)
+		r.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, content) {
+			t.Error("content mismatch")
+		}
+	}
+	if peers := p1.compressedPeers(); len(peers) != 1 || peers[0] != p2.ID() {
+		t.Errorf("only peers with CompressedTransfers should be used: %v", peers)
+	}
+}
+
+func TestCompressedTransfersQuota(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	p1 := setupPeer(t, ctx, &Config{CompressedTransfers: true, ServeDailyQuota: 1000, PeerStats: true})
+	p2 := setupPeer(t, ctx, &Config{CompressedTransfers: true})
+	if err := p2.host.Connect(ctx, peer.AddrInfo{ID: p1.ID(), Addrs: p1.Addrs()}); err != nil {
+		t.Fatal(err)
+	}
+
+	var nodes []cid.Cid
+	for i := 0; i < 2; i++ {
+		content := make([]byte, 2000)
+		rand.Read(content)
+		n, err := p1.AddFile(ctx, bytes.NewReader(content), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		nodes = append(nodes, n.Cid())
+	}
+
+	// The served blocks count in the quota and in the statistics.
+	if found := p2.getCompressed(ctx, nodes[:1]); len(found) != 1 {
+		t.Fatalf("unexpected blocks: %v", found)
+	}
+	stats := p1.PeerStats()
+	if len(stats) != 1 || stats[0].BytesSent == 0 {
+		t.Errorf("the served blocks should be accounted for: %+v", stats)
+	}
+	if found := p2.getCompressed(ctx, nodes[1:]); len(found) != 0 {
+		t.Error("the quota should apply to compressed transfers")
+	}
+}
+
+func TestCompressedLookupTimeout(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	defer func(timeout time.Duration) { compressedLookupTimeout = timeout }(compressedLookupTimeout)
+	compressedLookupTimeout = 200 * time.Millisecond
+
+	p := setupPeer(t, ctx, &Config{CompressedTransfers: true})
+	// Peers which never respond only delay the lookup until the
+	// timeout.
+	for i := 0; i < 3; i++ {
+		h, _ := setupHost(t, ctx)
+		h.SetStreamHandler(p.compressedProtocol(), func(s network.Stream) {
+			<-ctx.Done()
+			s.Reset()
+		})
+		if err := p.host.Connect(ctx, peer.AddrInfo{ID: h.ID(), Addrs: h.Addrs()}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for len(p.compressedPeers()) < 3 {
+		if time.Now().After(deadline) {
+			t.Fatal("the peers were not identified")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	start := time.Now()
+	if found := p.getCompressed(ctx, []cid.Cid{testCid(t, "missing")}); len(found) != 0 {
+		t.Fatalf("unexpected blocks: %v", found)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("the lookup took %s", d)
+	}
+}
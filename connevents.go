@@ -0,0 +1,128 @@
+package ipfslite
+
+import (
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multiaddr"
+)
+
+// connEventsQueueSize is the number of connection events waiting for the
+// hooks, beyond which events are dropped.
+const connEventsQueueSize = 256
+
+// ConnEvent describes a connection of the Peer's host which was opened or
+// closed. A peer can have several connections at the same time.
+type ConnEvent struct {
+	Peer peer.ID
+	// Connected is true when the connection was opened, false when it
+	// was closed.
+	Connected  bool
+	RemoteAddr multiaddr.Multiaddr
+	// Transport is the transport of the connection, as in
+	// ConnStats.Transports, or "p2p-circuit" when relayed.
+	Transport string
+	Direction network.Direction
+	// Relayed is true when the connection goes through the circuit relay
+	// Relay.
+	Relayed bool
+	Relay   peer.ID
+	Time    time.Time
+}
+
+// ConnHook is a function called with the connection events of the Peer's
+// host. Hooks are called in order, one event at a time, and should return
+// quickly.
+type ConnHook func(ConnEvent)
+
+type connHooks struct {
+	mu     sync.RWMutex
+	hooks  []ConnHook
+	events chan ConnEvent
+}
+
+func (h *connHooks) add(hook ConnHook) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.hooks = append(h.hooks, hook)
+}
+
+func (h *connHooks) run(e ConnEvent) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for _, hook := range h.hooks {
+		hook(e)
+	}
+}
+
+func (h *connHooks) empty() bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return len(h.hooks) == 0
+}
+
+// AddConnHook registers a hook which is called when a connection of the
+// Peer's host is opened or closed, so that applications can maintain their
+// own peer tables. It is never called when the Peer is offline.
+func (p *Peer) AddConnHook(hook ConnHook) {
+	p.conns.add(hook)
+}
+
+// newConnEvent describes the given connection.
+func newConnEvent(c network.Conn, connected bool) ConnEvent {
+	addr := c.RemoteMultiaddr()
+	e := ConnEvent{
+		Peer:       c.RemotePeer(),
+		Connected:  connected,
+		RemoteAddr: addr,
+		Direction:  c.Stat().Direction,
+		Time:       time.Now(),
+	}
+	if isRelayAddr(addr) {
+		e.Relayed = true
+		e.Transport = "p2p-circuit"
+		e.Relay, _ = relayID(addr)
+	} else {
+		e.Transport = transportName(addr)
+	}
+	return e
+}
+
+// connNotifiee queues the connection events of the host for the hooks, as
+// libp2p notifications must not block.
+func (p *Peer) connNotifiee() network.Notifiee {
+	notify := func(c network.Conn, connected bool) {
+		if p.conns.empty() {
+			return
+		}
+		select {
+		case p.conns.events <- newConnEvent(c, connected):
+		default:
+			logger.Warnf("connection hooks are too slow: dropping event for %s", c.RemotePeer())
+		}
+	}
+	return &network.NotifyBundle{
+		ConnectedF: func(_ network.Network, c network.Conn) {
+			notify(c, true)
+		},
+		DisconnectedF: func(_ network.Network, c network.Conn) {
+			notify(c, false)
+		},
+	}
+}
+
+// watchConns calls the connection hooks with the events queued by the
+// given notifiee until the Peer is closed.
+func (p *Peer) watchConns(n network.Notifiee) {
+	defer p.host.Network().StopNotify(n)
+	for {
+		select {
+		case <-p.ctx.Done():
+			return
+		case e := <-p.conns.events:
+			p.conns.run(e)
+		}
+	}
+}
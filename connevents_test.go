@@ -0,0 +1,49 @@
+package ipfslite
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+func TestConnHook(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	p1 := setupPeer(t, ctx, nil)
+	p2 := setupPeer(t, ctx, nil)
+
+	events := make(chan ConnEvent, 10)
+	p1.AddConnHook(func(e ConnEvent) {
+		events <- e
+	})
+	next := func() ConnEvent {
+		select {
+		case e := <-events:
+			return e
+		case <-time.After(5 * time.Second):
+			t.Fatal("no connection event")
+			return ConnEvent{}
+		}
+	}
+
+	err := p1.host.Connect(ctx, peer.AddrInfo{ID: p2.ID(), Addrs: p2.Addrs()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := next()
+	if !e.Connected || e.Peer != p2.ID() || e.Transport != "tcp" || e.Direction != network.DirOutbound || e.Relayed || e.RemoteAddr == nil {
+		t.Errorf("unexpected event: %+v", e)
+	}
+
+	err = p1.host.Network().ClosePeer(p2.ID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	e = next()
+	if e.Connected || e.Peer != p2.ID() {
+		t.Errorf("unexpected event: %+v", e)
+	}
+}
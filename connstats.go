@@ -0,0 +1,96 @@
+package ipfslite
+
+import (
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multiaddr"
+)
+
+// ConnStats describes the connections of a Peer's host, to assess how well
+// it traverses NATs.
+type ConnStats struct {
+	// Total is the number of open connections.
+	Total int
+	// Direct is the number of connections made directly to the remote
+	// peer, and Relayed the number of connections going through a
+	// circuit relay.
+	Direct  int
+	Relayed int
+	// Transports counts the direct connections by transport ("tcp",
+	// "quic-v1", "webtransport", "webrtc-direct", "ws", "wss"...).
+	Transports map[string]int
+	// Reservations lists the relays where the host holds a reservation,
+	// that is, which advertise a relayed address for it. They are only
+	// used when the host is not publicly reachable (AutoRelay).
+	Reservations []peer.ID
+}
+
+// ConnStats returns statistics about the open connections of the Peer's
+// host. It returns empty statistics when the Peer is offline.
+func (p *Peer) ConnStats() ConnStats {
+	s := ConnStats{Transports: make(map[string]int)}
+	if p.host == nil {
+		return s
+	}
+
+	for _, c := range p.host.Network().Conns() {
+		s.Total++
+		addr := c.RemoteMultiaddr()
+		if isRelayAddr(addr) {
+			s.Relayed++
+			continue
+		}
+		s.Direct++
+		s.Transports[transportName(addr)]++
+	}
+
+	seen := make(map[peer.ID]struct{})
+	for _, addr := range p.host.Addrs() {
+		if !isRelayAddr(addr) {
+			continue
+		}
+		relay, err := relayID(addr)
+		if err != nil {
+			continue
+		}
+		if _, ok := seen[relay]; !ok {
+			seen[relay] = struct{}{}
+			s.Reservations = append(s.Reservations, relay)
+		}
+	}
+	return s
+}
+
+// relayID returns the ID of the relay in a relayed address
+// (.../p2p/<relay>/p2p-circuit).
+func relayID(addr multiaddr.Multiaddr) (peer.ID, error) {
+	relayAddr, _ := multiaddr.SplitFunc(addr, func(c multiaddr.Component) bool {
+		return c.Protocol().Code == multiaddr.P_CIRCUIT
+	})
+	id, err := relayAddr.ValueForProtocol(multiaddr.P_P2P)
+	if err != nil {
+		return "", err
+	}
+	return peer.Decode(id)
+}
+
+// transportName returns the name of the transport of a direct connection
+// address: the last of its protocols which identifies a transport.
+func transportName(addr multiaddr.Multiaddr) string {
+	name := "unknown"
+	multiaddr.ForEach(addr, func(c multiaddr.Component) bool {
+		switch c.Protocol().Code {
+		case multiaddr.P_TCP, multiaddr.P_UDP, multiaddr.P_QUIC, multiaddr.P_QUIC_V1,
+			multiaddr.P_WEBTRANSPORT, multiaddr.P_WEBRTC_DIRECT, multiaddr.P_WEBRTC,
+			multiaddr.P_WS, multiaddr.P_WSS:
+			name = c.Protocol().Name
+		}
+		return true
+	})
+	// /tls/ws is equivalent to /wss.
+	if name == "ws" {
+		if _, err := addr.ValueForProtocol(multiaddr.P_TLS); err == nil {
+			name = "wss"
+		}
+	}
+	return name
+}
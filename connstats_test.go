@@ -0,0 +1,46 @@
+package ipfslite
+
+import (
+	"context"
+	"testing"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multiaddr"
+)
+
+func TestConnStats(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	p1 := setupPeer(t, ctx, nil)
+	p2 := setupPeer(t, ctx, nil)
+
+	err := p1.host.Connect(ctx, peer.AddrInfo{ID: p2.ID(), Addrs: p2.Addrs()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := p1.ConnStats()
+	if s.Total != 1 || s.Direct != 1 || s.Relayed != 0 || s.Transports["tcp"] != 1 {
+		t.Errorf("unexpected stats: %+v", s)
+	}
+}
+
+func TestTransportName(t *testing.T) {
+	for addr, name := range map[string]string{
+		"/ip4/1.2.3.4/tcp/4001":                            "tcp",
+		"/ip4/1.2.3.4/udp/4001/quic-v1":                    "quic-v1",
+		"/ip4/1.2.3.4/udp/4001/quic-v1/webtransport":       "webtransport",
+		"/dns4/example.com/tcp/443/wss":                    "wss",
+		"/dns4/example.com/tcp/443/tls/sni/example.com/ws": "wss",
+		"/ip4/1.2.3.4/tcp/80/ws":                           "ws",
+	} {
+		if got := transportName(multiaddr.StringCast(addr)); got != name {
+			t.Errorf("%s: expected %s, got %s", addr, name, got)
+		}
+	}
+
+	relay := "12D3KooWDpJ7As7BWAwRMfu1VU2WCqNjvq387JEYKDBj4kx6nXTN"
+	id, err := relayID(multiaddr.StringCast("/ip4/1.2.3.4/tcp/4001/p2p/" + relay + "/p2p-circuit"))
+	if err != nil || id.String() != relay {
+		t.Errorf("unexpected relay ID %s (%v)", id, err)
+	}
+}
@@ -0,0 +1,134 @@
+package ipfslite
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/ipfs/go-cid"
+	ipld "github.com/ipfs/go-ipld-format"
+	dagpb "github.com/ipld/go-codec-dagpb"
+	"github.com/ipld/go-ipld-prime/codec/dagjson"
+	"github.com/ipld/go-ipld-prime/datamodel"
+	"github.com/ipld/go-ipld-prime/multicodec"
+	"github.com/ipld/go-ipld-prime/node/basicnode"
+	mc "github.com/multiformats/go-multicodec"
+
+	// Register the decoders of the supported codecs.
+	_ "github.com/ipld/go-ipld-prime/codec/cbor"
+	_ "github.com/ipld/go-ipld-prime/codec/dagcbor"
+	_ "github.com/ipld/go-ipld-prime/codec/json"
+)
+
+// ErrUnsupportedCodec is returned when rendering a block whose codec cannot
+// be decoded.
+var ErrUnsupportedCodec = errors.New("unsupported codec")
+
+// GetDAGAsJSON retrieves the block with the given CID, from the local
+// blockstore or from the network, and renders it as dag-json, where links
+// are {"/": "<cid>"} objects and bytes {"/": {"bytes": "<base64>"}}. It
+// supports the dag-cbor, dag-json, cbor, json and dag-pb codecs, so that
+// IPLD data can be handed to web clients.
+func (p *Peer) GetDAGAsJSON(ctx context.Context, c cid.Cid, opts ...FetchOption) ([]byte, error) {
+	n, err := p.Fetch(ctx, c, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return renderDAGJSON(c, n.RawData())
+}
+
+// renderDAGJSON decodes a block with the codec of its CID and encodes it as
+// dag-json.
+func renderDAGJSON(c cid.Cid, data []byte) ([]byte, error) {
+	codec := c.Prefix().Codec
+	var proto datamodel.NodePrototype = basicnode.Prototype.Any
+	switch mc.Code(codec) {
+	case mc.DagCbor, mc.DagJson, mc.Cbor, mc.Json:
+	case mc.DagPb:
+		proto = dagpb.Type.PBNode
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedCodec, mc.Code(codec))
+	}
+	decode, err := multicodec.LookupDecoder(codec)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedCodec, mc.Code(codec))
+	}
+	nb := proto.NewBuilder()
+	if err := decode(nb, bytes.NewReader(data)); err != nil {
+		return nil, fmt.Errorf("cannot decode %s: %w", c, err)
+	}
+	var buf bytes.Buffer
+	if err := dagjson.Encode(nb.Build(), &buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// wantsJSON tells whether a gateway request asks for plain JSON, with the
+// format query parameter or the Accept header.
+func wantsJSON(r *http.Request) bool {
+	if format := r.URL.Query().Get("format"); format != "" {
+		return format == "json"
+	}
+	accept := r.Header.Get("Accept")
+	return strings.Contains(accept, "application/json") && !strings.Contains(accept, "text/html")
+}
+
+// rendersAsJSON tells whether renderJSON serves the request for the given
+// root CID: a GET or HEAD request for plain JSON, without any path below a
+// dag-cbor or cbor root.
+func rendersAsJSON(r *http.Request, root cid.Cid) bool {
+	codec := mc.Code(root.Prefix().Codec)
+	if codec != mc.DagCbor && codec != mc.Cbor {
+		return false
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+	rest := strings.TrimPrefix(r.URL.Path, "/ipfs/")
+	return strings.TrimSuffix(rest, "/") == root.String() && wantsJSON(r)
+}
+
+// renderJSON serves the dag-cbor and cbor documents requested as JSON on
+// /ipfs/<cid> paths, rendered as dag-json, which the gateway would reject.
+// Documents with JSON codecs are already served as they are.
+func (p *Peer) renderJSON(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, ok := gatewayRoot(r.URL.Path)
+		if !ok || !rendersAsJSON(r, c) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		etag := `"` + c.String() + `.json"`
+		w.Header().Set("Etag", etag)
+		w.Header().Set("Cache-Control", "public, max-age=29030400, immutable")
+		w.Header().Set("X-Ipfs-Path", r.URL.Path)
+		w.Header().Set("X-Ipfs-Roots", c.String())
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		data, err := p.GetDAGAsJSON(r.Context(), c)
+		switch {
+		case errors.Is(err, context.DeadlineExceeded):
+			http.Error(w, err.Error(), http.StatusGatewayTimeout)
+			return
+		case ipld.IsNotFound(err):
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.WriteHeader(http.StatusOK)
+		if r.Method == http.MethodGet {
+			w.Write(data)
+		}
+	})
+}
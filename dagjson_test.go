@@ -0,0 +1,75 @@
+package ipfslite
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	cbor "github.com/ipfs/go-ipld-cbor"
+	"github.com/multiformats/go-multihash"
+)
+
+func TestGetDAGAsJSON(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	p, file := setupGatewayPeer(t, ctx, []byte("hello json"))
+
+	node, err := cbor.WrapObject(map[string]interface{}{"name": "doc", "file": file}, multihash.SHA2_256, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Add(ctx, node); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := p.GetDAGAsJSON(ctx, node.Cid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc struct {
+		Name string
+		File map[string]string
+	}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc.Name != "doc" || doc.File["/"] != file.String() {
+		t.Errorf("unexpected document: %s", data)
+	}
+
+	// dag-pb nodes are rendered too.
+	if _, err := p.GetDAGAsJSON(ctx, file); err != nil {
+		t.Error(err)
+	}
+	raw := cid.NewCidV1(cid.Raw, testCid(t, "raw").Hash())
+	if _, err := renderDAGJSON(raw, []byte("raw")); !errors.Is(err, ErrUnsupportedCodec) {
+		t.Errorf("expected ErrUnsupportedCodec, got %v", err)
+	}
+
+	h, err := p.Gateway(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest(http.MethodGet, "/ipfs/"+node.Cid().String(), nil)
+	req.Header.Set("Accept", "application/json")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "application/json" || rec.Body.String() != string(data) {
+		t.Fatalf("unexpected response: %d %s %s", rec.Code, rec.Header().Get("Content-Type"), rec.Body)
+	}
+
+	rec = gatewayGet(t, h, "", "/ipfs/"+node.Cid().String()+"?format=json")
+	if rec.Code != http.StatusOK || rec.Body.String() != string(data) {
+		t.Errorf("unexpected response: %d %s", rec.Code, rec.Body)
+	}
+
+	// dag-json is still served by the gateway.
+	rec = gatewayGet(t, h, "", "/ipfs/"+node.Cid().String()+"?format=dag-json")
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "application/vnd.ipld.dag-json" {
+		t.Errorf("unexpected response: %d %s", rec.Code, rec.Header().Get("Content-Type"))
+	}
+}
@@ -0,0 +1,221 @@
+package ipfslite
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	provider "github.com/ipfs/boxo/provider"
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/routing"
+	"github.com/multiformats/go-multihash"
+)
+
+// DHTQueryEvent is a step of a DHT query.
+type DHTQueryEvent struct {
+	// Elapsed is the time since the query started.
+	Elapsed time.Duration
+	// Type tells whether a query is sent to Peer (routing.SendingQuery),
+	// Peer responded (routing.PeerResponse, with Responses closer peers or
+	// providers), failed (routing.QueryError, see Extra)...
+	Type      routing.QueryEventType
+	Peer      peer.ID
+	Responses int
+	Extra     string
+}
+
+// DHTQuery describes a DHT lookup made by the Peer, passed to the hooks
+// registered with Peer.AddDHTQueryHook.
+type DHTQuery struct {
+	// Op is the routing operation: "FindProviders", "Provide",
+	// "FindPeer", "GetValue", "SearchValue", "PutValue" or "ProvideMany"
+	// (whose Key is empty).
+	Op string
+	// Key is the CID, peer ID or record key looked up.
+	Key      string
+	Start    time.Time
+	Duration time.Duration
+	// PeersQueried is the number of distinct peers the query was sent to.
+	PeersQueried int
+	// Events are the steps of the query, in order.
+	Events []DHTQueryEvent
+	Err    error
+}
+
+// DHTQueryHook is a function called when a DHT query made by the Peer
+// finishes. It must not block.
+type DHTQueryHook func(DHTQuery)
+
+type dhtQueryHooks struct {
+	mu    sync.RWMutex
+	hooks []DHTQueryHook
+}
+
+func (h *dhtQueryHooks) add(hook DHTQueryHook) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.hooks = append(h.hooks, hook)
+}
+
+func (h *dhtQueryHooks) empty() bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return len(h.hooks) == 0
+}
+
+func (h *dhtQueryHooks) run(q DHTQuery) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for _, hook := range h.hooks {
+		hook(q)
+	}
+}
+
+// AddDHTQueryHook registers a hook which is called with the details of
+// every DHT query made by the Peer (when providing and fetching content,
+// resolving IPNS names...), i.e. to diagnose slow routing in production
+// without enabling debug logging. Config.TraceDHTQueries must be set.
+// Queries are only traced while hooks are registered.
+func (p *Peer) AddDHTQueryHook(hook DHTQueryHook) {
+	p.dhtQueries.add(hook)
+}
+
+// tracedRouting reports the queries of a routing.Routing to DHT query
+// hooks, using the query events of the DHT.
+type tracedRouting struct {
+	routing.Routing
+	hooks *dhtQueryHooks
+}
+
+// tracedManyRouting is a tracedRouting for routers which provide many CIDs
+// at once.
+type tracedManyRouting struct {
+	*tracedRouting
+}
+
+func newTracedRouting(r routing.Routing, hooks *dhtQueryHooks) routing.Routing {
+	tr := &tracedRouting{Routing: r, hooks: hooks}
+	if _, ok := r.(provider.ProvideMany); ok {
+		return &tracedManyRouting{tr}
+	}
+	return tr
+}
+
+// query traces a query until done is called with its error.
+func (r *tracedRouting) query(ctx context.Context, op, key string) (context.Context, func(error)) {
+	if r.hooks.empty() {
+		return ctx, func(error) {}
+	}
+	q := DHTQuery{Op: op, Key: key, Start: time.Now()}
+	qctx, cancel := context.WithCancel(ctx)
+	qctx, events := routing.RegisterForQueryEvents(qctx)
+	collected := make(chan struct{})
+	go func() {
+		defer close(collected)
+		queried := make(map[peer.ID]struct{})
+		for ev := range events {
+			if ev.Type == routing.SendingQuery {
+				queried[ev.ID] = struct{}{}
+			}
+			q.Events = append(q.Events, DHTQueryEvent{
+				Elapsed:   time.Since(q.Start),
+				Type:      ev.Type,
+				Peer:      ev.ID,
+				Responses: len(ev.Responses),
+				Extra:     ev.Extra,
+			})
+		}
+		q.PeersQueried = len(queried)
+	}()
+	return qctx, func(err error) {
+		cancel()
+		<-collected
+		q.Duration = time.Since(q.Start)
+		q.Err = err
+		r.hooks.run(q)
+	}
+}
+
+func (r *tracedRouting) Provide(ctx context.Context, c cid.Cid, announce bool) error {
+	ctx, done := r.query(ctx, "Provide", c.String())
+	err := r.Routing.Provide(ctx, c, announce)
+	done(err)
+	return err
+}
+
+func (r *tracedRouting) FindProvidersAsync(ctx context.Context, c cid.Cid, count int) <-chan peer.AddrInfo {
+	ctx, done := r.query(ctx, "FindProviders", c.String())
+	in := r.Routing.FindProvidersAsync(ctx, c, count)
+	out := make(chan peer.AddrInfo)
+	go func() {
+		defer close(out)
+		defer func() { done(ctx.Err()) }()
+		for pi := range in {
+			select {
+			case out <- pi:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out
+}
+
+func (r *tracedRouting) FindPeer(ctx context.Context, id peer.ID) (peer.AddrInfo, error) {
+	ctx, done := r.query(ctx, "FindPeer", id.String())
+	pi, err := r.Routing.FindPeer(ctx, id)
+	done(err)
+	return pi, err
+}
+
+func (r *tracedRouting) PutValue(ctx context.Context, key string, value []byte, opts ...routing.Option) error {
+	ctx, done := r.query(ctx, "PutValue", key)
+	err := r.Routing.PutValue(ctx, key, value, opts...)
+	done(err)
+	return err
+}
+
+func (r *tracedRouting) GetValue(ctx context.Context, key string, opts ...routing.Option) ([]byte, error) {
+	ctx, done := r.query(ctx, "GetValue", key)
+	value, err := r.Routing.GetValue(ctx, key, opts...)
+	done(err)
+	return value, err
+}
+
+func (r *tracedRouting) SearchValue(ctx context.Context, key string, opts ...routing.Option) (<-chan []byte, error) {
+	ctx, done := r.query(ctx, "SearchValue", key)
+	in, err := r.Routing.SearchValue(ctx, key, opts...)
+	if err != nil {
+		done(err)
+		return nil, err
+	}
+	out := make(chan []byte)
+	go func() {
+		defer close(out)
+		defer func() { done(ctx.Err()) }()
+		for v := range in {
+			select {
+			case out <- v:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out, nil
+}
+
+func (r *tracedManyRouting) ProvideMany(ctx context.Context, keys []multihash.Multihash) error {
+	ctx, done := r.query(ctx, "ProvideMany", "")
+	err := r.Routing.(provider.ProvideMany).ProvideMany(ctx, keys)
+	done(err)
+	return err
+}
+
+// Ready tells whether the underlying router is ready, when it tells.
+func (r *tracedManyRouting) Ready() bool {
+	if ready, ok := r.Routing.(provider.Ready); ok {
+		return ready.Ready()
+	}
+	return true
+}
@@ -0,0 +1,61 @@
+package ipfslite
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	dualdht "github.com/libp2p/go-libp2p-kad-dht/dual"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/routing"
+)
+
+func TestDHTQueryHook(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	p1 := setupPeer(t, ctx, &Config{TraceDHTQueries: true})
+	p2 := setupPeer(t, ctx, nil)
+
+	queries := make(chan DHTQuery, 10)
+	p1.AddDHTQueryHook(func(q DHTQuery) {
+		queries <- q
+	})
+	err := p1.host.Connect(ctx, peer.AddrInfo{ID: p2.host.ID(), Addrs: p2.host.Addrs()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Local peers are in the LAN DHT.
+	rt := p1.dht.(*tracedRouting).Routing.(*dualdht.DHT).LAN.RoutingTable()
+	for start := time.Now(); rt.Size() == 0; time.Sleep(10 * time.Millisecond) {
+		if time.Since(start) > 5*time.Second {
+			t.Fatal("the DHT routing table is empty")
+		}
+	}
+
+	c := testCid(t, "nobody provides this")
+	qctx, qcancel := context.WithTimeout(ctx, 2*time.Second)
+	defer qcancel()
+	for range p1.dht.FindProvidersAsync(qctx, c, 1) {
+	}
+
+	select {
+	case q := <-queries:
+		if q.Op != "FindProviders" || q.Key != c.String() {
+			t.Errorf("unexpected query %s %s", q.Op, q.Key)
+		}
+		if q.PeersQueried != 1 {
+			t.Errorf("expected 1 peer queried, got %d", q.PeersQueried)
+		}
+		sent := false
+		for _, ev := range q.Events {
+			if ev.Type == routing.SendingQuery && ev.Peer == p2.host.ID() {
+				sent = true
+			}
+		}
+		if !sent {
+			t.Errorf("no query event for the other peer: %+v", q.Events)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the hook was not called")
+	}
+}
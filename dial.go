@@ -0,0 +1,128 @@
+package ipfslite
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	libp2p "github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/p2p/net/swarm"
+	"github.com/multiformats/go-multiaddr"
+)
+
+// Dial ranking strategies that can be used in Config.DialRanking.
+const (
+	// DialRankingSmart uses the libp2p smart dialing defaults: QUIC is
+	// preferred and dials to further addresses are staggered.
+	DialRankingSmart = "smart"
+	// DialRankingNoDelay dials all addresses of a peer at once.
+	DialRankingNoDelay = "no-delay"
+	// DialRankingQUICFirst dials QUIC addresses first and the rest after
+	// Config.DialDelay.
+	DialRankingQUICFirst = "quic-first"
+	// DialRankingTCPFirst dials TCP addresses first and the rest after
+	// Config.DialDelay.
+	DialRankingTCPFirst = "tcp-first"
+)
+
+var defaultDialDelay = 250 * time.Millisecond
+
+// dialOptions returns the libp2p options corresponding to the dialing
+// settings in the Config.
+func (cfg *Config) dialOptions() ([]libp2p.Option, error) {
+	var opts []libp2p.Option
+	if cfg.DialTimeout > 0 {
+		opts = append(opts, libp2p.WithDialTimeout(cfg.DialTimeout))
+	}
+
+	delay := cfg.DialDelay
+	if delay <= 0 {
+		delay = defaultDialDelay
+	}
+	concurrency := cfg.DialConcurrency
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+
+	var ranker network.DialRanker
+	switch cfg.DialRanking {
+	case "", DialRankingSmart:
+	case DialRankingNoDelay:
+		ranker = swarm.NoDelayDialRanker
+	case DialRankingQUICFirst:
+		ranker = preferDialRanker(isQUICAddr, concurrency, delay)
+	case DialRankingTCPFirst:
+		ranker = preferDialRanker(isTCPAddr, concurrency, delay)
+	default:
+		return nil, fmt.Errorf("unknown dial ranking: %s", cfg.DialRanking)
+	}
+
+	if cfg.DialsPerSecond < 0 {
+		return nil, fmt.Errorf("invalid DialsPerSecond: %f", cfg.DialsPerSecond)
+	}
+	if cfg.DialsPerSecond > 0 {
+		if ranker == nil {
+			ranker = swarm.DefaultDialRanker
+		}
+		ranker = rateLimitedDialRanker(ranker, newTokenBucket(cfg.DialsPerSecond, cfg.DialBurst))
+	}
+	if ranker != nil {
+		opts = append(opts, libp2p.DialRanker(ranker))
+	}
+	return opts, nil
+}
+
+// preferDialRanker returns a dial ranker which dials the addresses matching
+// prefer before the rest, and circuit-relay addresses last. Addresses are
+// dialed in groups of concurrency, each group delayed by delay with respect
+// to the previous one.
+func preferDialRanker(prefer func(multiaddr.Multiaddr) bool, concurrency int, delay time.Duration) network.DialRanker {
+	rank := func(a multiaddr.Multiaddr) int {
+		switch {
+		case isRelayAddr(a):
+			return 2
+		case prefer(a):
+			return 0
+		default:
+			return 1
+		}
+	}
+
+	return func(addrs []multiaddr.Multiaddr) []network.AddrDelay {
+		sorted := make([]multiaddr.Multiaddr, len(addrs))
+		copy(sorted, addrs)
+		sort.SliceStable(sorted, func(i, j int) bool {
+			return rank(sorted[i]) < rank(sorted[j])
+		})
+
+		res := make([]network.AddrDelay, 0, len(sorted))
+		for i, a := range sorted {
+			res = append(res, network.AddrDelay{
+				Addr:  a,
+				Delay: time.Duration(i/concurrency) * delay,
+			})
+		}
+		return res
+	}
+}
+
+func isQUICAddr(a multiaddr.Multiaddr) bool {
+	_, err := a.ValueForProtocol(multiaddr.P_QUIC_V1)
+	return err == nil
+}
+
+func isTCPAddr(a multiaddr.Multiaddr) bool {
+	_, err := a.ValueForProtocol(multiaddr.P_TCP)
+	if err != nil {
+		return false
+	}
+	// WebSocket addresses run over TCP but are not plain TCP.
+	_, err = a.ValueForProtocol(multiaddr.P_WS)
+	return err != nil
+}
+
+func isRelayAddr(a multiaddr.Multiaddr) bool {
+	_, err := a.ValueForProtocol(multiaddr.P_CIRCUIT)
+	return err == nil
+}
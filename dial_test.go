@@ -0,0 +1,42 @@
+package ipfslite
+
+import (
+	"testing"
+	"time"
+
+	"github.com/multiformats/go-multiaddr"
+)
+
+func TestPreferDialRanker(t *testing.T) {
+	addrs := []multiaddr.Multiaddr{
+		multiaddr.StringCast("/ip4/1.2.3.4/tcp/4001/p2p/QmcgpsyWgH8Y8ajJz1Cu72KnS5uo2Aa2LpzU7kinSupNKC/p2p-circuit"),
+		multiaddr.StringCast("/ip4/1.2.3.4/tcp/4001"),
+		multiaddr.StringCast("/ip4/1.2.3.4/udp/4001/quic-v1"),
+		multiaddr.StringCast("/ip4/1.2.3.4/tcp/4002/ws"),
+	}
+
+	ranker := preferDialRanker(isQUICAddr, 2, time.Second)
+	res := ranker(addrs)
+	expected := []struct {
+		addr  multiaddr.Multiaddr
+		delay time.Duration
+	}{
+		{addrs[2], 0},
+		{addrs[1], 0},
+		{addrs[3], time.Second},
+		{addrs[0], time.Second},
+	}
+	if len(res) != len(expected) {
+		t.Fatalf("unexpected ranking: %v", res)
+	}
+	for i, e := range expected {
+		if !res[i].Addr.Equal(e.addr) || res[i].Delay != e.delay {
+			t.Errorf("%d: expected %s (%s), got %s (%s)", i, e.addr, e.delay, res[i].Addr, res[i].Delay)
+		}
+	}
+
+	cfg := &Config{DialRanking: "fastest"}
+	if _, err := cfg.Libp2pOptions(); err == nil {
+		t.Error("expected an error for an unknown dial ranking")
+	}
+}
@@ -0,0 +1,280 @@
+package ipfslite
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/ipfs/boxo/ipld/merkledag"
+	"github.com/ipfs/boxo/ipld/unixfs"
+	"github.com/ipfs/go-cid"
+	cbor "github.com/ipfs/go-ipld-cbor"
+	ipld "github.com/ipfs/go-ipld-format"
+	"github.com/multiformats/go-multihash"
+)
+
+// fileKeySize is the size of the AES keys generated for each encrypted file.
+const fileKeySize = 32
+
+// transformingDAGService transforms the nodes added to it before adding them
+// to the wrapped DAGService. The links of the nodes are rewritten to point
+// to the transformed children, which must be added first (as DAG builders
+// do), so that the transformed DAG can be traversed.
+type transformingDAGService struct {
+	ipld.DAGService
+	tr BlockTransformer
+
+	mu   sync.Mutex
+	cids map[cid.Cid]cid.Cid
+}
+
+func newTransformingDAGService(ds ipld.DAGService, tr BlockTransformer) *transformingDAGService {
+	return &transformingDAGService{
+		DAGService: ds,
+		tr:         tr,
+		cids:       make(map[cid.Cid]cid.Cid),
+	}
+}
+
+func (ds *transformingDAGService) Add(ctx context.Context, n ipld.Node) error {
+	return ds.AddMany(ctx, []ipld.Node{n})
+}
+
+func (ds *transformingDAGService) AddMany(ctx context.Context, nds []ipld.Node) error {
+	transformed := make([]ipld.Node, 0, len(nds))
+	for _, n := range nds {
+		tn, err := ds.transform(ctx, n)
+		if err != nil {
+			return err
+		}
+		transformed = append(transformed, tn)
+	}
+	return ds.DAGService.AddMany(ctx, transformed)
+}
+
+// transformed returns the CID of the transformed version of a node.
+func (ds *transformingDAGService) transformed(c cid.Cid) (cid.Cid, bool) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	tc, ok := ds.cids[c]
+	return tc, ok
+}
+
+func (ds *transformingDAGService) transform(ctx context.Context, n ipld.Node) (ipld.Node, error) {
+	orig := n.Cid()
+	if pn, ok := n.(*merkledag.ProtoNode); ok && len(pn.Links()) > 0 {
+		cp := merkledag.NodeWithData(pn.Data())
+		if err := cp.SetCidBuilder(pn.CidBuilder()); err != nil {
+			return nil, err
+		}
+		for _, l := range pn.Links() {
+			tc, ok := ds.transformed(l.Cid)
+			if !ok {
+				return nil, fmt.Errorf("%s links to %s, which was not added", orig, l.Cid)
+			}
+			err := cp.AddRawLink(l.Name, &ipld.Link{Name: l.Name, Size: l.Size, Cid: tc})
+			if err != nil {
+				return nil, err
+			}
+		}
+		n = cp
+	}
+
+	blk, err := ds.tr.Encode(ctx, n)
+	if err != nil {
+		return nil, err
+	}
+	ds.mu.Lock()
+	ds.cids[orig] = blk.Cid()
+	ds.mu.Unlock()
+	return merkledag.DecodeRawBlock(blk)
+}
+
+// AddFileEncrypted chunks and adds content like AddFile, but encrypts every
+// block of the resulting DAG with AES-GCM using a new random key for the
+// file. Only encrypted blocks are stored and exchanged. The file key is
+// encrypted with the given master key (16, 24 or 32 bytes) and stored,
+// along with the root of the encrypted DAG, in a small envelope node whose
+// CID is returned. The file can be read with GetFileDecrypted by anyone
+// holding the master key. params.Stats is not supported.
+func (p *Peer) AddFileEncrypted(ctx context.Context, r io.Reader, params *AddParams, masterKey []byte) (cid.Cid, error) {
+	if params == nil {
+		params = &AddParams{}
+	}
+	entry, err := p.journalStart(ctx, params, true)
+	if err != nil {
+		return cid.Undef, err
+	}
+	c, err := p.addFileEncrypted(ctx, r, params, masterKey)
+	p.journalFinish(entry, c, err)
+	return c, err
+}
+
+func (p *Peer) addFileEncrypted(ctx context.Context, r io.Reader, params *AddParams, masterKey []byte) (cid.Cid, error) {
+	r, err := p.checkAddLimits(r, params, 0)
+	if err != nil {
+		return cid.Undef, err
+	}
+	master, err := newGCM(masterKey)
+	if err != nil {
+		return cid.Undef, err
+	}
+	fileKey := make([]byte, fileKeySize)
+	if _, err := rand.Read(fileKey); err != nil {
+		return cid.Undef, err
+	}
+	tr, err := NewAESTransformer(fileKey)
+	if err != nil {
+		return cid.Undef, err
+	}
+
+	batch := newAddBatch(p, p.addWorkers)
+	tds := newTransformingDAGService(batch, tr)
+	n, size, err := buildFile(r, params, tds)
+	if cerr := batch.Commit(ctx); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return cid.Undef, err
+	}
+	root, _ := tds.transformed(n.Cid())
+
+	wrappedKey, err := sealGCM(master, fileKey)
+	if err != nil {
+		return cid.Undef, err
+	}
+	env, err := cbor.WrapObject(map[string]interface{}{
+		"root": root,
+		"key":  wrappedKey,
+	}, multihash.SHA2_256, -1)
+	if err != nil {
+		return cid.Undef, err
+	}
+	err = p.Add(ctx, env)
+	if err != nil {
+		return cid.Undef, err
+	}
+	p.ingestFile(ctx, IngestFileAdded, env.Cid(), size, params.Path)
+	if err := p.provide(ctx, env.Cid()); err != nil {
+		return cid.Undef, err
+	}
+	return env.Cid(), nil
+}
+
+// GetFileDecrypted returns a reader for a file added with AddFileEncrypted,
+// given the CID of its envelope and the master key used to add it. Blocks
+// which are not available locally are fetched from the network and
+// decrypted as the file is read. The reader must be closed.
+func (p *Peer) GetFileDecrypted(ctx context.Context, c cid.Cid, masterKey []byte) (io.ReadCloser, error) {
+	master, err := newGCM(masterKey)
+	if err != nil {
+		return nil, err
+	}
+	env, err := p.Get(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	var m map[string]interface{}
+	err = cbor.DecodeInto(env.RawData(), &m)
+	if err != nil {
+		return nil, fmt.Errorf("%s is not an encrypted file: %w", c, err)
+	}
+	root, ok := m["root"].(cid.Cid)
+	wrappedKey, ok2 := m["key"].([]byte)
+	if !ok || !ok2 {
+		return nil, fmt.Errorf("%s is not an encrypted file", c)
+	}
+	fileKey, err := openGCM(master, wrappedKey)
+	if err != nil {
+		return nil, fmt.Errorf("cannot decrypt the key of %s: %w", c, err)
+	}
+	tr, err := NewAESTransformer(fileKey)
+	if err != nil {
+		return nil, err
+	}
+	n, err := p.getTransformed(ctx, tr, root)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(p.writeTransformedFile(ctx, tr, n, pw))
+	}()
+	return &decryptedFile{PipeReader: pr, cancel: cancel}, nil
+}
+
+// writeTransformedFile writes the content of a transformed UnixFS file DAG.
+func (p *Peer) writeTransformedFile(ctx context.Context, tr BlockTransformer, n ipld.Node, w io.Writer) error {
+	switch n := n.(type) {
+	case *merkledag.RawNode:
+		_, err := w.Write(n.RawData())
+		return err
+	case *merkledag.ProtoNode:
+		fsn, err := unixfs.FSNodeFromBytes(n.Data())
+		if err != nil {
+			return err
+		}
+		if t := fsn.Type(); t != unixfs.TFile && t != unixfs.TRaw {
+			return errors.New("not a file")
+		}
+		if _, err := w.Write(fsn.Data()); err != nil {
+			return err
+		}
+		for _, l := range n.Links() {
+			child, err := p.getTransformed(ctx, tr, l.Cid)
+			if err != nil {
+				return err
+			}
+			if err := p.writeTransformedFile(ctx, tr, child, w); err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		return errors.New("not a file")
+	}
+}
+
+// decryptedFile is returned by GetFileDecrypted.
+type decryptedFile struct {
+	*io.PipeReader
+	cancel context.CancelFunc
+}
+
+func (f *decryptedFile) Close() error {
+	f.cancel()
+	return f.PipeReader.Close()
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// sealGCM encrypts data, prefixing the result with a random nonce.
+func sealGCM(aead cipher.AEAD, data []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(data)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, data, nil), nil
+}
+
+// openGCM decrypts data encrypted with sealGCM.
+func openGCM(aead cipher.AEAD, data []byte) ([]byte, error) {
+	if len(data) < aead.NonceSize() {
+		return nil, errors.New("encrypted data too short")
+	}
+	nonce, data := data[:aead.NonceSize()], data[aead.NonceSize():]
+	return aead.Open(nil, nonce, data, nil)
+}
@@ -0,0 +1,65 @@
+package ipfslite
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"io"
+	"testing"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+func TestAddFileEncrypted(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	p1 := setupPeer(t, ctx, nil)
+	p2 := setupPeer(t, ctx, nil)
+	err := p2.host.Connect(ctx, peer.AddrInfo{ID: p1.host.ID(), Addrs: p1.host.Addrs()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	content := make([]byte, 300*1024)
+	if _, err := rand.Read(content); err != nil {
+		t.Fatal(err)
+	}
+	masterKey := []byte("0123456789abcdef0123456789abcdef")
+	params := &AddParams{Chunker: "size-1024"}
+	c, err := p1.AddFileEncrypted(ctx, bytes.NewReader(content), params, masterKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The plaintext DAG is not stored.
+	plain, err := New(ctx, NewInMemoryDatastore(), nil, nil, nil, &Config{Offline: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err := plain.AddFile(ctx, bytes.NewReader(content), &AddParams{Chunker: "size-1024"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if has, _ := p1.HasBlock(ctx, n.Cid()); has {
+		t.Error("the plaintext root should not be stored")
+	}
+
+	r, err := p2.GetFileDecrypted(ctx, c, masterKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := io.ReadAll(r)
+	r.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, content) {
+		t.Error("decrypted content does not match")
+	}
+
+	_, err = p2.GetFileDecrypted(ctx, c, []byte("fedcba9876543210fedcba9876543210"))
+	if err == nil {
+		t.Error("decrypting with the wrong master key should fail")
+	}
+}
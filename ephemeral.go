@@ -0,0 +1,65 @@
+package ipfslite
+
+import (
+	"context"
+	"io"
+	"time"
+
+	dht "github.com/libp2p/go-libp2p-kad-dht"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multiaddr"
+)
+
+// Timeouts of ephemeral peers, which favor failing fast.
+const (
+	ephemeralFetchTimeout          = 2 * time.Minute
+	ephemeralProviderSearchTimeout = 15 * time.Second
+	ephemeralDialTimeout           = 5 * time.Second
+)
+
+// NewEphemeralPeer returns a throwaway Peer for short-lived jobs (i.e.
+// fetching some content from a CLI tool), bootstrapped to the public IPFS
+// network. It uses a new Ed25519 identity, in-memory storage, a DHT in
+// client mode, aggressive timeouts, and does not provide its content. The
+// host and the DHT are owned by the Peer, and closed with it.
+func NewEphemeralPeer(ctx context.Context) (*Peer, error) {
+	return newEphemeralPeer(ctx, DefaultBootstrapPeers())
+}
+
+func newEphemeralPeer(ctx context.Context, bootstrap []peer.AddrInfo) (*Peer, error) {
+	priv, _, err := crypto.GenerateKeyPair(crypto.Ed25519, 0)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &Config{
+		ReprovideInterval:     -1,
+		UncachedBlockstore:    true,
+		FetchTimeout:          ephemeralFetchTimeout,
+		ProviderSearchTimeout: ephemeralProviderSearchTimeout,
+		DialTimeout:           ephemeralDialTimeout,
+		OwnHost:               true,
+		OwnDHT:                true,
+	}
+	listen := []multiaddr.Multiaddr{
+		multiaddr.StringCast("/ip4/0.0.0.0/tcp/0"),
+		multiaddr.StringCast("/ip4/0.0.0.0/udp/0/quic-v1"),
+	}
+	h, r, err := cfg.SetupLibp2p(ctx, priv, nil, listen, nil, dht.ModeClient)
+	if err != nil {
+		return nil, err
+	}
+	p, err := New(ctx, NewInMemoryDatastore(), nil, h, r, cfg)
+	if err != nil {
+		if c, ok := r.(io.Closer); ok {
+			c.Close()
+		}
+		h.Close()
+		return nil, err
+	}
+
+	if len(bootstrap) > 0 {
+		p.BootstrapContext(ctx, bootstrap)
+	}
+	return p, nil
+}
@@ -0,0 +1,52 @@
+package ipfslite
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+func TestEphemeralPeer(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	server := setupPeer(t, ctx, nil)
+	content := []byte("fetched by an ephemeral peer")
+	n, err := server.AddFile(ctx, bytes.NewReader(content), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	epCtx, epCancel := context.WithCancel(ctx)
+	p, err := newEphemeralPeer(epCtx, []peer.AddrInfo{{ID: server.host.ID(), Addrs: server.host.Addrs()}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rc, err := p.GetFile(ctx, n.Cid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := io.ReadAll(rc)
+	rc.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, content) {
+		t.Error("unexpected content")
+	}
+
+	// The host is closed with the context.
+	epCancel()
+	deadline := time.Now().Add(5 * time.Second)
+	for server.host.Network().Connectedness(p.host.ID()) == network.Connected {
+		if time.Now().After(deadline) {
+			t.Fatal("the ephemeral host was not closed")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
@@ -0,0 +1,311 @@
+package ipfslite
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"github.com/ipfs/boxo/ipld/merkledag"
+	ufsio "github.com/ipfs/boxo/ipld/unixfs/io"
+	"github.com/ipfs/go-cid"
+	ipld "github.com/ipfs/go-ipld-format"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/routing"
+)
+
+// ErrMaxBlocks is returned by fetches needing more blocks than allowed by
+// WithMaxBlocks.
+var ErrMaxBlocks = errors.New("maximum number of blocks exceeded")
+
+// FetchOption configures how content is retrieved from the network by
+// methods like Fetch and GetFile.
+type FetchOption func(*fetchOptions)
+
+type fetchOptions struct {
+	providers    []peer.AddrInfo
+	priority     int
+	timeout      time.Duration
+	hasTimeout   bool
+	maxBlocks    int
+	maxProviders int
+	partial      bool
+}
+
+func newFetchOptions(opts []FetchOption) *fetchOptions {
+	fopts := &fetchOptions{}
+	for _, o := range opts {
+		o(fopts)
+	}
+	return fopts
+}
+
+// WithProviders provides peers which are known to have the requested
+// content. The Peer connects to them, best ranked first (see
+// Config.ProviderOrdering), before requesting any blocks, so that they are
+// asked first and no DHT lookups are needed when they have the content.
+func WithProviders(providers []peer.AddrInfo) FetchOption {
+	return func(o *fetchOptions) {
+		o.providers = append(o.providers, providers...)
+	}
+}
+
+// WithPriority sets the priority of the fetch. When the number of parallel
+// fetches is limited (see Config.MaxParallelFetches), fetches with higher
+// priority are started first. Defaults to FetchPriorityNormal.
+func WithPriority(priority int) FetchOption {
+	return func(o *fetchOptions) {
+		o.priority = priority
+	}
+}
+
+// WithTimeout sets how long the fetch may take, overriding
+// Config.FetchTimeout. Zero or negative values disable the timeout. For
+// GetFile, the timeout covers reading the file until the reader is closed.
+func WithTimeout(timeout time.Duration) FetchOption {
+	return func(o *fetchOptions) {
+		o.timeout = timeout
+		o.hasTimeout = true
+	}
+}
+
+// WithMaxBlocks limits the number of blocks which a fetch may retrieve,
+// from the local blockstore or from the network. Fetches needing more
+// blocks fail with ErrMaxBlocks. Zero or negative values (the default) mean
+// no limit.
+func WithMaxBlocks(n int) FetchOption {
+	return func(o *fetchOptions) {
+		o.maxBlocks = n
+	}
+}
+
+// WithMaxProviders makes the fetch look up at most n providers of the
+// requested CID in the content routing system, and connect to them, before
+// requesting any blocks. The lookup is bounded by the fetch context and by
+// Config.ProviderSearchTimeout, and skipped when the block is available
+// locally. Bitswap may still search for the providers of other blocks of
+// the DAG on its own.
+func WithMaxProviders(n int) FetchOption {
+	return func(o *fetchOptions) {
+		o.maxProviders = n
+	}
+}
+
+// fetchContext returns a context bounded by the fetch timeout.
+func (p *Peer) fetchContext(ctx context.Context, opts *fetchOptions) (context.Context, context.CancelFunc) {
+	timeout := p.cfg.FetchTimeout
+	if opts.hasTimeout {
+		timeout = opts.timeout
+	}
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// Fetch retrieves the node with the given CID, from the local blockstore or
+// from the network, according to the given options.
+func (p *Peer) Fetch(ctx context.Context, c cid.Cid, opts ...FetchOption) (ipld.Node, error) {
+	fopts := newFetchOptions(opts)
+	ctx, cancel := p.fetchContext(ctx, fopts)
+	defer cancel()
+	if p.coalesces(fopts) {
+		return p.joinFetch(ctx, fetchKey{c: c}, fopts, func(ctx context.Context, _ *coalescedFetch) (ipld.Node, error) {
+			return p.fetch(ctx, c, fopts)
+		})
+	}
+	return p.fetch(ctx, c, fopts)
+}
+
+func (p *Peer) fetch(ctx context.Context, c cid.Cid, opts *fetchOptions) (ipld.Node, error) {
+	release, err := p.scheduler.acquire(ctx, opts.priority)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	return p.fetchSession(ctx, c, opts).Get(ctx, c)
+}
+
+// FetchDAG retrieves the whole DAG below the given root into the local
+// blockstore, according to the given options.
+func (p *Peer) FetchDAG(ctx context.Context, root cid.Cid, opts ...FetchOption) error {
+	fopts := newFetchOptions(opts)
+	ctx, cancel := p.fetchContext(ctx, fopts)
+	defer cancel()
+	if p.coalesces(fopts) {
+		_, err := p.joinFetch(ctx, fetchKey{c: root, dag: true}, fopts, func(ctx context.Context, f *coalescedFetch) (ipld.Node, error) {
+			return nil, p.fetchDAG(ctx, root, fopts, &f.nodes)
+		})
+		return err
+	}
+	return p.fetchDAG(ctx, root, fopts, nil)
+}
+
+// fetchDAG retrieves the DAG below root, counting the retrieved nodes in
+// nodes when not nil.
+func (p *Peer) fetchDAG(ctx context.Context, root cid.Cid, opts *fetchOptions, nodes *int64) error {
+	release, err := p.scheduler.acquire(ctx, opts.priority)
+	if err != nil {
+		return err
+	}
+	defer release()
+	ng := p.fetchSession(ctx, root, opts)
+	if nodes != nil {
+		ng = &countingNodeGetter{NodeGetter: ng, nodes: nodes}
+	}
+	return merkledag.FetchGraph(ctx, root, merkledag.NewReadOnlyDagService(ng))
+}
+
+// fetchSession prepares a session-based NodeGetter to retrieve the DAG
+// below root according to the given options.
+func (p *Peer) fetchSession(ctx context.Context, root cid.Cid, opts *fetchOptions) ipld.NodeGetter {
+	p.dialPreferredPeers(ctx)
+	providers := append(opts.providers, p.lookupProviders(ctx, root, opts.maxProviders)...)
+	p.connectProviders(ctx, p.orderProviders(providers))
+	ng := merkledag.NewSession(ctx, p.DAGService)
+	if opts.maxBlocks > 0 {
+		ng = &budgetNodeGetter{NodeGetter: ng, remaining: int64(opts.maxBlocks)}
+	}
+	return ng
+}
+
+// lookupProviders returns at most max providers of the given CID, unless it
+// is available locally.
+func (p *Peer) lookupProviders(ctx context.Context, c cid.Cid, max int) []peer.AddrInfo {
+	if max <= 0 || p.cfg.Offline || p.dht == nil {
+		return nil
+	}
+	if has, _ := p.HasBlock(ctx, c); has {
+		return nil
+	}
+	if p.cfg.ProviderSearchTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.cfg.ProviderSearchTimeout)
+		defer cancel()
+	}
+	var providers []peer.AddrInfo
+	for prov := range p.dht.FindProvidersAsync(ctx, c, max) {
+		providers = append(providers, prov)
+		if len(providers) == max {
+			break
+		}
+	}
+	return providers
+}
+
+// connectProviders dials the given providers, in parallel, and returns
+// once the best ranked reachable one is connected: providers are ranked by
+// their order (see orderProviders). The other dials go on in the
+// background, so that bitswap sends its wants to those providers as they
+// connect. It is a best-effort operation: errors are only logged.
+func (p *Peer) connectProviders(ctx context.Context, providers []peer.AddrInfo) {
+	if p.cfg.Offline || p.host == nil || len(providers) == 0 {
+		return
+	}
+
+	results := make([]chan error, 0, len(providers))
+	for _, pinfo := range providers {
+		if pinfo.ID == p.host.ID() {
+			continue
+		}
+		res := make(chan error, 1)
+		results = append(results, res)
+		go func(pinfo peer.AddrInfo) {
+			err := p.host.Connect(ctx, pinfo)
+			if err != nil {
+				logger.Warnf("error connecting to provider %s: %s", pinfo.ID, err)
+			} else if p.reconnect != nil && p.reconnect.cfg.Partners {
+				p.reconnect.watchWhile(ctx, pinfo)
+			}
+			res <- err
+		}(pinfo)
+	}
+	for _, res := range results {
+		if err := <-res; err == nil {
+			return
+		}
+	}
+}
+
+// scheduledDagReader releases the fetch slot it holds, and cancels its
+// context, when closed.
+type scheduledDagReader struct {
+	ufsio.DagReader
+	release func()
+	cancel  context.CancelFunc
+}
+
+func (r *scheduledDagReader) Close() error {
+	r.release()
+	err := r.DagReader.Close()
+	r.cancel()
+	return err
+}
+
+// budgetNodeGetter fails with ErrMaxBlocks once it has returned the
+// allowed number of nodes.
+type budgetNodeGetter struct {
+	ipld.NodeGetter
+	remaining int64
+}
+
+func (ng *budgetNodeGetter) take() bool {
+	return atomic.AddInt64(&ng.remaining, -1) >= 0
+}
+
+func (ng *budgetNodeGetter) Get(ctx context.Context, c cid.Cid) (ipld.Node, error) {
+	if !ng.take() {
+		return nil, ErrMaxBlocks
+	}
+	return ng.NodeGetter.Get(ctx, c)
+}
+
+func (ng *budgetNodeGetter) GetMany(ctx context.Context, cids []cid.Cid) <-chan *ipld.NodeOption {
+	ctx, cancel := context.WithCancel(ctx)
+	nodes := ng.NodeGetter.GetMany(ctx, cids)
+	out := make(chan *ipld.NodeOption)
+	go func() {
+		defer cancel()
+		defer close(out)
+		for opt := range nodes {
+			exceeded := opt.Err == nil && !ng.take()
+			if exceeded {
+				opt = &ipld.NodeOption{Err: ErrMaxBlocks}
+			}
+			select {
+			case out <- opt:
+			case <-ctx.Done():
+				return
+			}
+			if exceeded {
+				// Stop retrieving the remaining nodes.
+				return
+			}
+		}
+	}()
+	return out
+}
+
+// providerSearchRouter bounds the duration of provider searches.
+type providerSearchRouter struct {
+	routing.ContentRouting
+	timeout time.Duration
+}
+
+func (r *providerSearchRouter) FindProvidersAsync(ctx context.Context, c cid.Cid, count int) <-chan peer.AddrInfo {
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	provs := r.ContentRouting.FindProvidersAsync(ctx, c, count)
+	out := make(chan peer.AddrInfo)
+	go func() {
+		defer cancel()
+		defer close(out)
+		for prov := range provs {
+			select {
+			case out <- prov:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out
+}
@@ -0,0 +1,212 @@
+package ipfslite
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	cbor "github.com/ipfs/go-ipld-cbor"
+	dht "github.com/libp2p/go-libp2p-kad-dht"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/routing"
+	"github.com/multiformats/go-multiaddr"
+	multihash "github.com/multiformats/go-multihash"
+)
+
+// setupPeer creates a peer listening on localhost which is not connected to
+// any other peer.
+func setupPeer(t *testing.T, ctx context.Context, cfg *Config) *Peer {
+	return setupPeerWithDatastore(t, ctx, NewInMemoryDatastore(), cfg)
+}
+
+// setupPeerWithDatastore is like setupPeer, using the given datastore.
+func setupPeerWithDatastore(t *testing.T, ctx context.Context, ds datastore.Batching, cfg *Config) *Peer {
+	priv, _, err := crypto.GenerateKeyPair(crypto.Ed25519, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	listen := multiaddr.StringCast("/ip4/127.0.0.1/tcp/0")
+	h, d, err := SetupLibp2p(ctx, priv, nil, []multiaddr.Multiaddr{listen}, nil, dht.ModeServer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		d.Close()
+		h.Close()
+	})
+	p, err := New(ctx, ds, nil, h, d, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestFetchWithProviders(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	p1 := setupPeer(t, ctx, nil)
+	p2 := setupPeer(t, ctx, nil)
+
+	codec := uint64(multihash.SHA2_256)
+	node, err := cbor.WrapObject(map[string]string{"akey": "avalue"}, codec, multihash.DefaultLengths[codec])
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = p1.Add(ctx, node)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	providers := []peer.AddrInfo{{ID: p1.host.ID(), Addrs: p1.host.Addrs()}}
+	n, err := p2.Fetch(ctx, node.Cid(), WithProviders(providers))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !n.Cid().Equals(node.Cid()) {
+		t.Error("fetched the wrong node")
+	}
+}
+
+func TestFetchTimeout(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	p := setupPeer(t, ctx, &Config{FetchTimeout: 200 * time.Millisecond})
+	c := testCid(t, "nobody has this")
+
+	start := time.Now()
+	_, err := p.Fetch(ctx, c)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected a deadline error, got %v", err)
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Error("fetch did not time out")
+	}
+
+	// Per-call override.
+	_, err = p.GetFile(ctx, c, WithTimeout(100*time.Millisecond))
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected a deadline error, got %v", err)
+	}
+}
+
+func TestProviderSearchTimeout(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	r := &providerSearchRouter{ContentRouting: blockingRouter{&mockRouter{}}, timeout: 100 * time.Millisecond}
+	done := make(chan struct{})
+	go func() {
+		for range r.FindProvidersAsync(ctx, testCid(t, "a"), 1) {
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("provider search did not time out")
+	}
+}
+
+// blockingRouter finds no providers until its context is cancelled.
+type blockingRouter struct {
+	*mockRouter
+}
+
+func (blockingRouter) FindProvidersAsync(ctx context.Context, _ cid.Cid, _ int) <-chan peer.AddrInfo {
+	ch := make(chan peer.AddrInfo)
+	go func() {
+		<-ctx.Done()
+		close(ch)
+	}()
+	return ch
+}
+
+func TestFetchWithMaxBlocks(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	p, err := New(ctx, NewInMemoryDatastore(), nil, nil, nil, &Config{Offline: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Three leaves and a root.
+	data := make([]byte, 3*1024)
+	rand.Read(data)
+	n, err := p.AddFile(ctx, bytes.NewReader(data), &AddParams{Chunker: "size-1024"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = p.FetchDAG(ctx, n.Cid(), WithMaxBlocks(2))
+	if !errors.Is(err, ErrMaxBlocks) {
+		t.Errorf("expected ErrMaxBlocks, got %v", err)
+	}
+	err = p.FetchDAG(ctx, n.Cid(), WithMaxBlocks(4))
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+// staticProvidersRouter finds the given providers for every CID.
+type staticProvidersRouter struct {
+	routing.Routing
+	providers []peer.AddrInfo
+}
+
+func (r *staticProvidersRouter) FindProvidersAsync(ctx context.Context, _ cid.Cid, count int) <-chan peer.AddrInfo {
+	ch := make(chan peer.AddrInfo, len(r.providers))
+	for _, prov := range r.providers {
+		ch <- prov
+	}
+	close(ch)
+	return ch
+}
+
+func TestFetchWithMaxProviders(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	p2 := setupPeer(t, ctx, nil)
+	p3 := setupPeer(t, ctx, nil)
+
+	priv, _, err := crypto.GenerateKeyPair(crypto.Ed25519, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	listen := multiaddr.StringCast("/ip4/127.0.0.1/tcp/0")
+	h, d, err := SetupLibp2p(ctx, priv, nil, []multiaddr.Multiaddr{listen}, nil, dht.ModeServer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+	defer d.Close()
+	router := &staticProvidersRouter{
+		Routing: d,
+		providers: []peer.AddrInfo{
+			{ID: p2.host.ID(), Addrs: p2.host.Addrs()},
+			{ID: p3.host.ID(), Addrs: p3.host.Addrs()},
+		},
+	}
+	p1, err := New(ctx, NewInMemoryDatastore(), nil, h, router, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	node, _ := cbor.WrapObject(map[string]string{"max": "providers"}, multihash.SHA2_256, -1)
+	if err := p2.Add(ctx, node); err != nil {
+		t.Fatal(err)
+	}
+	// Bitswap waits before searching for providers itself, so the
+	// fetch only succeeds in time with the providers looked up first.
+	_, err = p1.Fetch(ctx, node.Cid(), WithMaxProviders(1), WithTimeout(500*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.Network().Connectedness(p3.host.ID()) == network.Connected {
+		t.Error("only one provider should have been connected")
+	}
+}
@@ -0,0 +1,201 @@
+//go:build linux || darwin
+
+// Package fuse mounts UnixFS directories pinned by an IPFS-Lite Peer as
+// read-only FUSE file systems on Linux and macOS, so that existing tools can
+// browse IPFS content from an embedded node. It is a separate module so that
+// ipfs-lite itself does not depend on go-fuse.
+package fuse
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"path"
+	"sync"
+	"syscall"
+
+	ipfslite "github.com/dcnetio/ipfs-lite"
+	gofs "github.com/hanwen/go-fuse/v2/fs"
+	gofuse "github.com/hanwen/go-fuse/v2/fuse"
+	"github.com/ipfs/go-cid"
+)
+
+// Mount mounts the UnixFS directory root, which must be pinned recursively,
+// read-only at mountpoint. Files are read from the local blockstore with the
+// seekable UnixFS readers of ipfslite.ReadOnlyFS. The context bounds all
+// the reads, and unmounts the file system when done. opts may be nil.
+//
+// The returned server can be used to wait for, or force, the unmount.
+func Mount(ctx context.Context, p *ipfslite.Peer, root cid.Cid, mountpoint string, opts *gofs.Options) (*gofuse.Server, error) {
+	fsys, err := p.ReadOnlyFS(ctx, root)
+	if err != nil {
+		return nil, err
+	}
+	if opts == nil {
+		opts = &gofs.Options{}
+	}
+	if opts.MountOptions.Name == "" {
+		opts.MountOptions.Name = "ipfs-lite"
+	}
+	if opts.MountOptions.FsName == "" {
+		opts.MountOptions.FsName = root.String()
+	}
+	opts.MountOptions.Options = append(opts.MountOptions.Options, "ro")
+
+	server, err := gofs.Mount(mountpoint, &node{fsys: fsys, name: "."}, opts)
+	if err != nil {
+		return nil, err
+	}
+	unmounted := make(chan struct{})
+	go func() {
+		server.Wait()
+		close(unmounted)
+	}()
+	go func() {
+		select {
+		case <-ctx.Done():
+			// This fails while the mount is busy, in which case
+			// it is left to the caller.
+			server.Unmount()
+		case <-unmounted:
+		}
+	}()
+	return server, nil
+}
+
+// node is a file, directory or symbolic link of the mounted file system,
+// identified by its path in the ReadOnlyFS.
+type node struct {
+	gofs.Inode
+	fsys *ipfslite.ReadOnlyFS
+	name string
+}
+
+var (
+	_ gofs.NodeLookuper   = (*node)(nil)
+	_ gofs.NodeGetattrer  = (*node)(nil)
+	_ gofs.NodeReaddirer  = (*node)(nil)
+	_ gofs.NodeOpener     = (*node)(nil)
+	_ gofs.NodeReadlinker = (*node)(nil)
+)
+
+func (n *node) Lookup(ctx context.Context, name string, out *gofuse.EntryOut) (*gofs.Inode, syscall.Errno) {
+	child := path.Join(n.name, name)
+	info, err := n.fsys.Stat(child)
+	if err != nil {
+		return nil, errno(err)
+	}
+	setAttr(&out.Attr, info)
+	return n.NewInode(ctx, &node{fsys: n.fsys, name: child}, gofs.StableAttr{Mode: fileType(info.Mode())}), 0
+}
+
+func (n *node) Getattr(ctx context.Context, fh gofs.FileHandle, out *gofuse.AttrOut) syscall.Errno {
+	info, err := n.fsys.Stat(n.name)
+	if err != nil {
+		return errno(err)
+	}
+	setAttr(&out.Attr, info)
+	return 0
+}
+
+func (n *node) Readdir(ctx context.Context) (gofs.DirStream, syscall.Errno) {
+	entries, err := n.fsys.ReadDir(n.name)
+	if err != nil {
+		return nil, errno(err)
+	}
+	list := make([]gofuse.DirEntry, 0, len(entries))
+	for _, e := range entries {
+		list = append(list, gofuse.DirEntry{Name: e.Name(), Mode: fileType(e.Type())})
+	}
+	return gofs.NewListDirStream(list), 0
+}
+
+func (n *node) Open(ctx context.Context, flags uint32) (gofs.FileHandle, uint32, syscall.Errno) {
+	if flags&(syscall.O_WRONLY|syscall.O_RDWR|syscall.O_TRUNC|syscall.O_APPEND) != 0 {
+		return nil, 0, syscall.EROFS
+	}
+	f, err := n.fsys.Open(n.name)
+	if err != nil {
+		return nil, 0, errno(err)
+	}
+	ra, ok := f.(io.ReaderAt)
+	if !ok {
+		f.Close()
+		return nil, 0, syscall.EISDIR
+	}
+	// The content never changes, so the kernel can keep its cache.
+	return &handle{f: f, ra: ra}, gofuse.FOPEN_KEEP_CACHE, 0
+}
+
+func (n *node) Readlink(ctx context.Context) ([]byte, syscall.Errno) {
+	// Opening a symbolic link of a ReadOnlyFS reads its target.
+	target, err := fs.ReadFile(n.fsys, n.name)
+	if err != nil {
+		return nil, errno(err)
+	}
+	return target, 0
+}
+
+// handle is an open file of the mounted file system.
+type handle struct {
+	once sync.Once
+	f    fs.File
+	ra   io.ReaderAt
+}
+
+var (
+	_ gofs.FileReader   = (*handle)(nil)
+	_ gofs.FileReleaser = (*handle)(nil)
+)
+
+func (h *handle) Read(ctx context.Context, dest []byte, off int64) (gofuse.ReadResult, syscall.Errno) {
+	n, err := h.ra.ReadAt(dest, off)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, errno(err)
+	}
+	return gofuse.ReadResultData(dest[:n]), 0
+}
+
+func (h *handle) Release(ctx context.Context) syscall.Errno {
+	h.once.Do(func() { h.f.Close() })
+	return 0
+}
+
+// setAttr fills the FUSE attributes from the description of a UnixFS node.
+func setAttr(out *gofuse.Attr, info fs.FileInfo) {
+	out.Mode = fileType(info.Mode()) | uint32(info.Mode().Perm())
+	out.Size = uint64(info.Size())
+	out.Blocks = (out.Size + 511) / 512
+	if t := info.ModTime(); !t.IsZero() {
+		out.SetTimes(&t, &t, &t)
+	}
+}
+
+// fileType returns the FUSE file type bits for the given mode.
+func fileType(mode fs.FileMode) uint32 {
+	switch {
+	case mode.IsDir():
+		return syscall.S_IFDIR
+	case mode&fs.ModeSymlink != 0:
+		return syscall.S_IFLNK
+	default:
+		return syscall.S_IFREG
+	}
+}
+
+// errno maps the errors of the ReadOnlyFS to FUSE error numbers.
+func errno(err error) syscall.Errno {
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return syscall.ENOENT
+	case errors.Is(err, fs.ErrInvalid):
+		return syscall.EINVAL
+	case errors.Is(err, fs.ErrPermission):
+		return syscall.EACCES
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return syscall.EINTR
+	default:
+		return syscall.EIO
+	}
+}
@@ -0,0 +1,548 @@
+package ipfslite
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gabriel-vasile/mimetype"
+	"github.com/ipfs/boxo/gateway"
+	"github.com/ipfs/boxo/ipld/merkledag"
+	ufsio "github.com/ipfs/boxo/ipld/unixfs/io"
+	"github.com/ipfs/boxo/namesys"
+	"github.com/ipfs/go-cid"
+	madns "github.com/multiformats/go-multiaddr-dns"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// GatewayConfig configures the HTTP gateway returned by Peer.Gateway.
+type GatewayConfig struct {
+	// Hostnames lists the hostnames under which the gateway is publicly
+	// reachable (i.e. "example.com" or "localhost"). Content is served on
+	// /ipfs/ and /ipns/ paths on these hostnames, or on subdomains of
+	// them when Subdomains is set.
+	Hostnames []string
+	// Subdomains enables subdomain gateway mode on Hostnames: requests
+	// to example.com/ipfs/<cid> are redirected to
+	// <cid>.ipfs.example.com, so that every website gets its own origin.
+	// It requires wildcard DNS records (and TLS certificates) for the
+	// hostnames.
+	Subdomains bool
+	// InlineDNSLink makes subdomain redirects for DNSLink names use a
+	// single DNS label (en.wikipedia-on-ipfs.org.ipns.example.com becomes
+	// en-wikipedia--on--ipfs-org.ipns.example.com), to work with
+	// wildcard TLS certificates. It should be set when using HTTPS.
+	InlineDNSLink bool
+	// DNSLink enables hosting websites with DNSLink: requests for any
+	// other hostname with a DNSLink TXT record (_dnslink.<hostname>) are
+	// served with the content it points to.
+	DNSLink bool
+	// DNSResolver is used to resolve DNSLink records. Defaults to the
+	// system resolver, with DNS-over-HTTPS for non-ICANN TLDs (i.e. .eth).
+	DNSResolver madns.BasicResolver
+	// Headers are added to every response.
+	Headers map[string][]string
+	// NoDirectoryListing disables the HTML listings generated for
+	// directories without an index.html file. Such requests get a 404
+	// response instead.
+	NoDirectoryListing bool
+	// Trustless restricts the gateway to verifiable responses: raw blocks
+	// (?format=raw), CAR files (?format=car) and IPNS records
+	// (?format=ipns-record), which clients can check against the
+	// requested CIDs. This is the mode to use when the gateway acts as a
+	// content source for other nodes. Otherwise, deserialized responses
+	// (files, directories, and dag-json/dag-cbor documents) are served as
+	// well.
+	Trustless bool
+	// Timeout bounds how long requests may take, including the retrieval
+	// of content from the network, overriding Config.GatewayTimeout.
+	// Negative values disable the timeout.
+	Timeout time.Duration
+	// Auth, when set, requires requests to carry a bearer token granting
+	// ScopeRead. See RequireScope.
+	Auth *AuthConfig
+	// MutableMaxAge sets the max-age of the Cache-Control header of
+	// /ipns/ and DNSLink responses when the TTL of the name is unknown.
+	// By default, such responses carry no Cache-Control header. Responses
+	// for /ipfs/ paths are always cacheable forever.
+	MutableMaxAge time.Duration
+	// AccessLog, when set, is called after every request with its
+	// details (CID, path, status, size, duration and client), i.e. to
+	// write structured access logs or to bill usage.
+	AccessLog func(GatewayAccess)
+	// Metrics, when set, is where the Prometheus metrics of the gateway
+	// requests are registered (i.e. prometheus.DefaultRegisterer): the
+	// ipfslite_gateway_requests_total, ipfslite_gateway_response_bytes_total
+	// and ipfslite_gateway_request_duration_seconds metrics, labeled by
+	// route (see GatewayRouteIPFS). Gateways sharing a registerer share
+	// their metrics.
+	Metrics prometheus.Registerer
+}
+
+// Gateway returns an HTTP handler serving the Peer's content, which can be
+// mounted on an http.Server like any other handler. Content is retrieved
+// from the network when not available locally, and /ipns/ names are
+// resolved using the Peer's router.
+//
+// Static websites are served like on public gateways: directories are
+// served with their index.html file, or with a listing of their contents.
+// The _redirects file at the root of a website is honored on subdomain and
+// DNSLink hosts, but not on /ipfs/ paths, where websites do not have their
+// own origin.
+//
+// Range requests are served by seeking in the requested files, so that
+// videos can be streamed and downloads resumed.
+//
+// Responses carry the caching headers expected by CDNs: an ETag based on
+// the CID of the content, a Cache-Control header marking /ipfs/ responses
+// as immutable, and a Last-Modified header with the modification time of
+// UnixFS files which recorded one (UnixFS 1.5). Conditional requests
+// (If-None-Match and If-Modified-Since) get 304 responses.
+//
+// Besides, the response format can be chosen with the format query
+// parameter or the Accept header: raw blocks (raw,
+// application/vnd.ipld.raw), CAR files (car, application/vnd.ipld.car),
+// and dag-json or dag-cbor documents (dag-json, dag-cbor,
+// application/vnd.ipld.dag-json...), as specified for IPFS HTTP gateways.
+// Unless Trustless is set, dag-cbor documents requested as plain JSON
+// (format=json or Accept: application/json) are rendered as dag-json (see
+// Peer.GetDAGAsJSON), for web clients.
+func (p *Peer) Gateway(cfg *GatewayConfig) (http.Handler, error) {
+	if cfg == nil {
+		cfg = &GatewayConfig{}
+	}
+
+	dns := cfg.DNSResolver
+	if dns == nil {
+		r, err := gateway.NewDNSResolver(nil)
+		if err != nil {
+			return nil, err
+		}
+		dns = r
+	}
+
+	vs := p.valueStore()
+	ns, err := namesys.NewNameSystem(vs, namesys.WithDNSResolver(dns))
+	if err != nil {
+		return nil, err
+	}
+	backend, err := gateway.NewBlocksBackend(p.bserv,
+		gateway.WithValueStore(vs),
+		gateway.WithNameSystem(ns),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	gwConf := gateway.Config{
+		Headers:               cfg.Headers,
+		DeserializedResponses: !cfg.Trustless,
+		NoDNSLink:             !cfg.DNSLink,
+		PublicGateways:        make(map[string]*gateway.PublicGateway),
+	}
+	for _, h := range cfg.Hostnames {
+		gwConf.PublicGateways[h] = &gateway.PublicGateway{
+			Paths:                 []string{"/ipfs", "/ipns"},
+			UseSubdomains:         cfg.Subdomains,
+			InlineDNSLink:         cfg.InlineDNSLink,
+			NoDNSLink:             !cfg.DNSLink,
+			DeserializedResponses: !cfg.Trustless,
+		}
+	}
+
+	handler := gateway.NewHandler(gwConf, backend)
+	mux := http.NewServeMux()
+	ipfsHandler := http.Handler(handler)
+	if !cfg.Trustless {
+		ipfsHandler = p.renderJSON(ipfsHandler)
+	}
+	mux.Handle("/ipfs/", p.countAccesses(ipfsHandler))
+	mux.Handle("/ipns/", handler)
+	var gw http.Handler = gateway.NewHostnameHandler(gwConf, backend, mux)
+	if cfg.NoDirectoryListing {
+		gw = noDirListing(gw)
+	}
+	gw = p.cacheHeaders(cfg.MutableMaxAge, gw)
+	gw = p.rangeRequests(gw)
+	timeout := p.cfg.GatewayTimeout
+	if cfg.Timeout != 0 {
+		timeout = cfg.Timeout
+	}
+	if timeout > 0 {
+		gw = requestTimeout(timeout, gw)
+	}
+	if cfg.Auth != nil {
+		gw = RequireScope(cfg.Auth, ScopeRead, gw)
+	}
+	var metrics *gatewayMetrics
+	if cfg.Metrics != nil {
+		metrics, err = newGatewayMetrics(cfg.Metrics)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return accessLog(cfg.AccessLog, metrics, gw), nil
+}
+
+// dirListingEtagPrefix is the prefix of the Etag set by the gateway on
+// generated directory listings.
+const dirListingEtagPrefix = `"DirIndex-`
+
+// noDirListing replaces directory listing responses with 404 responses.
+func noDirListing(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(&noDirListingWriter{ResponseWriter: w}, r)
+	})
+}
+
+type noDirListingWriter struct {
+	http.ResponseWriter
+	wroteHeader bool
+	blocked     bool
+}
+
+func (w *noDirListingWriter) WriteHeader(code int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	if strings.HasPrefix(w.Header().Get("Etag"), dirListingEtagPrefix) {
+		w.blocked = true
+		w.Header().Del("Etag")
+		w.Header().Del("Cache-Control")
+		http.Error(w.ResponseWriter, "404 page not found", http.StatusNotFound)
+		return
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *noDirListingWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.blocked {
+		return len(b), nil
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// cacheHeaders completes the caching headers set by the gateway: it sets
+// Last-Modified on UnixFS files with a modification time, honoring
+// If-Modified-Since, and a default max-age on mutable responses.
+func (p *Peer) cacheHeaders(maxAge time.Duration, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+		next.ServeHTTP(&cacheHeadersWriter{ResponseWriter: w, p: p, req: r, maxAge: maxAge}, r)
+	})
+}
+
+type cacheHeadersWriter struct {
+	http.ResponseWriter
+	p      *Peer
+	req    *http.Request
+	maxAge time.Duration
+
+	wroteHeader bool
+	notModified bool
+}
+
+func (w *cacheHeadersWriter) WriteHeader(code int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	if code != http.StatusOK {
+		w.ResponseWriter.WriteHeader(code)
+		return
+	}
+
+	h := w.Header()
+	if w.maxAge > 0 && h.Get("Cache-Control") == "" && strings.HasPrefix(h.Get("X-Ipfs-Path"), "/ipns/") {
+		h.Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(w.maxAge.Seconds())))
+	}
+	mtime, ok := w.p.etagModTime(w.req.Context(), h.Get("Etag"))
+	if !ok {
+		w.ResponseWriter.WriteHeader(code)
+		return
+	}
+	h.Set("Last-Modified", mtime.UTC().Format(http.TimeFormat))
+	if notModifiedSince(w.req, mtime) {
+		w.notModified = true
+		h.Del("Content-Type")
+		h.Del("Content-Length")
+		h.Del("Content-Encoding")
+		w.ResponseWriter.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *cacheHeadersWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.notModified {
+		return len(b), nil
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// etagModTime returns the modification time of the UnixFS file whose CID is
+// the given ETag, when available locally.
+func (p *Peer) etagModTime(ctx context.Context, etag string) (time.Time, bool) {
+	c, err := cid.Decode(strings.Trim(etag, `"`))
+	if err != nil || c.Type() != cid.DagProtobuf {
+		return time.Time{}, false
+	}
+	blk, err := p.bstore.Get(ctx, c)
+	if err != nil {
+		return time.Time{}, false
+	}
+	n, err := merkledag.DecodeProtobufBlock(blk)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return unixfsModTime(n.(*merkledag.ProtoNode))
+}
+
+// notModifiedSince tells whether the If-Modified-Since header of the
+// request allows a 304 response. It is ignored when If-None-Match is set.
+func notModifiedSince(r *http.Request, mtime time.Time) bool {
+	if r.Header.Get("If-None-Match") != "" {
+		return false
+	}
+	ims, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	if err != nil {
+		return false
+	}
+	return !mtime.Truncate(time.Second).After(ims)
+}
+
+// rangeRequests fixes the partial responses of the gateway, which reads
+// UnixFS files and raw blocks (?format=raw) from the start of the first
+// requested range, so that:
+//
+//   - responses to suffix ranges (bytes=-N), used by video players to read
+//     the metadata at the end of files, and to requests whose If-Range
+//     precondition fails, which must carry the whole file, start at the
+//     right offset;
+//   - responses for ranges which do not start at the beginning of files
+//     without a known extension get a Content-Type, by sniffing the
+//     beginning of the file.
+func (p *Peer) rangeRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rng := r.Header.Get("Range")
+		if rng == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		rw := &rangeWriter{ResponseWriter: w, p: p, req: r, readFrom: firstRangeStart(rng)}
+		next.ServeHTTP(rw, r)
+		// The gateway may not write a body at all.
+		rw.sendReplacement()
+	})
+}
+
+// firstRangeStart returns the offset from which the gateway reads files
+// for the given Range header.
+func firstRangeStart(rng string) int64 {
+	spec, ok := strings.CutPrefix(rng, "bytes=")
+	if !ok {
+		return 0
+	}
+	spec, _, _ = strings.Cut(spec, ",")
+	start, _, _ := strings.Cut(strings.TrimSpace(spec), "-")
+	from, err := strconv.ParseInt(start, 10, 64)
+	if err != nil {
+		return 0
+	}
+	return from
+}
+
+type rangeWriter struct {
+	http.ResponseWriter
+	p        *Peer
+	req      *http.Request
+	readFrom int64
+
+	wroteHeader bool
+	// replacement, when set, is sent instead of the body written by the
+	// gateway.
+	replacement io.Reader
+	closer      io.Closer
+	replaced    bool
+}
+
+func (w *rangeWriter) WriteHeader(code int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	h := w.Header()
+	if code == http.StatusPartialContent && h.Get("Content-Type") == "" {
+		if ctype := w.p.sniffContentType(w.req.Context(), h.Get("Etag")); ctype != "" {
+			h.Set("Content-Type", ctype)
+		}
+	}
+
+	var start int64
+	switch code {
+	case http.StatusOK:
+	case http.StatusPartialContent:
+		var end, size int64
+		if _, err := fmt.Sscanf(h.Get("Content-Range"), "bytes %d-%d/%d", &start, &end, &size); err != nil {
+			w.ResponseWriter.WriteHeader(code)
+			return
+		}
+	default:
+		w.ResponseWriter.WriteHeader(code)
+		return
+	}
+	// Only UnixFS files and raw blocks are read from the wrong offset:
+	// other responses (codecs, directory listings) are passed through.
+	c, raw, ok := rangeETag(h.Get("Etag"))
+	if !ok || start == w.readFrom || w.req.Method != http.MethodGet {
+		w.ResponseWriter.WriteHeader(code)
+		return
+	}
+
+	// The body written by the gateway would start at the wrong offset:
+	// send the right bytes of the file instead.
+	length, err := strconv.ParseInt(h.Get("Content-Length"), 10, 64)
+	if err != nil {
+		w.ResponseWriter.WriteHeader(code)
+		return
+	}
+	var r io.ReadSeekCloser
+	if raw {
+		r, err = w.p.rawBlock(w.req.Context(), c)
+	} else {
+		r, err = w.p.unixfsFile(w.req.Context(), c)
+	}
+	if err != nil {
+		w.replaced = true
+		w.ResponseWriter.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if _, err := r.Seek(start, io.SeekStart); err != nil {
+		r.Close()
+		w.replaced = true
+		w.ResponseWriter.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.replacement = io.LimitReader(r, length)
+	w.closer = r
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *rangeWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.replacement != nil {
+		w.sendReplacement()
+		return len(b), nil
+	}
+	if w.replaced {
+		return len(b), nil
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// sendReplacement sends the replacement body, once.
+func (w *rangeWriter) sendReplacement() {
+	if w.replacement == nil {
+		return
+	}
+	io.Copy(w.ResponseWriter, w.replacement)
+	w.closer.Close()
+	w.replacement = nil
+	w.replaced = true
+}
+
+// rangeETag returns the CID of the content with the given ETag, when it is
+// a UnixFS file (the plain quoted CID) or a raw block (the quoted CID with
+// a .raw suffix, with ?format=raw).
+func rangeETag(etag string) (c cid.Cid, raw bool, ok bool) {
+	if len(etag) < 2 || etag[0] != '"' || etag[len(etag)-1] != '"' {
+		return cid.Undef, false, false
+	}
+	s := etag[1 : len(etag)-1]
+	s, raw = strings.CutSuffix(s, ".raw")
+	c, err := cid.Decode(s)
+	if err != nil {
+		return cid.Undef, false, false
+	}
+	if !raw && c.Type() != cid.DagProtobuf && c.Type() != cid.Raw {
+		return cid.Undef, false, false
+	}
+	return c, raw, true
+}
+
+// unixfsETag returns the CID of the UnixFS file with the given ETag.
+func unixfsETag(etag string) (cid.Cid, bool) {
+	c, raw, ok := rangeETag(etag)
+	return c, ok && !raw
+}
+
+type rawBlockReader struct {
+	*bytes.Reader
+}
+
+func (r rawBlockReader) Close() error {
+	return nil
+}
+
+// rawBlock opens the block with the given CID.
+func (p *Peer) rawBlock(ctx context.Context, c cid.Cid) (io.ReadSeekCloser, error) {
+	blk, err := p.BlockService().GetBlock(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	return rawBlockReader{bytes.NewReader(blk.RawData())}, nil
+}
+
+// unixfsFile opens the UnixFS file with the given CID.
+func (p *Peer) unixfsFile(ctx context.Context, c cid.Cid) (ufsio.DagReader, error) {
+	n, err := p.Get(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	return ufsio.NewDagReader(ctx, n, p)
+}
+
+// sniffContentType detects the content type of the UnixFS file whose CID is
+// the given ETag from its first bytes.
+func (p *Peer) sniffContentType(ctx context.Context, etag string) string {
+	c, ok := unixfsETag(etag)
+	if !ok {
+		return ""
+	}
+	r, err := p.unixfsFile(ctx, c)
+	if err != nil {
+		return ""
+	}
+	defer r.Close()
+	mtype, err := mimetype.DetectReader(r)
+	if err != nil {
+		return ""
+	}
+	return mtype.String()
+}
+
+// requestTimeout sets a deadline on the context of requests.
+func requestTimeout(timeout time.Duration, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
@@ -0,0 +1,437 @@
+package ipfslite
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ipfs/boxo/ipld/merkledag"
+	"github.com/ipfs/boxo/ipld/unixfs"
+	ufsio "github.com/ipfs/boxo/ipld/unixfs/io"
+	"github.com/ipfs/go-cid"
+	cbor "github.com/ipfs/go-ipld-cbor"
+	car "github.com/ipld/go-car/v2"
+	multihash "github.com/multiformats/go-multihash"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+type mockDNS map[string]string
+
+func (r mockDNS) LookupIPAddr(ctx context.Context, name string) ([]net.IPAddr, error) {
+	return nil, errors.New("not found")
+}
+
+func (r mockDNS) LookupTXT(ctx context.Context, name string) ([]string, error) {
+	if txt, ok := r[name]; ok {
+		return []string{txt}, nil
+	}
+	return nil, &net.DNSError{Err: "not found", Name: name, IsNotFound: true}
+}
+
+func gatewayGet(t *testing.T, h http.Handler, host, path string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	req.Host = host
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func setupGatewayPeer(t *testing.T, ctx context.Context, content []byte) (*Peer, cid.Cid) {
+	t.Helper()
+	p, err := New(ctx, NewInMemoryDatastore(), nil, nil, nil, &Config{Offline: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err := p.AddFile(ctx, bytes.NewReader(content), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p, n.Cid()
+}
+
+func TestGatewaySubdomainsAndDNSLink(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	content := []byte("hello gateway")
+	p, c := setupGatewayPeer(t, ctx, content)
+
+	h, err := p.Gateway(&GatewayConfig{
+		Hostnames:   []string{"example.com"},
+		Subdomains:  true,
+		DNSLink:     true,
+		DNSResolver: mockDNS{"_dnslink.site.org.": "dnslink=/ipfs/" + c.String()},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rec := gatewayGet(t, h, "example.com", "/ipfs/"+c.String())
+	if rec.Code != http.StatusMovedPermanently {
+		t.Fatalf("expected redirect, got %d", rec.Code)
+	}
+	loc := rec.Header().Get("Location")
+	if !strings.HasPrefix(loc, "http://"+c.String()+".ipfs.example.com/") {
+		t.Errorf("unexpected redirect location %s", loc)
+	}
+
+	rec = gatewayGet(t, h, c.String()+".ipfs.example.com", "/")
+	if rec.Code != http.StatusOK || !bytes.Equal(rec.Body.Bytes(), content) {
+		t.Errorf("subdomain request failed: %d %q", rec.Code, rec.Body.String())
+	}
+
+	rec = gatewayGet(t, h, "site.org", "/")
+	if rec.Code != http.StatusOK || !bytes.Equal(rec.Body.Bytes(), content) {
+		t.Errorf("DNSLink request failed: %d %q", rec.Code, rec.Body.String())
+	}
+}
+
+func TestGatewayPaths(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	content := []byte("hello gateway")
+	p, c := setupGatewayPeer(t, ctx, content)
+
+	h, err := p.Gateway(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := gatewayGet(t, h, "127.0.0.1:8080", "/ipfs/"+c.String())
+	if rec.Code != http.StatusOK || !bytes.Equal(rec.Body.Bytes(), content) {
+		t.Errorf("path request failed: %d %q", rec.Code, rec.Body.String())
+	}
+}
+
+func addTestDir(t *testing.T, ctx context.Context, p *Peer, files map[string]string) cid.Cid {
+	t.Helper()
+	dirs := make(map[string]ufsio.Directory)
+	var getDir func(path string) ufsio.Directory
+	getDir = func(path string) ufsio.Directory {
+		if d, ok := dirs[path]; ok {
+			return d
+		}
+		d := ufsio.NewDirectory(p)
+		dirs[path] = d
+		return d
+	}
+	getDir("")
+	for name, content := range files {
+		n, err := p.AddFile(ctx, strings.NewReader(content), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		dir, base := "", name
+		if i := strings.LastIndex(name, "/"); i >= 0 {
+			dir, base = name[:i], name[i+1:]
+		}
+		if err := getDir(dir).AddChild(ctx, base, n); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Link subdirectories (one level deep) into the root.
+	for path, d := range dirs {
+		if path == "" {
+			continue
+		}
+		n, err := d.GetNode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := p.Add(ctx, n); err != nil {
+			t.Fatal(err)
+		}
+		if err := dirs[""].AddChild(ctx, path, n); err != nil {
+			t.Fatal(err)
+		}
+	}
+	root, err := dirs[""].GetNode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Add(ctx, root); err != nil {
+		t.Fatal(err)
+	}
+	return root.Cid()
+}
+
+func TestGatewayWebsite(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	p, err := New(ctx, NewInMemoryDatastore(), nil, nil, nil, &Config{Offline: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := addTestDir(t, ctx, p, map[string]string{
+		"index.html":     "<h1>home</h1>",
+		"app/index.html": "<h1>app</h1>",
+		"docs/a.txt":     "a",
+		"_redirects":     "/old /app/ 301\n",
+	})
+	host := root.String() + ".ipfs.example.com"
+
+	h, err := p.Gateway(&GatewayConfig{Hostnames: []string{"example.com"}, Subdomains: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rec := gatewayGet(t, h, host, "/app/")
+	if rec.Code != http.StatusOK || rec.Body.String() != "<h1>app</h1>" {
+		t.Errorf("index.html not served: %d %q", rec.Code, rec.Body.String())
+	}
+	rec = gatewayGet(t, h, host, "/old")
+	if rec.Code != http.StatusMovedPermanently || rec.Header().Get("Location") != "/app/" {
+		t.Errorf("_redirects not applied: %d %s", rec.Code, rec.Header().Get("Location"))
+	}
+	rec = gatewayGet(t, h, host, "/docs/")
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "a.txt") {
+		t.Errorf("directory not listed: %d", rec.Code)
+	}
+
+	h, err = p.Gateway(&GatewayConfig{Hostnames: []string{"example.com"}, Subdomains: true, NoDirectoryListing: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec = gatewayGet(t, h, host, "/docs/")
+	if rec.Code != http.StatusNotFound || strings.Contains(rec.Body.String(), "a.txt") {
+		t.Errorf("directory listing not disabled: %d", rec.Code)
+	}
+	rec = gatewayGet(t, h, host, "/app/")
+	if rec.Code != http.StatusOK || rec.Body.String() != "<h1>app</h1>" {
+		t.Errorf("index.html not served: %d %q", rec.Code, rec.Body.String())
+	}
+}
+
+func TestGatewayFormats(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	p, err := New(ctx, NewInMemoryDatastore(), nil, nil, nil, &Config{Offline: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	codec := uint64(multihash.SHA2_256)
+	node, err := cbor.WrapObject(map[string]string{"akey": "avalue"}, codec, multihash.DefaultLengths[codec])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Add(ctx, node); err != nil {
+		t.Fatal(err)
+	}
+	path := "/ipfs/" + node.Cid().String()
+
+	for _, trustless := range []bool{false, true} {
+		h, err := p.Gateway(&GatewayConfig{Trustless: trustless})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rec := gatewayGet(t, h, "localhost", path+"?format=raw")
+		if rec.Code != http.StatusOK || !bytes.Equal(rec.Body.Bytes(), node.RawData()) {
+			t.Errorf("raw block not served: %d", rec.Code)
+		}
+
+		rec = gatewayGet(t, h, "localhost", path+"?format=car")
+		if rec.Code != http.StatusOK || !strings.HasPrefix(rec.Header().Get("Content-Type"), "application/vnd.ipld.car") {
+			t.Errorf("CAR not served: %d %s", rec.Code, rec.Header().Get("Content-Type"))
+		}
+		cr, err := car.NewBlockReader(rec.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		blk, err := cr.Next()
+		if err != nil || !blk.Cid().Equals(node.Cid()) {
+			t.Errorf("unexpected CAR contents: %v", err)
+		}
+
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("Accept", "application/vnd.ipld.dag-json")
+		rec = httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if trustless {
+			if rec.Code == http.StatusOK {
+				t.Error("trustless gateway should not serve dag-json")
+			}
+			continue
+		}
+		if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"akey":"avalue"`) {
+			t.Errorf("dag-json not served: %d %q", rec.Code, rec.Body.String())
+		}
+	}
+}
+
+func TestGatewayTimeout(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	p := setupPeer(t, ctx, &Config{GatewayTimeout: 200 * time.Millisecond})
+	gw, err := p.Gateway(&GatewayConfig{DNSResolver: mockDNS{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	rec := gatewayGet(t, gw, "localhost", "/ipfs/"+testCid(t, "nobody has this").String())
+	if rec.Code < 500 {
+		t.Errorf("expected an error status, got %d", rec.Code)
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Error("request did not time out")
+	}
+}
+
+func TestGatewayCacheHeaders(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	p, err := New(ctx, NewInMemoryDatastore(), nil, nil, nil, &Config{Offline: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A UnixFS 1.5 file with a modification time.
+	content := []byte("modified file")
+	mtime := time.Date(2023, 4, 5, 6, 7, 8, 0, time.UTC)
+	var unixTime []byte
+	unixTime = protowire.AppendTag(unixTime, 1, protowire.VarintType)
+	unixTime = protowire.AppendVarint(unixTime, uint64(mtime.Unix()))
+	data := unixfs.FilePBData(content, uint64(len(content)))
+	data = protowire.AppendTag(data, unixfsMtimeField, protowire.BytesType)
+	data = protowire.AppendBytes(data, unixTime)
+	n := merkledag.NodeWithData(data)
+	if err := p.Add(ctx, n); err != nil {
+		t.Fatal(err)
+	}
+
+	h, err := p.Gateway(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := gatewayGet(t, h, "127.0.0.1:8080", "/ipfs/"+n.Cid().String())
+	if rec.Code != http.StatusOK || !bytes.Equal(rec.Body.Bytes(), content) {
+		t.Fatalf("request failed: %d %q", rec.Code, rec.Body.String())
+	}
+	if etag := rec.Header().Get("Etag"); etag != `"`+n.Cid().String()+`"` {
+		t.Errorf("unexpected ETag: %s", etag)
+	}
+	if cc := rec.Header().Get("Cache-Control"); !strings.Contains(cc, "immutable") {
+		t.Errorf("unexpected Cache-Control: %s", cc)
+	}
+	if lm := rec.Header().Get("Last-Modified"); lm != mtime.Format(http.TimeFormat) {
+		t.Errorf("unexpected Last-Modified: %s", lm)
+	}
+
+	conditional := func(header, value string) int {
+		req := httptest.NewRequest(http.MethodGet, "/ipfs/"+n.Cid().String(), nil)
+		req.Header.Set(header, value)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	if code := conditional("If-None-Match", `"`+n.Cid().String()+`"`); code != http.StatusNotModified {
+		t.Errorf("If-None-Match: expected 304, got %d", code)
+	}
+	if code := conditional("If-Modified-Since", mtime.Format(http.TimeFormat)); code != http.StatusNotModified {
+		t.Errorf("If-Modified-Since: expected 304, got %d", code)
+	}
+	if code := conditional("If-Modified-Since", mtime.Add(-time.Hour).Format(http.TimeFormat)); code != http.StatusOK {
+		t.Errorf("If-Modified-Since before mtime: expected 200, got %d", code)
+	}
+}
+
+func TestGatewayRange(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	content := append([]byte("%PDF-1.4\n"), make([]byte, 1<<20)...)
+	for i := 9; i < len(content); i++ {
+		content[i] = byte(i)
+	}
+	p, c := setupGatewayPeer(t, ctx, content)
+	h, err := p.Gateway(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	get := func(rng, ifRange string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/ipfs/"+c.String(), nil)
+		req.Header.Set("Range", rng)
+		if ifRange != "" {
+			req.Header.Set("If-Range", ifRange)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := get("bytes=500000-500009", "")
+	if rec.Code != http.StatusPartialContent || !bytes.Equal(rec.Body.Bytes(), content[500000:500010]) {
+		t.Fatalf("range request failed: %d", rec.Code)
+	}
+	if cr := rec.Header().Get("Content-Range"); cr != "bytes 500000-500009/1048585" {
+		t.Errorf("unexpected Content-Range: %s", cr)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "application/pdf" {
+		t.Errorf("unexpected Content-Type: %s", ct)
+	}
+
+	rec = get("bytes=-5", "")
+	if rec.Code != http.StatusPartialContent || !bytes.Equal(rec.Body.Bytes(), content[len(content)-5:]) {
+		t.Errorf("suffix range request failed: %d", rec.Code)
+	}
+
+	// Resuming a download.
+	rec = get("bytes=1048000-", `"`+c.String()+`"`)
+	if rec.Code != http.StatusPartialContent || !bytes.Equal(rec.Body.Bytes(), content[1048000:]) {
+		t.Errorf("If-Range request failed: %d", rec.Code)
+	}
+	rec = get("bytes=1048000-", `"other"`)
+	if rec.Code != http.StatusOK || rec.Body.Len() != len(content) {
+		t.Errorf("expected the whole file for a mismatching If-Range, got %d", rec.Code)
+	}
+
+	// Other responses than UnixFS files are left alone.
+	node, err := cbor.WrapObject(map[string]string{"akey": "avalue"}, multihash.SHA2_256, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Add(ctx, node); err != nil {
+		t.Fatal(err)
+	}
+	for _, format := range []string{"raw", "dag-json", "dag-cbor"} {
+		path := "/ipfs/" + node.Cid().String() + "?format=" + format
+		full := gatewayGet(t, h, "", path).Body.Bytes()
+		if len(full) < 5 {
+			t.Fatalf("unexpected %s response: %q", format, full)
+		}
+		for rng, want := range map[string][]byte{
+			"bytes=-3":  full[len(full)-3:],
+			"bytes=2-4": full[2:5],
+		} {
+			req := httptest.NewRequest(http.MethodGet, path, nil)
+			req.Header.Set("Range", rng)
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			// The gateway ignores ranges for codecs.
+			partial := rec.Code == http.StatusPartialContent && bytes.Equal(rec.Body.Bytes(), want)
+			whole := format != "raw" && rec.Code == http.StatusOK && bytes.Equal(rec.Body.Bytes(), full)
+			if !partial && !whole {
+				t.Errorf("%s range %s: %d %q", format, rng, rec.Code, rec.Body.Bytes())
+			}
+		}
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("Range", "bytes=2-")
+		req.Header.Set("If-Range", `"other"`)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK || !bytes.Equal(rec.Body.Bytes(), full) {
+			t.Errorf("%s with a mismatching If-Range: %d", format, rec.Code)
+		}
+	}
+}
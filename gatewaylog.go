@@ -0,0 +1,195 @@
+package ipfslite
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Gateway routes, as reported in GatewayAccess.Route and in the route label
+// of the gateway metrics.
+const (
+	// GatewayRouteIPFS covers immutable content: /ipfs/ paths and
+	// subdomains.
+	GatewayRouteIPFS = "ipfs"
+	// GatewayRouteIPNS covers mutable content: /ipns/ paths and
+	// subdomains, and DNSLink websites.
+	GatewayRouteIPNS = "ipns"
+	// GatewayRouteOther covers the requests which were not served with
+	// content, i.e. rejected or invalid requests.
+	GatewayRouteOther = "other"
+)
+
+// GatewayAccess describes a request served by the gateway (see
+// GatewayConfig.AccessLog).
+type GatewayAccess struct {
+	Time   time.Time
+	Method string
+	Host   string
+	// Path is the requested path, including the query.
+	Path  string
+	Route string
+	// Cid is the root CID of the served content, when known.
+	Cid    cid.Cid
+	Status int
+	// Bytes is the size of the response body.
+	Bytes    int64
+	Duration time.Duration
+	// Client is the remote address of the client, and ForwardedFor the
+	// X-Forwarded-For header of the request, when set by a proxy.
+	Client       string
+	ForwardedFor string
+}
+
+// gatewayMetrics are the per-route Prometheus metrics of a gateway.
+type gatewayMetrics struct {
+	requests *prometheus.CounterVec
+	bytes    *prometheus.CounterVec
+	duration *prometheus.HistogramVec
+}
+
+// newGatewayMetrics registers the gateway metrics, or reuses the ones
+// registered by another gateway.
+func newGatewayMetrics(reg prometheus.Registerer) (*gatewayMetrics, error) {
+	m := &gatewayMetrics{
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "ipfslite",
+			Subsystem: "gateway",
+			Name:      "requests_total",
+			Help:      "Number of gateway requests, by route, method and status code.",
+		}, []string{"route", "method", "code"}),
+		bytes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "ipfslite",
+			Subsystem: "gateway",
+			Name:      "response_bytes_total",
+			Help:      "Size of the gateway response bodies, by route.",
+		}, []string{"route"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "ipfslite",
+			Subsystem: "gateway",
+			Name:      "request_duration_seconds",
+			Help:      "Duration of the gateway requests, by route.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"route"}),
+	}
+	requests, err := registerCollector(reg, m.requests)
+	if err != nil {
+		return nil, err
+	}
+	bytes, err := registerCollector(reg, m.bytes)
+	if err != nil {
+		return nil, err
+	}
+	duration, err := registerCollector(reg, m.duration)
+	if err != nil {
+		return nil, err
+	}
+	var ok1, ok2, ok3 bool
+	m.requests, ok1 = requests.(*prometheus.CounterVec)
+	m.bytes, ok2 = bytes.(*prometheus.CounterVec)
+	m.duration, ok3 = duration.(*prometheus.HistogramVec)
+	if !ok1 || !ok2 || !ok3 {
+		return nil, errors.New("conflicting gateway metrics are registered")
+	}
+	return m, nil
+}
+
+// registerCollector registers c, or returns the identical collector which
+// is already registered.
+func registerCollector(reg prometheus.Registerer, c prometheus.Collector) (prometheus.Collector, error) {
+	err := reg.Register(c)
+	var are prometheus.AlreadyRegisteredError
+	if errors.As(err, &are) {
+		return are.ExistingCollector, nil
+	}
+	return c, err
+}
+
+func (m *gatewayMetrics) observe(a GatewayAccess) {
+	m.requests.WithLabelValues(a.Route, a.Method, strconv.Itoa(a.Status)).Inc()
+	m.bytes.WithLabelValues(a.Route).Add(float64(a.Bytes))
+	m.duration.WithLabelValues(a.Route).Observe(a.Duration.Seconds())
+}
+
+// accessLog reports every request to log and to the metrics, when set.
+func accessLog(log func(GatewayAccess), metrics *gatewayMetrics, next http.Handler) http.Handler {
+	if log == nil && metrics == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		aw := &accessWriter{ResponseWriter: w, code: http.StatusOK}
+		next.ServeHTTP(aw, r)
+
+		a := GatewayAccess{
+			Time:         start,
+			Method:       r.Method,
+			Host:         r.Host,
+			Path:         r.URL.RequestURI(),
+			Status:       aw.code,
+			Bytes:        aw.bytes,
+			Duration:     time.Since(start),
+			Client:       r.RemoteAddr,
+			ForwardedFor: r.Header.Get("X-Forwarded-For"),
+		}
+		a.Route, a.Cid = accessedContent(r, w.Header())
+		if metrics != nil {
+			metrics.observe(a)
+		}
+		if log != nil {
+			log(a)
+		}
+	})
+}
+
+// accessedContent returns the route and the root CID of the content served
+// for a request, using the headers set by the gateway on the response.
+func accessedContent(r *http.Request, h http.Header) (string, cid.Cid) {
+	path := h.Get("X-Ipfs-Path")
+	if path == "" {
+		path = r.URL.Path
+	}
+	route := GatewayRouteOther
+	switch {
+	case strings.HasPrefix(path, "/ipfs/"):
+		route = GatewayRouteIPFS
+	case strings.HasPrefix(path, "/ipns/"):
+		route = GatewayRouteIPNS
+	}
+	if roots := h.Get("X-Ipfs-Roots"); roots != "" {
+		root, _, _ := strings.Cut(roots, ",")
+		if c, err := cid.Decode(root); err == nil {
+			return route, c
+		}
+	}
+	c, _ := gatewayRoot(path)
+	return route, c
+}
+
+// accessWriter records the status code and the size of a response.
+type accessWriter struct {
+	http.ResponseWriter
+	code        int
+	bytes       int64
+	wroteHeader bool
+}
+
+func (w *accessWriter) WriteHeader(code int) {
+	if !w.wroteHeader {
+		w.code = code
+		w.wroteHeader = true
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *accessWriter) Write(b []byte) (int, error) {
+	w.wroteHeader = true
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += int64(n)
+	return n, err
+}
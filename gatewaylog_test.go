@@ -0,0 +1,74 @@
+package ipfslite
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestGatewayAccessLog(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	content := []byte("hello access log")
+	p, c := setupGatewayPeer(t, ctx, content)
+
+	var mu sync.Mutex
+	var accesses []GatewayAccess
+	reg := prometheus.NewRegistry()
+	cfg := &GatewayConfig{
+		AccessLog: func(a GatewayAccess) {
+			mu.Lock()
+			defer mu.Unlock()
+			accesses = append(accesses, a)
+		},
+		Metrics: reg,
+	}
+	h, err := p.Gateway(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gatewayGet(t, h, "localhost", "/ipfs/"+c.String())
+	gatewayGet(t, h, "localhost", "/unknown")
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(accesses) != 2 {
+		t.Fatalf("unexpected accesses: %+v", accesses)
+	}
+	a := accesses[0]
+	if a.Route != GatewayRouteIPFS || !a.Cid.Equals(c) || a.Status != http.StatusOK || a.Bytes != int64(len(content)) || a.Method != http.MethodGet {
+		t.Errorf("unexpected access: %+v", a)
+	}
+	if accesses[1].Route != GatewayRouteOther || accesses[1].Status != http.StatusNotFound {
+		t.Errorf("unexpected access: %+v", accesses[1])
+	}
+
+	// A second gateway shares the registered metrics.
+	if _, err := p.Gateway(cfg); err != nil {
+		t.Fatal(err)
+	}
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	counts := make(map[string]float64)
+	for _, f := range families {
+		if f.GetName() != "ipfslite_gateway_requests_total" {
+			continue
+		}
+		for _, m := range f.GetMetric() {
+			for _, l := range m.GetLabel() {
+				if l.GetName() == "route" {
+					counts[l.GetValue()] += m.GetCounter().GetValue()
+				}
+			}
+		}
+	}
+	if counts[GatewayRouteIPFS] != 1 || counts[GatewayRouteOther] != 1 {
+		t.Errorf("unexpected request counts: %v", counts)
+	}
+}
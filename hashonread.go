@@ -0,0 +1,42 @@
+package ipfslite
+
+import (
+	blockstore "github.com/ipfs/boxo/blockstore"
+)
+
+// HashOnReadPolicy selects when the hashes of the blocks read from the
+// blockstore are verified (see Config.HashOnRead). Verifying detects
+// corrupted or tampered storage, at the cost of hashing every block read.
+type HashOnReadPolicy int
+
+// Hash-on-read policies.
+const (
+	// HashOnReadDefault verifies the blocks of untrusted storage only.
+	// Blocks read from the blockstore created from the datastore given
+	// to New (local storage) are not verified. Blocks read from the
+	// remote tier of a tiered blockstore (see NewTieredBlockstore),
+	// which may be shared with other writers, are verified, and those of
+	// its local tier are not. Other blockstores given to New are left as
+	// configured by the application.
+	HashOnReadDefault HashOnReadPolicy = iota
+	// HashOnReadAlways verifies all the blocks read (paranoid mode).
+	HashOnReadAlways
+	// HashOnReadNever never verifies the blocks read, i.e. for trusted
+	// local or read-only media (fast mode).
+	HashOnReadNever
+)
+
+// applyHashOnRead configures the verification of the blocks read from the
+// given blockstore according to the policy.
+func applyHashOnRead(bs blockstore.Blockstore, policy HashOnReadPolicy) {
+	switch policy {
+	case HashOnReadAlways:
+		bs.HashOnRead(true)
+	case HashOnReadNever:
+		bs.HashOnRead(false)
+	default:
+		if t, ok := bs.(*tieredBlockstore); ok {
+			t.remote.HashOnRead(true)
+		}
+	}
+}
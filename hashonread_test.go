@@ -0,0 +1,57 @@
+package ipfslite
+
+import (
+	"context"
+	"testing"
+
+	blockstore "github.com/ipfs/boxo/blockstore"
+	blocks "github.com/ipfs/go-block-format"
+)
+
+func TestHashOnRead(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	good := blocks.NewBlock([]byte("good"))
+	corrupted, err := blocks.NewBlockWithCid([]byte("corrupted"), good.Cid())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for policy, verified := range map[HashOnReadPolicy]bool{
+		HashOnReadDefault: false,
+		HashOnReadAlways:  true,
+		HashOnReadNever:   false,
+	} {
+		ds := NewInMemoryDatastore()
+		bs := blockstore.NewBlockstore(ds)
+		if err := bs.Put(ctx, corrupted); err != nil {
+			t.Fatal(err)
+		}
+		p, err := New(ctx, ds, bs, nil, nil, &Config{Offline: true, HashOnRead: policy})
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = p.BlockStore().Get(ctx, good.Cid())
+		if verified && err == nil {
+			t.Errorf("policy %d: the corrupted block should be rejected", policy)
+		}
+		if !verified && err != nil {
+			t.Errorf("policy %d: unexpected error: %s", policy, err)
+		}
+	}
+
+	// The remote tier of tiered blockstores is verified by default.
+	local := blockstore.NewBlockstore(NewInMemoryDatastore())
+	remote := blockstore.NewBlockstore(NewInMemoryDatastore())
+	if err := remote.Put(ctx, corrupted); err != nil {
+		t.Fatal(err)
+	}
+	p, err := New(ctx, NewInMemoryDatastore(), NewTieredBlockstore(local, remote, TieredOptions{}), nil, nil, &Config{Offline: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.BlockStore().Get(ctx, good.Cid()); err == nil {
+		t.Error("the corrupted remote block should be rejected")
+	}
+}
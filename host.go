@@ -0,0 +1,95 @@
+package ipfslite
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/ipfs/boxo/bitswap/network"
+	dht "github.com/libp2p/go-libp2p-kad-dht"
+	dualdht "github.com/libp2p/go-libp2p-kad-dht/dual"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/libp2p/go-libp2p/p2p/protocol/identify"
+)
+
+// ErrInvalidHost is returned by New when the given libp2p host or DHT
+// cannot be used by the Peer.
+var ErrInvalidHost = errors.New("invalid libp2p host")
+
+// validateHost checks that the host and the DHT given to New are usable:
+// the host runs identify, the DHT (when it is a kad-dht) runs on the same
+// host, and no other Peer serves the protocols of this one on the host,
+// i.e. a second Peer without a different Config.Tenant.
+func (p *Peer) validateHost() error {
+	if p.cfg.Offline {
+		return nil
+	}
+	if p.host == nil {
+		return fmt.Errorf("%w: a host is required unless Offline", ErrInvalidHost)
+	}
+
+	served := make(map[protocol.ID]struct{})
+	for _, proto := range p.host.Mux().Protocols() {
+		served[proto] = struct{}{}
+	}
+	if _, ok := served[identify.ID]; !ok {
+		return fmt.Errorf("%w: the host does not run the identify protocol", ErrInvalidHost)
+	}
+	protos := []protocol.ID{p.protocolPrefix() + network.ProtocolBitswap}
+	if p.cfg.CompressedTransfers {
+		protos = append(protos, p.compressedProtocol())
+	}
+	if p.cfg.Metadata != nil {
+		protos = append(protos, p.metadataProtocol())
+	}
+	if p.cfg.Admin != nil {
+		protos = append(protos, p.adminProtocol())
+	}
+	for _, proto := range protos {
+		if _, ok := served[proto]; ok {
+			return fmt.Errorf("%w: %s is already served by the host (set a different Config.Tenant to share it)", ErrInvalidHost, proto)
+		}
+	}
+
+	switch d := p.baseDHT.(type) {
+	case *dualdht.DHT:
+		if d.WAN.Host().ID() != p.host.ID() {
+			return fmt.Errorf("%w: the DHT runs on another host", ErrInvalidHost)
+		}
+	case *dht.IpfsDHT:
+		if d.Host().ID() != p.host.ID() {
+			return fmt.Errorf("%w: the DHT runs on another host", ErrInvalidHost)
+		}
+	}
+	return nil
+}
+
+// closeOwned closes the DHT and the host when the Peer owns them (see
+// Config.OwnHost and Config.OwnDHT). The DHT is closed first, as it uses
+// the host.
+func (p *Peer) closeOwned() error {
+	var errs []error
+	if p.cfg.OwnDHT && p.baseDHT != nil {
+		if c, ok := p.baseDHT.(io.Closer); ok {
+			if err := c.Close(); err != nil {
+				errs = append(errs, fmt.Errorf("closing the DHT: %w", err))
+			}
+		}
+	}
+	if p.cfg.OwnHost && p.host != nil {
+		if err := p.host.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("closing the host: %w", err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Close shuts the Peer down, like cancelling the context given to New,
+// and waits until it is done. The host and the DHT are closed too when the
+// Peer owns them (see Config.OwnHost and Config.OwnDHT). Close can be
+// called several times, and returns the same error every time.
+func (p *Peer) Close() error {
+	p.cancel()
+	<-p.closed
+	return p.closeErr
+}
@@ -0,0 +1,112 @@
+package ipfslite
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	dht "github.com/libp2p/go-libp2p-kad-dht"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/routing"
+	"github.com/multiformats/go-multiaddr"
+)
+
+func setupHost(t *testing.T, ctx context.Context) (host.Host, routing.Routing) {
+	priv, _, err := crypto.GenerateKeyPair(crypto.Ed25519, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	listen := multiaddr.StringCast("/ip4/127.0.0.1/tcp/0")
+	h, d, err := SetupLibp2p(ctx, priv, nil, []multiaddr.Multiaddr{listen}, nil, dht.ModeServer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		d.Close()
+		h.Close()
+	})
+	return h, d
+}
+
+func TestPeerCloseOwnership(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	server := setupPeer(t, ctx, nil)
+	serverInfo := peer.AddrInfo{ID: server.host.ID(), Addrs: server.host.Addrs()}
+
+	// A host which is not owned outlives the Peer.
+	h, d := setupHost(t, ctx)
+	p, err := New(ctx, NewInMemoryDatastore(), nil, h, d, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.Connect(ctx, serverInfo); err != nil {
+		t.Fatalf("the host should still be usable: %s", err)
+	}
+
+	// An owned host is closed with the Peer.
+	h, d = setupHost(t, ctx)
+	p, err = New(ctx, NewInMemoryDatastore(), nil, h, d, &Config{OwnHost: true, OwnDHT: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := h.Connect(ctx, serverInfo); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Close(); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for server.host.Network().Connectedness(h.ID()) == network.Connected {
+		if time.Now().After(deadline) {
+			t.Fatal("the owned host was not closed")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func TestValidateHost(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	_, err := New(ctx, NewInMemoryDatastore(), nil, nil, nil, nil)
+	if !errors.Is(err, ErrInvalidHost) {
+		t.Errorf("a host should be required: %v", err)
+	}
+
+	h, d := setupHost(t, ctx)
+	p, err := New(ctx, NewInMemoryDatastore(), nil, h, d, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	_, err = New(ctx, NewInMemoryDatastore(), nil, h, d, nil)
+	if !errors.Is(err, ErrInvalidHost) {
+		t.Errorf("the bitswap protocol should already be served: %v", err)
+	}
+	tenant, err := New(ctx, NewInMemoryDatastore(), nil, h, d, &Config{Tenant: "other"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tenant.Close()
+
+	other, otherDHT := setupHost(t, ctx)
+	_, err = New(ctx, NewInMemoryDatastore(), nil, other, d, nil)
+	if !errors.Is(err, ErrInvalidHost) {
+		t.Errorf("the DHT should run on the given host: %v", err)
+	}
+	_, err = New(ctx, NewInMemoryDatastore(), nil, other, otherDHT, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
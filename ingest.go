@@ -0,0 +1,147 @@
+package ipfslite
+
+import (
+	"context"
+	"io"
+	"sync"
+
+	blockstore "github.com/ipfs/boxo/blockstore"
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+)
+
+// IngestKind tells what kind of content an IngestEvent refers to.
+type IngestKind int
+
+const (
+	// IngestBlock is used for every block written to the blockstore,
+	// whether added locally or fetched from the network.
+	IngestBlock IngestKind = iota
+	// IngestFileAdded is used for the root of files added with AddFile.
+	IngestFileAdded
+	// IngestFileFetched is used for the root of files opened with
+	// GetFile. Their blocks are reported as they are fetched.
+	IngestFileFetched
+)
+
+// IngestEvent describes content added to or fetched by the Peer.
+type IngestEvent struct {
+	Kind  IngestKind
+	Cid   cid.Cid
+	Codec uint64
+	// Size is the size of the block or, for files, of the file
+	// contents.
+	Size int64
+	// Path is the UnixFS path of a file, when known (see AddParams.Path).
+	Path string
+}
+
+// IngestHook is a function called on ingest events. Hooks are called
+// synchronously, so they should return quickly and hand off any expensive
+// work (i.e. indexing) to other goroutines.
+type IngestHook func(ctx context.Context, ev IngestEvent)
+
+// ingestHooks is a list of registered hooks. Hooks are referenced by
+// pointer, so that they can be removed. The list is never modified in
+// place, so that run can call the hooks of a snapshot without the lock,
+// letting hooks add or remove hooks.
+type ingestHooks struct {
+	mu    sync.RWMutex
+	hooks []*IngestHook
+}
+
+// add registers a hook, and returns a function removing it.
+func (h *ingestHooks) add(hook IngestHook) func() {
+	entry := &hook
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.hooks = append(h.hooks[:len(h.hooks):len(h.hooks)], entry)
+	return func() { h.remove(entry) }
+}
+
+func (h *ingestHooks) remove(entry *IngestHook) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for i, hook := range h.hooks {
+		if hook == entry {
+			h.hooks = append(h.hooks[:i:i], h.hooks[i+1:]...)
+			return
+		}
+	}
+}
+
+func (h *ingestHooks) run(ctx context.Context, ev IngestEvent) {
+	h.mu.RLock()
+	hooks := h.hooks
+	h.mu.RUnlock()
+	for _, hook := range hooks {
+		(*hook)(ctx, ev)
+	}
+}
+
+// AddIngestHook registers a hook which is called for each block written to
+// the blockstore and for each file added or fetched, so that applications
+// can keep search indexes or databases in sync with the Peer's content.
+// The returned function removes the hook.
+func (p *Peer) AddIngestHook(hook IngestHook) (remove func()) {
+	return p.ingest.add(hook)
+}
+
+func (p *Peer) ingestFile(ctx context.Context, kind IngestKind, c cid.Cid, size int64, path string) {
+	p.ingest.run(ctx, IngestEvent{
+		Kind:  kind,
+		Cid:   c,
+		Codec: c.Prefix().Codec,
+		Size:  size,
+		Path:  path,
+	})
+}
+
+// ingestBlockstore runs the ingest hooks for every block written.
+type ingestBlockstore struct {
+	blockstore.Blockstore
+	hooks *ingestHooks
+}
+
+func (bs *ingestBlockstore) ingest(ctx context.Context, blk blocks.Block) {
+	bs.hooks.run(ctx, IngestEvent{
+		Kind:  IngestBlock,
+		Cid:   blk.Cid(),
+		Codec: blk.Cid().Prefix().Codec,
+		Size:  int64(len(blk.RawData())),
+	})
+}
+
+func (bs *ingestBlockstore) View(ctx context.Context, c cid.Cid, f func([]byte) error) error {
+	return viewBlock(ctx, bs.Blockstore, c, f)
+}
+
+func (bs *ingestBlockstore) Put(ctx context.Context, blk blocks.Block) error {
+	err := bs.Blockstore.Put(ctx, blk)
+	if err == nil {
+		bs.ingest(ctx, blk)
+	}
+	return err
+}
+
+func (bs *ingestBlockstore) PutMany(ctx context.Context, blks []blocks.Block) error {
+	err := bs.Blockstore.PutMany(ctx, blks)
+	if err == nil {
+		for _, blk := range blks {
+			bs.ingest(ctx, blk)
+		}
+	}
+	return err
+}
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	io.Reader
+	n int64
+}
+
+func (r *countingReader) Read(b []byte) (int, error) {
+	n, err := r.Reader.Read(b)
+	r.n += int64(n)
+	return n, err
+}
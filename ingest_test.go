@@ -0,0 +1,139 @@
+package ipfslite
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"sync"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+)
+
+func TestIngestHooks(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	p, err := New(ctx, NewInMemoryDatastore(), nil, nil, nil, &Config{Offline: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var mu sync.Mutex
+	var events []IngestEvent
+	p.AddIngestHook(func(ctx context.Context, ev IngestEvent) {
+		mu.Lock()
+		defer mu.Unlock()
+		events = append(events, ev)
+	})
+
+	content := bytes.Repeat([]byte("ingest"), 100000)
+	n, err := p.AddFile(ctx, bytes.NewReader(content), &AddParams{RawLeaves: true, Path: "/docs/file.txt"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	var blocks int
+	var added *IngestEvent
+	for i, ev := range events {
+		switch ev.Kind {
+		case IngestBlock:
+			blocks++
+		case IngestFileAdded:
+			added = &events[i]
+		}
+	}
+	mu.Unlock()
+	if blocks < 2 {
+		t.Errorf("expected block events, got %d", blocks)
+	}
+	if added == nil {
+		t.Fatal("no file event")
+	}
+	if !added.Cid.Equals(n.Cid()) || added.Size != int64(len(content)) || added.Path != "/docs/file.txt" || added.Codec != cid.DagProtobuf {
+		t.Errorf("unexpected file event: %+v", added)
+	}
+
+	r, err := p.GetFile(ctx, n.Cid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if _, err := io.Copy(io.Discard, r); err != nil {
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	last := events[len(events)-1]
+	if last.Kind != IngestFileFetched || !last.Cid.Equals(n.Cid()) || last.Size != int64(len(content)) {
+		t.Errorf("unexpected fetch event: %+v", last)
+	}
+}
+
+func TestRemoveIngestHook(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	p, err := New(ctx, NewInMemoryDatastore(), nil, nil, nil, &Config{Offline: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var mu sync.Mutex
+	var first, second int
+	remove := p.AddIngestHook(func(ctx context.Context, ev IngestEvent) {
+		mu.Lock()
+		defer mu.Unlock()
+		first++
+	})
+	p.AddIngestHook(func(ctx context.Context, ev IngestEvent) {
+		mu.Lock()
+		defer mu.Unlock()
+		second++
+	})
+	remove()
+	remove()
+
+	if _, err := p.AddFile(ctx, bytes.NewReader([]byte("removed")), nil); err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if first != 0 {
+		t.Errorf("a removed hook was called %d times", first)
+	}
+	if second == 0 {
+		t.Error("the remaining hook should be called")
+	}
+}
+
+func TestIngestHookRemovesItself(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	p, err := New(ctx, NewInMemoryDatastore(), nil, nil, nil, &Config{Offline: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Hooks run without the lock, so a hook can remove itself.
+	var mu sync.Mutex
+	calls := 0
+	var remove func()
+	remove = p.AddIngestHook(func(ctx context.Context, ev IngestEvent) {
+		mu.Lock()
+		calls++
+		mu.Unlock()
+		remove()
+	})
+	if _, err := p.AddFile(ctx, bytes.NewReader([]byte("once")), nil); err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if calls != 1 {
+		t.Errorf("expected a single call, got %d", calls)
+	}
+}
@@ -7,8 +7,10 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"runtime"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/ipfs/boxo/bitswap"
@@ -19,16 +21,20 @@ import (
 	exchange "github.com/ipfs/boxo/exchange"
 	offline "github.com/ipfs/boxo/exchange/offline"
 	"github.com/ipfs/boxo/ipld/merkledag"
+	"github.com/ipfs/boxo/ipld/unixfs"
 	"github.com/ipfs/boxo/ipld/unixfs/importer/balanced"
 	"github.com/ipfs/boxo/ipld/unixfs/importer/helpers"
 	"github.com/ipfs/boxo/ipld/unixfs/importer/trickle"
 	ufsio "github.com/ipfs/boxo/ipld/unixfs/io"
+	pin "github.com/ipfs/boxo/pinning/pinner"
 	provider "github.com/ipfs/boxo/provider"
+	blocks "github.com/ipfs/go-block-format"
 	"github.com/ipfs/go-cid"
 	"github.com/ipfs/go-datastore"
 	ipld "github.com/ipfs/go-ipld-format"
 	logging "github.com/ipfs/go-log/v2"
 	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/metrics"
 	"github.com/libp2p/go-libp2p/core/peer"
 	"github.com/libp2p/go-libp2p/core/routing"
 	"github.com/multiformats/go-multihash"
@@ -40,6 +46,20 @@ var (
 	defaultReprovideInterval = 12 * time.Hour
 )
 
+// DHTType selects which DHTs a host runs (see Config.DHT).
+type DHTType int
+
+// DHT types.
+const (
+	// DHTDual runs a LAN DHT, among peers with private addresses, and a
+	// WAN DHT, among peers with public addresses (the public IPFS DHT).
+	DHTDual DHTType = iota
+	// DHTLANOnly only runs the LAN DHT.
+	DHTLANOnly
+	// DHTWANOnly only runs the WAN DHT.
+	DHTWANOnly
+)
+
 // Config wraps configuration options for the Peer.
 type Config struct {
 	// The DAGService will not announce or retrieve blocks from the network
@@ -50,37 +70,415 @@ type Config struct {
 	// when the given blockstore or datastore already has caching, or when
 	// caching is not needed.
 	UncachedBlockstore bool
+	// BlockstoreMetrics enables the measurement of the blockstore
+	// operations: rates, latencies and cache hits, returned by
+	// Peer.BlockstoreMetrics, to identify storage bottlenecks.
+	BlockstoreMetrics bool
+	// HashOnRead selects when the hashes of the blocks read from the
+	// blockstore are verified. See HashOnReadPolicy for the defaults of
+	// each kind of blockstore.
+	HashOnRead HashOnReadPolicy
+	// Announce, when set, replaces the addresses the host announces to
+	// other peers. See Config.Libp2pOptions.
+	Announce []string
+	// AppendAnnounce lists addresses which are announced in addition to
+	// the listen (or Announce) addresses, i.e. a static public IP or a DNS
+	// name mapped by a load balancer. See Config.Libp2pOptions.
+	AppendAnnounce []string
+	// NoAnnounce lists addresses that are never announced. Entries can be
+	// multiaddresses, multiaddress masks (/ip4/10.0.0.0/ipcidr/8) or CIDRs
+	// (192.168.0.0/16). See Config.Libp2pOptions.
+	NoAnnounce []string
+	// Muxers lists the stream multiplexers to use, in order of
+	// preference (MuxerYamux, MuxerMplex). Defaults to the libp2p ones
+	// (yamux). See Config.Libp2pOptions.
+	Muxers []string
+	// Security lists the security transports to use, in order of
+	// preference (SecurityTLS, SecurityNoise). Defaults to the libp2p
+	// ones (noise and TLS). See Config.Libp2pOptions.
+	Security []string
+	// DialTimeout sets the timeout for outbound dials. Defaults to the
+	// libp2p one. See Config.Libp2pOptions.
+	DialTimeout time.Duration
+	// DialRanking sets the strategy used to schedule dials to the
+	// addresses of a peer (DialRankingSmart, DialRankingNoDelay,
+	// DialRankingQUICFirst, DialRankingTCPFirst). Defaults to
+	// DialRankingSmart. See Config.Libp2pOptions.
+	DialRanking string
+	// DialDelay is the delay between successive groups of dials when
+	// using DialRankingQUICFirst or DialRankingTCPFirst. Defaults to
+	// 250ms.
+	DialDelay time.Duration
+	// DialConcurrency is the number of addresses of a peer dialed at once
+	// when using DialRankingQUICFirst or DialRankingTCPFirst. Defaults to
+	// 1.
+	DialConcurrency int
+	// DialsPerSecond, when positive, limits the rate at which peers are
+	// dialed, so that bursts of application requests do not exhaust the
+	// connection tracking tables of consumer routers. Dials beyond the
+	// limit are delayed. See Config.Libp2pOptions.
+	DialsPerSecond float64
+	// DialBurst is the number of peers which can be dialed at once
+	// when DialsPerSecond is set. Defaults to DialsPerSecond, and at
+	// least 1.
+	DialBurst int
+	// UserAgent sets the agent version announced via the libp2p identify
+	// protocol (i.e. "myapp/1.2.3 ipfs-lite"), so that operators and
+	// crawlers can distinguish application fleets. See
+	// Config.Libp2pOptions.
+	UserAgent string
+	// BandwidthReporter, when set, records the bandwidth used by hosts
+	// created with the Config (i.e. metrics.NewBandwidthCounter()), and
+	// is reported by Peer.StatsSnapshot. See Config.Libp2pOptions.
+	BandwidthReporter metrics.Reporter
+	// ProtocolVersion sets the protocol version announced via the libp2p
+	// identify protocol. See Config.Libp2pOptions.
+	ProtocolVersion string
+	// PeerstoreMaxAddrTTL caps how long learned addresses of other peers
+	// are retained in the peerstore. Addresses of connected peers and
+	// permanent addresses are not affected. See Config.Libp2pOptions.
+	PeerstoreMaxAddrTTL time.Duration
+	// PeerstoreGCInterval sets how often peers which are not connected and
+	// have no valid addresses are removed from the host's peerstore. Zero
+	// disables peerstore garbage collection.
+	PeerstoreGCInterval time.Duration
+	// IsolationTimeout, when positive, makes the Peer watch for periods
+	// of that length without any connected peer, like a node of a
+	// private network whose other nodes are down or have changed
+	// addresses. The Peer then bootstraps again with FallbackPeers and
+	// runs the hooks registered with AddIsolationHook, and does so again
+	// after every further IsolationTimeout until it is connected.
+	IsolationTimeout time.Duration
+	// FallbackPeers are the peers dialed when the Peer is isolated (see
+	// IsolationTimeout), i.e. static nodes of the private network. With
+	// a PSK, public bootstrap peers cannot be reached.
+	FallbackPeers []peer.AddrInfo
+	// PeerStats enables tracking the blocks exchanged with every peer
+	// and their latency, persisted in the datastore (see
+	// Peer.PeerStats). The best ranked peers are dialed when fetches
+	// start, so that they are asked for blocks first on repeat
+	// interactions.
+	PeerStats bool
+	// FavorReciprocatingPeers makes bitswap serve first the peers which
+	// sent the Peer more blocks than they received from it (see
+	// PeerStats.Reciprocity), so that peers which reciprocate are
+	// favored in community swarms. The exchange ledgers are persisted
+	// across restarts, and PeerStats is implied.
+	FavorReciprocatingPeers bool
+	// ServePolicy, when set, decides for every block requested by other
+	// peers (with bitswap or compressed transfers) whether to serve it,
+	// so that a node only serves the content of its application even
+	// though its blockstore caches third-party blocks. Blocks which are
+	// not served are handled as if they were not available.
+	ServePolicy ServePolicy
+	// ServePinnedOnly only serves to other peers the blocks which are
+	// pinned, directly or as part of a pinned DAG. It can be combined
+	// with ServePolicy. Pins made with Peer.Pin are served right away,
+	// other pin changes are accounted for within 10 minutes.
+	ServePinnedOnly bool
+	// MaxParallelFetches limits how many fetches (Fetch, FetchDAG and open
+	// GetFile readers) can run at the same time. Waiting fetches are
+	// started by priority (see WithPriority). Zero means no limit.
+	MaxParallelFetches int
+	// CoalesceFetches makes concurrent Fetch or FetchDAG calls for the
+	// same CID share a single network fetch and bitswap session, i.e.
+	// in servers where many clients request the same content at once.
+	// The shared fetch runs with the options of the first caller, until
+	// it completes or all its callers gave up, and only counts once
+	// towards MaxParallelFetches. Fetches limited with WithMaxBlocks are
+	// not shared. See Peer.FetchesInProgress.
+	CoalesceFetches bool
+	// DHT selects the DHT run by hosts created with Config.SetupLibp2p:
+	// both the LAN and the WAN DHTs (the default), or only one of them.
+	DHT DHTType
+	// DHTConcurrency is the number of peers queried in parallel by the
+	// DHT lookups (alpha), and DHTResiliency the number of closest peers
+	// which must answer for a lookup to end (beta). Higher values lower
+	// the lookup latency at the cost of more bandwidth. They apply to
+	// hosts created with Config.SetupLibp2p, and default to the DHT
+	// defaults (10 and 3).
+	DHTConcurrency int
+	DHTResiliency  int
+	// DHTRefreshInterval is the interval at which the DHT routing tables
+	// are refreshed, for hosts created with Config.SetupLibp2p. Defaults
+	// to the DHT default (10 minutes).
+	DHTRefreshInterval time.Duration
+	// Isolated makes Bootstrap ignore the public IPFS bootstrap peers
+	// (DefaultBootstrapPeers), so that isolated swarms (see
+	// SetupIsolatedLibp2p) never connect to the public network by
+	// mistake.
+	Isolated bool
+	// BitswapPeerFilter, when set, restricts the connected peers which
+	// bitswap sends wants to (i.e. AllowPeers or AllowProtocolPrefix), so
+	// that a node of a private swarm which is also connected to the
+	// public network does not broadcast its wants there. Rejected peers
+	// can still request blocks from the node.
+	BitswapPeerFilter BitswapPeerFilter
+	// CompressedTransfers enables an extension to exchange blocks
+	// compressed with zstd with the connected peers which enable it too,
+	// which greatly reduces transfer sizes for text-heavy content. Blocks
+	// are requested from such peers first, then with bitswap.
+	CompressedTransfers bool
+	// AddWorkers limits how many batches of blocks added with AddFile
+	// are written at the same time, across all concurrent AddFile calls.
+	// Defaults to the number of CPUs.
+	AddWorkers int
+	// CacheSize, when positive, runs the Peer as a bounded content cache:
+	// blocks which are not pinned are evicted, least recently used first,
+	// when the blockstore grows beyond this size in bytes.
+	CacheSize int64
+	// CacheGCInterval sets how often cached blocks are evicted when
+	// CacheSize is set. Defaults to one minute.
+	CacheGCInterval time.Duration
+	// TrackAccessTime enables recording the last access time of every
+	// block in the datastore. See Peer.ColdBlocks.
+	TrackAccessTime bool
+	// AccessTimeSampleRate sets the fraction (0, 1] of block accesses
+	// which update the recorded access time, trading precision for fewer
+	// datastore writes. Defaults to 1 (every access).
+	AccessTimeSampleRate float64
+	// FetchTimeout bounds how long fetches (Fetch, FetchDAG, GetFile,
+	// Walk) may take, so that hung retrievals fail instead of holding
+	// resources. It can be overridden per call with WithTimeout. Zero
+	// means no timeout.
+	FetchTimeout time.Duration
+	// ProviderSearchTimeout bounds each search for providers made when
+	// fetching blocks. Zero means no timeout.
+	ProviderSearchTimeout time.Duration
+	// ProviderOrdering ranks the providers of the content requested by
+	// fetches, to decide which ones are dialed and asked for blocks
+	// first. Defaults to LatencyOrdering.
+	ProviderOrdering ProviderOrdering
+	// ProvideQueueSize bounds the number of CIDs of added content which
+	// wait to be announced to the network. When the queue is full, new
+	// CIDs are handled according to ProvideQueuePolicy. Zero (the
+	// default) means no bound. See Peer.ProvideQueueStats.
+	ProvideQueueSize int
+	// ProvideQueuePolicy sets what happens when the provide queue is
+	// full. Defaults to ProvideBlock.
+	ProvideQueuePolicy ProvidePolicy
+	// ProvideWorkers sets how many CIDs of added content are announced
+	// to the network at the same time. Defaults to 4.
+	ProvideWorkers int
+	// ReprovideWorkers sets how many CIDs are announced at the same time
+	// when reproviding the content of the blockstore, and by
+	// ProvideMany. Larger values let servers with big archives announce
+	// them faster, smaller ones limit the CPU and network use of small
+	// devices. Zero keeps the defaults: reprovides announce one CID at a
+	// time, and ProvideMany up to 32. It has no effect when the router
+	// announces many CIDs at once (see ProvideMany).
+	ReprovideWorkers int
+	// MaxBlockSize, when positive, rejects the blocks larger than this
+	// many bytes with ErrBlockTooLarge when they are added, instead of
+	// letting other peers refuse them. AddFile fails early when its
+	// chunker produces larger chunks. NetworkBlockSizeLimit is the usual
+	// value.
+	MaxBlockSize int
+	// MaxFileSize, when positive, makes AddFile and similar calls fail
+	// with ErrFileTooLarge when the content is larger than this many
+	// bytes.
+	MaxFileSize int64
+	// AllowedCodecs, when set, restricts the codecs of the blocks which
+	// the Peer stores and serves to the given multicodec codes (i.e.
+	// cid.Raw, cid.DagProtobuf and cid.DagCBOR), hardening it against
+	// the injection of junk blocks. Adding or receiving other blocks
+	// fails with ErrCIDNotAllowed, and the ones stored before are
+	// neither served nor announced.
+	AllowedCodecs []uint64
+	// AllowedHashes, when set, restricts the hash functions of the
+	// blocks which the Peer stores and serves like AllowedCodecs (i.e.
+	// to multihash.SHA2_256 and multihash.BLAKE3). Blocks with other
+	// hash functions are not fetched either.
+	AllowedHashes []uint64
+	// ServeDailyQuota, when positive, limits the bytes served to each
+	// peer with bitswap over a day. The wants of peers over their quota
+	// are answered with DONT_HAVE until the day is over. See
+	// Peer.AddThrottleHook.
+	ServeDailyQuota int64
+	// ServeRateLimit, when positive, limits the rate at which each peer
+	// is served blocks with bitswap, in bytes per second. The wants of
+	// peers over the limit are answered with DONT_HAVE.
+	ServeRateLimit int64
+	// TrackPopularity enables counting the accesses to the content served
+	// by the Peer: sends of pinned roots with bitswap, and gateway
+	// requests, persisted in the datastore. See Peer.PopularContent.
+	TrackPopularity bool
+	// AddJournal enables journaling the adds (AddFile, AddStream and
+	// AddFileEncrypted) in the datastore: every add is recorded before
+	// it starts, with its parameters, and again with its root CID or its
+	// error when it finishes, so that after a crash the application can
+	// tell which adds must be retried. See Peer.AddJournal.
+	AddJournal bool
+	// TraceDHTQueries enables reporting the DHT queries made by the Peer
+	// to the hooks registered with Peer.AddDHTQueryHook.
+	TraceDHTQueries bool
+	// DHTQueriesPerSecond, when positive, limits the rate of the queries
+	// (provider searches, provides, peer and value lookups) made with the
+	// Routing given to New. Queries beyond the limit wait for their turn,
+	// or until their context is cancelled.
+	DHTQueriesPerSecond float64
+	// DHTQueryBurst is the number of queries which can be made at once
+	// when DHTQueriesPerSecond is set. Defaults to DHTQueriesPerSecond,
+	// and at least 1.
+	DHTQueryBurst int
+	// GatewayTimeout bounds how long gateway requests may take. It can be
+	// overridden with GatewayConfig.Timeout. Zero means no timeout.
+	GatewayTimeout time.Duration
+	// NamespacedDatastore stores the data of each component (pins,
+	// provider queues, metadata...) under its own namespace in the
+	// datastore (see PinsNamespace and friends), so that a single
+	// datastore can back everything without key collisions. It changes
+	// the on-disk layout, so it should not be toggled on existing
+	// datastores.
+	NamespacedDatastore bool
+	// BlockDatastore, when set, stores the block payloads instead of the
+	// datastore given to New, which then only holds the small and
+	// frequently accessed data (pins, provider queue, keys, statistics),
+	// like the datastore given to SetupLibp2p for the DHT. Both can live
+	// on different disks, i.e. a fast SSD for the metadata and a large
+	// disk for the blocks. It is ignored when a blockstore is given to
+	// New.
+	BlockDatastore datastore.Batching
+	// CheckRepo runs CheckRepo when the peer is created, so that
+	// corrupted blocks are quarantined before they are read or provided.
+	CheckRepo bool
+	// BlockTransformer transforms the blocks added with AddTransformed and
+	// read with GetTransformed, i.e. to encrypt them. See
+	// BlockTransformer.
+	BlockTransformer BlockTransformer
+	// ReadOnly serves the content of the blockstore without ever
+	// modifying it, i.e. from immutable media: adding, pinning,
+	// unpinning and removing content fail with ErrReadOnly, and blocks
+	// are served to other peers with bitswap but never fetched from
+	// them. The datastore layout is not migrated.
+	ReadOnly bool
+	// Tenant allows several Peers, each with its own datastore (and so
+	// blockstore and pinset), to share a single libp2p host and DHT, i.e.
+	// to serve several isolated applications from one process. The
+	// bitswap (and compressed transfers) protocols of the Peer are
+	// prefixed with the tenant name, so that the Peer only exchanges
+	// blocks with the Peers of the same tenant on other hosts. Peers
+	// sharing a host must have different tenants, and the name cannot
+	// contain slashes.
+	Tenant string
+	// BitswapProtocolPrefix is prepended to the bitswap protocol IDs
+	// (i.e. "/myapp" gives "/myapp/ipfs/bitswap/1.2.0"), so that the
+	// Peers of a private application swarm only exchange blocks among
+	// themselves, and cannot be asked for their blocks by public IPFS
+	// nodes, even without a pre-shared key. It applies to compressed
+	// transfers too, and is combined with Tenant. It must start with a
+	// slash and not end with one. See also SetupIsolatedLibp2p to keep
+	// the DHT private.
+	BitswapProtocolPrefix string
+	// Metadata, when set, is sent to the connected peers which set it
+	// too, in a record signed with the key of the host, so that the
+	// peers of a private swarm know the role, version and capabilities
+	// of each other (see PeerMetadata). It is ignored when Offline.
+	Metadata *PeerMetadata
+	// Admin, when set, enables the remote administration of the Peer by
+	// the controllers it lists (see AdminProtocol). It is ignored when
+	// Offline.
+	Admin *AdminConfig
+	// OwnHost and OwnDHT hand the ownership of the libp2p host and of the
+	// DHT given to New over to the Peer: they are closed when the Peer
+	// is (see Peer.Close). Leave them unset when the host or the DHT
+	// outlive the Peer or are shared with other components. When New
+	// fails, they are left open.
+	OwnHost bool
+	OwnDHT  bool
+	// Reconnect, when set, enables the reconnection manager, which
+	// redials important peers when their connections are lost (see
+	// ReconnectConfig). It is ignored when Offline.
+	Reconnect *ReconnectConfig
 }
 
 func (cfg *Config) setDefaults() {
 	if cfg.ReprovideInterval == 0 {
 		cfg.ReprovideInterval = defaultReprovideInterval
 	}
+	if cfg.CacheGCInterval == 0 {
+		cfg.CacheGCInterval = defaultCacheGCInterval
+	}
+	if cfg.AddWorkers <= 0 {
+		cfg.AddWorkers = runtime.NumCPU()
+	}
+	if cfg.ProvideWorkers <= 0 {
+		cfg.ProvideWorkers = defaultProvideWorkers
+	}
 }
 
 // Peer is an IPFS-Lite peer. It provides a DAG service that can fetch and put
 // blocks from/to the IPFS network.
 type Peer struct {
-	ctx context.Context
+	ctx    context.Context
+	cancel context.CancelFunc
+	// closed is closed once the Peer has shut down, with closeErr.
+	closed   chan struct{}
+	closeErr error
 
 	cfg *Config
 
+	scheduler  *fetchScheduler
+	fetches    fetchGroup
+	addWorkers chan struct{}
+
 	host  host.Host
 	dht   routing.Routing
 	store datastore.Batching
+	// blocksDS is the datastore of the default blockstore, or nil when a
+	// blockstore is given to New.
+	blocksDS datastore.Batching
+	// baseDHT is the Routing given to New, before it is wrapped.
+	baseDHT routing.Routing
 
 	ipld.DAGService // become a DAG service
 	exch            exchange.Interface
 	bstore          blockstore.Blockstore
+	lru             *lruBlockstore
+	cachePinned     cachedPinnedSet
+	atime           *accessTimeBlockstore
 	bserv           blockservice.BlockService
 	reprovider      provider.System
+	provideQueue    *provideQueue
+	pinner          pin.Pinner
+	ingest          ingestHooks
+	isolation       isolationHooks
+	peerStats       *peerStatsTracker
+	throttle        throttleHooks
+	popularity      *popularityTracker
+	dhtQueries      dhtQueryHooks
+	metadata        *metadataExchange
+	conns           connHooks
+	reconnect       *reconnectManager
+	reconnects      reconnectHooks
+	bsMetrics       *blockstoreMetrics
+	servePinned     *pinnedServeSet
+	// baseBstore is the blockstore given to New (or the default one)
+	// before it is wrapped, and trackedBstore the blockstore wrapped by
+	// the caches and trackers, but not by the CID policy and limits.
+	// CheckRepo scans the former and deletes through the latter.
+	baseBstore    blockstore.Blockstore
+	trackedBstore blockstore.Blockstore
+	// serveFilter and tracers are the block request filter and the
+	// tracers given to bitswap.
+	serveFilter func(peer.ID, cid.Cid) bool
+	tracers     multiTracer
+	// adminTimeout is AdminConfig.Timeout, or its default.
+	adminTimeout time.Duration
+
+	ipnsMu    sync.Mutex
+	ipnsNames map[peer.ID]*ipnsName
 }
 
 // New creates an IPFS-Lite Peer. It uses the given datastore, blockstore,
 // libp2p Host and Routing (usuall the DHT). If the blockstore is nil, the
-// given datastore will be wrapped to create one. The Host and the Routing may
-// be nil if config.Offline is set to true, as they are not used in that
-// case. Peer implements the ipld.DAGService interface.
+// given datastore (or Config.BlockDatastore) will be wrapped to create one.
+// The Host and the Routing may be nil if config.Offline is set to true, as
+// they are not used in that case. The Peer runs until ctx is cancelled or
+// Close is called, and only closes the Host and the Routing when it owns
+// them (see Config.OwnHost). Peer implements the ipld.DAGService
+// interface. The layout of the datastore is migrated to the current version
+// when needed (see Migrate).
 func New(
 	ctx context.Context,
 	datastore datastore.Batching,
@@ -95,16 +493,52 @@ func New(
 	}
 
 	cfg.setDefaults()
+	if err := validateTenant(cfg.Tenant); err != nil {
+		return nil, err
+	}
+	if err := validateProtocolPrefix(cfg.BitswapProtocolPrefix); err != nil {
+		return nil, err
+	}
 
 	p := &Peer{
-		ctx:   ctx,
 		cfg:   cfg,
 		host:  host,
 		dht:   dht,
 		store: datastore,
+
+		baseDHT: dht,
+
+		scheduler:  newFetchScheduler(cfg.MaxParallelFetches),
+		addWorkers: make(chan struct{}, cfg.AddWorkers),
+		conns:      connHooks{events: make(chan ConnEvent, connEventsQueueSize)},
+		closed:     make(chan struct{}),
+	}
+	if err := p.validateHost(); err != nil {
+		return nil, err
+	}
+	p.ctx, p.cancel = context.WithCancel(ctx)
+	started := false
+	defer func() {
+		if !started {
+			p.cancel()
+		}
+	}()
+	if dht != nil && cfg.DHTQueriesPerSecond > 0 {
+		p.dht = newRateLimitedRouting(p.dht, newTokenBucket(cfg.DHTQueriesPerSecond, cfg.DHTQueryBurst))
+	}
+	if dht != nil && cfg.TraceDHTQueries {
+		p.dht = newTracedRouting(p.dht, &p.dhtQueries)
 	}
 
-	err := p.setupBlockstore(blockstore)
+	err := p.migrate(ctx)
+	if err != nil {
+		return nil, err
+	}
+	err = p.setupBlockstore(blockstore)
+	if err != nil {
+		return nil, err
+	}
+	err = p.setupPopularity()
 	if err != nil {
 		return nil, err
 	}
@@ -117,12 +551,67 @@ func New(
 		p.bserv.Close()
 		return nil, err
 	}
+	err = p.setupPinner()
+	if err != nil {
+		p.bserv.Close()
+		return nil, err
+	}
+	if cfg.CheckRepo {
+		report, err := p.CheckRepo(ctx)
+		if err != nil {
+			p.bserv.Close()
+			return nil, err
+		}
+		if len(report.Corrupted) > 0 || len(report.Unreadable) > 0 || len(report.PinErrors) > 0 {
+			logger.Warnf("repo check: %d blocks checked, %d quarantined, %d unreadable, %d pinset errors", report.Checked, len(report.Corrupted), len(report.Unreadable), len(report.PinErrors))
+		}
+	}
 	err = p.setupReprovider()
 	if err != nil {
 		p.bserv.Close()
 		return nil, err
 	}
+	p.setupProvideQueue()
+	if p.host != nil && !cfg.Offline && cfg.Metadata != nil {
+		err = p.setupMetadata()
+		if err != nil {
+			p.bserv.Close()
+			return nil, err
+		}
+	}
 
+	if p.host != nil && !cfg.Offline && cfg.Admin != nil {
+		p.setupAdmin()
+	}
+	if p.host != nil && !cfg.Offline && cfg.Reconnect != nil {
+		p.setupReconnect()
+	}
+
+	if p.host != nil && cfg.PeerstoreGCInterval > 0 {
+		go p.peerstoreGC()
+	}
+	if p.lru != nil && !cfg.ReadOnly {
+		go p.cacheGC()
+	}
+	if p.peerStats != nil {
+		go p.peerStatsLoop()
+	}
+	if p.popularity != nil {
+		go p.popularityLoop()
+	}
+	if p.servePinned != nil {
+		go p.servePinnedLoop()
+	}
+	if p.host != nil && !cfg.Offline && cfg.IsolationTimeout > 0 {
+		go p.watchIsolation()
+	}
+	if p.host != nil && !cfg.Offline {
+		n := p.connNotifiee()
+		p.host.Network().Notify(n)
+		go p.watchConns(n)
+	}
+
+	started = true
 	go p.autoclose()
 
 	return p, nil
@@ -131,31 +620,150 @@ func New(
 func (p *Peer) setupBlockstore(bs blockstore.Blockstore) error {
 	var err error
 	if bs == nil {
-		bs = blockstore.NewBlockstore(p.store)
+		ds := p.store
+		if p.cfg.BlockDatastore != nil {
+			ds = p.cfg.BlockDatastore
+		}
+		bs = blockstore.NewBlockstore(ds)
+		p.blocksDS = ds
 	}
+	applyHashOnRead(bs, p.cfg.HashOnRead)
+	p.baseBstore = bs
 
 	// Support Identity multihashes.
 	bs = blockstore.NewIdStore(bs)
 
+	if p.cfg.BlockstoreMetrics {
+		p.bsMetrics = newBlockstoreMetrics()
+		if !p.cfg.UncachedBlockstore {
+			bs = &uncachedMetricsBlockstore{Blockstore: bs, m: p.bsMetrics}
+		}
+	}
+
 	if !p.cfg.UncachedBlockstore {
 		bs, err = blockstore.CachedBlockstore(p.ctx, bs, blockstore.DefaultCacheOpts())
 		if err != nil {
 			return err
 		}
 	}
-	p.bstore = bs
+
+	if p.cfg.TrackAccessTime {
+		p.atime = newAccessTimeBlockstore(bs, p.datastore(MetaNamespace), p.cfg.AccessTimeSampleRate)
+		bs = p.atime
+	}
+
+	if p.cfg.CacheSize > 0 {
+		p.lru = newLRUBlockstore(bs)
+		bs = p.lru
+	}
+
+	p.trackedBstore = bs
+	p.bstore = &ingestBlockstore{Blockstore: bs, hooks: &p.ingest}
+	if pol := newCIDPolicy(p.cfg.AllowedCodecs, p.cfg.AllowedHashes); pol != nil {
+		p.bstore = &cidPolicyBlockstore{Blockstore: p.bstore, policy: pol}
+	}
+	if p.cfg.MaxBlockSize > 0 {
+		p.bstore = &blockSizeBlockstore{Blockstore: p.bstore, max: p.cfg.MaxBlockSize}
+	}
+	if p.cfg.ReadOnly {
+		p.bstore = &readOnlyBlockstore{Blockstore: p.bstore}
+	}
+	if p.bsMetrics != nil {
+		p.bstore = &metricsBlockstore{Blockstore: p.bstore, m: p.bsMetrics}
+	}
 	return nil
 }
 
+// blockServiceOptions replaces the default hash functions allowlist of the
+// block service with Config.AllowedHashes, when set.
+func (p *Peer) blockServiceOptions() []blockservice.Option {
+	if len(p.cfg.AllowedHashes) == 0 {
+		return nil
+	}
+	return []blockservice.Option{blockservice.WithAllowlist(newCIDPolicy(nil, p.cfg.AllowedHashes))}
+}
+
 func (p *Peer) setupBlockService() error {
 	if p.cfg.Offline {
-		p.bserv = blockservice.New(p.bstore, offline.Exchange(p.bstore))
+		p.bserv = blockservice.New(p.bstore, offline.Exchange(p.bstore), p.blockServiceOptions()...)
 		return nil
 	}
 
-	bswapnet := network.NewFromIpfsHost(p.host, p.dht)
-	bswap := bitswap.New(p.ctx, bswapnet, p.bstore)
-	p.bserv = blockservice.New(p.bstore, bswap)
+	var router routing.ContentRouting = p.dht
+	if p.cfg.ProviderSearchTimeout > 0 {
+		router = &providerSearchRouter{ContentRouting: p.dht, timeout: p.cfg.ProviderSearchTimeout}
+	}
+	var netOpts []network.NetOpt
+	if prefix := p.protocolPrefix(); prefix != "" {
+		netOpts = append(netOpts, network.Prefix(prefix))
+	}
+	var bswapnet network.BitSwapNetwork = network.NewFromIpfsHost(p.host, router, netOpts...)
+	if p.cfg.BitswapPeerFilter != nil {
+		fnet := newFilteredNetwork(bswapnet, p.host, p.cfg.BitswapPeerFilter)
+		if err := fnet.watchIdentify(p.ctx); err != nil {
+			return err
+		}
+		bswapnet = fnet
+	}
+	var bsOpts []bitswap.Option
+	var tracers multiTracer
+	if p.cfg.PeerStats || p.cfg.FavorReciprocatingPeers {
+		tracker, err := newPeerStatsTracker(p.ctx, p.datastore(MetaNamespace), p.host.Peerstore())
+		if err != nil {
+			return err
+		}
+		p.peerStats = tracker
+		tracers = append(tracers, tracker)
+		if p.cfg.FavorReciprocatingPeers {
+			bsOpts = append(bsOpts, bitswap.WithTaskComparator(tracker.favorReciprocating))
+		}
+	}
+	if p.cfg.ServePinnedOnly {
+		p.servePinned = newPinnedServeSet()
+	}
+	var filter func(peer.ID, cid.Cid) bool
+	if p.hasServePolicy() {
+		filter = p.allowServe
+	}
+	if p.cfg.ServeDailyQuota > 0 || p.cfg.ServeRateLimit > 0 {
+		quotas := newServeQuotas(p.cfg.ServeDailyQuota, p.cfg.ServeRateLimit, &p.throttle)
+		tracers = append(tracers, quotas)
+		if filter == nil {
+			filter = quotas.allow
+		} else {
+			filter = func(pid peer.ID, c cid.Cid) bool {
+				return p.allowServe(pid, c) && quotas.allow(pid, c)
+			}
+		}
+	}
+	if filter != nil {
+		bsOpts = append(bsOpts, bitswap.WithPeerBlockRequestFilter(filter))
+	}
+	if p.popularity != nil {
+		tracers = append(tracers, p.popularity)
+	}
+	// The compressed blocks protocol serves and accounts for blocks
+	// like bitswap.
+	p.serveFilter = filter
+	p.tracers = tracers
+	switch len(tracers) {
+	case 0:
+	case 1:
+		bsOpts = append(bsOpts, bitswap.WithTracer(tracers[0]))
+	default:
+		bsOpts = append(bsOpts, bitswap.WithTracer(tracers))
+	}
+	bswap := bitswap.New(p.ctx, bswapnet, p.bstore, bsOpts...)
+	var exch exchange.Interface = bswap
+	if p.cfg.CompressedTransfers {
+		p.host.SetStreamHandler(p.compressedProtocol(), p.handleCompressedBlocks)
+		exch = &compressedExchange{SessionExchange: bswap, p: p}
+	}
+	if p.cfg.ReadOnly {
+		// Serve blocks with bitswap, but do not fetch any.
+		exch = offline.Exchange(p.bstore)
+	}
+	p.bserv = blockservice.New(p.bstore, exch, p.blockServiceOptions()...)
 	p.exch = bswap
 	return nil
 }
@@ -171,9 +779,13 @@ func (p *Peer) setupReprovider() error {
 		return nil
 	}
 
-	prov, err := provider.New(p.store,
+	var router provider.Provide = p.dht
+	if _, many := p.dht.(provider.ProvideMany); !many && p.cfg.ReprovideWorkers > 0 {
+		router = &parallelProvider{ContentRouting: p.dht, workers: p.cfg.ReprovideWorkers}
+	}
+	prov, err := provider.New(p.datastore(ProviderNamespace),
 		provider.DatastorePrefix(datastore.NewKey("repro")),
-		provider.Online(p.dht),
+		provider.Online(router),
 		provider.ReproviderInterval(p.cfg.ReprovideInterval),
 		provider.KeyProvider(provider.NewBlockstoreProvider(p.bstore)))
 	if err != nil {
@@ -184,18 +796,53 @@ func (p *Peer) setupReprovider() error {
 	return nil
 }
 
+func (p *Peer) setupProvideQueue() {
+	if p.cfg.Offline {
+		return
+	}
+	p.provideQueue = newProvideQueue(p.ctx, p.datastore(ProviderNamespace), p.dht, p.cfg.ProvideWorkers,
+		p.cfg.ProvideQueueSize, p.cfg.ProvideQueuePolicy)
+}
+
 func (p *Peer) autoclose() {
 	<-p.ctx.Done()
+	if p.provideQueue != nil {
+		p.provideQueue.Close()
+	}
 	p.reprovider.Close()
 	p.bserv.Close()
+	if p.cfg.CompressedTransfers && !p.cfg.Offline {
+		p.host.RemoveStreamHandler(p.compressedProtocol())
+	}
+	if p.metadata != nil {
+		p.host.RemoveStreamHandler(p.metadataProtocol())
+	}
+	if p.cfg.Admin != nil && !p.cfg.Offline && p.host != nil {
+		p.host.RemoveStreamHandler(p.adminProtocol())
+	}
+	if p.reconnect != nil {
+		p.host.Network().StopNotify(p.reconnect.notifiee)
+	}
+	p.closeErr = p.closeOwned()
+	close(p.closed)
 }
 
 // Bootstrap is an optional helper to connect to the given peers and bootstrap
 // the Peer DHT (and Bitswap). This is a best-effort function. Errors are only
 // logged and a warning is printed when less than half of the given peers
 // could be contacted. It is fine to pass a list where some peers will not be
-// reachable.
+// reachable. It runs until the Peer is closed at most: see BootstrapContext
+// to bound it.
 func (p *Peer) Bootstrap(peers []peer.AddrInfo) error {
+	return p.BootstrapContext(p.ctx, peers)
+}
+
+// BootstrapContext is like Bootstrap, giving up dialing the peers when ctx
+// is done.
+func (p *Peer) BootstrapContext(ctx context.Context, peers []peer.AddrInfo) error {
+	if p.cfg.Isolated {
+		peers = withoutPublicBootstrapPeers(peers)
+	}
 	connected := make(chan struct{})
 
 	var wg sync.WaitGroup
@@ -204,7 +851,10 @@ func (p *Peer) Bootstrap(peers []peer.AddrInfo) error {
 		wg.Add(1)
 		go func(pinfo peer.AddrInfo) {
 			defer wg.Done()
-			err := p.host.Connect(p.ctx, pinfo)
+			err := p.host.Connect(ctx, pinfo)
+			if p.reconnect != nil && p.reconnect.cfg.Bootstrap {
+				p.reconnect.watch(pinfo, true)
+			}
 			if err != nil {
 				logger.Warn(err)
 				return
@@ -227,13 +877,30 @@ func (p *Peer) Bootstrap(peers []peer.AddrInfo) error {
 		logger.Warnf("only connected to %d bootstrap peers out of %d", i, nPeers)
 	}
 
-	err := p.dht.Bootstrap(p.ctx)
+	err := p.dht.Bootstrap(ctx)
 	if err != nil {
 		logger.Error(err)
 	}
 	return err
 }
 
+// withoutPublicBootstrapPeers filters out the default bootstrap peers.
+func withoutPublicBootstrapPeers(peers []peer.AddrInfo) []peer.AddrInfo {
+	public := make(map[peer.ID]struct{})
+	for _, pi := range DefaultBootstrapPeers() {
+		public[pi.ID] = struct{}{}
+	}
+	var filtered []peer.AddrInfo
+	for _, pi := range peers {
+		if _, ok := public[pi.ID]; ok {
+			logger.Warnf("isolated peer: not bootstrapping to public peer %s", pi.ID)
+			continue
+		}
+		filtered = append(filtered, pi)
+	}
+	return filtered
+}
+
 // Session returns a session-based NodeGetter.
 func (p *Peer) Session(ctx context.Context) ipld.NodeGetter {
 	ng := merkledag.NewSession(ctx, p.DAGService)
@@ -253,69 +920,343 @@ type AddParams struct {
 	Shard     bool
 	NoCopy    bool
 	HashFun   string
+	// Reproducible guarantees that the same content always gets the
+	// same CIDs, across versions, i.e. for content verification
+	// pipelines. Adds fail with ErrNotReproducible unless Layout,
+	// Chunker and HashFun are set explicitly, to values whose meaning
+	// does not depend on defaults. See ReproducibleAddParams.
+	Reproducible bool
+	// Path is an optional UnixFS path for the file, passed to the ingest
+	// hooks (see Peer.AddIngestHook) and recorded in the add journal.
+	Path string
+	// Stats, when set, is filled with deduplication statistics about the
+	// added blocks.
+	Stats *AddStats
 }
 
 // AddFile chunks and adds content to the DAGService from a reader. The content
 // is stored as a UnixFS DAG (default for IPFS). It returns the root ipld.Node.
+// When the reader is an io.ReaderAt with a known size (i.e. an *os.File)
+// and a fixed-size chunker is used, chunks are read and hashed in parallel.
 func (p *Peer) AddFile(ctx context.Context, r io.Reader, params *AddParams) (ipld.Node, error) {
+	if p.cfg.ReadOnly {
+		return nil, ErrReadOnly
+	}
 	if params == nil {
 		params = &AddParams{}
 	}
+	entry, err := p.journalStart(ctx, params, false)
+	if err != nil {
+		return nil, err
+	}
+	n, err := p.addFile(ctx, r, params)
+	root := cid.Undef
+	if err == nil {
+		root = n.Cid()
+	}
+	p.journalFinish(entry, root, err)
+	return n, err
+}
+
+func (p *Peer) addFile(ctx context.Context, r io.Reader, params *AddParams) (ipld.Node, error) {
+	r, err := p.checkAddLimits(r, params, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	batch := newAddBatch(p, p.addWorkers)
+	var dserv ipld.DAGService = batch
+	if params.Stats != nil {
+		*params.Stats = AddStats{}
+		dserv = &statsDAGService{DAGService: batch, bs: p.bstore, stats: params.Stats}
+	}
+
+	n, size, err := buildFile(r, params, dserv)
+	if cerr := batch.Commit(ctx); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return nil, err
+	}
+	p.ingestFile(ctx, IngestFileAdded, n.Cid(), size, params.Path)
+	// The whole network broadcasts the success of storing the cid.
+	if err := p.provide(ctx, n.Cid()); err != nil {
+		return nil, err
+	}
+	return n, nil
+}
+
+// HashOnly computes the CID of the root of the DAG that AddFile would create
+// for the given content and parameters, without storing any blocks, like
+// "ipfs add --only-hash". It can be used to check whether content is
+// already available before adding or uploading it.
+func (p *Peer) HashOnly(ctx context.Context, r io.Reader, params *AddParams) (cid.Cid, error) {
+	if params == nil {
+		params = &AddParams{}
+	}
+	n, _, err := buildFile(r, params, discardDAGService{})
+	if err != nil {
+		return cid.Undef, err
+	}
+	return n.Cid(), nil
+}
+
+// discardDAGService is a DAGService which does not store anything.
+type discardDAGService struct{}
+
+func (discardDAGService) Get(ctx context.Context, c cid.Cid) (ipld.Node, error) {
+	return nil, ipld.ErrNotFound{Cid: c}
+}
+
+func (discardDAGService) GetMany(ctx context.Context, cids []cid.Cid) <-chan *ipld.NodeOption {
+	ch := make(chan *ipld.NodeOption, len(cids))
+	for _, c := range cids {
+		ch <- &ipld.NodeOption{Err: ipld.ErrNotFound{Cid: c}}
+	}
+	close(ch)
+	return ch
+}
+
+func (discardDAGService) Add(context.Context, ipld.Node) error        { return nil }
+func (discardDAGService) AddMany(context.Context, []ipld.Node) error  { return nil }
+func (discardDAGService) Remove(context.Context, cid.Cid) error       { return nil }
+func (discardDAGService) RemoveMany(context.Context, []cid.Cid) error { return nil }
+
+// buildFile chunks the content of the reader into a UnixFS DAG, whose nodes
+// are added to the given DAGService, and returns the root node and the
+// size of the content. Fixed-size chunks of io.ReaderAt sources with a
+// known size are read and hashed in parallel (see parallelSplitter).
+func buildFile(r io.Reader, params *AddParams, dserv ipld.DAGService) (ipld.Node, int64, error) {
+	maxLinks := helpers.DefaultLinksPerBlock
+	if params.Reproducible {
+		if err := params.checkReproducible(); err != nil {
+			return nil, 0, err
+		}
+		maxLinks = reproducibleMaxLinks
+	}
 	if params.HashFun == "" {
 		params.HashFun = "sha2-256"
 	}
 
 	prefix, err := merkledag.PrefixForCidVersion(1)
 	if err != nil {
-		return nil, fmt.Errorf("bad CID Version: %s", err)
+		return nil, 0, fmt.Errorf("bad CID Version: %s", err)
 	}
 
 	hashFunCode, ok := multihash.Names[strings.ToLower(params.HashFun)]
 	if !ok {
-		return nil, fmt.Errorf("unrecognized hash function: %s", params.HashFun)
+		return nil, 0, fmt.Errorf("unrecognized hash function: %s", params.HashFun)
 	}
 	prefix.MhType = hashFunCode
 	prefix.MhLength = -1
 
+	leafType := unixfs.TFile
+	var layout func(*helpers.DagBuilderHelper) (ipld.Node, error)
+	switch params.Layout {
+	case "trickle":
+		layout = trickle.Layout
+		leafType = unixfs.TRaw
+	case "balanced", "":
+		layout = balanced.Layout
+	default:
+		return nil, 0, errors.New("invalid Layout")
+	}
+
 	dbp := helpers.DagBuilderParams{
-		Dagserv:    p,
+		Dagserv:    dserv,
 		RawLeaves:  params.RawLeaves,
-		Maxlinks:   helpers.DefaultLinksPerBlock,
+		Maxlinks:   maxLinks,
 		NoCopy:     params.NoCopy,
 		CidBuilder: &prefix,
 	}
 
-	chnk, err := chunker.FromString(r, params.Chunker)
+	chunkSize, fixed := fixedChunkSize(params.Chunker)
+	ra, start, size, isReaderAt := readerAtSource(r)
+	if fixed && isReaderAt && !params.NoCopy && size > chunkSize {
+		leafParams := dbp
+		leafParams.Dagserv = discardDAGService{}
+		leaves, err := leafParams.New(chunker.NewSizeSplitter(r, chunkSize))
+		if err != nil {
+			return nil, 0, err
+		}
+		spl := newParallelSplitter(r, ra, start, size, chunkSize, leaves, leafType)
+		defer spl.Close()
+		dbp.CidBuilder = prehashingBuilder{Builder: &prefix, q: spl.q}
+		dbh, err := dbp.New(spl)
+		if err != nil {
+			return nil, 0, err
+		}
+		n, err := layout(dbh)
+		if err != nil {
+			return nil, 0, err
+		}
+		// Leave the source where a sequential read would have.
+		if s, ok := r.(io.Seeker); ok {
+			_, err = s.Seek(start+size, io.SeekStart)
+		}
+		return n, size, err
+	}
+
+	cr := &countingReader{Reader: r}
+	chnk, err := chunker.FromString(cr, params.Chunker)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 	dbh, err := dbp.New(chnk)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
+	n, err := layout(dbh)
+	return n, cr.n, err
+}
 
-	var n ipld.Node
-	switch params.Layout {
-	case "trickle":
-		n, err = trickle.Layout(dbh)
-	case "balanced", "":
-		n, err = balanced.Layout(dbh)
-	default:
-		return nil, errors.New("invalid Layout")
+// provide schedules the given CID to be announced to the network. It is a
+// no-op when the Peer is offline. When the provide queue is full, it may
+// wait for room until the context is cancelled (see ProvideBlock).
+func (p *Peer) provide(ctx context.Context, c cid.Cid) error {
+	if p.provideQueue == nil {
+		return nil
 	}
-	//The whole network broadcasts the success of storing the cid.
-	go p.dht.Provide(ctx, n.Cid(), true)
-	return n, err
+	err := p.provideQueue.Enqueue(ctx, c)
+	if err != nil && ctx.Err() == nil {
+		logger.Errorf("error queuing %s for providing: %s", c, err)
+	}
+	return err
+}
+
+// ProvideQueueStats returns statistics about the queue of CIDs waiting to
+// be announced to the network. They are empty when the Peer is offline.
+func (p *Peer) ProvideQueueStats() ProvideQueueStats {
+	if p.provideQueue == nil {
+		return ProvideQueueStats{}
+	}
+	return p.provideQueue.Stats()
 }
 
 // GetFile returns a reader to a file as identified by its root CID. The file
-// must have been added as a UnixFS DAG (default for IPFS).
-func (p *Peer) GetFile(ctx context.Context, c cid.Cid) (ufsio.ReadSeekCloser, error) {
-	n, err := p.Get(ctx, c)
+// must have been added as a UnixFS DAG (default for IPFS). FetchOptions can
+// be given to tune how the file is retrieved from the network.
+//
+// When the number of parallel fetches is limited (see
+// Config.MaxParallelFetches), the returned reader holds a fetch slot until
+// it is closed. With WithPartialResults, the missing parts of the file are
+// reported when the fetch deadline expires.
+func (p *Peer) GetFile(ctx context.Context, c cid.Cid, opts ...FetchOption) (ufsio.ReadSeekCloser, error) {
+	fopts := newFetchOptions(opts)
+	ctx, cancel := p.fetchContext(ctx, fopts)
+	release, err := p.scheduler.acquire(ctx, fopts.priority)
 	if err != nil {
+		cancel()
 		return nil, err
 	}
-	return ufsio.NewDagReader(ctx, n, p)
+
+	ng := p.fetchSession(ctx, c, fopts)
+	n, err := ng.Get(ctx, c)
+	if err != nil {
+		if fopts.partial {
+			err = p.partialError(ctx, c, 0, err)
+		}
+		release()
+		cancel()
+		return nil, err
+	}
+	dr, err := ufsio.NewDagReader(ctx, n, ng)
+	if err != nil {
+		release()
+		cancel()
+		return nil, err
+	}
+	p.ingestFile(ctx, IngestFileFetched, c, int64(dr.Size()), "")
+	var r ufsio.ReadSeekCloser = &scheduledDagReader{DagReader: dr, release: release, cancel: cancel}
+	if fopts.partial {
+		r = &partialDagReader{ReadSeekCloser: r, p: p, ctx: ctx, root: c}
+	}
+	return r, nil
+}
+
+// GetMany retrieves the nodes with the given CIDs, i.e. the roots of related
+// files such as the tracks of an album, with a single bitswap session, so
+// that the providers found for one of them are asked for the others first,
+// instead of searching for providers of every node. The providers of the
+// first CID are looked up as with Fetch. It overrides the GetMany method of
+// the embedded DAGService, so the Peer stays an ipld.DAGService. GetFiles
+// returns readers for UnixFS files instead.
+func (p *Peer) GetMany(ctx context.Context, cids []cid.Cid) <-chan *ipld.NodeOption {
+	if len(cids) == 0 {
+		out := make(chan *ipld.NodeOption)
+		close(out)
+		return out
+	}
+	return p.fetchSession(ctx, cids[0], newFetchOptions(nil)).GetMany(ctx, cids)
+}
+
+// GetFiles is like GetFile for several related files (i.e. the tracks of an
+// album). They are retrieved with a single bitswap session, like GetMany,
+// so that the providers found for one of them are asked for the others
+// first, instead of searching for providers of every file. The readers are returned in
+// the order of the roots. They share a fetch slot and the fetch timeout,
+// which are released once all of them are closed. The providers looked up
+// with WithMaxProviders are those of the first root.
+func (p *Peer) GetFiles(ctx context.Context, roots []cid.Cid, opts ...FetchOption) ([]ufsio.ReadSeekCloser, error) {
+	if len(roots) == 0 {
+		return nil, nil
+	}
+	fopts := newFetchOptions(opts)
+	ctx, cancel := p.fetchContext(ctx, fopts)
+	release, err := p.scheduler.acquire(ctx, fopts.priority)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	done := func() {
+		release()
+		cancel()
+	}
+
+	ng := p.fetchSession(ctx, roots[0], fopts)
+	nodes := make(map[cid.Cid]ipld.Node, len(roots))
+	for opt := range ng.GetMany(ctx, roots) {
+		if opt.Err != nil {
+			done()
+			return nil, opt.Err
+		}
+		nodes[opt.Node.Cid()] = opt.Node
+	}
+
+	readers := make([]ufsio.ReadSeekCloser, 0, len(roots))
+	closeAll := func() {
+		for _, r := range readers {
+			r.Close()
+		}
+	}
+	open := int32(len(roots))
+	for _, c := range roots {
+		n, ok := nodes[c]
+		if !ok {
+			closeAll()
+			done()
+			return nil, ipld.ErrNotFound{Cid: c}
+		}
+		dr, err := ufsio.NewDagReader(ctx, n, ng)
+		if err != nil {
+			closeAll()
+			done()
+			return nil, err
+		}
+		var once sync.Once
+		closed := func() {
+			once.Do(func() {
+				if atomic.AddInt32(&open, -1) == 0 {
+					done()
+				}
+			})
+		}
+		readers = append(readers, &scheduledDagReader{DagReader: dr, release: func() {}, cancel: closed})
+	}
+	for i, c := range roots {
+		p.ingestFile(ctx, IngestFileFetched, c, int64(readers[i].(ufsio.DagReader).Size()), "")
+	}
+	return readers, nil
 }
 
 // BlockStore offers access to the blockstore underlying the Peer's DAGService.
@@ -329,6 +1270,53 @@ func (p *Peer) HasBlock(ctx context.Context, c cid.Cid) (bool, error) {
 	return p.BlockStore().Has(ctx, c)
 }
 
+// GetBlock returns the block with the given CID, fetching it from the
+// network when it is not available locally. Unlike Get, the block is not
+// decoded into an ipld.Node: its RawData is the data returned by the
+// blockstore, without any copy, and must not be modified.
+func (p *Peer) GetBlock(ctx context.Context, c cid.Cid) (blocks.Block, error) {
+	return p.bserv.GetBlock(ctx, c)
+}
+
+// GetBlockInto reads the data of the block with the given CID into buf,
+// which is grown when too small, and returns the filled slice. It allows
+// callers serving many blocks (i.e. gateways) to reuse their buffers
+// across calls (i.e. with a sync.Pool), rather than keeping a new
+// allocation per block. When the blockstore implements blockstore.Viewer,
+// local blocks are copied straight from it.
+func (p *Peer) GetBlockInto(ctx context.Context, c cid.Cid, buf []byte) ([]byte, error) {
+	if v, ok := p.bstore.(blockstore.Viewer); ok {
+		err := v.View(ctx, c, func(data []byte) error {
+			buf = append(buf[:0], data...)
+			return nil
+		})
+		if !ipld.IsNotFound(err) {
+			if err != nil {
+				return buf[:0], err
+			}
+			return buf, nil
+		}
+	}
+	blk, err := p.GetBlock(ctx, c)
+	if err != nil {
+		return buf[:0], err
+	}
+	return append(buf[:0], blk.RawData()...), nil
+}
+
+// viewBlock calls f with the data of the block with the given CID, from bs
+// when it is a blockstore.Viewer, or from a copy otherwise.
+func viewBlock(ctx context.Context, bs blockstore.Blockstore, c cid.Cid, f func([]byte) error) error {
+	if v, ok := bs.(blockstore.Viewer); ok {
+		return v.View(ctx, c, f)
+	}
+	blk, err := bs.Get(ctx, c)
+	if err != nil {
+		return err
+	}
+	return f(blk.RawData())
+}
+
 // Exchange returns the underlying exchange implementation.
 func (p *Peer) Exchange() exchange.Interface {
 	return p.exch
@@ -5,8 +5,13 @@ import (
 	"context"
 	"encoding/hex"
 	"io"
+	"strings"
 	"testing"
+	"time"
 
+	blockstore "github.com/ipfs/boxo/blockstore"
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
 	cbor "github.com/ipfs/go-ipld-cbor"
 	dht "github.com/libp2p/go-libp2p-kad-dht"
 	"github.com/libp2p/go-libp2p/core/crypto"
@@ -99,6 +104,25 @@ func setupPeers(t *testing.T) (p1, p2 *Peer, closer func(t *testing.T)) {
 	return
 }
 
+func TestBootstrapContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	p1 := setupPeer(t, ctx, nil)
+	p2 := setupPeer(t, ctx, nil)
+
+	// The peers are not dialed once the context is done.
+	cctx, ccancel := context.WithCancel(ctx)
+	ccancel()
+	p1.BootstrapContext(cctx, []peer.AddrInfo{{ID: p2.ID(), Addrs: p2.Addrs()}})
+	if len(p1.host.Network().ConnsToPeer(p2.ID())) > 0 {
+		t.Error("the peer should not be dialed with a cancelled context")
+	}
+	p1.BootstrapContext(ctx, []peer.AddrInfo{{ID: p2.ID(), Addrs: p2.Addrs()}})
+	if len(p1.host.Network().ConnsToPeer(p2.ID())) == 0 {
+		t.Error("the peer should be dialed")
+	}
+}
+
 func TestDAG(t *testing.T) {
 	ctx := context.Background()
 	p1, p2, closer := setupPeers(t)
@@ -201,3 +225,169 @@ func TestFiles(t *testing.T) {
 		t.Error("different content put and retrieved")
 	}
 }
+
+func TestGetBlockInto(t *testing.T) {
+	ctx := context.Background()
+	p1, p2, closer := setupPeers(t)
+	defer closer(t)
+
+	codec := uint64(multihash.SHA2_256)
+	node, err := cbor.WrapObject(map[string]string{"akey": "avalue"}, codec, multihash.DefaultLengths[codec])
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = p1.Add(ctx, node)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	blk, err := p2.GetBlock(ctx, node.Cid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(blk.RawData(), node.RawData()) {
+		t.Error("unexpected block data")
+	}
+
+	buf := make([]byte, 0, 1024)
+	data, err := p1.GetBlockInto(ctx, node.Cid(), buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, node.RawData()) {
+		t.Error("unexpected block data")
+	}
+	if &data[0] != &buf[:1][0] {
+		t.Error("buffer was not reused")
+	}
+
+	// Blocks missing locally are fetched.
+	data, err = p2.GetBlockInto(ctx, node.Cid(), data)
+	if err != nil || !bytes.Equal(data, node.RawData()) {
+		t.Errorf("unexpected block data: %v", err)
+	}
+}
+
+// viewerBlockstore counts the blocks read with View and Get.
+type viewerBlockstore struct {
+	blockstore.Blockstore
+	views, gets int
+}
+
+func (bs *viewerBlockstore) View(ctx context.Context, c cid.Cid, f func([]byte) error) error {
+	blk, err := bs.Blockstore.Get(ctx, c)
+	if err != nil {
+		return err
+	}
+	bs.views++
+	return f(blk.RawData())
+}
+
+func (bs *viewerBlockstore) Get(ctx context.Context, c cid.Cid) (blocks.Block, error) {
+	bs.gets++
+	return bs.Blockstore.Get(ctx, c)
+}
+
+func TestGetBlockIntoViewer(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ds := NewInMemoryDatastore()
+	bs := &viewerBlockstore{Blockstore: blockstore.NewBlockstore(ds)}
+	p, err := New(ctx, ds, bs, nil, nil, &Config{Offline: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err := p.AddFile(ctx, strings.NewReader("viewed"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bs.gets = 0
+	data, err := p.GetBlockInto(ctx, n.Cid(), nil)
+	if err != nil || !bytes.Equal(data, n.RawData()) {
+		t.Fatalf("unexpected block data: %v", err)
+	}
+	if bs.views != 1 || bs.gets != 0 {
+		t.Errorf("the block should be viewed: %d views, %d gets", bs.views, bs.gets)
+	}
+}
+
+func TestGetMany(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	p1 := setupPeer(t, ctx, nil)
+	p2 := setupPeer(t, ctx, nil)
+	err := p2.host.Connect(ctx, peer.AddrInfo{ID: p1.host.ID(), Addrs: p1.host.Addrs()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var roots []cid.Cid
+	for _, content := range []string{"track one", "track two", "track three"} {
+		n, err := p1.AddFile(ctx, strings.NewReader(content), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		roots = append(roots, n.Cid())
+	}
+
+	getCtx, getCancel := context.WithTimeout(ctx, 10*time.Second)
+	defer getCancel()
+	got := make(map[cid.Cid]bool)
+	for opt := range p2.GetMany(getCtx, roots) {
+		if opt.Err != nil {
+			t.Fatal(opt.Err)
+		}
+		got[opt.Node.Cid()] = true
+	}
+	for _, c := range roots {
+		if !got[c] {
+			t.Errorf("%s was not retrieved", c)
+		}
+	}
+	for range p2.GetMany(ctx, nil) {
+		t.Error("no nodes expected")
+	}
+}
+
+func TestGetFiles(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	p1 := setupPeer(t, ctx, nil)
+	p2 := setupPeer(t, ctx, &Config{MaxParallelFetches: 1})
+	err := p2.host.Connect(ctx, peer.AddrInfo{ID: p1.host.ID(), Addrs: p1.host.Addrs()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	contents := []string{"track one", "track two", "track three"}
+	var roots []cid.Cid
+	for _, content := range contents {
+		n, err := p1.AddFile(ctx, strings.NewReader(content), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		roots = append(roots, n.Cid())
+	}
+
+	readers, err := p2.GetFiles(ctx, roots, WithTimeout(10*time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, r := range readers {
+		data, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != contents[i] {
+			t.Errorf("expected %q, got %q", contents[i], data)
+		}
+		r.Close()
+	}
+
+	// The fetch slot is released once all the readers are closed.
+	fetchCtx, fetchCancel := context.WithTimeout(ctx, time.Second)
+	defer fetchCancel()
+	if _, err := p2.Fetch(fetchCtx, roots[0]); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,272 @@
+package ipfslite
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ipfs/boxo/ipns"
+	"github.com/ipfs/boxo/namesys"
+	"github.com/ipfs/boxo/path"
+	routinghelpers "github.com/libp2p/go-libp2p-routing-helpers"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/routing"
+)
+
+// ipnsRetryInterval sets how long to wait before retrying a failed
+// republication.
+var ipnsRetryInterval = time.Minute
+
+// PublishOption configures how IPNS records are published by PublishIPNS.
+type PublishOption func(*publishOptions)
+
+type publishOptions struct {
+	lifetime    time.Duration
+	ttl         time.Duration
+	seq         uint64
+	hasSeq      bool
+	noRepublish bool
+	until       time.Time
+}
+
+func newPublishOptions(opts []PublishOption) *publishOptions {
+	popts := &publishOptions{
+		lifetime: ipns.DefaultRecordLifetime,
+		ttl:      ipns.DefaultRecordTTL,
+	}
+	for _, o := range opts {
+		o(popts)
+	}
+	return popts
+}
+
+// WithLifetime sets how long the published record is valid (its EOL is the
+// publication time plus the lifetime). Defaults to
+// ipns.DefaultRecordLifetime.
+func WithLifetime(lifetime time.Duration) PublishOption {
+	return func(o *publishOptions) {
+		o.lifetime = lifetime
+	}
+}
+
+// WithTTL sets how long resolvers may cache the published record before
+// looking for updates. Defaults to ipns.DefaultRecordTTL.
+func WithTTL(ttl time.Duration) PublishOption {
+	return func(o *publishOptions) {
+		o.ttl = ttl
+	}
+}
+
+// WithSequence sets the sequence number of the published record, which must
+// be higher than the one of the last published record. By default, the
+// last sequence number (looked up in the datastore, or in the network if
+// the name was never published by this peer) is incremented when the value
+// changes.
+func WithSequence(seq uint64) PublishOption {
+	return func(o *publishOptions) {
+		o.seq = seq
+		o.hasSeq = true
+	}
+}
+
+// WithoutRepublish disables the automatic republication of the record.
+func WithoutRepublish() PublishOption {
+	return func(o *publishOptions) {
+		o.noRepublish = true
+	}
+}
+
+// republishUntil stops the automatic republication of the record after the
+// given time.
+func republishUntil(t time.Time) PublishOption {
+	return func(o *publishOptions) {
+		o.until = t
+	}
+}
+
+// ipnsName is a name which is republished in the background.
+type ipnsName struct {
+	key   crypto.PrivKey
+	opts  *publishOptions
+	timer *time.Timer
+}
+
+// valueStore returns the router used for IPNS records.
+func (p *Peer) valueStore() routing.ValueStore {
+	if p.cfg.Offline || p.dht == nil {
+		return routinghelpers.Null{}
+	}
+	return p.dht
+}
+
+// PublishIPNS publishes an IPNS record pointing the name of the given key to
+// value (i.e. an /ipfs/ path). Published records are stored in the
+// datastore, so that sequence numbers keep increasing across restarts.
+// Unless WithoutRepublish is given, the record is published again in the
+// background, with a new validity, when half of its lifetime has passed, so
+// that the name does not go stale while the Peer runs. Republication uses
+// the last value published for the name, and stops when a later
+// PublishIPNS call for the same name disables it.
+func (p *Peer) PublishIPNS(ctx context.Context, key crypto.PrivKey, value path.Path, opts ...PublishOption) error {
+	popts := newPublishOptions(opts)
+	id, err := peer.IDFromPrivateKey(key)
+	if err != nil {
+		return err
+	}
+
+	p.ipnsMu.Lock()
+	defer p.ipnsMu.Unlock()
+	err = p.publishIPNS(ctx, key, id, value, popts)
+	if err != nil {
+		return err
+	}
+	p.scheduleRepublish(key, id, popts)
+	return nil
+}
+
+// publishIPNS creates, stores and publishes a record. ipnsMu must be held.
+func (p *Peer) publishIPNS(ctx context.Context, key crypto.PrivKey, id peer.ID, value path.Path, opts *publishOptions) error {
+	name := ipns.NameFromPeer(id)
+	vs := p.valueStore()
+	publisher := namesys.NewIPNSPublisher(vs, p.datastore(MetaNamespace))
+	last, err := publisher.GetPublished(ctx, name, true)
+	if err != nil {
+		return err
+	}
+
+	var seq uint64
+	if last != nil {
+		lastSeq, err := last.Sequence()
+		if err != nil {
+			return err
+		}
+		lastValue, err := last.Value()
+		if err != nil {
+			return err
+		}
+		seq = lastSeq
+		if lastValue.String() != value.String() {
+			seq++
+		}
+		if opts.hasSeq && opts.seq <= lastSeq {
+			return fmt.Errorf("sequence number %d is not higher than the last published one (%d)", opts.seq, lastSeq)
+		}
+	}
+	if opts.hasSeq {
+		seq = opts.seq
+	}
+
+	rec, err := ipns.NewRecord(key, value, seq, time.Now().Add(opts.lifetime), opts.ttl)
+	if err != nil {
+		return err
+	}
+	data, err := ipns.MarshalRecord(rec)
+	if err != nil {
+		return err
+	}
+	err = p.datastore(MetaNamespace).Put(ctx, namesys.IpnsDsKey(name), data)
+	if err != nil {
+		return err
+	}
+	return namesys.PublishIPNSRecord(ctx, vs, key.GetPublic(), rec)
+}
+
+// scheduleRepublish (re)schedules the republication of a name. ipnsMu must
+// be held.
+func (p *Peer) scheduleRepublish(key crypto.PrivKey, id peer.ID, opts *publishOptions) {
+	if n, ok := p.ipnsNames[id]; ok {
+		n.timer.Stop()
+		delete(p.ipnsNames, id)
+	}
+	if opts.noRepublish {
+		return
+	}
+	if p.ipnsNames == nil {
+		p.ipnsNames = make(map[peer.ID]*ipnsName)
+	}
+	n := &ipnsName{key: key, opts: opts}
+	n.timer = time.AfterFunc(opts.lifetime/2, func() {
+		p.republish(id, n)
+	})
+	p.ipnsNames[id] = n
+}
+
+// republish publishes the last published value of a name again, with a new
+// validity.
+func (p *Peer) republish(id peer.ID, n *ipnsName) {
+	p.ipnsMu.Lock()
+	defer p.ipnsMu.Unlock()
+	if p.ipnsNames[id] != n || p.ctx.Err() != nil {
+		return
+	}
+	if !n.opts.until.IsZero() && time.Now().After(n.opts.until) {
+		delete(p.ipnsNames, id)
+		return
+	}
+
+	// Only the validity changes: keep the sequence number.
+	opts := *n.opts
+	opts.hasSeq = false
+	err := p.republishIPNS(id, n.key, &opts)
+	if err != nil {
+		logger.Warnf("error republishing IPNS name %s: %s", ipns.NameFromPeer(id), err)
+		n.timer.Reset(ipnsRetryInterval)
+		return
+	}
+	n.timer.Reset(n.opts.lifetime / 2)
+}
+
+func (p *Peer) republishIPNS(id peer.ID, key crypto.PrivKey, opts *publishOptions) error {
+	value, err := p.publishedIPNS(p.ctx, id)
+	if err != nil {
+		return err
+	}
+	return p.publishIPNS(p.ctx, key, id, value, opts)
+}
+
+// publishedIPNS returns the value last published by this peer for the name
+// of the given peer ID.
+func (p *Peer) publishedIPNS(ctx context.Context, id peer.ID) (path.Path, error) {
+	publisher := namesys.NewIPNSPublisher(p.valueStore(), p.datastore(MetaNamespace))
+	rec, err := publisher.GetPublished(ctx, ipns.NameFromPeer(id), false)
+	if err != nil {
+		return nil, err
+	}
+	if rec == nil {
+		return nil, fmt.Errorf("no IPNS record published for %s", id)
+	}
+	return rec.Value()
+}
+
+// RotateIPNSKey moves the content published under the name of oldKey to the
+// name of newKey: the current value of the old name, as last published by
+// this peer, is published under the new name, and the old name is pointed
+// to the new one (/ipns/<new name>), so that resolving it keeps working
+// and tells clients where the content moved. The new name is then
+// republished like with PublishIPNS, while the old one is only republished
+// during the grace period, after which it is left to expire.
+func (p *Peer) RotateIPNSKey(ctx context.Context, oldKey, newKey crypto.PrivKey, grace time.Duration) error {
+	oldID, err := peer.IDFromPrivateKey(oldKey)
+	if err != nil {
+		return err
+	}
+	newID, err := peer.IDFromPrivateKey(newKey)
+	if err != nil {
+		return err
+	}
+	if oldID == newID {
+		return errors.New("the new key must be different from the old one")
+	}
+
+	value, err := p.publishedIPNS(ctx, oldID)
+	if err != nil {
+		return err
+	}
+	err = p.PublishIPNS(ctx, newKey, value)
+	if err != nil {
+		return err
+	}
+	return p.PublishIPNS(ctx, oldKey, ipns.NameFromPeer(newID).AsPath(), republishUntil(time.Now().Add(grace)))
+}
@@ -0,0 +1,155 @@
+package ipfslite
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ipfs/boxo/ipns"
+	"github.com/ipfs/boxo/namesys"
+	"github.com/ipfs/boxo/path"
+	"github.com/libp2p/go-libp2p-kad-dht/dual"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// setupDHTPeers returns two connected peers, once they are in each other's
+// DHT routing tables.
+func setupDHTPeers(t *testing.T, ctx context.Context) (*Peer, *Peer) {
+	t.Helper()
+	p1 := setupPeer(t, ctx, nil)
+	p2 := setupPeer(t, ctx, nil)
+	err := p2.host.Connect(ctx, peer.AddrInfo{ID: p1.host.ID(), Addrs: p1.host.Addrs()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range []*Peer{p1, p2} {
+		for p.dht.(*dual.DHT).LAN.RoutingTable().Size() == 0 {
+			if ctx.Err() != nil {
+				t.Fatal("peers did not join each other's DHT")
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+	}
+	return p1, p2
+}
+
+func TestRotateIPNSKey(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	p1, p2 := setupDHTPeers(t, ctx)
+
+	oldKey, _, err := crypto.GenerateKeyPair(crypto.Ed25519, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newKey, _, err := crypto.GenerateKeyPair(crypto.Ed25519, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	value := path.FromCid(testCid(t, "content"))
+
+	if err := p1.RotateIPNSKey(ctx, oldKey, newKey, time.Hour); err == nil {
+		t.Error("rotating an unpublished name should fail")
+	}
+	if err := p1.PublishIPNS(ctx, oldKey, value); err != nil {
+		t.Fatal(err)
+	}
+	if err := p1.RotateIPNSKey(ctx, oldKey, newKey, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	ns, err := namesys.NewNameSystem(p2.dht)
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldID, _ := peer.IDFromPrivateKey(oldKey)
+	newID, _ := peer.IDFromPrivateKey(newKey)
+	for _, id := range []peer.ID{oldID, newID} {
+		res, err := ns.Resolve(ctx, ipns.NameFromPeer(id).AsPath())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.Path.String() != value.String() {
+			t.Errorf("%s resolved to %s, expected %s", id, res.Path, value)
+		}
+	}
+
+	res, err := ns.Resolve(ctx, ipns.NameFromPeer(oldID).AsPath(), namesys.ResolveWithDepth(1))
+	if err != nil && !errors.Is(err, namesys.ErrResolveRecursion) {
+		t.Fatal(err)
+	}
+	if res.Path.String() != ipns.NameFromPeer(newID).AsPath().String() {
+		t.Errorf("the old name should point to the new one, got %s", res.Path)
+	}
+}
+
+func storedIPNSRecord(t *testing.T, p *Peer, id peer.ID) *ipns.Record {
+	t.Helper()
+	data, err := p.datastore(MetaNamespace).Get(context.Background(), namesys.IpnsDsKey(ipns.NameFromPeer(id)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec, err := ipns.UnmarshalRecord(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rec
+}
+
+func TestPublishIPNSOptions(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	p1, _ := setupDHTPeers(t, ctx)
+
+	key, _, err := crypto.GenerateKeyPair(crypto.Ed25519, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, _ := peer.IDFromPrivateKey(key)
+	value := path.FromCid(testCid(t, "content"))
+
+	err = p1.PublishIPNS(ctx, key, value, WithTTL(time.Minute), WithLifetime(time.Second), WithSequence(5))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := storedIPNSRecord(t, p1, id)
+	if ttl, _ := rec.TTL(); ttl != time.Minute {
+		t.Errorf("expected a TTL of 1m, got %s", ttl)
+	}
+	if seq, _ := rec.Sequence(); seq != 5 {
+		t.Errorf("expected sequence 5, got %d", seq)
+	}
+	eol, _ := rec.Validity()
+	if time.Until(eol) > time.Second {
+		t.Errorf("unexpected EOL %s", eol)
+	}
+
+	err = p1.PublishIPNS(ctx, key, value, WithLifetime(time.Second), WithSequence(5))
+	if err == nil {
+		t.Error("publishing with a sequence number which is not higher should fail")
+	}
+
+	// The record is republished after half its lifetime, with the same
+	// sequence number.
+	time.Sleep(800 * time.Millisecond)
+	rec = storedIPNSRecord(t, p1, id)
+	if seq, _ := rec.Sequence(); seq != 5 {
+		t.Errorf("expected sequence 5 after republishing, got %d", seq)
+	}
+	if republished, _ := rec.Validity(); !republished.After(eol) {
+		t.Error("the record should have been republished")
+	}
+
+	err = p1.PublishIPNS(ctx, key, value, WithoutRepublish())
+	if err != nil {
+		t.Fatal(err)
+	}
+	p1.ipnsMu.Lock()
+	_, ok := p1.ipnsNames[id]
+	p1.ipnsMu.Unlock()
+	if ok {
+		t.Error("the name should not be republished anymore")
+	}
+}
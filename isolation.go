@@ -0,0 +1,78 @@
+package ipfslite
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// IsolationHook is a function called when the Peer has had no connected
+// peers for Config.IsolationTimeout. It can run alternative discovery
+// mechanisms (i.e. a rendezvous service) and connect to the peers found,
+// or alert an operator. The context is cancelled after IsolationTimeout.
+type IsolationHook func(ctx context.Context)
+
+type isolationHooks struct {
+	mu    sync.RWMutex
+	hooks []IsolationHook
+}
+
+func (h *isolationHooks) add(hook IsolationHook) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.hooks = append(h.hooks, hook)
+}
+
+func (h *isolationHooks) run(ctx context.Context) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for _, hook := range h.hooks {
+		hook(ctx)
+	}
+}
+
+// AddIsolationHook registers a hook which is called when the Peer has had
+// no connected peers for Config.IsolationTimeout, after dialing
+// Config.FallbackPeers.
+func (p *Peer) AddIsolationHook(hook IsolationHook) {
+	p.isolation.add(hook)
+}
+
+// watchIsolation recovers from periods without connected peers longer than
+// the isolation timeout.
+func (p *Peer) watchIsolation() {
+	timeout := p.cfg.IsolationTimeout
+	ticker := time.NewTicker(timeout / 4)
+	defer ticker.Stop()
+
+	lastConnected := time.Now()
+	for {
+		select {
+		case <-p.ctx.Done():
+			return
+		case now := <-ticker.C:
+			if len(p.host.Network().Peers()) > 0 {
+				lastConnected = now
+				continue
+			}
+			if now.Sub(lastConnected) < timeout {
+				continue
+			}
+			logger.Warnf("no connected peers for %s", now.Sub(lastConnected).Round(time.Second))
+			p.recoverIsolation()
+			// Wait for another timeout before trying again.
+			lastConnected = time.Now()
+		}
+	}
+}
+
+func (p *Peer) recoverIsolation() {
+	if len(p.cfg.FallbackPeers) > 0 {
+		ctx, cancel := context.WithTimeout(p.ctx, p.cfg.IsolationTimeout)
+		p.BootstrapContext(ctx, p.cfg.FallbackPeers)
+		cancel()
+	}
+	ctx, cancel := context.WithTimeout(p.ctx, p.cfg.IsolationTimeout)
+	defer cancel()
+	p.isolation.run(ctx)
+}
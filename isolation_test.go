@@ -0,0 +1,37 @@
+package ipfslite
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+func TestIsolationRecovery(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	fallback := setupPeer(t, ctx, nil)
+	p := setupPeer(t, ctx, &Config{
+		IsolationTimeout: 200 * time.Millisecond,
+		FallbackPeers:    []peer.AddrInfo{{ID: fallback.host.ID(), Addrs: fallback.host.Addrs()}},
+	})
+	called := make(chan struct{}, 1)
+	p.AddIsolationHook(func(ctx context.Context) {
+		select {
+		case called <- struct{}{}:
+		default:
+		}
+	})
+
+	select {
+	case <-called:
+	case <-time.After(5 * time.Second):
+		t.Fatal("isolation hook was not called")
+	}
+	if p.host.Network().Connectedness(fallback.host.ID()) != network.Connected {
+		t.Error("the fallback peer should have been dialed")
+	}
+}
@@ -0,0 +1,166 @@
+package ipfslite
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+)
+
+var addJournalPrefix = datastore.NewKey("/journal/add")
+
+// addJournalSeq makes the IDs of journal entries created at the same time
+// unique.
+var addJournalSeq uint32
+
+// AddJournalEntry records an add operation (see Config.AddJournal).
+type AddJournalEntry struct {
+	// ID identifies the entry. IDs sort in the order the adds started.
+	ID string `json:"-"`
+	// Path, Chunker, Layout, RawLeaves, HashFun and Reproducible are
+	// the parameters of the add.
+	Path         string `json:"path,omitempty"`
+	Chunker      string `json:"chunker,omitempty"`
+	Layout       string `json:"layout,omitempty"`
+	RawLeaves    bool   `json:"raw_leaves,omitempty"`
+	HashFun      string `json:"hash,omitempty"`
+	Reproducible bool   `json:"reproducible,omitempty"`
+	Encrypted    bool   `json:"encrypted,omitempty"`
+
+	Started time.Time `json:"started"`
+	// Finished is nil while the add is running, or when the Peer
+	// stopped before it completed, in which case it must be retried.
+	Finished *time.Time `json:"finished,omitempty"`
+	// Root is the CID of the added content, when the add succeeded.
+	Root cid.Cid `json:"root,omitempty"`
+	// Err is the error of a failed add.
+	Err string `json:"error,omitempty"`
+}
+
+// Completed tells whether the add completed successfully.
+func (e *AddJournalEntry) Completed() bool {
+	return e.Finished != nil && e.Err == ""
+}
+
+func addJournalKey(id string) datastore.Key {
+	return addJournalPrefix.ChildString(id)
+}
+
+func (p *Peer) putJournalEntry(ctx context.Context, e *AddJournalEntry) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	ds := p.datastore(MetaNamespace)
+	key := addJournalKey(e.ID)
+	if err := ds.Put(ctx, key, data); err != nil {
+		return err
+	}
+	// The entry must survive a crash.
+	return ds.Sync(ctx, key)
+}
+
+// journalStart records the start of an add, and returns the entry to
+// finish, or nil when the journal is disabled.
+func (p *Peer) journalStart(ctx context.Context, params *AddParams, encrypted bool) (*AddJournalEntry, error) {
+	if !p.cfg.AddJournal {
+		return nil, nil
+	}
+	now := time.Now()
+	e := &AddJournalEntry{
+		ID:           fmt.Sprintf("%020d-%010d", now.UnixNano(), atomic.AddUint32(&addJournalSeq, 1)),
+		Path:         params.Path,
+		Chunker:      params.Chunker,
+		Layout:       params.Layout,
+		RawLeaves:    params.RawLeaves,
+		HashFun:      params.HashFun,
+		Reproducible: params.Reproducible,
+		Encrypted:    encrypted,
+		Started:      now,
+	}
+	if err := p.putJournalEntry(ctx, e); err != nil {
+		return nil, fmt.Errorf("error journaling add: %w", err)
+	}
+	return e, nil
+}
+
+// journalFinish records the result of an add. The blocks of a successful
+// add are synced first, so that a completed entry never refers to blocks
+// lost in a crash. This is only possible with the default blockstore.
+func (p *Peer) journalFinish(e *AddJournalEntry, root cid.Cid, addErr error) {
+	if e == nil {
+		return
+	}
+	// Record the result even when the add was cancelled.
+	ctx := context.Background()
+	if addErr == nil && p.blocksDS != nil {
+		if err := p.blocksDS.Sync(ctx, BlocksNamespace); err != nil {
+			addErr = fmt.Errorf("error syncing the added blocks: %w", err)
+		}
+	}
+	now := time.Now()
+	e.Finished = &now
+	e.Root = root
+	if addErr != nil {
+		e.Err = addErr.Error()
+	}
+	if err := p.putJournalEntry(ctx, e); err != nil {
+		logger.Errorf("error journaling the result of add %s: %s", e.ID, err)
+	}
+}
+
+// AddJournal returns the entries of the add journal, oldest first. After a
+// crash, the entries which are not finished are the adds which must be
+// retried. Entries are kept until removed with ClearAddJournal.
+func (p *Peer) AddJournal(ctx context.Context) ([]*AddJournalEntry, error) {
+	res, err := p.datastore(MetaNamespace).Query(ctx, query.Query{
+		Prefix: addJournalPrefix.String(),
+		Orders: []query.Order{query.OrderByKey{}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+	var entries []*AddJournalEntry
+	for r := range res.Next() {
+		if r.Error != nil {
+			return nil, r.Error
+		}
+		e := &AddJournalEntry{ID: datastore.RawKey(r.Key).BaseNamespace()}
+		if err := json.Unmarshal(r.Value, e); err != nil {
+			logger.Warnf("invalid add journal entry %s: %s", r.Key, err)
+			continue
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+// ClearAddJournal removes the given entries from the add journal, i.e. once
+// failed adds have been retried. Without IDs, it removes all the finished
+// entries.
+func (p *Peer) ClearAddJournal(ctx context.Context, ids ...string) error {
+	if len(ids) == 0 {
+		entries, err := p.AddJournal(ctx)
+		if err != nil {
+			return err
+		}
+		for _, e := range entries {
+			if e.Finished != nil {
+				ids = append(ids, e.ID)
+			}
+		}
+	}
+	ds := p.datastore(MetaNamespace)
+	for _, id := range ids {
+		if err := ds.Delete(ctx, addJournalKey(id)); err != nil {
+			return err
+		}
+	}
+	return ds.Sync(ctx, addJournalPrefix)
+}
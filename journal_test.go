@@ -0,0 +1,121 @@
+package ipfslite
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/ipfs/go-datastore"
+)
+
+func TestAddJournal(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	p, err := New(ctx, NewInMemoryDatastore(), nil, nil, nil, &Config{Offline: true, AddJournal: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err := p.AddFile(ctx, strings.NewReader("content"), &AddParams{Path: "a.txt", RawLeaves: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = p.AddFile(ctx, strings.NewReader("content"), &AddParams{Path: "b.txt", Layout: "invalid"})
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	// An add interrupted by a crash.
+	_, err = p.journalStart(ctx, &AddParams{Path: "c.txt"}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := p.AddJournal(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 3 {
+		t.Fatalf("expected 3 entries, got %d", len(entries))
+	}
+	if e := entries[0]; e.Path != "a.txt" || !e.RawLeaves || !e.Completed() || !e.Root.Equals(n.Cid()) {
+		t.Errorf("unexpected entry %+v", e)
+	}
+	if e := entries[1]; e.Path != "b.txt" || e.Completed() || e.Finished == nil || e.Err == "" {
+		t.Errorf("unexpected entry %+v", e)
+	}
+	if e := entries[2]; e.Path != "c.txt" || e.Finished != nil || e.Root.Defined() {
+		t.Errorf("unexpected entry %+v", e)
+	}
+
+	err = p.ClearAddJournal(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries, err = p.AddJournal(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Path != "c.txt" {
+		t.Fatalf("only the unfinished entry should remain, got %+v", entries)
+	}
+	err = p.ClearAddJournal(ctx, entries[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entries, _ = p.AddJournal(ctx); len(entries) != 0 {
+		t.Errorf("expected an empty journal, got %d entries", len(entries))
+	}
+}
+
+// syncRecorder records the keys put and synced, in order.
+type syncRecorder struct {
+	datastore.Batching
+	mu  sync.Mutex
+	ops []string
+}
+
+func (r *syncRecorder) Put(ctx context.Context, key datastore.Key, value []byte) error {
+	r.mu.Lock()
+	r.ops = append(r.ops, "put "+key.String())
+	r.mu.Unlock()
+	return r.Batching.Put(ctx, key, value)
+}
+
+func (r *syncRecorder) Sync(ctx context.Context, prefix datastore.Key) error {
+	r.mu.Lock()
+	r.ops = append(r.ops, "sync "+prefix.String())
+	r.mu.Unlock()
+	return r.Batching.Sync(ctx, prefix)
+}
+
+func TestAddJournalSyncsBlocks(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ds := &syncRecorder{Batching: NewInMemoryDatastore()}
+	p, err := New(ctx, ds, nil, nil, nil, &Config{Offline: true, AddJournal: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = p.AddFile(ctx, strings.NewReader("content"), &AddParams{Path: "a.txt"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	synced := -1
+	finished := -1
+	for i, op := range ds.ops {
+		if op == "sync "+BlocksNamespace.String() {
+			synced = i
+		}
+		if strings.HasPrefix(op, "put "+addJournalPrefix.String()) {
+			finished = i
+		}
+	}
+	if synced < 0 || synced > finished {
+		t.Errorf("the blocks should be synced before the add is recorded as finished: %v", ds.ops)
+	}
+}
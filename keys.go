@@ -0,0 +1,115 @@
+package ipfslite
+
+import (
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"golang.org/x/crypto/argon2"
+)
+
+// Key formats supported by ExportKey and ImportKey. The names match the
+// ones used by "ipfs key export/import" in kubo.
+const (
+	// KeyFormatProtobuf is the libp2p protobuf encoding of a private key,
+	// as used by kubo and js-ipfs keystores and by the kubo config file
+	// (base64-encoded).
+	KeyFormatProtobuf = "libp2p-protobuf-cleartext"
+	// KeyFormatPEM is a PEM-encoded PKCS#8 private key. Secp256k1 keys
+	// cannot be represented in this format.
+	KeyFormatPEM = "pem-pkcs8-cleartext"
+)
+
+const pemPrivateKeyType = "PRIVATE KEY"
+
+// ExportKey serializes a private key (host or IPNS key) in the given format.
+func ExportKey(priv crypto.PrivKey, format string) ([]byte, error) {
+	switch format {
+	case KeyFormatProtobuf:
+		return crypto.MarshalPrivateKey(priv)
+	case KeyFormatPEM:
+		stdKey, err := crypto.PrivKeyToStdKey(priv)
+		if err != nil {
+			return nil, err
+		}
+		// x509 expects ed25519 keys by value.
+		if k, ok := stdKey.(*ed25519.PrivateKey); ok {
+			stdKey = *k
+		}
+		der, err := x509.MarshalPKCS8PrivateKey(stdKey)
+		if err != nil {
+			return nil, fmt.Errorf("cannot export %s key as PEM: %w", priv.Type(), err)
+		}
+		return pem.EncodeToMemory(&pem.Block{Type: pemPrivateKeyType, Bytes: der}), nil
+	default:
+		return nil, fmt.Errorf("unknown key format: %s", format)
+	}
+}
+
+// ImportKey parses a private key in the given format. When format is empty,
+// the format is detected: PEM input is parsed as KeyFormatPEM and anything
+// else as KeyFormatProtobuf.
+func ImportKey(data []byte, format string) (crypto.PrivKey, error) {
+	if format == "" {
+		format = KeyFormatProtobuf
+		if blk, _ := pem.Decode(data); blk != nil {
+			format = KeyFormatPEM
+		}
+	}
+
+	switch format {
+	case KeyFormatProtobuf:
+		return crypto.UnmarshalPrivateKey(data)
+	case KeyFormatPEM:
+		blk, _ := pem.Decode(data)
+		if blk == nil {
+			return nil, errors.New("no PEM data found")
+		}
+		if blk.Type != pemPrivateKeyType {
+			return nil, fmt.Errorf("unsupported PEM block type: %s", blk.Type)
+		}
+		stdKey, err := x509.ParsePKCS8PrivateKey(blk.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		if k, ok := stdKey.(ed25519.PrivateKey); ok {
+			stdKey = &k
+		}
+		priv, _, err := crypto.KeyPairFromStdKey(stdKey)
+		return priv, err
+	default:
+		return nil, fmt.Errorf("unknown key format: %s", format)
+	}
+}
+
+// Parameters of the Argon2id key derivation used by DeriveKey. Changing
+// them changes the derived keys.
+const (
+	deriveKeyTime    = 3
+	deriveKeyMemory  = 64 * 1024
+	deriveKeyThreads = 4
+)
+
+// deriveKeySalt is used by DeriveKey when no salt is given.
+var deriveKeySalt = []byte("ipfs-lite identity v1")
+
+// DeriveKey derives an Ed25519 private key (i.e. a host key) from a seed,
+// such as a backup passphrase, so that a device can recover its peer ID
+// from it. The seed is stretched with Argon2id, which makes guessing weak
+// passphrases expensive but takes a noticeable time and 64MiB of memory.
+// The same seed and salt always give the same key. The salt can be nil,
+// in which case a fixed one is used; an application-specific salt keeps
+// keys derived from the same seed by different applications apart.
+func DeriveKey(seed, salt []byte) (crypto.PrivKey, error) {
+	if len(seed) == 0 {
+		return nil, errors.New("empty seed")
+	}
+	if salt == nil {
+		salt = deriveKeySalt
+	}
+	k := argon2.IDKey(seed, salt, deriveKeyTime, deriveKeyMemory, deriveKeyThreads, ed25519.SeedSize)
+	return crypto.UnmarshalEd25519PrivateKey(ed25519.NewKeyFromSeed(k))
+}
@@ -0,0 +1,84 @@
+package ipfslite
+
+import (
+	"testing"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+func TestExportImportKey(t *testing.T) {
+	for _, kt := range []int{crypto.Ed25519, crypto.ECDSA, crypto.RSA, crypto.Secp256k1} {
+		priv, _, err := crypto.GenerateKeyPair(kt, 2048)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, format := range []string{KeyFormatProtobuf, KeyFormatPEM} {
+			data, err := ExportKey(priv, format)
+			if kt == crypto.Secp256k1 && format == KeyFormatPEM {
+				if err == nil {
+					t.Error("expected error exporting secp256k1 key as PEM")
+				}
+				continue
+			}
+			if err != nil {
+				t.Fatalf("%s/%s: %s", priv.Type(), format, err)
+			}
+
+			for _, f := range []string{format, ""} {
+				imported, err := ImportKey(data, f)
+				if err != nil {
+					t.Fatalf("%s/%q: %s", priv.Type(), f, err)
+				}
+				if !imported.Equals(priv) {
+					t.Errorf("%s/%q: imported key does not match", priv.Type(), f)
+				}
+			}
+		}
+	}
+
+	if _, err := ExportKey(nil, "bad"); err == nil {
+		t.Error("expected error for unknown format")
+	}
+}
+
+func TestDeriveKey(t *testing.T) {
+	seed := []byte("correct horse battery staple")
+	k1, err := DeriveKey(seed, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	k2, err := DeriveKey(seed, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !k1.Equals(k2) {
+		t.Error("the same seed should give the same key")
+	}
+	id, err := peer.IDFromPrivateKey(k1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Derived keys must not change across releases.
+	if id.String() != "12D3KooWCKF8xS35YXDJ4bjNpxhYPLK9RZH39GJzYyYmVQr8m2Es" {
+		t.Errorf("unexpected derived peer ID: %s", id)
+	}
+
+	k3, err := DeriveKey(seed, []byte("another app"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if k1.Equals(k3) {
+		t.Error("different salts should give different keys")
+	}
+	k4, err := DeriveKey([]byte("another seed"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if k1.Equals(k4) {
+		t.Error("different seeds should give different keys")
+	}
+	if _, err := DeriveKey(nil, nil); err == nil {
+		t.Error("an empty seed should be rejected")
+	}
+}
@@ -0,0 +1,238 @@
+package ipfslite
+
+import (
+	"context"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	blockstore "github.com/ipfs/boxo/blockstore"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/mount"
+	flatfs "github.com/ipfs/go-ds-flatfs"
+	leveldb "github.com/ipfs/go-ds-leveldb"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// KuboRepo gives access to the identity, keys and datastore of an existing
+// kubo (go-ipfs) repository, so that an ipfs-lite Peer can reuse or import
+// its data. Only the default datastore layout (flatfs blocks and a leveldb
+// root datastore) is supported. The kubo daemon must not be running while
+// the repository is open.
+type KuboRepo struct {
+	// Path is the location of the repository.
+	Path string
+	// Version is the repository version, as found in the "version" file.
+	Version string
+	// Identity is the private key of the kubo node.
+	Identity crypto.PrivKey
+	// Datastore is the datastore of the repository as seen by kubo, with
+	// blocks mounted under BlocksNamespace. It can be passed directly to
+	// New to reuse the blocks and pins of the repository.
+	Datastore datastore.Batching
+
+	closers []func() error
+}
+
+type kuboConfig struct {
+	Identity struct {
+		PeerID  string
+		PrivKey string
+	}
+}
+
+type kuboDatastoreSpec struct {
+	Type   string `json:"type"`
+	Mounts []struct {
+		Mountpoint string `json:"mountpoint"`
+		Type       string `json:"type"`
+		Path       string `json:"path"`
+		ShardFunc  string `json:"shardFunc"`
+	} `json:"mounts"`
+}
+
+// OpenKuboRepo opens the kubo repository at the given path (usually
+// ~/.ipfs).
+func OpenKuboRepo(path string) (*KuboRepo, error) {
+	r := &KuboRepo{Path: path}
+
+	version, err := os.ReadFile(filepath.Join(path, "version"))
+	if err != nil {
+		return nil, fmt.Errorf("not a kubo repository: %w", err)
+	}
+	r.Version = strings.TrimSpace(string(version))
+
+	err = r.loadIdentity()
+	if err != nil {
+		return nil, err
+	}
+
+	err = r.openDatastore()
+	if err != nil {
+		r.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *KuboRepo) loadIdentity() error {
+	data, err := os.ReadFile(filepath.Join(r.Path, "config"))
+	if err != nil {
+		return err
+	}
+	var cfg kuboConfig
+	err = json.Unmarshal(data, &cfg)
+	if err != nil {
+		return fmt.Errorf("parsing kubo config: %w", err)
+	}
+	keyBytes, err := base64.StdEncoding.DecodeString(cfg.Identity.PrivKey)
+	if err != nil {
+		return fmt.Errorf("decoding kubo identity: %w", err)
+	}
+	priv, err := crypto.UnmarshalPrivateKey(keyBytes)
+	if err != nil {
+		return fmt.Errorf("decoding kubo identity: %w", err)
+	}
+
+	pid, err := peer.IDFromPrivateKey(priv)
+	if err != nil {
+		return err
+	}
+	if cfg.Identity.PeerID != "" && pid.String() != cfg.Identity.PeerID {
+		return fmt.Errorf("kubo identity does not match peer ID %s", cfg.Identity.PeerID)
+	}
+	r.Identity = priv
+	return nil
+}
+
+func (r *KuboRepo) openDatastore() error {
+	data, err := os.ReadFile(filepath.Join(r.Path, "datastore_spec"))
+	if err != nil {
+		return err
+	}
+	var spec kuboDatastoreSpec
+	err = json.Unmarshal(data, &spec)
+	if err != nil {
+		return fmt.Errorf("parsing kubo datastore spec: %w", err)
+	}
+	if spec.Type != "mount" {
+		return fmt.Errorf("unsupported kubo datastore type: %s", spec.Type)
+	}
+
+	var mounts []mount.Mount
+	for _, m := range spec.Mounts {
+		path := m.Path
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(r.Path, path)
+		}
+
+		var ds datastore.Datastore
+		switch m.Type {
+		case "flatfs":
+			fds, err := flatfs.Open(path, false)
+			if err != nil {
+				return fmt.Errorf("opening flatfs at %s: %w", path, err)
+			}
+			r.closers = append(r.closers, fds.Close)
+			ds = fds
+		case "levelds":
+			lds, err := leveldb.NewDatastore(path, nil)
+			if err != nil {
+				return fmt.Errorf("opening leveldb at %s: %w", path, err)
+			}
+			r.closers = append(r.closers, lds.Close)
+			ds = lds
+		default:
+			return fmt.Errorf("unsupported kubo datastore mount type: %s", m.Type)
+		}
+		mounts = append(mounts, mount.Mount{
+			Prefix:    datastore.NewKey(m.Mountpoint),
+			Datastore: ds,
+		})
+	}
+	r.Datastore = mount.New(mounts)
+	return nil
+}
+
+// Blockstore returns a blockstore backed by the repository blocks.
+func (r *KuboRepo) Blockstore() blockstore.Blockstore {
+	return blockstore.NewBlockstore(r.Datastore)
+}
+
+// Keys returns the keys in the repository keystore (used for IPNS), indexed
+// by name.
+func (r *KuboRepo) Keys() (map[string]crypto.PrivKey, error) {
+	dir := filepath.Join(r.Path, "keystore")
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return map[string]crypto.PrivKey{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	enc := base32.StdEncoding.WithPadding(base32.NoPadding)
+	keys := make(map[string]crypto.PrivKey)
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasPrefix(e.Name(), "key_") {
+			continue
+		}
+		name, err := enc.DecodeString(strings.ToUpper(strings.TrimPrefix(e.Name(), "key_")))
+		if err != nil {
+			logger.Warnf("skipping kubo key with bad name: %s", e.Name())
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(dir, e.Name()))
+		if err != nil {
+			return nil, err
+		}
+		priv, err := crypto.UnmarshalPrivateKey(data)
+		if err != nil {
+			return nil, fmt.Errorf("decoding kubo key %s: %w", name, err)
+		}
+		keys[string(name)] = priv
+	}
+	return keys, nil
+}
+
+// ImportBlocks copies all the blocks in the repository to the given
+// blockstore, i.e. the one of a Peer (Peer.BlockStore()). It returns the
+// number of copied blocks.
+func (r *KuboRepo) ImportBlocks(ctx context.Context, dst blockstore.Blockstore) (int, error) {
+	src := r.Blockstore()
+	keys, err := src.AllKeysChan(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	n := 0
+	for c := range keys {
+		blk, err := src.Get(ctx, c)
+		if err != nil {
+			return n, fmt.Errorf("reading block %s: %w", c, err)
+		}
+		err = dst.Put(ctx, blk)
+		if err != nil {
+			return n, fmt.Errorf("writing block %s: %w", c, err)
+		}
+		n++
+	}
+	return n, ctx.Err()
+}
+
+// Close closes the repository datastores.
+func (r *KuboRepo) Close() error {
+	var errs []error
+	for _, c := range r.closers {
+		if err := c(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
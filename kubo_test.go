@@ -0,0 +1,150 @@
+package ipfslite
+
+import (
+	"context"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	blocks "github.com/ipfs/go-block-format"
+	flatfs "github.com/ipfs/go-ds-flatfs"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+const testKuboDatastoreSpec = `{"mounts":[{"mountpoint":"/blocks","path":"blocks","shardFunc":"/repo/flatfs/shard/v1/next-to-last/2","type":"flatfs"},{"mountpoint":"/","path":"datastore","type":"levelds"}],"type":"mount"}`
+
+func writeKuboRepo(t *testing.T, dir string, priv crypto.PrivKey) {
+	t.Helper()
+	keyBytes, err := crypto.MarshalPrivateKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pid, err := peer.IDFromPrivateKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var cfg kuboConfig
+	cfg.Identity.PeerID = pid.String()
+	cfg.Identity.PrivKey = base64.StdEncoding.EncodeToString(keyBytes)
+	cfgBytes, err := json.Marshal(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files := map[string][]byte{
+		"version":        []byte("15\n"),
+		"config":         cfgBytes,
+		"datastore_spec": []byte(testKuboDatastoreSpec),
+	}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	shard, err := flatfs.ParseShardFunc("/repo/flatfs/shard/v1/next-to-last/2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fds, err := flatfs.CreateOrOpen(filepath.Join(dir, "blocks"), shard, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fds.Close()
+}
+
+func TestOpenKuboRepo(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	dir := t.TempDir()
+	priv, _, err := crypto.GenerateKeyPair(crypto.Ed25519, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeKuboRepo(t, dir, priv)
+
+	keyDir := filepath.Join(dir, "keystore")
+	if err := os.Mkdir(keyDir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	ipnsKey, _, err := crypto.GenerateKeyPair(crypto.Ed25519, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ipnsKeyBytes, err := crypto.MarshalPrivateKey(ipnsKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	name := strings.ToLower(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("mykey")))
+	if err := os.WriteFile(filepath.Join(keyDir, "key_"+name), ipnsKeyBytes, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	repo, err := OpenKuboRepo(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer repo.Close()
+
+	if repo.Version != "15" {
+		t.Errorf("unexpected version %q", repo.Version)
+	}
+	if !repo.Identity.Equals(priv) {
+		t.Error("identity does not match")
+	}
+
+	keys, err := repo.Keys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if k, ok := keys["mykey"]; !ok || !k.Equals(ipnsKey) {
+		t.Errorf("keystore key not found: %v", keys)
+	}
+
+	blk := blocks.NewBlock([]byte("kubo block"))
+	if err := repo.Blockstore().Put(ctx, blk); err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := New(ctx, NewInMemoryDatastore(), nil, nil, nil, &Config{Offline: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err := repo.ImportBlocks(ctx, p.BlockStore())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Fatalf("expected 1 imported block, got %d", n)
+	}
+	has, err := p.BlockStore().Has(ctx, blk.Cid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !has {
+		t.Error("imported block not found")
+	}
+}
+
+func TestOpenKuboRepoUnsupported(t *testing.T) {
+	dir := t.TempDir()
+	priv, _, err := crypto.GenerateKeyPair(crypto.Ed25519, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeKuboRepo(t, dir, priv)
+	spec := `{"type":"mount","mounts":[{"mountpoint":"/","path":"badgerds","type":"badgerds"}]}`
+	if err := os.WriteFile(filepath.Join(dir, "datastore_spec"), []byte(spec), 0600); err != nil {
+		t.Fatal(err)
+	}
+	_, err = OpenKuboRepo(dir)
+	if err == nil {
+		t.Fatal("expected error for unsupported datastore")
+	}
+}
@@ -0,0 +1,105 @@
+package ipfslite
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	blockstore "github.com/ipfs/boxo/blockstore"
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+)
+
+// NetworkBlockSizeLimit is the size of the largest blocks which all IPFS
+// implementations exchange. Bitswap messages, and so blocks, are limited to
+// 2MiB, but many nodes refuse blocks larger than 1MiB.
+const NetworkBlockSizeLimit = 1 << 20
+
+var (
+	// ErrBlockTooLarge is returned when adding blocks larger than
+	// Config.MaxBlockSize.
+	ErrBlockTooLarge = errors.New("block too large")
+	// ErrFileTooLarge is returned when adding files larger than
+	// Config.MaxFileSize.
+	ErrFileTooLarge = errors.New("file too large")
+)
+
+// blockSizeBlockstore rejects the blocks larger than a maximum size.
+type blockSizeBlockstore struct {
+	blockstore.Blockstore
+	max int
+}
+
+func (bs *blockSizeBlockstore) check(blk blocks.Block) error {
+	if size := len(blk.RawData()); size > bs.max {
+		return fmt.Errorf("%w: %s is %d bytes, the limit is %d", ErrBlockTooLarge, blk.Cid(), size, bs.max)
+	}
+	return nil
+}
+
+func (bs *blockSizeBlockstore) View(ctx context.Context, c cid.Cid, f func([]byte) error) error {
+	return viewBlock(ctx, bs.Blockstore, c, f)
+}
+
+func (bs *blockSizeBlockstore) Put(ctx context.Context, blk blocks.Block) error {
+	if err := bs.check(blk); err != nil {
+		return err
+	}
+	return bs.Blockstore.Put(ctx, blk)
+}
+
+func (bs *blockSizeBlockstore) PutMany(ctx context.Context, blks []blocks.Block) error {
+	for _, blk := range blks {
+		if err := bs.check(blk); err != nil {
+			return err
+		}
+	}
+	return bs.Blockstore.PutMany(ctx, blks)
+}
+
+// checkAddLimits checks the parameters of a file to add, whose existing
+// content is already size bytes long, against the configured limits. The
+// returned reader must be used to read the content: it fails with
+// ErrFileTooLarge once the file grows larger than Config.MaxFileSize.
+func (p *Peer) checkAddLimits(r io.Reader, params *AddParams, size int64) (io.Reader, error) {
+	if max := p.cfg.MaxBlockSize; max > 0 {
+		if chunkSize, fixed := fixedChunkSize(params.Chunker); fixed && chunkSize > int64(max) {
+			return nil, fmt.Errorf("%w: chunks are %d bytes, the limit is %d", ErrBlockTooLarge, chunkSize, max)
+		}
+	}
+	max := p.cfg.MaxFileSize
+	if max <= 0 {
+		return r, nil
+	}
+	if size > max {
+		return nil, fmt.Errorf("%w: the limit is %d bytes", ErrFileTooLarge, max)
+	}
+	// Keep io.ReaderAt sources, which are read in parallel.
+	if _, _, rsize, ok := readerAtSource(r); ok {
+		if size+rsize > max {
+			return nil, fmt.Errorf("%w: %d bytes, the limit is %d", ErrFileTooLarge, size+rsize, max)
+		}
+		return r, nil
+	}
+	return &fileSizeReader{r: r, remaining: max - size, max: max}, nil
+}
+
+// fileSizeReader fails once more than a maximum number of bytes are read.
+type fileSizeReader struct {
+	r         io.Reader
+	remaining int64
+	max       int64
+}
+
+func (r *fileSizeReader) Read(b []byte) (int, error) {
+	if int64(len(b)) > r.remaining+1 {
+		b = b[:r.remaining+1]
+	}
+	n, err := r.r.Read(b)
+	r.remaining -= int64(n)
+	if r.remaining < 0 {
+		return 0, fmt.Errorf("%w: the limit is %d bytes", ErrFileTooLarge, r.max)
+	}
+	return n, err
+}
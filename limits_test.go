@@ -0,0 +1,61 @@
+package ipfslite
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	cbor "github.com/ipfs/go-ipld-cbor"
+	"github.com/multiformats/go-multihash"
+)
+
+func TestAddLimits(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	p, err := New(ctx, NewInMemoryDatastore(), nil, nil, nil, &Config{
+		Offline:      true,
+		MaxBlockSize: 1024,
+		MaxFileSize:  4096,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = p.AddFile(ctx, strings.NewReader("small"), &AddParams{Chunker: "size-2048"})
+	if !errors.Is(err, ErrBlockTooLarge) {
+		t.Errorf("expected ErrBlockTooLarge for the chunker, got %v", err)
+	}
+	node, err := cbor.WrapObject(map[string]string{"data": strings.Repeat("a", 2048)}, multihash.SHA2_256, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = p.Add(ctx, node)
+	if !errors.Is(err, ErrBlockTooLarge) {
+		t.Errorf("expected ErrBlockTooLarge for a large node, got %v", err)
+	}
+
+	content := make([]byte, 4097)
+	params := &AddParams{Chunker: "size-1024", RawLeaves: true}
+	_, err = p.AddFile(ctx, bytes.NewReader(content), params)
+	if !errors.Is(err, ErrFileTooLarge) {
+		t.Errorf("expected ErrFileTooLarge, got %v", err)
+	}
+	// Readers of unknown size are checked while reading.
+	_, err = p.AddFile(ctx, io.MultiReader(bytes.NewReader(content)), params)
+	if !errors.Is(err, ErrFileTooLarge) {
+		t.Errorf("expected ErrFileTooLarge while reading, got %v", err)
+	}
+
+	n, err := p.AddFile(ctx, io.MultiReader(bytes.NewReader(content[:4096])), params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = p.AppendFile(ctx, n.Cid(), strings.NewReader("more"), params)
+	if !errors.Is(err, ErrFileTooLarge) {
+		t.Errorf("expected ErrFileTooLarge when appending, got %v", err)
+	}
+}
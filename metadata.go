@@ -0,0 +1,274 @@
+package ipfslite
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/event"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/libp2p/go-libp2p/core/record"
+)
+
+// PeerMetadataProtocol is the libp2p protocol used by Peers with
+// Config.Metadata to exchange their signed metadata records.
+const PeerMetadataProtocol protocol.ID = "/ipfs-lite/metadata/1.0.0"
+
+const (
+	// peerMetadataDomain is the signature domain of the metadata
+	// records, so that their signatures cannot be reused for other
+	// records.
+	peerMetadataDomain  = "ipfs-lite-peer-metadata"
+	peerMetadataMaxSize = 64 << 10
+	peerMetadataTimeout = 10 * time.Second
+)
+
+var peerMetadataCodec = []byte("/ipfs-lite/peer-metadata-record")
+
+func init() {
+	record.RegisterType(&peerMetadataRecord{})
+}
+
+// PeerMetadata describes a Peer to the other peers of an application swarm
+// (see Config.Metadata), i.e. to tell storage nodes from clients.
+type PeerMetadata struct {
+	Role         string   `json:"role,omitempty"`
+	Version      string   `json:"version,omitempty"`
+	Capabilities []string `json:"capabilities,omitempty"`
+	// Extra holds any other application values.
+	Extra map[string]string `json:"extra,omitempty"`
+}
+
+// HasCapability tells whether the given capability is listed in the
+// metadata.
+func (m PeerMetadata) HasCapability(capability string) bool {
+	for _, c := range m.Capabilities {
+		if c == capability {
+			return true
+		}
+	}
+	return false
+}
+
+func (m PeerMetadata) clone() PeerMetadata {
+	c := m
+	c.Capabilities = append([]string(nil), m.Capabilities...)
+	if m.Extra != nil {
+		c.Extra = make(map[string]string, len(m.Extra))
+		for k, v := range m.Extra {
+			c.Extra[k] = v
+		}
+	}
+	return c
+}
+
+// peerMetadataRecord is the libp2p record holding the metadata, signed with
+// the key of the host in an envelope.
+type peerMetadataRecord struct {
+	Metadata PeerMetadata `json:"metadata"`
+	Created  time.Time    `json:"created"`
+}
+
+func (r *peerMetadataRecord) Domain() string {
+	return peerMetadataDomain
+}
+
+func (r *peerMetadataRecord) Codec() []byte {
+	return peerMetadataCodec
+}
+
+func (r *peerMetadataRecord) MarshalRecord() ([]byte, error) {
+	return json.Marshal(r)
+}
+
+func (r *peerMetadataRecord) UnmarshalRecord(data []byte) error {
+	return json.Unmarshal(data, r)
+}
+
+// metadataExchange holds the signed metadata record of the Peer, and the
+// verified metadata of the connected peers.
+type metadataExchange struct {
+	envelope []byte
+
+	mu    sync.RWMutex
+	peers map[peer.ID]PeerMetadata
+}
+
+// sealPeerMetadata signs the metadata with the given key, and returns the
+// serialized envelope.
+func sealPeerMetadata(md PeerMetadata, key crypto.PrivKey) ([]byte, error) {
+	env, err := record.Seal(&peerMetadataRecord{Metadata: md, Created: time.Now()}, key)
+	if err != nil {
+		return nil, err
+	}
+	return env.Marshal()
+}
+
+// openPeerMetadata verifies a serialized envelope, which must be signed by
+// the given peer, and returns the metadata it holds.
+func openPeerMetadata(data []byte, pid peer.ID) (PeerMetadata, error) {
+	env, rec, err := record.ConsumeEnvelope(data, peerMetadataDomain)
+	if err != nil {
+		return PeerMetadata{}, err
+	}
+	signer, err := peer.IDFromPublicKey(env.PublicKey)
+	if err != nil {
+		return PeerMetadata{}, err
+	}
+	if signer != pid {
+		return PeerMetadata{}, fmt.Errorf("peer metadata of %s signed by %s", pid, signer)
+	}
+	mr, ok := rec.(*peerMetadataRecord)
+	if !ok {
+		return PeerMetadata{}, errors.New("invalid peer metadata record")
+	}
+	return mr.Metadata, nil
+}
+
+// setupMetadata signs the metadata of the Peer, serves it to the other
+// peers, and requests theirs once they are identified.
+func (p *Peer) setupMetadata() error {
+	key := p.host.Peerstore().PrivKey(p.host.ID())
+	if key == nil {
+		return errors.New("peer metadata: the private key of the host is unknown")
+	}
+	env, err := sealPeerMetadata(*p.cfg.Metadata, key)
+	if err != nil {
+		return err
+	}
+	sub, err := p.host.EventBus().Subscribe([]interface{}{
+		new(event.EvtPeerIdentificationCompleted),
+		new(event.EvtPeerConnectednessChanged),
+	})
+	if err != nil {
+		return err
+	}
+	p.metadata = &metadataExchange{
+		envelope: env,
+		peers:    make(map[peer.ID]PeerMetadata),
+	}
+	p.host.SetStreamHandler(p.metadataProtocol(), p.handleMetadata)
+	go func() {
+		defer sub.Close()
+		for {
+			select {
+			case e, ok := <-sub.Out():
+				if !ok {
+					return
+				}
+				switch e := e.(type) {
+				case event.EvtPeerIdentificationCompleted:
+					go p.fetchMetadata(e.Peer)
+				case event.EvtPeerConnectednessChanged:
+					if e.Connectedness == network.NotConnected {
+						p.metadata.mu.Lock()
+						delete(p.metadata.peers, e.Peer)
+						p.metadata.mu.Unlock()
+					}
+				}
+			case <-p.ctx.Done():
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+// handleMetadata sends the signed metadata record of the Peer.
+func (p *Peer) handleMetadata(s network.Stream) {
+	defer s.Close()
+	s.SetDeadline(time.Now().Add(peerMetadataTimeout))
+	if _, err := s.Write(p.metadata.envelope); err != nil {
+		logger.Debugf("peer metadata request from %s: %s", s.Conn().RemotePeer(), err)
+		s.Reset()
+	}
+}
+
+// fetchMetadata requests the metadata record of the given peer, when it
+// supports the protocol, and keeps it once verified.
+func (p *Peer) fetchMetadata(pid peer.ID) {
+	protos, err := p.host.Peerstore().SupportsProtocols(pid, p.metadataProtocol())
+	if err != nil || len(protos) == 0 {
+		return
+	}
+	md, err := p.requestMetadata(pid)
+	if err != nil {
+		logger.Debugf("peer metadata request to %s: %s", pid, err)
+		return
+	}
+	if p.host.Network().Connectedness(pid) != network.Connected {
+		return
+	}
+	p.metadata.mu.Lock()
+	p.metadata.peers[pid] = md
+	p.metadata.mu.Unlock()
+}
+
+func (p *Peer) requestMetadata(pid peer.ID) (PeerMetadata, error) {
+	ctx, cancel := context.WithTimeout(p.ctx, peerMetadataTimeout)
+	defer cancel()
+	s, err := p.host.NewStream(ctx, pid, p.metadataProtocol())
+	if err != nil {
+		return PeerMetadata{}, err
+	}
+	defer s.Close()
+	s.SetDeadline(time.Now().Add(peerMetadataTimeout))
+	data, err := io.ReadAll(io.LimitReader(s, peerMetadataMaxSize+1))
+	if err != nil {
+		return PeerMetadata{}, err
+	}
+	if len(data) > peerMetadataMaxSize {
+		return PeerMetadata{}, errors.New("peer metadata too large")
+	}
+	return openPeerMetadata(data, pid)
+}
+
+// PeerMetadata returns the verified metadata of the given connected peer.
+// It returns false unless Config.Metadata is set and the peer has sent its
+// signed metadata record.
+func (p *Peer) PeerMetadata(pid peer.ID) (PeerMetadata, bool) {
+	if p.metadata == nil {
+		return PeerMetadata{}, false
+	}
+	p.metadata.mu.RLock()
+	defer p.metadata.mu.RUnlock()
+	md, ok := p.metadata.peers[pid]
+	if !ok {
+		return PeerMetadata{}, false
+	}
+	return md.clone(), true
+}
+
+// PeersMetadata returns the verified metadata of all the connected peers
+// which have sent it (see PeerMetadata).
+func (p *Peer) PeersMetadata() map[peer.ID]PeerMetadata {
+	if p.metadata == nil {
+		return nil
+	}
+	p.metadata.mu.RLock()
+	defer p.metadata.mu.RUnlock()
+	peers := make(map[peer.ID]PeerMetadata, len(p.metadata.peers))
+	for pid, md := range p.metadata.peers {
+		peers[pid] = md.clone()
+	}
+	return peers
+}
+
+// PeersWithRole returns the connected peers whose metadata has the given
+// role.
+func (p *Peer) PeersWithRole(role string) []peer.ID {
+	var peers []peer.ID
+	for pid, md := range p.PeersMetadata() {
+		if md.Role == role {
+			peers = append(peers, pid)
+		}
+	}
+	return peers
+}
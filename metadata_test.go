@@ -0,0 +1,95 @@
+package ipfslite
+
+import (
+	"context"
+	"crypto/rand"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+func TestPeerMetadata(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	p1 := setupPeer(t, ctx, &Config{Metadata: &PeerMetadata{
+		Role:         "storage",
+		Version:      "1.2.0",
+		Capabilities: []string{"pin"},
+	}})
+	p2 := setupPeer(t, ctx, &Config{Metadata: &PeerMetadata{Role: "client"}})
+	p3 := setupPeer(t, ctx, nil)
+
+	for _, p := range []*Peer{p2, p3} {
+		err := p.host.Connect(ctx, peer.AddrInfo{ID: p1.host.ID(), Addrs: p1.host.Addrs()})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		_, ok1 := p1.PeerMetadata(p2.host.ID())
+		_, ok2 := p2.PeerMetadata(p1.host.ID())
+		if ok1 && ok2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the peers did not exchange their metadata")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	md, _ := p2.PeerMetadata(p1.host.ID())
+	if md.Role != "storage" || md.Version != "1.2.0" || !md.HasCapability("pin") {
+		t.Errorf("unexpected metadata: %+v", md)
+	}
+	if peers := p1.PeersWithRole("client"); len(peers) != 1 || peers[0] != p2.host.ID() {
+		t.Errorf("unexpected client peers: %v", peers)
+	}
+	if _, ok := p1.PeerMetadata(p3.host.ID()); ok {
+		t.Error("a peer without metadata should not have any")
+	}
+	if p3.PeersMetadata() != nil {
+		t.Error("metadata should only be exchanged with Config.Metadata")
+	}
+}
+
+func TestPeerMetadataSignature(t *testing.T) {
+	key, _, err := crypto.GenerateEd25519Key(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := peer.IDFromPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, _, err := crypto.GenerateEd25519Key(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherID, err := peer.IDFromPrivateKey(other)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := sealPeerMetadata(PeerMetadata{Role: "storage"}, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	md, err := openPeerMetadata(data, signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if md.Role != "storage" {
+		t.Errorf("unexpected metadata: %+v", md)
+	}
+	if _, err := openPeerMetadata(data, otherID); err == nil {
+		t.Error("metadata signed by another peer should be rejected")
+	}
+	data[len(data)-1] ^= 0xff
+	if _, err := openPeerMetadata(data, signer); err == nil {
+		t.Error("tampered metadata should be rejected")
+	}
+}
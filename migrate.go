@@ -0,0 +1,119 @@
+package ipfslite
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/ipfs/go-datastore"
+)
+
+// ErrDatastoreTooNew is returned when a datastore was migrated by a newer
+// release than the running one.
+var ErrDatastoreTooNew = errors.New("datastore layout is newer than supported")
+
+// layoutVersionKey stores the layout version of the Peer's datastore,
+// under MetaNamespace when Config.NamespacedDatastore is set.
+var layoutVersionKey = datastore.NewKey("/layout-version")
+
+func (p *Peer) layoutVersionKey() datastore.Key {
+	if p.cfg.NamespacedDatastore {
+		return MetaNamespace.Child(layoutVersionKey)
+	}
+	return layoutVersionKey
+}
+
+// Migration upgrades a datastore from Version-1 to Version. Migrations
+// should be idempotent, as they run again when interrupted before the new
+// version is recorded.
+type Migration struct {
+	Version     int
+	Description string
+	Migrate     func(ctx context.Context, ds datastore.Batching) error
+}
+
+// layoutMigrations evolve the layout of the Peer's datastore (i.e.
+// re-keying blocks or changing the pinset format). They are run by New, so
+// that upgrading ipfs-lite upgrades the stored data. New migrations are
+// appended with the next version.
+var layoutMigrations = []Migration{
+	{
+		Version:     1,
+		Description: "record the layout version",
+		Migrate:     func(context.Context, datastore.Batching) error { return nil },
+	},
+}
+
+// migrate migrates the layout of the Peer's datastore. Read-only peers are
+// not migrated, but still refuse layouts which are too new.
+func (p *Peer) migrate(ctx context.Context) error {
+	if !p.cfg.ReadOnly {
+		return Migrate(ctx, p.store, p.layoutVersionKey(), layoutMigrations)
+	}
+	current, err := DatastoreVersion(ctx, p.store, p.layoutVersionKey())
+	if err != nil {
+		return err
+	}
+	if latest := layoutMigrations[len(layoutMigrations)-1].Version; current > latest {
+		return fmt.Errorf("%w: version %d, supported %d", ErrDatastoreTooNew, current, latest)
+	}
+	return nil
+}
+
+// DatastoreVersion returns the version stored under the given key, or 0
+// when there is none (i.e. a datastore which was never migrated).
+func DatastoreVersion(ctx context.Context, ds datastore.Datastore, key datastore.Key) (int, error) {
+	data, err := ds.Get(ctx, key)
+	if err == datastore.ErrNotFound {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	v, err := strconv.Atoi(string(data))
+	if err != nil {
+		return 0, fmt.Errorf("invalid datastore version %q: %w", data, err)
+	}
+	return v, nil
+}
+
+// Migrate brings the datastore to the version of the last of the given
+// migrations, which must be sorted by version, by running those newer than
+// the version stored under the given key. The version is updated after
+// each migration. Applications can use it to version their own data, with
+// their own key. It returns ErrDatastoreTooNew when the stored version is
+// newer than the last migration.
+func Migrate(ctx context.Context, ds datastore.Batching, key datastore.Key, migrations []Migration) error {
+	current, err := DatastoreVersion(ctx, ds, key)
+	if err != nil {
+		return err
+	}
+	if len(migrations) == 0 {
+		return nil
+	}
+	if latest := migrations[len(migrations)-1].Version; current > latest {
+		return fmt.Errorf("%w: version %d, supported %d", ErrDatastoreTooNew, current, latest)
+	}
+
+	for _, m := range migrations {
+		if m.Version <= current {
+			continue
+		}
+		if m.Version != current+1 {
+			return fmt.Errorf("missing migration to version %d", current+1)
+		}
+		logger.Infof("migrating datastore to version %d: %s", m.Version, m.Description)
+		if err := m.Migrate(ctx, ds); err != nil {
+			return fmt.Errorf("migration to version %d: %w", m.Version, err)
+		}
+		if err := ds.Put(ctx, key, []byte(strconv.Itoa(m.Version))); err != nil {
+			return err
+		}
+		if err := ds.Sync(ctx, key); err != nil {
+			return err
+		}
+		current = m.Version
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package ipfslite
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/ipfs/go-datastore"
+)
+
+func TestMigrate(t *testing.T) {
+	ctx := context.Background()
+	ds := NewInMemoryDatastore()
+	key := datastore.NewKey("/app/version")
+
+	var applied []int
+	migration := func(v int) Migration {
+		return Migration{
+			Version: v,
+			Migrate: func(ctx context.Context, ds datastore.Batching) error {
+				applied = append(applied, v)
+				return nil
+			},
+		}
+	}
+	migrations := []Migration{migration(1), migration(2)}
+
+	if err := Migrate(ctx, ds, key, migrations); err != nil {
+		t.Fatal(err)
+	}
+	if len(applied) != 2 {
+		t.Fatalf("expected 2 migrations, got %v", applied)
+	}
+	if v, _ := DatastoreVersion(ctx, ds, key); v != 2 {
+		t.Errorf("expected version 2, got %d", v)
+	}
+
+	// Only new migrations run.
+	applied = nil
+	failing := Migration{Version: 4, Migrate: func(context.Context, datastore.Batching) error {
+		return errors.New("failed")
+	}}
+	err := Migrate(ctx, ds, key, append(migrations, migration(3), failing))
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	if len(applied) != 1 || applied[0] != 3 {
+		t.Errorf("expected migration 3 only, got %v", applied)
+	}
+	if v, _ := DatastoreVersion(ctx, ds, key); v != 3 {
+		t.Errorf("expected version 3, got %d", v)
+	}
+
+	err = Migrate(ctx, ds, key, migrations)
+	if !errors.Is(err, ErrDatastoreTooNew) {
+		t.Errorf("expected ErrDatastoreTooNew, got %v", err)
+	}
+}
+
+func TestNewMigratesLayout(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ds := NewInMemoryDatastore()
+	_, err := New(ctx, ds, nil, nil, nil, &Config{Offline: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err := DatastoreVersion(ctx, ds, layoutVersionKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if latest := layoutMigrations[len(layoutMigrations)-1].Version; v != latest {
+		t.Errorf("expected layout version %d, got %d", latest, v)
+	}
+
+	err = ds.Put(ctx, layoutVersionKey, []byte("1000"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := New(ctx, ds, nil, nil, nil, &Config{Offline: true}); !errors.Is(err, ErrDatastoreTooNew) {
+		t.Errorf("expected ErrDatastoreTooNew, got %v", err)
+	}
+}
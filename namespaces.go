@@ -0,0 +1,49 @@
+package ipfslite
+
+import (
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/namespace"
+)
+
+// Datastore namespaces used when Config.NamespacedDatastore is set, so that
+// a single datastore can back every component without key collisions.
+// Blocks are always stored under BlocksNamespace, as that is the layout of
+// the default blockstore. The DHT records are stored under DHTNamespace by
+// Config.SetupLibp2p.
+var (
+	BlocksNamespace   = datastore.NewKey("/blocks")
+	PinsNamespace     = datastore.NewKey("/pins")
+	ProviderNamespace = datastore.NewKey("/provider")
+	DHTNamespace      = datastore.NewKey("/dht")
+	MetaNamespace     = datastore.NewKey("/meta")
+
+	// DHTProvidersNamespace is meant for the provider records stored by
+	// DHT servers (see DHTDatastore).
+	DHTProvidersNamespace = datastore.NewKey("/dht-providers")
+)
+
+// NamespacedDatastore returns a view of the given datastore where all keys
+// are prefixed with the given namespace. It can be used to share a datastore
+// between the DHT (with DHTNamespace, when calling SetupLibp2p) and the
+// Peer.
+func NamespacedDatastore(ds datastore.Batching, ns datastore.Key) datastore.Batching {
+	return namespace.Wrap(ds, ns)
+}
+
+// dhtDatastore returns the datastore to give to the DHT: ds, under
+// DHTNamespace when Config.NamespacedDatastore is set.
+func (cfg *Config) dhtDatastore(ds datastore.Batching) datastore.Batching {
+	if ds == nil || !cfg.NamespacedDatastore {
+		return ds
+	}
+	return NamespacedDatastore(ds, DHTNamespace)
+}
+
+// datastore returns the datastore to use for the given namespace. Unless
+// Config.NamespacedDatastore is set, this is the Peer's datastore as is.
+func (p *Peer) datastore(ns datastore.Key) datastore.Batching {
+	if !p.cfg.NamespacedDatastore {
+		return p.store
+	}
+	return NamespacedDatastore(p.store, ns)
+}
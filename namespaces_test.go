@@ -0,0 +1,137 @@
+package ipfslite
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/ipfs/boxo/ipld/merkledag"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+)
+
+func TestNamespacedDatastore(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ds := NewInMemoryDatastore()
+	p, err := New(ctx, ds, nil, nil, nil, &Config{
+		Offline:             true,
+		NamespacedDatastore: true,
+		TrackAccessTime:     true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n := merkledag.NewRawNode([]byte("namespaced"))
+	if err := p.Add(ctx, n); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Pin(ctx, n.Cid(), true); err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := ds.Query(ctx, query.Query{KeysOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries, err := res.Rest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	namespaces := []string{BlocksNamespace.String(), PinsNamespace.String(), MetaNamespace.String()}
+	for _, e := range entries {
+		found := false
+		for _, ns := range namespaces {
+			if strings.HasPrefix(e.Key, ns+"/") {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("key outside known namespaces: %s", e.Key)
+		}
+	}
+}
+
+func TestBlockDatastore(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	meta := NewInMemoryDatastore()
+	blocks := NewInMemoryDatastore()
+	p, err := New(ctx, meta, nil, nil, nil, &Config{
+		Offline:             true,
+		NamespacedDatastore: true,
+		BlockDatastore:      blocks,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n := merkledag.NewRawNode([]byte("split"))
+	if err := p.Add(ctx, n); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Pin(ctx, n.Cid(), true); err != nil {
+		t.Fatal(err)
+	}
+
+	keys := func(ds interface {
+		Query(context.Context, query.Query) (query.Results, error)
+	}) []string {
+		res, err := ds.Query(ctx, query.Query{KeysOnly: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries, err := res.Rest()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var keys []string
+		for _, e := range entries {
+			keys = append(keys, e.Key)
+		}
+		return keys
+	}
+	for _, k := range keys(meta) {
+		if strings.HasPrefix(k, BlocksNamespace.String()+"/") {
+			t.Errorf("block stored in the metadata datastore: %s", k)
+		}
+	}
+	blockKeys := keys(blocks)
+	if len(blockKeys) != 1 || !strings.HasPrefix(blockKeys[0], BlocksNamespace.String()+"/") {
+		t.Errorf("unexpected block datastore keys: %v", blockKeys)
+	}
+	if pinned, err := p.IsPinned(ctx, n.Cid()); err != nil || !pinned {
+		t.Error("the block should be pinned", err)
+	}
+}
+
+func TestDHTNamespace(t *testing.T) {
+	ctx := context.Background()
+	cfg := &Config{NamespacedDatastore: true}
+	main := NewInMemoryDatastore()
+	providers := NewInMemoryDatastore()
+	dhtDS, err := DHTDatastore(main, providers, ProviderStoreOptions{MaxRecords: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ds := cfg.dhtDatastore(dhtDS)
+
+	if err := ds.Put(ctx, datastore.NewKey("/providers/CID/PEER"), []byte("record")); err != nil {
+		t.Fatal(err)
+	}
+	if err := ds.Put(ctx, datastore.NewKey("/pk/KEY"), []byte("value")); err != nil {
+		t.Fatal(err)
+	}
+	if has, _ := providers.Has(ctx, datastore.NewKey("/CID/PEER")); !has {
+		t.Error("provider record should be in the providers datastore")
+	}
+	if has, _ := main.Has(ctx, DHTNamespace.ChildString("pk/KEY")); !has {
+		t.Error("other records should be under the DHT namespace")
+	}
+	if has, _ := main.Has(ctx, datastore.NewKey("/pk/KEY")); has {
+		t.Error("records should not be stored outside the DHT namespace")
+	}
+}
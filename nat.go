@@ -0,0 +1,353 @@
+package ipfslite
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	libp2p "github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/network"
+	basichost "github.com/libp2p/go-libp2p/p2p/host/basic"
+	inat "github.com/libp2p/go-libp2p/p2p/net/nat"
+	"github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
+)
+
+var natDiscoveryTimeout = 10 * time.Second
+
+var errNoPortMapper = errors.New("the host does not use NATPortMap")
+
+// portMappers maps the networks of hosts using NATPortMap to their port
+// mapper, so that Peers can find the one of their host.
+var portMappers sync.Map
+
+// PortMapping is a port of the host which is mapped on the NAT device.
+type PortMapping struct {
+	// Protocol is "tcp" or "udp".
+	Protocol     string
+	InternalPort int
+	// External is the external address of the mapping, or nil when the
+	// NAT device has not mapped the port.
+	External multiaddr.Multiaddr
+}
+
+// NATStatus describes the port mappings of a host using NATPortMap.
+type NATStatus struct {
+	// Discovered is set when a NAT device supporting port mapping (UPnP
+	// or NAT-PMP) was found.
+	Discovered bool
+	Mappings   []PortMapping
+}
+
+// NATPortMap returns a libp2p option which maps the listening ports of the
+// host on the NAT device of the network (using UPnP or NAT-PMP), like
+// libp2p.NATPortMap, while allowing Peers using the host to report the
+// mappings (Peer.NATStatus) and to re-map them (Peer.RefreshNAT). It is
+// included in Libp2pOptionsExtra.
+func NATPortMap() libp2p.Option {
+	return libp2p.NATManager(func(n network.Network) basichost.NATManager {
+		return newPortMapper(n)
+	})
+}
+
+// NATStatus returns the status of the port mappings of the Peer's host. It
+// fails when the host was not created with the NATPortMap option.
+func (p *Peer) NATStatus() (NATStatus, error) {
+	pm, err := p.portMapper()
+	if err != nil {
+		return NATStatus{}, err
+	}
+	return pm.status(), nil
+}
+
+// RefreshNAT discovers the NAT device again and re-maps all the listening
+// ports of the Peer's host, i.e. after the router was restarted or
+// replaced. It fails when the host was not created with the NATPortMap
+// option, or when no NAT device is found.
+func (p *Peer) RefreshNAT(ctx context.Context) error {
+	pm, err := p.portMapper()
+	if err != nil {
+		return err
+	}
+	return pm.refresh(ctx)
+}
+
+func (p *Peer) portMapper() (*portMapper, error) {
+	if p.host == nil {
+		return nil, errNoPortMapper
+	}
+	pm, ok := portMappers.Load(p.host.Network())
+	if !ok {
+		return nil, errNoPortMapper
+	}
+	return pm.(*portMapper), nil
+}
+
+type natEntry struct {
+	protocol string
+	port     int
+}
+
+// portMapper is a basichost.NATManager which maps the listening ports of
+// the network, and which can report and refresh its mappings.
+type portMapper struct {
+	net    network.Network
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+	notify chan struct{}
+
+	// syncMu serializes the changes to the mappings.
+	syncMu sync.Mutex
+	mu     sync.Mutex
+	nat    *inat.NAT
+	ports  map[natEntry]struct{}
+}
+
+func newPortMapper(n network.Network) *portMapper {
+	ctx, cancel := context.WithCancel(context.Background())
+	pm := &portMapper{
+		net:    n,
+		ctx:    ctx,
+		cancel: cancel,
+		notify: make(chan struct{}, 1),
+		ports:  make(map[natEntry]struct{}),
+	}
+	portMappers.Store(n, pm)
+	pm.wg.Add(1)
+	go pm.background()
+	return pm
+}
+
+func (pm *portMapper) background() {
+	defer pm.wg.Done()
+
+	ctx, cancel := context.WithTimeout(pm.ctx, natDiscoveryTimeout)
+	nat, err := inat.DiscoverNAT(ctx)
+	cancel()
+	if err != nil {
+		logger.Infof("NAT discovery: %s", err)
+	} else {
+		pm.mu.Lock()
+		pm.nat = nat
+		pm.mu.Unlock()
+	}
+
+	pm.net.Notify((*portMapperNotifiee)(pm))
+	defer pm.net.StopNotify((*portMapperNotifiee)(pm))
+	pm.sync()
+	for {
+		select {
+		case <-pm.notify:
+			pm.sync()
+		case <-pm.ctx.Done():
+			return
+		}
+	}
+}
+
+// listenEntries returns the ports which should be mapped.
+func (pm *portMapper) listenEntries() map[natEntry]struct{} {
+	entries := make(map[natEntry]struct{})
+	for _, addr := range pm.net.ListenAddresses() {
+		naddr, err := manet.ToNetAddr(addr)
+		if err != nil {
+			continue
+		}
+		var e natEntry
+		var ip net.IP
+		switch a := naddr.(type) {
+		case *net.TCPAddr:
+			e, ip = natEntry{"tcp", a.Port}, a.IP
+		case *net.UDPAddr:
+			e, ip = natEntry{"udp", a.Port}, a.IP
+		default:
+			continue
+		}
+		if ip.IsGlobalUnicast() || ip.IsUnspecified() {
+			entries[e] = struct{}{}
+		}
+	}
+	return entries
+}
+
+// sync maps new listening ports and unmaps closed ones.
+func (pm *portMapper) sync() {
+	pm.syncMu.Lock()
+	defer pm.syncMu.Unlock()
+
+	pm.mu.Lock()
+	nat := pm.nat
+	pm.mu.Unlock()
+	if nat == nil {
+		return
+	}
+
+	want := pm.listenEntries()
+	for e := range pm.ports {
+		if _, ok := want[e]; !ok {
+			nat.RemoveMapping(pm.ctx, e.protocol, e.port)
+		}
+	}
+	for e := range want {
+		if _, ok := pm.ports[e]; ok {
+			continue
+		}
+		if err := nat.AddMapping(pm.ctx, e.protocol, e.port); err != nil {
+			logger.Errorf("failed to map %s port %d: %s", e.protocol, e.port, err)
+		}
+	}
+	pm.mu.Lock()
+	pm.ports = want
+	pm.mu.Unlock()
+}
+
+// refresh replaces the NAT device and maps all the listening ports again.
+func (pm *portMapper) refresh(ctx context.Context) error {
+	pm.syncMu.Lock()
+	defer pm.syncMu.Unlock()
+
+	ctx, cancel := context.WithTimeout(ctx, natDiscoveryTimeout)
+	defer cancel()
+	nat, err := inat.DiscoverNAT(ctx)
+	if err != nil {
+		return err
+	}
+
+	pm.mu.Lock()
+	old := pm.nat
+	pm.nat = nat
+	pm.ports = make(map[natEntry]struct{})
+	pm.mu.Unlock()
+	if old != nil {
+		old.Close()
+	}
+
+	want := pm.listenEntries()
+	for e := range want {
+		if err := nat.AddMapping(ctx, e.protocol, e.port); err != nil {
+			return err
+		}
+	}
+	pm.mu.Lock()
+	pm.ports = want
+	pm.mu.Unlock()
+	return nil
+}
+
+func (pm *portMapper) status() NATStatus {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+
+	s := NATStatus{Discovered: pm.nat != nil}
+	for e := range pm.ports {
+		m := PortMapping{Protocol: e.protocol, InternalPort: e.port}
+		if ext, ok := pm.nat.GetMapping(e.protocol, e.port); ok && ext.Port() != 0 {
+			var naddr net.Addr = net.TCPAddrFromAddrPort(ext)
+			if e.protocol == "udp" {
+				naddr = net.UDPAddrFromAddrPort(ext)
+			}
+			m.External, _ = manet.FromNetAddr(naddr)
+		}
+		s.Mappings = append(s.Mappings, m)
+	}
+	sort.Slice(s.Mappings, func(i, j int) bool {
+		a, b := s.Mappings[i], s.Mappings[j]
+		return a.Protocol < b.Protocol || a.Protocol == b.Protocol && a.InternalPort < b.InternalPort
+	})
+	return s
+}
+
+func (pm *portMapper) HasDiscoveredNAT() bool {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	return pm.nat != nil
+}
+
+// GetMapping returns the external address corresponding to the given
+// listen address, or nil if it is not mapped.
+func (pm *portMapper) GetMapping(addr multiaddr.Multiaddr) multiaddr.Multiaddr {
+	pm.mu.Lock()
+	nat := pm.nat
+	pm.mu.Unlock()
+	if nat == nil {
+		return nil
+	}
+
+	// Split the address after its transport (tcp/udp) component.
+	var found bool
+	transport, rest := multiaddr.SplitFunc(addr, func(c multiaddr.Component) bool {
+		if found {
+			return true
+		}
+		code := c.Protocol().Code
+		found = code == multiaddr.P_TCP || code == multiaddr.P_UDP
+		return false
+	})
+	naddr, err := manet.ToNetAddr(transport)
+	if err != nil {
+		return nil
+	}
+
+	var mapped net.Addr
+	switch a := naddr.(type) {
+	case *net.TCPAddr:
+		if !a.IP.IsGlobalUnicast() && !a.IP.IsUnspecified() {
+			return nil
+		}
+		ext, ok := nat.GetMapping("tcp", a.Port)
+		if !ok {
+			return nil
+		}
+		mapped = net.TCPAddrFromAddrPort(ext)
+	case *net.UDPAddr:
+		if !a.IP.IsGlobalUnicast() && !a.IP.IsUnspecified() {
+			return nil
+		}
+		ext, ok := nat.GetMapping("udp", a.Port)
+		if !ok {
+			return nil
+		}
+		mapped = net.UDPAddrFromAddrPort(ext)
+	default:
+		return nil
+	}
+	ext, err := manet.FromNetAddr(mapped)
+	if err != nil {
+		return nil
+	}
+	if rest != nil {
+		ext = multiaddr.Join(ext, rest)
+	}
+	return ext
+}
+
+func (pm *portMapper) Close() error {
+	pm.cancel()
+	pm.wg.Wait()
+	portMappers.Delete(pm.net)
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	if pm.nat != nil {
+		return pm.nat.Close()
+	}
+	return nil
+}
+
+// portMapperNotifiee triggers a sync when the listen addresses change.
+type portMapperNotifiee portMapper
+
+func (n *portMapperNotifiee) trigger() {
+	select {
+	case n.notify <- struct{}{}:
+	default:
+	}
+}
+
+func (n *portMapperNotifiee) Listen(network.Network, multiaddr.Multiaddr)      { n.trigger() }
+func (n *portMapperNotifiee) ListenClose(network.Network, multiaddr.Multiaddr) { n.trigger() }
+func (n *portMapperNotifiee) Connected(network.Network, network.Conn)          {}
+func (n *portMapperNotifiee) Disconnected(network.Network, network.Conn)       {}
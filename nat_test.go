@@ -0,0 +1,56 @@
+package ipfslite
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	dht "github.com/libp2p/go-libp2p-kad-dht"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/multiformats/go-multiaddr"
+)
+
+func TestNATStatus(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	defer func(d time.Duration) { natDiscoveryTimeout = d }(natDiscoveryTimeout)
+	natDiscoveryTimeout = 500 * time.Millisecond
+
+	priv, _, err := crypto.GenerateKeyPair(crypto.Ed25519, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	listen := []multiaddr.Multiaddr{multiaddr.StringCast("/ip4/127.0.0.1/tcp/0")}
+	h, d, err := SetupLibp2p(ctx, priv, nil, listen, nil, dht.ModeClient, NATPortMap())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+
+	p, err := New(ctx, NewInMemoryDatastore(), nil, h, d, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// There is no NAT device in the test environment, and loopback
+	// ports are never mapped.
+	s, err := p.NATStatus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(s.Mappings) != 0 {
+		t.Errorf("unexpected mappings: %v", s.Mappings)
+	}
+
+	h.Close()
+	if _, err := p.NATStatus(); err != errNoPortMapper {
+		t.Errorf("expected errNoPortMapper after closing the host, got %v", err)
+	}
+
+	other := setupPeer(t, ctx, nil)
+	if _, err := other.NATStatus(); err != errNoPortMapper {
+		t.Errorf("expected errNoPortMapper, got %v", err)
+	}
+	if err := other.RefreshNAT(ctx); err != errNoPortMapper {
+		t.Errorf("expected errNoPortMapper, got %v", err)
+	}
+}
@@ -0,0 +1,144 @@
+package ipfslite
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/ipfs/boxo/blockservice"
+	offline "github.com/ipfs/boxo/exchange/offline"
+	"github.com/ipfs/boxo/ipld/merkledag"
+	"github.com/ipfs/boxo/ipld/unixfs"
+	ufsio "github.com/ipfs/boxo/ipld/unixfs/io"
+	"github.com/ipfs/go-cid"
+)
+
+// MissingRange is a part of a file which could not be retrieved: the
+// content of the block Cid, at Offset in the file. Length is -1 when the
+// size of the block is not known.
+type MissingRange struct {
+	Cid    cid.Cid
+	Offset int64
+	Length int64
+}
+
+// PartialFileError is returned by the readers of GetFile with
+// WithPartialResults when the fetch deadline expires. The content up to
+// Read was returned by the reader. Missing lists the blocks of the file
+// which are not available locally, in file order, so that they can be
+// retried, or their ranges be skipped when rendering. It wraps the context
+// error.
+type PartialFileError struct {
+	Root    cid.Cid
+	Read    int64
+	Missing []MissingRange
+	Err     error
+}
+
+func (e *PartialFileError) Error() string {
+	return fmt.Sprintf("partial file %s: %d bytes read, %d blocks missing: %s", e.Root, e.Read, len(e.Missing), e.Err)
+}
+
+func (e *PartialFileError) Unwrap() error {
+	return e.Err
+}
+
+// WithPartialResults makes GetFile report what it could not retrieve when
+// the fetch deadline expires (see WithTimeout and Config.FetchTimeout),
+// with a *PartialFileError, instead of failing with a bare context error.
+// The reader returns the content fetched so far before the error.
+func WithPartialResults() FetchOption {
+	return func(o *fetchOptions) {
+		o.partial = true
+	}
+}
+
+// missingRanges lists the blocks of the UnixFS file below root which are
+// not available locally. It never fetches blocks from the network.
+func (p *Peer) missingRanges(ctx context.Context, root cid.Cid) ([]MissingRange, error) {
+	dag := merkledag.NewDAGService(blockservice.New(p.bstore, offline.Exchange(p.bstore)))
+	var missing []MissingRange
+	var walk func(c cid.Cid, offset, length int64) error
+	walk = func(c cid.Cid, offset, length int64) error {
+		has, err := p.bstore.Has(ctx, c)
+		if err != nil {
+			return err
+		}
+		if !has {
+			missing = append(missing, MissingRange{Cid: c, Offset: offset, Length: length})
+			return nil
+		}
+		n, err := dag.Get(ctx, c)
+		if err != nil {
+			return err
+		}
+		switch n := n.(type) {
+		case *merkledag.RawNode:
+			return nil
+		case *merkledag.ProtoNode:
+			fsn, err := unixfs.FSNodeFromBytes(n.Data())
+			if err != nil {
+				return err
+			}
+			offset += int64(len(fsn.Data()))
+			for i, l := range n.Links() {
+				if i >= fsn.NumChildren() {
+					return errors.New("invalid UnixFS file node: " + c.String())
+				}
+				size := int64(fsn.BlockSize(i))
+				if err := walk(l.Cid, offset, size); err != nil {
+					return err
+				}
+				offset += size
+			}
+			return nil
+		default:
+			return errors.New("not a UnixFS file: " + c.String())
+		}
+	}
+	err := walk(root, 0, -1)
+	return missing, err
+}
+
+// partialError returns the *PartialFileError corresponding to an error of
+// a fetch in partial results mode, or the error itself when the fetch
+// deadline has not expired.
+func (p *Peer) partialError(ctx context.Context, root cid.Cid, read int64, err error) error {
+	if !errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return err
+	}
+	// The context has expired, but the report only needs local lookups.
+	missing, merr := p.missingRanges(context.Background(), root)
+	if merr != nil {
+		logger.Warnf("error listing the missing blocks of %s: %s", root, merr)
+	}
+	return &PartialFileError{Root: root, Read: read, Missing: missing, Err: ctx.Err()}
+}
+
+// partialDagReader turns the errors of a file reader into
+// PartialFileErrors when the fetch deadline expires.
+type partialDagReader struct {
+	ufsio.ReadSeekCloser
+	p    *Peer
+	ctx  context.Context
+	root cid.Cid
+	pos  int64
+}
+
+func (r *partialDagReader) Read(buf []byte) (int, error) {
+	n, err := r.ReadSeekCloser.Read(buf)
+	r.pos += int64(n)
+	if err != nil && err != io.EOF {
+		err = r.p.partialError(r.ctx, r.root, r.pos, err)
+	}
+	return n, err
+}
+
+func (r *partialDagReader) Seek(offset int64, whence int) (int64, error) {
+	pos, err := r.ReadSeekCloser.Seek(offset, whence)
+	if err == nil {
+		r.pos = pos
+	}
+	return pos, err
+}
@@ -0,0 +1,76 @@
+package ipfslite
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-cid"
+)
+
+func TestGetFilePartialResults(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	src, err := New(ctx, NewInMemoryDatastore(), nil, nil, nil, &Config{Offline: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	content := make([]byte, 4096)
+	rand.Read(content)
+	root, err := src.AddFile(ctx, bytes.NewReader(content), &AddParams{Chunker: "size-1024", RawLeaves: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	links := root.Links()
+	if len(links) != 4 {
+		t.Fatalf("expected 4 leaves, got %d", len(links))
+	}
+
+	// Only the root and the first leaf are available to the peer, which
+	// is not connected to the source.
+	p := setupPeer(t, ctx, nil)
+	for _, c := range []cid.Cid{root.Cid(), links[0].Cid} {
+		blk, err := src.BlockStore().Get(ctx, c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := p.BlockStore().Put(ctx, blk); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r, err := p.GetFile(ctx, root.Cid(), WithTimeout(500*time.Millisecond), WithPartialResults())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	data, err := io.ReadAll(r)
+	var perr *PartialFileError
+	if !errors.As(err, &perr) {
+		t.Fatalf("expected a partial file error, got %v", err)
+	}
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Error("the error should wrap the context error")
+	}
+	if !bytes.Equal(data, content[:1024]) || perr.Read != 1024 {
+		t.Errorf("expected the first block, got %d bytes (%d reported)", len(data), perr.Read)
+	}
+	if len(perr.Missing) != 3 {
+		t.Fatalf("unexpected missing blocks: %+v", perr.Missing)
+	}
+	for i, m := range perr.Missing {
+		if m.Cid != links[i+1].Cid || m.Offset != int64(1024*(i+1)) || m.Length != 1024 {
+			t.Errorf("unexpected missing range: %+v", m)
+		}
+	}
+
+	// The root itself is missing.
+	_, err = p.GetFile(ctx, testCid(t, "missing"), WithTimeout(200*time.Millisecond), WithPartialResults())
+	if !errors.As(err, &perr) || len(perr.Missing) != 1 || perr.Missing[0].Length != -1 {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
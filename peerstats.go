@@ -0,0 +1,325 @@
+package ipfslite
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"sync"
+	"time"
+
+	bsmsg "github.com/ipfs/boxo/bitswap/message"
+	bsserver "github.com/ipfs/boxo/bitswap/server"
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/namespace"
+	"github.com/ipfs/go-datastore/query"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/peerstore"
+	"github.com/multiformats/go-multiaddr"
+)
+
+var (
+	peerStatsPrefix        = datastore.NewKey("/peerstats")
+	peerStatsFlushInterval = time.Minute
+	// peerStatsPreferred is the number of best ranked peers dialed when
+	// a fetch starts.
+	peerStatsPreferred = 4
+)
+
+// PeerStats are the statistics of the block exchanges with a peer, kept
+// across restarts when Config.PeerStats is set.
+type PeerStats struct {
+	Peer peer.ID
+	// BlocksReceived and BytesReceived count the blocks received from
+	// the peer, BytesSent the size of the blocks sent to it.
+	BlocksReceived uint64
+	BytesReceived  uint64
+	BytesSent      uint64
+	// Latency is the average round-trip latency to the peer, when known.
+	Latency  time.Duration
+	LastSeen time.Time
+}
+
+// score ranks peers by the blocks they provided, discounted by their
+// latency.
+func (s PeerStats) score() float64 {
+	return float64(s.BlocksReceived) / (1 + s.Latency.Seconds()*10)
+}
+
+// Reciprocity is the ratio of the bytes received from the peer to the bytes
+// sent to it, smoothed so that new peers have a reciprocity of 1. Peers
+// which gave more than they took have a reciprocity above 1.
+func (s PeerStats) Reciprocity() float64 {
+	return reciprocity(s.BytesReceived, s.BytesSent)
+}
+
+// reciprocityUnit smoothes the reciprocity of the peers which exchanged few
+// bytes.
+const reciprocityUnit = 1 << 20
+
+func reciprocity(received, sent uint64) float64 {
+	return (float64(received) + reciprocityUnit) / (float64(sent) + reciprocityUnit)
+}
+
+// peerStatsRecord is the persisted form of PeerStats.
+type peerStatsRecord struct {
+	BlocksReceived uint64        `json:"blocks_received"`
+	BytesReceived  uint64        `json:"bytes_received"`
+	BytesSent      uint64        `json:"bytes_sent"`
+	Latency        time.Duration `json:"latency"`
+	LastSeen       time.Time     `json:"last_seen"`
+	// Addrs are the last known addresses of the peer, to dial it after
+	// a restart.
+	Addrs []string `json:"addrs,omitempty"`
+}
+
+// peerStatsTracker records the blocks exchanged with each peer as a
+// bitswap tracer, and persists them in the datastore.
+type peerStatsTracker struct {
+	ds datastore.Datastore
+	ps peerstore.Peerstore
+
+	mu    sync.Mutex
+	stats map[peer.ID]*peerStatsRecord
+	dirty map[peer.ID]struct{}
+}
+
+func newPeerStatsTracker(ctx context.Context, ds datastore.Datastore, ps peerstore.Peerstore) (*peerStatsTracker, error) {
+	t := &peerStatsTracker{
+		ds:    namespace.Wrap(ds, peerStatsPrefix),
+		ps:    ps,
+		stats: make(map[peer.ID]*peerStatsRecord),
+		dirty: make(map[peer.ID]struct{}),
+	}
+	res, err := t.ds.Query(ctx, query.Query{})
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+	for r := range res.Next() {
+		if r.Error != nil {
+			return nil, r.Error
+		}
+		pid, err := peer.Decode(datastore.RawKey(r.Key).BaseNamespace())
+		if err != nil {
+			logger.Warnf("invalid peer stats key %s: %s", r.Key, err)
+			continue
+		}
+		var rec peerStatsRecord
+		if err := json.Unmarshal(r.Value, &rec); err != nil {
+			logger.Warnf("invalid peer stats for %s: %s", pid, err)
+			continue
+		}
+		t.stats[pid] = &rec
+	}
+	return t, nil
+}
+
+// record returns the record of the given peer, marked as modified. It must
+// be called with the lock held.
+func (t *peerStatsTracker) record(pid peer.ID) *peerStatsRecord {
+	rec, ok := t.stats[pid]
+	if !ok {
+		rec = &peerStatsRecord{}
+		t.stats[pid] = rec
+	}
+	rec.LastSeen = time.Now()
+	t.dirty[pid] = struct{}{}
+	return rec
+}
+
+func (t *peerStatsTracker) received(pid peer.ID, blks []blocks.Block) {
+	if len(blks) == 0 {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	rec := t.record(pid)
+	for _, blk := range blks {
+		rec.BlocksReceived++
+		rec.BytesReceived += uint64(len(blk.RawData()))
+	}
+}
+
+func (t *peerStatsTracker) sent(pid peer.ID, blks []blocks.Block) {
+	if len(blks) == 0 {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	rec := t.record(pid)
+	for _, blk := range blks {
+		rec.BytesSent += uint64(len(blk.RawData()))
+	}
+}
+
+// MessageReceived implements the bitswap tracer interface.
+func (t *peerStatsTracker) MessageReceived(pid peer.ID, msg bsmsg.BitSwapMessage) {
+	t.received(pid, msg.Blocks())
+}
+
+// MessageSent implements the bitswap tracer interface.
+func (t *peerStatsTracker) MessageSent(pid peer.ID, msg bsmsg.BitSwapMessage) {
+	t.sent(pid, msg.Blocks())
+}
+
+// flush writes the modified records to the datastore, along with the
+// current latency and addresses of the peers.
+func (t *peerStatsTracker) flush(ctx context.Context) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for pid := range t.dirty {
+		rec := t.stats[pid]
+		if l := t.ps.LatencyEWMA(pid); l > 0 {
+			rec.Latency = l
+		}
+		if addrs := t.ps.Addrs(pid); len(addrs) > 0 {
+			rec.Addrs = rec.Addrs[:0]
+			for _, a := range addrs {
+				rec.Addrs = append(rec.Addrs, a.String())
+			}
+		}
+		data, err := json.Marshal(rec)
+		if err != nil {
+			return err
+		}
+		if err := t.ds.Put(ctx, datastore.NewKey(pid.String()), data); err != nil {
+			return err
+		}
+		delete(t.dirty, pid)
+	}
+	return nil
+}
+
+// ranked returns the stats of all the known peers, best first.
+func (t *peerStatsTracker) ranked() []PeerStats {
+	t.mu.Lock()
+	stats := make([]PeerStats, 0, len(t.stats))
+	for pid, rec := range t.stats {
+		s := PeerStats{
+			Peer:           pid,
+			BlocksReceived: rec.BlocksReceived,
+			BytesReceived:  rec.BytesReceived,
+			BytesSent:      rec.BytesSent,
+			Latency:        rec.Latency,
+			LastSeen:       rec.LastSeen,
+		}
+		if l := t.ps.LatencyEWMA(pid); l > 0 {
+			s.Latency = l
+		}
+		stats = append(stats, s)
+	}
+	t.mu.Unlock()
+	sort.SliceStable(stats, func(i, j int) bool {
+		return stats[i].score() > stats[j].score()
+	})
+	return stats
+}
+
+// reciprocity returns the reciprocity of the given peer (see
+// PeerStats.Reciprocity).
+func (t *peerStatsTracker) reciprocity(pid peer.ID) float64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	rec, ok := t.stats[pid]
+	if !ok {
+		return reciprocity(0, 0)
+	}
+	return reciprocity(rec.BytesReceived, rec.BytesSent)
+}
+
+// latency returns the persisted latency of the given peer, or zero.
+func (t *peerStatsTracker) latency(pid peer.ID) time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if rec, ok := t.stats[pid]; ok {
+		return rec.Latency
+	}
+	return 0
+}
+
+// favorReciprocating is a bitswap task comparator serving the peers with
+// the highest reciprocity first (tit-for-tat). The wants of a peer are
+// served in the order they were received.
+func (t *peerStatsTracker) favorReciprocating(ta, tb *bsserver.TaskInfo) bool {
+	if ta.Peer == tb.Peer {
+		return false
+	}
+	return t.reciprocity(ta.Peer) > t.reciprocity(tb.Peer)
+}
+
+// addrInfo returns the known addresses of the given peer.
+func (t *peerStatsTracker) addrInfo(pid peer.ID) peer.AddrInfo {
+	pi := peer.AddrInfo{ID: pid, Addrs: t.ps.Addrs(pid)}
+	if len(pi.Addrs) > 0 {
+		return pi
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if rec, ok := t.stats[pid]; ok {
+		for _, s := range rec.Addrs {
+			if a, err := multiaddr.NewMultiaddr(s); err == nil {
+				pi.Addrs = append(pi.Addrs, a)
+			}
+		}
+	}
+	return pi
+}
+
+// PeerStats returns the statistics of the peers the Peer has exchanged
+// blocks with, best ranked first: peers which provided more blocks, with a
+// lower latency, come first. It returns nil unless Config.PeerStats is set.
+func (p *Peer) PeerStats() []PeerStats {
+	if p.peerStats == nil {
+		return nil
+	}
+	return p.peerStats.ranked()
+}
+
+// peerStatsLoop persists the peer statistics periodically, and when the
+// Peer is closed.
+func (p *Peer) peerStatsLoop() {
+	ticker := time.NewTicker(peerStatsFlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.ctx.Done():
+			if err := p.peerStats.flush(context.Background()); err != nil {
+				logger.Errorf("error storing peer stats: %s", err)
+			}
+			return
+		case <-ticker.C:
+			if err := p.peerStats.flush(p.ctx); err != nil {
+				logger.Errorf("error storing peer stats: %s", err)
+			}
+		}
+	}
+}
+
+// dialPreferredPeers dials, in the background, the best ranked peers which
+// are not connected, so that bitswap asks them for blocks first.
+func (p *Peer) dialPreferredPeers(ctx context.Context) {
+	if p.peerStats == nil {
+		return
+	}
+	dialed := 0
+	for _, s := range p.peerStats.ranked() {
+		if dialed == peerStatsPreferred || s.BlocksReceived == 0 {
+			return
+		}
+		if p.host.Network().Connectedness(s.Peer) == network.Connected {
+			continue
+		}
+		pi := p.peerStats.addrInfo(s.Peer)
+		if len(pi.Addrs) == 0 {
+			continue
+		}
+		dialed++
+		go func() {
+			if err := p.host.Connect(ctx, pi); err != nil {
+				logger.Debugf("error dialing preferred peer %s: %s", pi.ID, err)
+			}
+		}()
+	}
+}
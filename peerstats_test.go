@@ -0,0 +1,114 @@
+package ipfslite
+
+import (
+	"context"
+	"testing"
+
+	bsserver "github.com/ipfs/boxo/bitswap/server"
+	blocks "github.com/ipfs/go-block-format"
+	cbor "github.com/ipfs/go-ipld-cbor"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multihash"
+)
+
+func TestPeerStats(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	p1 := setupPeer(t, ctx, nil)
+	ds := NewInMemoryDatastore()
+	p2 := setupPeerWithDatastore(t, ctx, ds, &Config{PeerStats: true})
+
+	codec := uint64(multihash.SHA2_256)
+	node, err := cbor.WrapObject(map[string]string{"akey": "avalue"}, codec, multihash.DefaultLengths[codec])
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = p1.Add(ctx, node)
+	if err != nil {
+		t.Fatal(err)
+	}
+	providers := []peer.AddrInfo{{ID: p1.host.ID(), Addrs: p1.host.Addrs()}}
+	_, err = p2.Fetch(ctx, node.Cid(), WithProviders(providers))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stats := p2.PeerStats()
+	if len(stats) != 1 || stats[0].Peer != p1.host.ID() {
+		t.Fatalf("unexpected peer stats: %+v", stats)
+	}
+	if stats[0].BlocksReceived != 1 || stats[0].BytesReceived != uint64(len(node.RawData())) {
+		t.Errorf("unexpected peer stats: %+v", stats[0])
+	}
+	if p1.PeerStats() != nil {
+		t.Error("stats should only be tracked with Config.PeerStats")
+	}
+
+	// The stats are reloaded from the datastore.
+	err = p2.peerStats.flush(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tracker, err := newPeerStatsTracker(ctx, p2.datastore(MetaNamespace), p2.host.Peerstore())
+	if err != nil {
+		t.Fatal(err)
+	}
+	reloaded := tracker.ranked()
+	if len(reloaded) != 1 || reloaded[0].BlocksReceived != 1 {
+		t.Fatalf("unexpected reloaded stats: %+v", reloaded)
+	}
+	if pi := tracker.addrInfo(p1.host.ID()); len(pi.Addrs) == 0 {
+		t.Error("the peer addresses should be known")
+	}
+}
+
+func TestFavorReciprocatingPeers(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ds := NewInMemoryDatastore()
+	p := setupPeerWithDatastore(t, ctx, ds, &Config{FavorReciprocatingPeers: true})
+	if p.peerStats == nil {
+		t.Fatal("the exchanges should be tracked")
+	}
+
+	var ids []peer.ID
+	for i := 0; i < 3; i++ {
+		priv, _, err := crypto.GenerateEd25519Key(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pid, err := peer.IDFromPrivateKey(priv)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, pid)
+	}
+	giver, taker, stranger := ids[0], ids[1], ids[2]
+	blk := blocks.NewBlock(make([]byte, 4<<20))
+	p.peerStats.received(giver, []blocks.Block{blk})
+	p.peerStats.sent(taker, []blocks.Block{blk})
+
+	less := p.peerStats.favorReciprocating
+	task := func(pid peer.ID) *bsserver.TaskInfo {
+		return &bsserver.TaskInfo{Peer: pid}
+	}
+	if !less(task(giver), task(stranger)) || !less(task(stranger), task(taker)) || less(task(taker), task(giver)) {
+		t.Error("peers which reciprocate should be served first")
+	}
+	if r := (PeerStats{}).Reciprocity(); r != 1 {
+		t.Errorf("unexpected reciprocity of a new peer: %f", r)
+	}
+
+	// The ledgers survive restarts.
+	if err := p.peerStats.flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	tracker, err := newPeerStatsTracker(ctx, p.datastore(MetaNamespace), p.host.Peerstore())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !tracker.favorReciprocating(task(giver), task(taker)) {
+		t.Error("the reloaded ledgers should favor the same peers")
+	}
+}
@@ -0,0 +1,117 @@
+package ipfslite
+
+import (
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/peerstore"
+	"github.com/libp2p/go-libp2p/core/record"
+	"github.com/libp2p/go-libp2p/p2p/host/peerstore/pstoremem"
+	"github.com/multiformats/go-multiaddr"
+)
+
+// ttlCappedPeerstore is a peerstore which caps the TTL of learned addresses.
+// Permanent addresses and addresses of connected peers are not affected.
+type ttlCappedPeerstore struct {
+	peerstore.Peerstore
+	maxTTL time.Duration
+}
+
+var _ peerstore.CertifiedAddrBook = (*ttlCappedPeerstore)(nil)
+
+// newTTLCappedPeerstore returns an in-memory peerstore where the TTL of
+// learned addresses is capped to maxTTL.
+func newTTLCappedPeerstore(maxTTL time.Duration) (peerstore.Peerstore, error) {
+	ps, err := pstoremem.NewPeerstore()
+	if err != nil {
+		return nil, err
+	}
+	return &ttlCappedPeerstore{
+		Peerstore: ps,
+		maxTTL:    maxTTL,
+	}, nil
+}
+
+func (ps *ttlCappedPeerstore) cap(ttl time.Duration) time.Duration {
+	if ttl >= peerstore.ConnectedAddrTTL || ttl <= ps.maxTTL {
+		return ttl
+	}
+	return ps.maxTTL
+}
+
+func (ps *ttlCappedPeerstore) AddAddr(p peer.ID, addr multiaddr.Multiaddr, ttl time.Duration) {
+	ps.Peerstore.AddAddr(p, addr, ps.cap(ttl))
+}
+
+func (ps *ttlCappedPeerstore) AddAddrs(p peer.ID, addrs []multiaddr.Multiaddr, ttl time.Duration) {
+	ps.Peerstore.AddAddrs(p, addrs, ps.cap(ttl))
+}
+
+func (ps *ttlCappedPeerstore) SetAddr(p peer.ID, addr multiaddr.Multiaddr, ttl time.Duration) {
+	ps.Peerstore.SetAddr(p, addr, ps.cap(ttl))
+}
+
+func (ps *ttlCappedPeerstore) SetAddrs(p peer.ID, addrs []multiaddr.Multiaddr, ttl time.Duration) {
+	ps.Peerstore.SetAddrs(p, addrs, ps.cap(ttl))
+}
+
+func (ps *ttlCappedPeerstore) UpdateAddrs(p peer.ID, oldTTL time.Duration, newTTL time.Duration) {
+	ps.Peerstore.UpdateAddrs(p, oldTTL, ps.cap(newTTL))
+}
+
+func (ps *ttlCappedPeerstore) ConsumePeerRecord(s *record.Envelope, ttl time.Duration) (bool, error) {
+	cab, ok := peerstore.GetCertifiedAddrBook(ps.Peerstore)
+	if !ok {
+		return false, nil
+	}
+	return cab.ConsumePeerRecord(s, ps.cap(ttl))
+}
+
+func (ps *ttlCappedPeerstore) GetPeerRecord(p peer.ID) *record.Envelope {
+	cab, ok := peerstore.GetCertifiedAddrBook(ps.Peerstore)
+	if !ok {
+		return nil
+	}
+	return cab.GetPeerRecord(p)
+}
+
+// peerstoreGC periodically removes from the peerstore the peers which are
+// not connected and have no known addresses left, so that keys, protocols
+// and metadata of peers seen once do not accumulate forever.
+func (p *Peer) peerstoreGC() {
+	ticker := time.NewTicker(p.cfg.PeerstoreGCInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.ctx.Done():
+			return
+		case <-ticker.C:
+			n := p.gcPeerstore()
+			if n > 0 {
+				logger.Debugf("peerstore GC removed %d peers", n)
+			}
+		}
+	}
+}
+
+func (p *Peer) gcPeerstore() int {
+	ps := p.host.Peerstore()
+	self := p.host.ID()
+	removed := 0
+	for _, pid := range ps.Peers() {
+		if pid == self {
+			continue
+		}
+		if p.host.Network().Connectedness(pid) == network.Connected {
+			continue
+		}
+		if len(ps.Addrs(pid)) > 0 {
+			continue
+		}
+		ps.RemovePeer(pid)
+		removed++
+	}
+	return removed
+}
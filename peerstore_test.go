@@ -0,0 +1,33 @@
+package ipfslite
+
+import (
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/peerstore"
+	"github.com/multiformats/go-multiaddr"
+)
+
+func TestTTLCappedPeerstore(t *testing.T) {
+	ps, err := newTTLCappedPeerstore(50 * time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ps.Close()
+
+	p1, _ := peer.Decode("QmcgpsyWgH8Y8ajJz1Cu72KnS5uo2Aa2LpzU7kinSupNKC")
+	p2, _ := peer.Decode("12D3KooWD3eckifWpRn9wQpMG9R9hX3sD158z7EqHWmweQAJU5SA")
+	addr := multiaddr.StringCast("/ip4/1.2.3.4/tcp/4001")
+
+	ps.AddAddr(p1, addr, time.Hour)
+	ps.AddAddr(p2, addr, peerstore.ConnectedAddrTTL)
+	time.Sleep(100 * time.Millisecond)
+
+	if len(ps.Addrs(p1)) != 0 {
+		t.Error("address TTL was not capped")
+	}
+	if len(ps.Addrs(p2)) != 1 {
+		t.Error("connected address TTL should not be capped")
+	}
+}
@@ -0,0 +1,67 @@
+package ipfslite
+
+import (
+	"context"
+
+	pin "github.com/ipfs/boxo/pinning/pinner"
+	"github.com/ipfs/boxo/pinning/pinner/dspinner"
+	"github.com/ipfs/go-cid"
+)
+
+func (p *Peer) setupPinner() error {
+	pinner, err := dspinner.New(p.ctx, p.datastore(PinsNamespace), p.DAGService)
+	if err != nil {
+		return err
+	}
+	p.pinner = pinner
+	return nil
+}
+
+// Pin pins the given CID. When recursive is true, the whole DAG is fetched
+// (if not available locally) and pinned.
+func (p *Peer) Pin(ctx context.Context, c cid.Cid, recursive bool) error {
+	if p.cfg.ReadOnly {
+		return ErrReadOnly
+	}
+	n, err := p.Get(ctx, c)
+	if err != nil {
+		return err
+	}
+	err = p.pinner.Pin(ctx, n, recursive)
+	if err != nil {
+		return err
+	}
+	err = p.pinner.Flush(ctx)
+	if err != nil {
+		return err
+	}
+	p.addServedPins(ctx, c, recursive)
+	return nil
+}
+
+// Unpin removes the pin for the given CID.
+func (p *Peer) Unpin(ctx context.Context, c cid.Cid, recursive bool) error {
+	if p.cfg.ReadOnly {
+		return ErrReadOnly
+	}
+	err := p.pinner.Unpin(ctx, c, recursive)
+	if err != nil {
+		return err
+	}
+	if p.servePinned != nil {
+		p.servePinned.invalidate()
+	}
+	return p.pinner.Flush(ctx)
+}
+
+// IsPinned returns whether the given CID is pinned, either directly,
+// recursively or indirectly.
+func (p *Peer) IsPinned(ctx context.Context, c cid.Cid) (bool, error) {
+	_, pinned, err := p.pinner.IsPinned(ctx, c)
+	return pinned, err
+}
+
+// Pinner returns the underlying pinner implementation.
+func (p *Peer) Pinner() pin.Pinner {
+	return p.pinner
+}
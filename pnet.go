@@ -0,0 +1,170 @@
+package ipfslite
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync"
+
+	libp2p "github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	ipnet "github.com/libp2p/go-libp2p/core/pnet"
+	"github.com/libp2p/go-libp2p/core/transport"
+	"github.com/libp2p/go-libp2p/p2p/net/pnet"
+	"github.com/libp2p/go-libp2p/p2p/transport/tcp"
+	"github.com/libp2p/go-libp2p/p2p/transport/websocket"
+	manet "github.com/multiformats/go-multiaddr/net"
+)
+
+// pnetNonceSize is the size of the nonce sent by each side at the start of
+// a private network connection.
+const pnetNonceSize = 24
+
+// multistreamHeader is the first message sent by the dialer of a libp2p
+// connection (the multistream-select header, length-prefixed). It is used to
+// find out which PSK protects an inbound connection.
+var multistreamHeader = []byte("\x13/multistream/1.0.0\n")
+
+var errUnknownPSK = errors.New("connection is not protected by any accepted PSK")
+
+// PSKRotation returns a libp2p option to rotate the pre-shared key of a
+// private network without a flag-day upgrade of every node. Outbound
+// connections are protected with the current PSK, while inbound connections
+// are accepted when protected by the current PSK or any of the accepted
+// ones.
+//
+// It replaces the PSK given to SetupLibp2p, which must be nil, and, like
+// private networks, only the TCP and WebSocket transports are enabled. A
+// rotation from an old to a new PSK is done in three stages, each of them
+// rolled out to every node before starting the next one:
+//
+//  1. PSKRotation(old, new): accept both, dial with the old PSK.
+//  2. PSKRotation(new, old): accept both, dial with the new PSK.
+//  3. Use the new PSK as the SetupLibp2p secret.
+func PSKRotation(current ipnet.PSK, accepted ...ipnet.PSK) libp2p.Option {
+	keys := append([]ipnet.PSK{current}, accepted...)
+	wrap := func(u transport.Upgrader) transport.Upgrader {
+		return &pskRotationUpgrader{Upgrader: u, keys: keys}
+	}
+	return libp2p.ChainOptions(
+		libp2p.NoTransports,
+		libp2p.Transport(func(u transport.Upgrader, rcmgr network.ResourceManager) (*tcp.TcpTransport, error) {
+			return tcp.NewTCPTransport(wrap(u), rcmgr)
+		}),
+		libp2p.Transport(func(u transport.Upgrader, rcmgr network.ResourceManager) (*websocket.WebsocketTransport, error) {
+			return websocket.New(wrap(u), rcmgr)
+		}),
+	)
+}
+
+// pskRotationUpgrader protects connections before handing them to the
+// libp2p upgrader, which runs without a PSK.
+type pskRotationUpgrader struct {
+	transport.Upgrader
+	keys []ipnet.PSK
+}
+
+func (u *pskRotationUpgrader) UpgradeListener(t transport.Transport, l manet.Listener) transport.Listener {
+	return u.Upgrader.UpgradeListener(t, &pskListener{Listener: l, keys: u.keys})
+}
+
+func (u *pskRotationUpgrader) Upgrade(ctx context.Context, t transport.Transport, maconn manet.Conn, dir network.Direction, p peer.ID, scope network.ConnManagementScope) (transport.CapableConn, error) {
+	if dir == network.DirOutbound {
+		pconn, err := pnet.NewProtectedConn(u.keys[0], maconn)
+		if err != nil {
+			maconn.Close()
+			scope.Done()
+			return nil, err
+		}
+		maconn = &pskConn{Conn: maconn, protected: pconn}
+	}
+	return u.Upgrader.Upgrade(ctx, t, maconn, dir, p, scope)
+}
+
+type pskListener struct {
+	manet.Listener
+	keys []ipnet.PSK
+}
+
+func (l *pskListener) Accept() (manet.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &pskConn{Conn: c, keys: l.keys}, nil
+}
+
+// pskConn is a connection protected by a PSK. For inbound connections, the
+// PSK is selected among keys on first use, by checking which one decrypts
+// the multistream header sent by the dialer.
+type pskConn struct {
+	manet.Conn
+	keys []ipnet.PSK
+
+	once      sync.Once
+	protected net.Conn
+	err       error
+}
+
+func (c *pskConn) init() error {
+	c.once.Do(func() {
+		if c.protected != nil {
+			return
+		}
+		c.protected, c.err = c.detect()
+	})
+	return c.err
+}
+
+func (c *pskConn) detect() (net.Conn, error) {
+	prefix := make([]byte, pnetNonceSize+len(multistreamHeader))
+	_, err := io.ReadFull(c.Conn, prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, k := range c.keys {
+		probe, err := pnet.NewProtectedConn(k, &replayConn{r: bytes.NewReader(prefix)})
+		if err != nil {
+			return nil, err
+		}
+		buf := make([]byte, len(multistreamHeader))
+		_, err = io.ReadFull(probe, buf)
+		if err != nil || !bytes.Equal(buf, multistreamHeader) {
+			continue
+		}
+		return pnet.NewProtectedConn(k, &replayConn{
+			Conn: c.Conn,
+			r:    io.MultiReader(bytes.NewReader(prefix), c.Conn),
+		})
+	}
+	return nil, errUnknownPSK
+}
+
+func (c *pskConn) Read(b []byte) (int, error) {
+	if err := c.init(); err != nil {
+		return 0, err
+	}
+	return c.protected.Read(b)
+}
+
+func (c *pskConn) Write(b []byte) (int, error) {
+	if err := c.init(); err != nil {
+		return 0, err
+	}
+	return c.protected.Write(b)
+}
+
+// replayConn is a connection whose reads are served from r, which starts
+// with data already read from the connection.
+type replayConn struct {
+	net.Conn
+	r io.Reader
+}
+
+func (c *replayConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
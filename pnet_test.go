@@ -0,0 +1,79 @@
+package ipfslite
+
+import (
+	"context"
+	"crypto/rand"
+	"testing"
+	"time"
+
+	libp2p "github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/pnet"
+	"github.com/libp2p/go-libp2p/p2p/transport/tcp"
+	multiaddr "github.com/multiformats/go-multiaddr"
+)
+
+func newTestPSK(t *testing.T) pnet.PSK {
+	psk := make([]byte, 32)
+	if _, err := rand.Read(psk); err != nil {
+		t.Fatal(err)
+	}
+	return psk
+}
+
+func newPSKHost(t *testing.T, psk pnet.PSK, opts ...libp2p.Option) host.Host {
+	priv, _, err := crypto.GenerateKeyPair(crypto.Ed25519, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts = append([]libp2p.Option{
+		libp2p.Identity(priv),
+		libp2p.ListenAddrs(multiaddr.StringCast("/ip4/127.0.0.1/tcp/0")),
+		libp2p.PrivateNetwork(psk),
+	}, opts...)
+	if psk != nil {
+		opts = append(opts, libp2p.ChainOptions(libp2p.NoTransports, libp2p.Transport(tcp.NewTCPTransport)))
+	}
+	h, err := libp2p.New(opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { h.Close() })
+	return h
+}
+
+func TestPSKRotation(t *testing.T) {
+	oldPSK := newTestPSK(t)
+	newPSK := newTestPSK(t)
+
+	rotating := newPSKHost(t, nil, PSKRotation(newPSK, oldPSK))
+	oldHost := newPSKHost(t, oldPSK)
+	newHost := newPSKHost(t, newPSK)
+	otherHost := newPSKHost(t, newTestPSK(t))
+
+	connect := func(from, to host.Host) error {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		return from.Connect(ctx, peer.AddrInfo{ID: to.ID(), Addrs: to.Addrs()})
+	}
+
+	if err := connect(oldHost, rotating); err != nil {
+		t.Errorf("old PSK should be accepted: %s", err)
+	}
+	if err := connect(newHost, rotating); err != nil {
+		t.Errorf("new PSK should be accepted: %s", err)
+	}
+	if err := connect(otherHost, rotating); err == nil {
+		t.Error("unknown PSK should be rejected")
+	}
+
+	rotating2 := newPSKHost(t, nil, PSKRotation(newPSK, oldPSK))
+	if err := connect(rotating2, newHost); err != nil {
+		t.Errorf("dialing with the current PSK should work: %s", err)
+	}
+	if err := connect(rotating2, rotating); err != nil {
+		t.Errorf("rotating hosts should connect: %s", err)
+	}
+}
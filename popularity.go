@@ -0,0 +1,262 @@
+package ipfslite
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	bsmsg "github.com/ipfs/boxo/bitswap/message"
+	pin "github.com/ipfs/boxo/pinning/pinner"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/namespace"
+	"github.com/ipfs/go-datastore/query"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+var (
+	popularityPrefix        = datastore.NewKey("/popularity")
+	popularityFlushInterval = time.Minute
+)
+
+// ContentAccess counts the accesses to some content served by the Peer.
+type ContentAccess struct {
+	Cid cid.Cid
+	// BitswapFetches counts the times the root block was sent to other
+	// peers with bitswap.
+	BitswapFetches uint64 `json:"bitswap"`
+	// GatewayFetches counts the successful gateway requests for the
+	// content, or paths below it.
+	GatewayFetches uint64    `json:"gateway"`
+	LastAccess     time.Time `json:"last_access"`
+}
+
+func (a *ContentAccess) total() uint64 {
+	return a.BitswapFetches + a.GatewayFetches
+}
+
+// popularityTracker counts the accesses to content, persisted in the
+// datastore.
+type popularityTracker struct {
+	ds datastore.Datastore
+	// isRoot tells whether blocks sent with bitswap are content roots.
+	isRoot func(c cid.Cid) bool
+
+	mu     sync.Mutex
+	counts map[cid.Cid]*ContentAccess
+	dirty  map[cid.Cid]struct{}
+}
+
+func newPopularityTracker(ctx context.Context, ds datastore.Datastore, isRoot func(cid.Cid) bool) (*popularityTracker, error) {
+	t := &popularityTracker{
+		ds:     namespace.Wrap(ds, popularityPrefix),
+		isRoot: isRoot,
+		counts: make(map[cid.Cid]*ContentAccess),
+		dirty:  make(map[cid.Cid]struct{}),
+	}
+	res, err := t.ds.Query(ctx, query.Query{})
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+	for r := range res.Next() {
+		if r.Error != nil {
+			return nil, r.Error
+		}
+		c, err := cid.Decode(datastore.RawKey(r.Key).BaseNamespace())
+		if err != nil {
+			logger.Warnf("invalid popularity key %s: %s", r.Key, err)
+			continue
+		}
+		a := &ContentAccess{Cid: c}
+		if err := json.Unmarshal(r.Value, a); err != nil {
+			logger.Warnf("invalid popularity record for %s: %s", c, err)
+			continue
+		}
+		t.counts[c] = a
+	}
+	return t, nil
+}
+
+// record returns the counts of the given CID, marked as accessed. It must
+// be called with the lock held.
+func (t *popularityTracker) record(c cid.Cid) *ContentAccess {
+	a, ok := t.counts[c]
+	if !ok {
+		a = &ContentAccess{Cid: c}
+		t.counts[c] = a
+	}
+	a.LastAccess = time.Now()
+	t.dirty[c] = struct{}{}
+	return a
+}
+
+func (t *popularityTracker) gatewayFetch(c cid.Cid) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.record(c).GatewayFetches++
+}
+
+func (t *popularityTracker) MessageReceived(peer.ID, bsmsg.BitSwapMessage) {}
+
+// MessageSent implements the bitswap tracer interface, counting the sent
+// content roots.
+func (t *popularityTracker) MessageSent(_ peer.ID, msg bsmsg.BitSwapMessage) {
+	for _, blk := range msg.Blocks() {
+		if !t.isRoot(blk.Cid()) {
+			continue
+		}
+		t.mu.Lock()
+		t.record(blk.Cid()).BitswapFetches++
+		t.mu.Unlock()
+	}
+}
+
+// flush writes the modified counts to the datastore.
+func (t *popularityTracker) flush(ctx context.Context) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for c := range t.dirty {
+		data, err := json.Marshal(t.counts[c])
+		if err != nil {
+			return err
+		}
+		if err := t.ds.Put(ctx, datastore.NewKey(c.String()), data); err != nil {
+			return err
+		}
+		delete(t.dirty, c)
+	}
+	return nil
+}
+
+// ranked returns at most limit counts, most accessed first.
+func (t *popularityTracker) ranked(limit int) []ContentAccess {
+	t.mu.Lock()
+	counts := make([]ContentAccess, 0, len(t.counts))
+	for _, a := range t.counts {
+		counts = append(counts, *a)
+	}
+	t.mu.Unlock()
+	sort.Slice(counts, func(i, j int) bool {
+		if counts[i].total() != counts[j].total() {
+			return counts[i].total() > counts[j].total()
+		}
+		return counts[i].LastAccess.After(counts[j].LastAccess)
+	})
+	if limit > 0 && len(counts) > limit {
+		counts = counts[:limit]
+	}
+	return counts
+}
+
+func (p *Peer) setupPopularity() error {
+	if !p.cfg.TrackPopularity {
+		return nil
+	}
+	t, err := newPopularityTracker(p.ctx, p.datastore(MetaNamespace), p.isPinnedRoot)
+	if err != nil {
+		return err
+	}
+	p.popularity = t
+	return nil
+}
+
+// isPinnedRoot returns whether the given CID is pinned recursively or
+// directly.
+func (p *Peer) isPinnedRoot(c cid.Cid) bool {
+	if p.pinner == nil {
+		return false
+	}
+	for _, mode := range []pin.Mode{pin.Recursive, pin.Direct} {
+		if _, pinned, err := p.pinner.IsPinnedWithType(p.ctx, c, mode); err == nil && pinned {
+			return true
+		}
+	}
+	return false
+}
+
+// PopularContent returns the access counts of the content served by the
+// Peer, most accessed first, so that operators know which content to keep
+// pinned or to replicate. At most limit entries are returned, or all of
+// them when limit is not positive. It returns nil unless
+// Config.TrackPopularity is set.
+func (p *Peer) PopularContent(limit int) []ContentAccess {
+	if p.popularity == nil {
+		return nil
+	}
+	return p.popularity.ranked(limit)
+}
+
+// popularityLoop persists the access counts periodically, and when the
+// Peer is closed.
+func (p *Peer) popularityLoop() {
+	ticker := time.NewTicker(popularityFlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.ctx.Done():
+			if err := p.popularity.flush(context.Background()); err != nil {
+				logger.Errorf("error storing access counts: %s", err)
+			}
+			return
+		case <-ticker.C:
+			if err := p.popularity.flush(p.ctx); err != nil {
+				logger.Errorf("error storing access counts: %s", err)
+			}
+		}
+	}
+}
+
+// countAccesses counts the successful gateway requests for /ipfs/ paths.
+func (p *Peer) countAccesses(next http.Handler) http.Handler {
+	if p.popularity == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, ok := gatewayRoot(r.URL.Path)
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		sw := &statusWriter{ResponseWriter: w, code: http.StatusOK}
+		next.ServeHTTP(sw, r)
+		if sw.code < http.StatusBadRequest {
+			p.popularity.gatewayFetch(c)
+		}
+	})
+}
+
+// gatewayRoot returns the root CID of an /ipfs/ path.
+func gatewayRoot(path string) (cid.Cid, bool) {
+	rest, ok := strings.CutPrefix(path, "/ipfs/")
+	if !ok {
+		return cid.Undef, false
+	}
+	if i := strings.IndexByte(rest, '/'); i >= 0 {
+		rest = rest[:i]
+	}
+	c, err := cid.Decode(rest)
+	if err != nil {
+		return cid.Undef, false
+	}
+	return c, true
+}
+
+// statusWriter records the status code of a response.
+type statusWriter struct {
+	http.ResponseWriter
+	code        int
+	wroteHeader bool
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	if !w.wroteHeader {
+		w.code = code
+		w.wroteHeader = true
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
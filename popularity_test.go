@@ -0,0 +1,74 @@
+package ipfslite
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+func TestPopularContent(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	p1 := setupPeer(t, ctx, &Config{TrackPopularity: true})
+	p2 := setupPeer(t, ctx, nil)
+
+	popular, err := p1.AddFile(ctx, strings.NewReader("popular"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := p1.AddFile(ctx, strings.NewReader("other"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, n := range []cid.Cid{popular.Cid(), other.Cid()} {
+		if err := p1.Pin(ctx, n, true); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	h, err := p1.Gateway(&GatewayConfig{Timeout: 500 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if rec := gatewayGet(t, h, "localhost", "/ipfs/"+popular.Cid().String()); rec.Code != http.StatusOK {
+			t.Fatalf("unexpected status %d", rec.Code)
+		}
+	}
+	gatewayGet(t, h, "localhost", "/ipfs/"+testCid(t, "missing").String())
+
+	providers := []peer.AddrInfo{{ID: p1.host.ID(), Addrs: p1.host.Addrs()}}
+	_, err = p2.Fetch(ctx, other.Cid(), WithProviders(providers))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	counts := p1.PopularContent(0)
+	if len(counts) != 2 {
+		t.Fatalf("expected 2 entries, got %+v", counts)
+	}
+	if !counts[0].Cid.Equals(popular.Cid()) || counts[0].GatewayFetches != 2 {
+		t.Errorf("unexpected first entry %+v", counts[0])
+	}
+	if !counts[1].Cid.Equals(other.Cid()) || counts[1].BitswapFetches != 1 {
+		t.Errorf("unexpected second entry %+v", counts[1])
+	}
+
+	// The counts are reloaded from the datastore.
+	err = p1.popularity.flush(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tracker, err := newPopularityTracker(ctx, p1.datastore(MetaNamespace), p1.isPinnedRoot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reloaded := tracker.ranked(1); len(reloaded) != 1 || reloaded[0].GatewayFetches != 2 {
+		t.Errorf("unexpected reloaded counts %+v", reloaded)
+	}
+}
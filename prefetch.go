@@ -0,0 +1,71 @@
+package ipfslite
+
+import (
+	"context"
+	"sync/atomic"
+
+	"github.com/ipfs/go-cid"
+	ipld "github.com/ipfs/go-ipld-format"
+)
+
+// prefetchConcurrency is the number of nodes of a DAG fetched in parallel
+// by Prefetch.
+var prefetchConcurrency = 8
+
+// PrefetchEvent reports the progress of Prefetch.
+type PrefetchEvent struct {
+	// Root is the root being prefetched.
+	Root cid.Cid
+	// Nodes is the number of nodes of the DAG below Root retrieved so
+	// far, whether they were available locally or fetched.
+	Nodes int
+	// Done is set once the DAG below Root has been retrieved, or when it
+	// failed, in which case Err is set.
+	Done bool
+	Err  error
+}
+
+// Prefetch retrieves the DAGs below the given roots into the local
+// blockstore in the background, down to depth links below every root (the
+// whole DAGs when depth is negative), so that they can later be read
+// without waiting for the network, i.e. for content the user is likely to
+// open next. Fetches have the FetchPriorityBackground priority unless
+// overridden in opts.
+//
+// Progress is reported on the returned channel, which must be drained: an
+// event is sent for every retrieved node, then one with Done set for every
+// root. The channel is closed once all the roots are done, or ctx is
+// cancelled. Prefetched content is not pinned.
+func (p *Peer) Prefetch(ctx context.Context, roots []cid.Cid, depth int, opts ...FetchOption) <-chan PrefetchEvent {
+	events := make(chan PrefetchEvent)
+	opts = append([]FetchOption{WithPriority(FetchPriorityBackground)}, opts...)
+	go func() {
+		defer close(events)
+		send := func(ev PrefetchEvent) bool {
+			select {
+			case events <- ev:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+		for _, root := range roots {
+			var nodes int64
+			err := p.Walk(ctx, root, func(n ipld.Node, path string) error {
+				ev := PrefetchEvent{Root: root, Nodes: int(atomic.AddInt64(&nodes, 1))}
+				if !send(ev) {
+					return ctx.Err()
+				}
+				return nil
+			}, WithMaxDepth(depth), WithWalkConcurrency(prefetchConcurrency), WithWalkFetchOptions(opts...))
+			if err != nil {
+				logger.Debugf("error prefetching %s: %s", root, err)
+			}
+			ev := PrefetchEvent{Root: root, Nodes: int(atomic.LoadInt64(&nodes)), Done: true, Err: err}
+			if !send(ev) {
+				return
+			}
+		}
+	}()
+	return events
+}
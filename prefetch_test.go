@@ -0,0 +1,71 @@
+package ipfslite
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+func TestPrefetch(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	p1 := setupPeer(t, ctx, nil)
+	p2 := setupPeer(t, ctx, nil)
+
+	var roots []cid.Cid
+	for i := 0; i < 2; i++ {
+		content := make([]byte, 4096)
+		rand.Read(content)
+		n, err := p1.AddFile(ctx, bytes.NewReader(content), &AddParams{Chunker: "size-1024", RawLeaves: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		roots = append(roots, n.Cid())
+	}
+	providers := []peer.AddrInfo{{ID: p1.host.ID(), Addrs: p1.host.Addrs()}}
+
+	// Only the root of the first DAG, and the whole second one.
+	var done []PrefetchEvent
+	for ev := range p2.Prefetch(ctx, roots[:1], 0, WithProviders(providers)) {
+		if ev.Done {
+			done = append(done, ev)
+		}
+	}
+	for ev := range p2.Prefetch(ctx, roots[1:], -1, WithProviders(providers)) {
+		if ev.Done {
+			done = append(done, ev)
+		}
+	}
+	if len(done) != 2 {
+		t.Fatalf("expected 2 done events, got %d", len(done))
+	}
+	for i, ev := range done {
+		if ev.Err != nil {
+			t.Fatal(ev.Err)
+		}
+		if !ev.Root.Equals(roots[i]) {
+			t.Errorf("unexpected root %s", ev.Root)
+		}
+	}
+	if done[0].Nodes != 1 || done[1].Nodes != 5 {
+		t.Errorf("unexpected node counts %d and %d", done[0].Nodes, done[1].Nodes)
+	}
+
+	for i, root := range roots {
+		n, err := p1.Get(ctx, root)
+		if err != nil {
+			t.Fatal(err)
+		}
+		has, err := p2.HasBlock(ctx, n.Links()[0].Cid)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if has != (i == 1) {
+			t.Errorf("unexpected presence of the first leaf of root %d: %t", i, has)
+		}
+	}
+}
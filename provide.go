@@ -0,0 +1,434 @@
+package ipfslite
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	provider "github.com/ipfs/boxo/provider"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/namespace"
+	"github.com/ipfs/go-datastore/query"
+	"github.com/libp2p/go-libp2p/core/routing"
+	"github.com/multiformats/go-multihash"
+	"golang.org/x/sync/errgroup"
+)
+
+var (
+	provideQueuePrefix    = datastore.NewKey("/provide/queue")
+	defaultProvideWorkers = 4
+	provideRetryInterval  = time.Minute
+	// provideManyWorkers limits the parallel announcements made by
+	// ProvideMany when the router cannot provide many CIDs at once, unless
+	// Config.ReprovideWorkers is set.
+	provideManyWorkers = 32
+)
+
+// ProvidePolicy sets what happens to new CIDs to announce when the provide
+// queue is full (see Config.ProvideQueueSize).
+type ProvidePolicy int
+
+// Provide queue policies.
+const (
+	// ProvideBlock makes the operations adding content (i.e. AddFile)
+	// wait until there is room in the queue, or until their context is
+	// cancelled. This is the default.
+	ProvideBlock ProvidePolicy = iota
+	// ProvideDrop drops the CIDs which do not fit in the queue. They are
+	// not announced until the next reprovide, and are counted in
+	// ProvideQueueStats.Dropped.
+	ProvideDrop
+)
+
+// ProvideQueueStats describes the queue of CIDs waiting to be announced to
+// the network.
+type ProvideQueueStats struct {
+	// Pending is the number of queued CIDs, including those being
+	// provided.
+	Pending int
+	// OldestAge is how long the oldest queued CID has been waiting.
+	OldestAge time.Duration
+	// Dropped is the number of CIDs dropped because the queue was full
+	// (see ProvideDrop) since the Peer started.
+	Dropped uint64
+}
+
+type provideResult struct {
+	c   cid.Cid
+	err error
+}
+
+// provideQueue announces CIDs to the content routing system. Pending CIDs
+// are persisted in the datastore and only removed once they have been
+// provided, so that announcements interrupted by a shutdown are resumed
+// when the queue is started again.
+type provideQueue struct {
+	ctx    context.Context
+	ds     datastore.Datastore
+	router routing.ContentRouting
+
+	size   int
+	policy ProvidePolicy
+
+	notify  chan struct{}
+	jobs    chan cid.Cid
+	results chan provideResult
+	wg      sync.WaitGroup
+
+	// mu protects entries, which maps the queued CIDs to the time they
+	// were queued, unsaved, the entries being persisted, room, which is
+	// closed when entries are removed, and dropped.
+	mu      sync.Mutex
+	entries map[cid.Cid]time.Time
+	unsaved map[cid.Cid]struct{}
+	room    chan struct{}
+	dropped uint64
+}
+
+// newProvideQueue starts a queue holding at most size CIDs (no limit when
+// zero), handling new CIDs according to policy when full.
+func newProvideQueue(ctx context.Context, ds datastore.Datastore, router routing.ContentRouting, workers, size int, policy ProvidePolicy) *provideQueue {
+	q := &provideQueue{
+		ctx:     ctx,
+		ds:      namespace.Wrap(ds, provideQueuePrefix),
+		router:  router,
+		size:    size,
+		policy:  policy,
+		notify:  make(chan struct{}, 1),
+		jobs:    make(chan cid.Cid),
+		results: make(chan provideResult),
+		entries: make(map[cid.Cid]time.Time),
+		unsaved: make(map[cid.Cid]struct{}),
+		room:    make(chan struct{}),
+	}
+	if err := q.load(); err != nil {
+		logger.Errorf("error loading provide queue: %s", err)
+	}
+
+	q.wg.Add(1)
+	go q.dispatch()
+	for i := 0; i < workers; i++ {
+		q.wg.Add(1)
+		go q.worker()
+	}
+	return q
+}
+
+// load reads the queued CIDs persisted by a previous run.
+func (q *provideQueue) load() error {
+	res, err := q.ds.Query(q.ctx, query.Query{})
+	if err != nil {
+		return err
+	}
+	defer res.Close()
+
+	now := time.Now()
+	for r := range res.Next() {
+		if r.Error != nil {
+			return r.Error
+		}
+		c, err := cid.Decode(datastore.RawKey(r.Key).BaseNamespace())
+		if err != nil {
+			logger.Warnf("removing bad provide queue entry %s: %s", r.Key, err)
+			q.ds.Delete(q.ctx, datastore.RawKey(r.Key))
+			continue
+		}
+		queued := now
+		if t, n := binary.Varint(r.Value); n > 0 {
+			queued = time.Unix(0, t)
+		}
+		q.entries[c] = queued
+	}
+	return nil
+}
+
+// Enqueue persists the given CID and schedules it to be provided. When the
+// queue is full, it waits for room until the context is cancelled, or
+// drops the CID, according to the queue policy.
+func (q *provideQueue) Enqueue(ctx context.Context, c cid.Cid) error {
+	q.mu.Lock()
+	for {
+		if _, ok := q.entries[c]; ok {
+			q.mu.Unlock()
+			return nil
+		}
+		if q.size <= 0 || len(q.entries) < q.size {
+			break
+		}
+		if q.policy == ProvideDrop {
+			q.dropped++
+			q.mu.Unlock()
+			logger.Debugf("provide queue full: dropping %s", c)
+			return nil
+		}
+		room := q.room
+		q.mu.Unlock()
+		select {
+		case <-room:
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-q.ctx.Done():
+			return q.ctx.Err()
+		}
+		q.mu.Lock()
+	}
+	now := time.Now()
+	q.entries[c] = now
+	q.unsaved[c] = struct{}{}
+	q.mu.Unlock()
+
+	buf := make([]byte, binary.MaxVarintLen64)
+	err := q.ds.Put(q.ctx, datastore.NewKey(c.String()), buf[:binary.PutVarint(buf, now.UnixNano())])
+	q.mu.Lock()
+	delete(q.unsaved, c)
+	q.mu.Unlock()
+	if err != nil {
+		q.remove(c)
+		return err
+	}
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// remove forgets the given CID and wakes up the callers waiting for room.
+func (q *provideQueue) remove(c cid.Cid) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if _, ok := q.entries[c]; !ok {
+		return
+	}
+	delete(q.entries, c)
+	close(q.room)
+	q.room = make(chan struct{})
+}
+
+// Stats returns statistics about the queue.
+func (q *provideQueue) Stats() ProvideQueueStats {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	s := ProvideQueueStats{Pending: len(q.entries), Dropped: q.dropped}
+	now := time.Now()
+	for _, queued := range q.entries {
+		if age := now.Sub(queued); age > s.OldestAge {
+			s.OldestAge = age
+		}
+	}
+	return s
+}
+
+// Close waits until the queue has stopped. The queue stops when its context
+// is cancelled.
+func (q *provideQueue) Close() error {
+	q.wg.Wait()
+	return nil
+}
+
+// pending returns the persisted CIDs which are not in the skip set, oldest
+// first. The entries mirror the datastore, which is only read by load.
+func (q *provideQueue) pending(skip map[cid.Cid]struct{}) []cid.Cid {
+	q.mu.Lock()
+	cids := make([]cid.Cid, 0, len(q.entries))
+	for c := range q.entries {
+		if _, ok := skip[c]; ok {
+			continue
+		}
+		if _, ok := q.unsaved[c]; ok {
+			continue
+		}
+		cids = append(cids, c)
+	}
+	sort.Slice(cids, func(i, j int) bool {
+		return q.entries[cids[i]].Before(q.entries[cids[j]])
+	})
+	q.mu.Unlock()
+	return cids
+}
+
+func (q *provideQueue) dispatch() {
+	defer q.wg.Done()
+
+	retry := time.NewTicker(provideRetryInterval)
+	defer retry.Stop()
+
+	// inflight contains CIDs handed to workers. failed contains CIDs
+	// which will not be retried until the next retry tick.
+	inflight := make(map[cid.Cid]struct{})
+	failed := make(map[cid.Cid]struct{})
+	skip := make(map[cid.Cid]struct{})
+
+	handleResult := func(r provideResult) {
+		delete(inflight, r.c)
+		if r.err != nil {
+			logger.Warnf("error providing %s: %s", r.c, r.err)
+			failed[r.c] = struct{}{}
+			return
+		}
+		err := q.ds.Delete(q.ctx, datastore.NewKey(r.c.String()))
+		if err != nil {
+			logger.Errorf("error removing %s from provide queue: %s", r.c, err)
+		}
+		q.remove(r.c)
+	}
+
+	for {
+		for c := range skip {
+			delete(skip, c)
+		}
+		for c := range inflight {
+			skip[c] = struct{}{}
+		}
+		for c := range failed {
+			skip[c] = struct{}{}
+		}
+
+		cids := q.pending(skip)
+
+		for _, c := range cids {
+			sent := false
+			for !sent {
+				select {
+				case q.jobs <- c:
+					inflight[c] = struct{}{}
+					sent = true
+				case r := <-q.results:
+					handleResult(r)
+				case <-q.ctx.Done():
+					return
+				}
+			}
+		}
+
+		if len(cids) > 0 {
+			continue
+		}
+
+		select {
+		case <-q.notify:
+		case r := <-q.results:
+			handleResult(r)
+		case <-retry.C:
+			for c := range failed {
+				delete(failed, c)
+			}
+		case <-q.ctx.Done():
+			return
+		}
+	}
+}
+
+func (q *provideQueue) worker() {
+	defer q.wg.Done()
+	for {
+		select {
+		case <-q.ctx.Done():
+			return
+		case c := <-q.jobs:
+			err := q.router.Provide(q.ctx, c, true)
+			select {
+			case q.results <- provideResult{c: c, err: err}:
+			case <-q.ctx.Done():
+				return
+			}
+		}
+	}
+}
+
+// ProvideMany announces the given CIDs to the network. When the Peer's
+// router supports announcing many CIDs at once, like the full routing table
+// DHT client (go-libp2p-kad-dht/fullrt) given to New, it is used to
+// announce all of them in one go, which is much faster for large sets (i.e.
+// after importing a CAR file). Otherwise the CIDs are announced in
+// parallel, and the ones which fail are queued to be retried in the
+// background. It is a no-op when the Peer is offline.
+func (p *Peer) ProvideMany(ctx context.Context, cids []cid.Cid) error {
+	if p.cfg.Offline || len(cids) == 0 {
+		return nil
+	}
+
+	if many, ok := p.dht.(provider.ProvideMany); ok {
+		keys := make([]multihash.Multihash, len(cids))
+		for i, c := range cids {
+			keys[i] = c.Hash()
+		}
+		return many.ProvideMany(ctx, keys)
+	}
+
+	var mu sync.Mutex
+	var failed int
+	workers := provideManyWorkers
+	if p.cfg.ReprovideWorkers > 0 {
+		workers = p.cfg.ReprovideWorkers
+	}
+	var g errgroup.Group
+	g.SetLimit(workers)
+	for _, c := range cids {
+		if ctx.Err() != nil {
+			break
+		}
+		c := c
+		g.Go(func() error {
+			err := p.dht.Provide(ctx, c, true)
+			if err == nil {
+				return nil
+			}
+			mu.Lock()
+			failed++
+			mu.Unlock()
+			if ctx.Err() == nil {
+				p.provide(ctx, c)
+			}
+			return nil
+		})
+	}
+	g.Wait()
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d CIDs could not be provided and were queued for retry", failed, len(cids))
+	}
+	return nil
+}
+
+// parallelProvider lets the reprovider announce batches of CIDs with
+// several workers, for routers which cannot announce many CIDs at once.
+type parallelProvider struct {
+	routing.ContentRouting
+	workers int
+}
+
+// ProvideMany announces all the keys, even when some of them fail, and
+// returns the errors joined.
+func (pp *parallelProvider) ProvideMany(ctx context.Context, keys []multihash.Multihash) error {
+	var mu sync.Mutex
+	var errs []error
+	var g errgroup.Group
+	g.SetLimit(pp.workers)
+	for _, k := range keys {
+		if ctx.Err() != nil {
+			break
+		}
+		c := cid.NewCidV1(cid.Raw, k)
+		g.Go(func() error {
+			if err := pp.Provide(ctx, c, true); err != nil {
+				mu.Lock()
+				errs = append(errs, fmt.Errorf("providing %s: %w", c, err))
+				mu.Unlock()
+			}
+			return nil
+		})
+	}
+	g.Wait()
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,232 @@
+package ipfslite
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/routing"
+	multihash "github.com/multiformats/go-multihash"
+)
+
+type mockRouter struct {
+	provided chan cid.Cid
+	block    bool
+}
+
+func (r *mockRouter) Provide(ctx context.Context, c cid.Cid, _ bool) error {
+	if r.block {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	r.provided <- c
+	return nil
+}
+
+func (r *mockRouter) FindProvidersAsync(ctx context.Context, _ cid.Cid, _ int) <-chan peer.AddrInfo {
+	ch := make(chan peer.AddrInfo)
+	close(ch)
+	return ch
+}
+
+func testCid(t *testing.T, data string) cid.Cid {
+	mh, err := multihash.Sum([]byte(data), multihash.SHA2_256, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cid.NewCidV1(cid.Raw, mh)
+}
+
+func TestProvideQueueResumes(t *testing.T) {
+	ds := NewInMemoryDatastore()
+	c := testCid(t, "provide me")
+
+	// First run: the router never completes, as if the node was shut
+	// down while providing.
+	ctx, cancel := context.WithCancel(context.Background())
+	q := newProvideQueue(ctx, ds, &mockRouter{block: true}, 1, 0, ProvideBlock)
+	err := q.Enqueue(ctx, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	cancel()
+	q.Close()
+
+	// Second run: the pending CID should be provided.
+	ctx, cancel = context.WithCancel(context.Background())
+	router := &mockRouter{provided: make(chan cid.Cid, 1)}
+	q = newProvideQueue(ctx, ds, router, 1, 0, ProvideBlock)
+	defer func() {
+		cancel()
+		q.Close()
+	}()
+
+	select {
+	case pc := <-router.provided:
+		if !pc.Equals(c) {
+			t.Fatalf("provided %s, expected %s", pc, c)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("pending CID was not provided after restart")
+	}
+}
+
+func TestProvideQueueFull(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	a, b := testCid(t, "a"), testCid(t, "b")
+
+	// The router never completes, so the queue stays full.
+	q := newProvideQueue(ctx, NewInMemoryDatastore(), &mockRouter{block: true}, 1, 1, ProvideBlock)
+	if err := q.Enqueue(ctx, a); err != nil {
+		t.Fatal(err)
+	}
+	// Already queued.
+	if err := q.Enqueue(ctx, a); err != nil {
+		t.Fatal(err)
+	}
+	enqCtx, enqCancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer enqCancel()
+	if err := q.Enqueue(enqCtx, b); err != context.DeadlineExceeded {
+		t.Errorf("expected the enqueue to block until the deadline, got %v", err)
+	}
+	s := q.Stats()
+	if s.Pending != 1 || s.OldestAge < 100*time.Millisecond || s.Dropped != 0 {
+		t.Errorf("unexpected stats: %+v", s)
+	}
+
+	q.policy = ProvideDrop
+	if err := q.Enqueue(ctx, b); err != nil {
+		t.Fatal(err)
+	}
+	if s := q.Stats(); s.Pending != 1 || s.Dropped != 1 {
+		t.Errorf("unexpected stats: %+v", s)
+	}
+	cancel()
+	q.Close()
+}
+
+// mockFullRouter is a routing.Routing using a mockRouter for content
+// routing.
+type mockFullRouter struct {
+	routing.Routing
+	cr *mockRouter
+}
+
+func (r *mockFullRouter) Provide(ctx context.Context, c cid.Cid, announce bool) error {
+	return r.cr.Provide(ctx, c, announce)
+}
+
+type mockManyRouter struct {
+	routing.Routing
+	keys []multihash.Multihash
+}
+
+func (r *mockManyRouter) ProvideMany(ctx context.Context, keys []multihash.Multihash) error {
+	r.keys = append(r.keys, keys...)
+	return nil
+}
+
+func TestProvideMany(t *testing.T) {
+	ctx := context.Background()
+	cids := []cid.Cid{testCid(t, "a"), testCid(t, "b"), testCid(t, "c")}
+
+	many := &mockManyRouter{}
+	p := &Peer{cfg: &Config{}, dht: many}
+	err := p.ProvideMany(ctx, cids)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(many.keys) != len(cids) {
+		t.Errorf("expected %d keys provided at once, got %d", len(cids), len(many.keys))
+	}
+
+	router := &mockRouter{provided: make(chan cid.Cid, len(cids))}
+	p = &Peer{cfg: &Config{}, dht: &mockFullRouter{cr: router}}
+	err = p.ProvideMany(ctx, cids)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(router.provided) != len(cids) {
+		t.Errorf("expected %d CIDs provided, got %d", len(cids), len(router.provided))
+	}
+}
+
+// concurrencyRouter records the maximum number of concurrent Provide calls,
+// and the number of CIDs provided. Providing fail, when set, fails.
+type concurrencyRouter struct {
+	routing.Routing
+	fail     cid.Cid
+	mu       sync.Mutex
+	current  int
+	max      int
+	provided int
+}
+
+func (r *concurrencyRouter) Provide(ctx context.Context, c cid.Cid, announce bool) error {
+	r.mu.Lock()
+	r.current++
+	if r.current > r.max {
+		r.max = r.current
+	}
+	r.mu.Unlock()
+	time.Sleep(20 * time.Millisecond)
+	r.mu.Lock()
+	r.current--
+	r.mu.Unlock()
+	if r.fail.Defined() && string(c.Hash()) == string(r.fail.Hash()) {
+		return errors.New("provide failed")
+	}
+	r.mu.Lock()
+	r.provided++
+	r.mu.Unlock()
+	return nil
+}
+
+func TestReprovideWorkers(t *testing.T) {
+	ctx := context.Background()
+	var keys []multihash.Multihash
+	var cids []cid.Cid
+	for i := 0; i < 12; i++ {
+		c := testCid(t, fmt.Sprint(i))
+		cids = append(cids, c)
+		keys = append(keys, c.Hash())
+	}
+
+	router := &concurrencyRouter{}
+	pp := &parallelProvider{ContentRouting: router, workers: 3}
+	err := pp.ProvideMany(ctx, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if router.max != 3 {
+		t.Errorf("expected 3 concurrent provides, got %d", router.max)
+	}
+
+	// A failed key does not stop the others.
+	router = &concurrencyRouter{fail: cids[0]}
+	pp = &parallelProvider{ContentRouting: router, workers: 3}
+	err = pp.ProvideMany(ctx, keys)
+	if err == nil {
+		t.Error("the failed provide should be reported")
+	}
+	if router.provided != len(keys)-1 {
+		t.Errorf("expected %d provided keys, got %d", len(keys)-1, router.provided)
+	}
+
+	router = &concurrencyRouter{}
+	p := &Peer{cfg: &Config{ReprovideWorkers: 2}, dht: router}
+	err = p.ProvideMany(ctx, cids)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if router.max != 2 {
+		t.Errorf("expected 2 concurrent provides, got %d", router.max)
+	}
+}
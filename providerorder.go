@@ -0,0 +1,106 @@
+package ipfslite
+
+import (
+	"sort"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// ProviderCandidate is a provider of the content requested by a fetch,
+// along with what the Peer knows about it.
+type ProviderCandidate struct {
+	peer.AddrInfo
+	// Connected is true when the Peer is connected to the provider.
+	Connected bool
+	// Latency is the observed round-trip latency to the provider, or
+	// zero when unknown.
+	Latency time.Duration
+}
+
+// ProviderOrdering ranks the providers of the content requested by a
+// fetch (given with WithProviders, or found with WithMaxProviders). The
+// providers are dialed in the returned order, and the bitswap session
+// starts as soon as the best ranked reachable provider is connected, so
+// that it is asked for blocks first. Providers left out of the returned
+// slice are not dialed.
+type ProviderOrdering interface {
+	Order(providers []ProviderCandidate) []ProviderCandidate
+}
+
+// ProviderOrderingFunc is a function implementing ProviderOrdering.
+type ProviderOrderingFunc func(providers []ProviderCandidate) []ProviderCandidate
+
+// Order calls f.
+func (f ProviderOrderingFunc) Order(providers []ProviderCandidate) []ProviderCandidate {
+	return f(providers)
+}
+
+// LatencyOrdering is the default ProviderOrdering: connected providers
+// come first, then the providers with the lowest observed latency.
+// Providers with an unknown latency come last, in the order they were
+// found.
+var LatencyOrdering ProviderOrdering = ProviderOrderingFunc(orderByLatency)
+
+func orderByLatency(providers []ProviderCandidate) []ProviderCandidate {
+	sort.SliceStable(providers, func(i, j int) bool {
+		a, b := providers[i], providers[j]
+		if a.Connected != b.Connected {
+			return a.Connected
+		}
+		if (a.Latency > 0) != (b.Latency > 0) {
+			return a.Latency > 0
+		}
+		return a.Latency < b.Latency
+	})
+	return providers
+}
+
+// orderProviders ranks the given providers with Config.ProviderOrdering.
+// Duplicates and the Peer itself are left out.
+func (p *Peer) orderProviders(providers []peer.AddrInfo) []peer.AddrInfo {
+	if p.host == nil || len(providers) == 0 {
+		return providers
+	}
+	seen := make(map[peer.ID]int)
+	candidates := make([]ProviderCandidate, 0, len(providers))
+	for _, pi := range providers {
+		if pi.ID == p.host.ID() {
+			continue
+		}
+		if i, ok := seen[pi.ID]; ok {
+			candidates[i].Addrs = append(candidates[i].Addrs, pi.Addrs...)
+			continue
+		}
+		seen[pi.ID] = len(candidates)
+		candidates = append(candidates, ProviderCandidate{
+			AddrInfo:  pi,
+			Connected: p.host.Network().Connectedness(pi.ID) == network.Connected,
+			Latency:   p.peerLatency(pi.ID),
+		})
+	}
+
+	ordering := p.cfg.ProviderOrdering
+	if ordering == nil {
+		ordering = LatencyOrdering
+	}
+	candidates = ordering.Order(candidates)
+	ordered := make([]peer.AddrInfo, 0, len(candidates))
+	for _, c := range candidates {
+		ordered = append(ordered, c.AddrInfo)
+	}
+	return ordered
+}
+
+// peerLatency returns the observed latency to the given peer, falling back
+// to the persisted peer stats, or zero when unknown.
+func (p *Peer) peerLatency(pid peer.ID) time.Duration {
+	if l := p.host.Peerstore().LatencyEWMA(pid); l > 0 {
+		return l
+	}
+	if p.peerStats != nil {
+		return p.peerStats.latency(pid)
+	}
+	return 0
+}
@@ -0,0 +1,65 @@
+package ipfslite
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	cbor "github.com/ipfs/go-ipld-cbor"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multihash"
+)
+
+func TestLatencyOrdering(t *testing.T) {
+	candidates := []ProviderCandidate{
+		{AddrInfo: peer.AddrInfo{ID: "unknown"}},
+		{AddrInfo: peer.AddrInfo{ID: "slow"}, Latency: 50 * time.Millisecond},
+		{AddrInfo: peer.AddrInfo{ID: "connected"}, Connected: true},
+		{AddrInfo: peer.AddrInfo{ID: "fast"}, Latency: 10 * time.Millisecond},
+	}
+	ordered := LatencyOrdering.Order(candidates)
+	want := []peer.ID{"connected", "fast", "slow", "unknown"}
+	for i, c := range ordered {
+		if c.ID != want[i] {
+			t.Fatalf("unexpected order at %d: %s", i, c.ID)
+		}
+	}
+}
+
+func TestProviderOrdering(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	p2 := setupPeer(t, ctx, nil)
+	p3 := setupPeer(t, ctx, nil)
+
+	var seen []ProviderCandidate
+	ordering := ProviderOrderingFunc(func(providers []ProviderCandidate) []ProviderCandidate {
+		seen = providers
+		var kept []ProviderCandidate
+		for _, c := range providers {
+			if c.ID == p3.host.ID() {
+				kept = append(kept, c)
+			}
+		}
+		return kept
+	})
+	p1 := setupPeer(t, ctx, &Config{ProviderOrdering: ordering})
+
+	node, _ := cbor.WrapObject(map[string]string{"provider": "ordering"}, multihash.SHA2_256, -1)
+	if err := p3.Add(ctx, node); err != nil {
+		t.Fatal(err)
+	}
+	p2info := peer.AddrInfo{ID: p2.host.ID(), Addrs: p2.host.Addrs()}
+	p3info := peer.AddrInfo{ID: p3.host.ID(), Addrs: p3.host.Addrs()}
+	_, err := p1.Fetch(ctx, node.Cid(), WithProviders([]peer.AddrInfo{p2info, p3info, p2info}), WithTimeout(5*time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(seen) != 2 {
+		t.Errorf("the duplicated provider should be ranked once: %+v", seen)
+	}
+	if p1.host.Network().Connectedness(p2.host.ID()) == network.Connected {
+		t.Error("providers left out by the ordering should not be dialed")
+	}
+}
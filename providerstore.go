@@ -0,0 +1,280 @@
+package ipfslite
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/mount"
+	dsq "github.com/ipfs/go-datastore/query"
+)
+
+// providersPrefix is where the DHT stores provider records.
+var providersPrefix = datastore.NewKey("/providers")
+
+var errProviderStoreFull = errors.New("provider record store is full")
+
+// providerStoreSweepInterval is the minimum delay between the sweeps of
+// expired records made when the provider store is full.
+var providerStoreSweepInterval = 10 * time.Second
+
+// ProviderStoreOptions bounds the provider records stored by a DHT server.
+type ProviderStoreOptions struct {
+	// MaxRecords is the maximum number of provider records (one per
+	// provider and CID). New records are rejected when the limit is
+	// reached, until older ones expire. Zero means no limit.
+	MaxRecords int
+	// TTL is how long provider records are kept. Zero keeps them as long
+	// as the DHT does (48 hours, unless re-provided).
+	TTL time.Duration
+}
+
+// DHTDatastore returns a datastore to pass to SetupLibp2p so that the
+// provider records which the DHT stores in server mode (on behalf of other
+// peers) are kept in the providers datastore, i.e.
+// NamespacedDatastore(ds, DHTProvidersNamespace), within the given bounds.
+// Other DHT records are stored in ds, which may be nil to keep them in
+// memory. This prevents server-mode nodes from bloating the application's
+// datastore with records about content they do not hold. The returned
+// datastore can be namespaced under DHTNamespace (see
+// Config.NamespacedDatastore), the other records then being stored under
+// DHTNamespace in ds.
+func DHTDatastore(ds, providers datastore.Batching, opts ProviderStoreOptions) (datastore.Batching, error) {
+	if ds == nil {
+		ds = NewInMemoryDatastore()
+	}
+	ps, err := newProviderStore(providers, opts)
+	if err != nil {
+		return nil, err
+	}
+	return mount.New([]mount.Mount{
+		{Prefix: providersPrefix, Datastore: ps},
+		{Prefix: DHTNamespace.Child(providersPrefix), Datastore: ps},
+		{Prefix: datastore.NewKey("/"), Datastore: ds},
+	}), nil
+}
+
+// providerStore is a size-bounded datastore whose entries expire. Values
+// are stored prefixed with their expiration time in Unix nanoseconds (zero
+// when they do not expire).
+type providerStore struct {
+	ds   datastore.Batching
+	opts ProviderStoreOptions
+
+	mu    sync.Mutex
+	count int
+	// nextExpiry is the earliest expiration time of the stored entries,
+	// in Unix nanoseconds (zero when none expires), and lastSweep the
+	// time of the last sweep. When the store is full, it is only swept
+	// again once entries have expired, at most every
+	// providerStoreSweepInterval.
+	nextExpiry int64
+	lastSweep  time.Time
+}
+
+func newProviderStore(ds datastore.Batching, opts ProviderStoreOptions) (*providerStore, error) {
+	s := &providerStore{ds: ds, opts: opts}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s, s.sweep(context.Background())
+}
+
+// sweep removes expired entries and recounts the remaining ones. It must
+// be called with the lock held.
+func (s *providerStore) sweep(ctx context.Context) error {
+	res, err := s.ds.Query(ctx, dsq.Query{})
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	count := 0
+	s.nextExpiry = 0
+	var expired []datastore.Key
+	for e := range res.Next() {
+		if e.Error != nil {
+			res.Close()
+			return e.Error
+		}
+		if _, ok := s.decode(e.Value, now); !ok {
+			expired = append(expired, datastore.RawKey(e.Key))
+			continue
+		}
+		count++
+		s.noteExpiry(e.Value)
+	}
+	res.Close()
+	for _, key := range expired {
+		err := s.ds.Delete(ctx, key)
+		if err != nil && err != datastore.ErrNotFound {
+			return err
+		}
+	}
+	s.count = count
+	s.lastSweep = now
+	return nil
+}
+
+// sweepDue tells whether entries may have expired since the last sweep,
+// which is old enough for another one. It must be called with the lock
+// held.
+func (s *providerStore) sweepDue(now time.Time) bool {
+	return s.nextExpiry != 0 && now.UnixNano() >= s.nextExpiry && now.Sub(s.lastSweep) >= providerStoreSweepInterval
+}
+
+// noteExpiry updates nextExpiry with the expiration time of a stored
+// entry. It must be called with the lock held.
+func (s *providerStore) noteExpiry(data []byte) {
+	expires := int64(binary.BigEndian.Uint64(data))
+	if expires != 0 && (s.nextExpiry == 0 || expires < s.nextExpiry) {
+		s.nextExpiry = expires
+	}
+}
+
+func (s *providerStore) encode(value []byte) []byte {
+	var expires int64
+	if s.opts.TTL > 0 {
+		expires = time.Now().Add(s.opts.TTL).UnixNano()
+	}
+	buf := make([]byte, 8+len(value))
+	binary.BigEndian.PutUint64(buf, uint64(expires))
+	copy(buf[8:], value)
+	return buf
+}
+
+// decode returns the value of an entry and whether it is still valid.
+func (s *providerStore) decode(data []byte, now time.Time) ([]byte, bool) {
+	if len(data) < 8 {
+		return nil, false
+	}
+	expires := int64(binary.BigEndian.Uint64(data))
+	if expires != 0 && now.UnixNano() >= expires {
+		return nil, false
+	}
+	return data[8:], true
+}
+
+func (s *providerStore) Put(ctx context.Context, key datastore.Key, value []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	has, err := s.ds.Has(ctx, key)
+	if err != nil {
+		return err
+	}
+	if !has && s.opts.MaxRecords > 0 && s.count >= s.opts.MaxRecords {
+		if !s.sweepDue(time.Now()) {
+			return errProviderStoreFull
+		}
+		if err := s.sweep(ctx); err != nil {
+			return err
+		}
+		if s.count >= s.opts.MaxRecords {
+			return errProviderStoreFull
+		}
+	}
+	data := s.encode(value)
+	err = s.ds.Put(ctx, key, data)
+	if err != nil {
+		return err
+	}
+	if !has {
+		s.count++
+	}
+	s.noteExpiry(data)
+	return nil
+}
+
+func (s *providerStore) Get(ctx context.Context, key datastore.Key) ([]byte, error) {
+	data, err := s.ds.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	value, ok := s.decode(data, time.Now())
+	if !ok {
+		if err := s.Delete(ctx, key); err != nil {
+			return nil, err
+		}
+		return nil, datastore.ErrNotFound
+	}
+	return value, nil
+}
+
+func (s *providerStore) Has(ctx context.Context, key datastore.Key) (bool, error) {
+	_, err := s.Get(ctx, key)
+	if err == datastore.ErrNotFound {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (s *providerStore) GetSize(ctx context.Context, key datastore.Key) (int, error) {
+	value, err := s.Get(ctx, key)
+	if err != nil {
+		return -1, err
+	}
+	return len(value), nil
+}
+
+func (s *providerStore) Delete(ctx context.Context, key datastore.Key) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	has, err := s.ds.Has(ctx, key)
+	if err != nil || !has {
+		return err
+	}
+	err = s.ds.Delete(ctx, key)
+	if err != nil {
+		return err
+	}
+	s.count--
+	return nil
+}
+
+// Query returns the valid entries matching the query. Expired entries are
+// removed.
+func (s *providerStore) Query(ctx context.Context, q dsq.Query) (dsq.Results, error) {
+	res, err := s.ds.Query(ctx, dsq.Query{Prefix: q.Prefix})
+	if err != nil {
+		return nil, err
+	}
+	all, err := res.Rest()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	entries := make([]dsq.Entry, 0, len(all))
+	for _, e := range all {
+		value, ok := s.decode(e.Value, now)
+		if !ok {
+			if err := s.Delete(ctx, datastore.RawKey(e.Key)); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		e.Size = len(value)
+		e.Value = value
+		if q.KeysOnly {
+			e.Value = nil
+		}
+		entries = append(entries, e)
+	}
+
+	naive := q
+	naive.Prefix = ""
+	return dsq.NaiveQueryApply(naive, dsq.ResultsWithEntries(q, entries)), nil
+}
+
+func (s *providerStore) Sync(ctx context.Context, prefix datastore.Key) error {
+	return s.ds.Sync(ctx, prefix)
+}
+
+func (s *providerStore) Batch(ctx context.Context) (datastore.Batch, error) {
+	return datastore.NewBasicBatch(s), nil
+}
+
+func (s *providerStore) Close() error {
+	return s.ds.Close()
+}
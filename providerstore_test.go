@@ -0,0 +1,157 @@
+package ipfslite
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-datastore"
+	dsq "github.com/ipfs/go-datastore/query"
+)
+
+func TestProviderStoreBounds(t *testing.T) {
+	ctx := context.Background()
+	s, err := newProviderStore(NewInMemoryDatastore(), ProviderStoreOptions{MaxRecords: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, k := range []string{"/a", "/b"} {
+		if err := s.Put(ctx, datastore.NewKey(k), []byte(k)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Put(ctx, datastore.NewKey("/c"), []byte("c")); err != errProviderStoreFull {
+		t.Fatalf("expected the store to be full, got %v", err)
+	}
+	// Overwriting is allowed.
+	if err := s.Put(ctx, datastore.NewKey("/a"), []byte("a2")); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Delete(ctx, datastore.NewKey("/b")); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Put(ctx, datastore.NewKey("/c"), []byte("c")); err != nil {
+		t.Fatal(err)
+	}
+
+	v, err := s.Get(ctx, datastore.NewKey("/a"))
+	if err != nil || string(v) != "a2" {
+		t.Errorf("unexpected value %q (%v)", v, err)
+	}
+}
+
+func TestProviderStoreTTL(t *testing.T) {
+	ctx := context.Background()
+	s, err := newProviderStore(NewInMemoryDatastore(), ProviderStoreOptions{MaxRecords: 1, TTL: 100 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Put(ctx, datastore.NewKey("/a"), []byte("a")); err != nil {
+		t.Fatal(err)
+	}
+	if has, _ := s.Has(ctx, datastore.NewKey("/a")); !has {
+		t.Fatal("record should be stored")
+	}
+
+	time.Sleep(200 * time.Millisecond)
+	res, err := s.Query(ctx, dsq.Query{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries, err := res.Rest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("expired records were returned: %v", entries)
+	}
+	// The expired record made room for a new one.
+	if err := s.Put(ctx, datastore.NewKey("/b"), []byte("b")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDHTDatastore(t *testing.T) {
+	ctx := context.Background()
+	main := NewInMemoryDatastore()
+	providers := NewInMemoryDatastore()
+	ds, err := DHTDatastore(main, providers, ProviderStoreOptions{MaxRecords: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	provKey := datastore.NewKey("/providers/CID/PEER")
+	if err := ds.Put(ctx, provKey, []byte("record")); err != nil {
+		t.Fatal(err)
+	}
+	if err := ds.Put(ctx, datastore.NewKey("/pk/KEY"), []byte("value")); err != nil {
+		t.Fatal(err)
+	}
+
+	if has, _ := providers.Has(ctx, datastore.NewKey("/CID/PEER")); !has {
+		t.Error("provider record should be in the providers datastore")
+	}
+	if has, _ := main.Has(ctx, provKey); has {
+		t.Error("provider record should not be in the main datastore")
+	}
+	if has, _ := main.Has(ctx, datastore.NewKey("/pk/KEY")); !has {
+		t.Error("other records should be in the main datastore")
+	}
+
+	res, err := ds.Query(ctx, dsq.Query{Prefix: "/providers/CID"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries, err := res.Rest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Key != provKey.String() || string(entries[0].Value) != "record" {
+		t.Errorf("unexpected query results: %v", entries)
+	}
+}
+
+// queryCounter counts the queries made to a datastore.
+type queryCounter struct {
+	datastore.Batching
+	queries int
+}
+
+func (c *queryCounter) Query(ctx context.Context, q dsq.Query) (dsq.Results, error) {
+	c.queries++
+	return c.Batching.Query(ctx, q)
+}
+
+func TestProviderStoreSweeps(t *testing.T) {
+	defer func(d time.Duration) { providerStoreSweepInterval = d }(providerStoreSweepInterval)
+	providerStoreSweepInterval = 0
+
+	ctx := context.Background()
+	ds := &queryCounter{Batching: NewInMemoryDatastore()}
+	s, err := newProviderStore(ds, ProviderStoreOptions{MaxRecords: 1, TTL: 100 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Put(ctx, datastore.NewKey("/a"), []byte("a")); err != nil {
+		t.Fatal(err)
+	}
+	// Nothing expired yet: a full store is not swept.
+	ds.queries = 0
+	for i := 0; i < 10; i++ {
+		if err := s.Put(ctx, datastore.NewKey("/b"), []byte("b")); err != errProviderStoreFull {
+			t.Fatalf("expected the store to be full, got %v", err)
+		}
+	}
+	if ds.queries != 0 {
+		t.Errorf("the store was swept %d times before records expired", ds.queries)
+	}
+
+	time.Sleep(200 * time.Millisecond)
+	if err := s.Put(ctx, datastore.NewKey("/b"), []byte("b")); err != nil {
+		t.Fatal(err)
+	}
+	if ds.queries != 1 {
+		t.Errorf("expected a single sweep once records expired, got %d", ds.queries)
+	}
+}
@@ -0,0 +1,100 @@
+package ipfslite
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// SigningPolicy sets how pubsub messages are signed and verified.
+type SigningPolicy int
+
+// Signing policies.
+const (
+	// SigningStrict signs published messages with the host key, and
+	// drops received messages which are unsigned or whose signature is
+	// invalid, so that the author (Message.GetFrom) of every message is
+	// authenticated. This is the default.
+	SigningStrict SigningPolicy = iota
+	// SigningNone neither signs published messages, nor includes their
+	// author, and drops received messages carrying a signature. It suits
+	// anonymous messaging or messages authenticated by other means.
+	SigningNone
+	// SigningLax signs published messages, and verifies the signature of
+	// received messages when there is one, but accepts unsigned ones.
+	SigningLax
+)
+
+func (s SigningPolicy) option() (pubsub.Option, error) {
+	switch s {
+	case SigningStrict:
+		return pubsub.WithMessageSignaturePolicy(pubsub.StrictSign), nil
+	case SigningNone:
+		return pubsub.WithMessageSignaturePolicy(pubsub.StrictNoSign), nil
+	case SigningLax:
+		return pubsub.WithMessageSignaturePolicy(pubsub.LaxSign), nil
+	default:
+		return nil, fmt.Errorf("unknown signing policy: %d", s)
+	}
+}
+
+// PubSubConfig configures the PubSub instance created by NewPubSub.
+type PubSubConfig struct {
+	// Signing sets how messages are signed and verified.
+	Signing SigningPolicy
+	// Validators are registered for their topics. Messages rejected by
+	// a validator are neither delivered to subscribers nor forwarded to
+	// other peers. More validators can be registered later with
+	// PubSub.RegisterTopicValidator.
+	Validators map[string]pubsub.ValidatorEx
+	// Options are additional options passed to pubsub.NewGossipSub.
+	Options []pubsub.Option
+}
+
+// NewPubSub creates a GossipSub PubSub instance on the Peer's host, for
+// application-layer messaging (i.e. with NewReplicator). With the default
+// configuration, all messages are signed and verified.
+func NewPubSub(ctx context.Context, p *Peer, cfg *PubSubConfig) (*pubsub.PubSub, error) {
+	if p.host == nil {
+		return nil, errors.New("pubsub is not available offline")
+	}
+	if cfg == nil {
+		cfg = &PubSubConfig{}
+	}
+
+	signing, err := cfg.Signing.option()
+	if err != nil {
+		return nil, err
+	}
+	opts := append([]pubsub.Option{signing}, cfg.Options...)
+	ps, err := pubsub.NewGossipSub(ctx, p.host, opts...)
+	if err != nil {
+		return nil, err
+	}
+	for topic, v := range cfg.Validators {
+		err := ps.RegisterTopicValidator(topic, v)
+		if err != nil {
+			return nil, fmt.Errorf("registering validator for %s: %w", topic, err)
+		}
+	}
+	return ps, nil
+}
+
+// AllowedAuthors returns a pubsub validator accepting the messages
+// authored by the given peers only. Authors are only authenticated with
+// SigningStrict.
+func AllowedAuthors(authors ...peer.ID) pubsub.ValidatorEx {
+	allowed := make(map[peer.ID]struct{}, len(authors))
+	for _, a := range authors {
+		allowed[a] = struct{}{}
+	}
+	return func(_ context.Context, _ peer.ID, msg *pubsub.Message) pubsub.ValidationResult {
+		if _, ok := allowed[msg.GetFrom()]; ok {
+			return pubsub.ValidationAccept
+		}
+		return pubsub.ValidationReject
+	}
+}
@@ -0,0 +1,81 @@
+package ipfslite
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+)
+
+func TestPubSubValidators(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	p1, p2, closer := setupPeers(t)
+	defer closer(t)
+
+	ps1, err := NewPubSub(ctx, p1, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ps2, err := NewPubSub(ctx, p2, &PubSubConfig{
+		Validators: map[string]pubsub.ValidatorEx{
+			"allowed": AllowedAuthors(p1.ID()),
+			"denied":  AllowedAuthors(p2.ID()),
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	subs := make(map[string]*pubsub.Subscription)
+	pubs := make(map[string]*pubsub.Topic)
+	for _, name := range []string{"allowed", "denied"} {
+		topic, err := ps2.Join(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		subs[name], err = topic.Subscribe()
+		if err != nil {
+			t.Fatal(err)
+		}
+		pubs[name], err = ps1.Join(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	time.Sleep(time.Second)
+
+	for _, name := range []string{"allowed", "denied"} {
+		if err := pubs[name].Publish(ctx, []byte(name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rctx, rcancel := context.WithTimeout(ctx, 5*time.Second)
+	defer rcancel()
+	msg, err := subs["allowed"].Next(rctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.GetFrom() != p1.ID() || string(msg.Data) != "allowed" {
+		t.Errorf("unexpected message %q from %s", msg.Data, msg.GetFrom())
+	}
+
+	rctx, rcancel = context.WithTimeout(ctx, time.Second)
+	defer rcancel()
+	if msg, err := subs["denied"].Next(rctx); err == nil {
+		t.Errorf("rejected message was delivered: %q", msg.Data)
+	}
+}
+
+func TestSigningPolicy(t *testing.T) {
+	for _, s := range []SigningPolicy{SigningStrict, SigningNone, SigningLax} {
+		if _, err := s.option(); err != nil {
+			t.Error(err)
+		}
+	}
+	if _, err := SigningPolicy(42).option(); err == nil {
+		t.Error("expected an error for an unknown policy")
+	}
+}
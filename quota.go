@@ -0,0 +1,215 @@
+package ipfslite
+
+import (
+	"sync"
+	"time"
+
+	bsmsg "github.com/ipfs/boxo/bitswap/message"
+	"github.com/ipfs/boxo/bitswap/tracer"
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+var (
+	serveQuotaWindow = 24 * time.Hour
+	// serveQuotaPruneInterval sets how often the state of peers which are
+	// not throttled anymore is dropped.
+	serveQuotaPruneInterval = time.Hour
+)
+
+// ThrottleReason tells which quota a throttled peer exceeded.
+type ThrottleReason int
+
+const (
+	// ThrottleDaily means that the peer was served Config.ServeDailyQuota
+	// bytes in the last day.
+	ThrottleDaily ThrottleReason = iota
+	// ThrottleRate means that the peer is requesting blocks faster than
+	// Config.ServeRateLimit.
+	ThrottleRate
+)
+
+func (r ThrottleReason) String() string {
+	switch r {
+	case ThrottleDaily:
+		return "daily quota"
+	case ThrottleRate:
+		return "rate limit"
+	default:
+		return "unknown"
+	}
+}
+
+// ThrottleEvent describes a peer which is not served blocks anymore
+// because it exceeded its quota.
+type ThrottleEvent struct {
+	Peer   peer.ID
+	Reason ThrottleReason
+	// Served is the number of bytes served to the peer in the current
+	// daily window.
+	Served int64
+}
+
+// ThrottleHook is a function called when a peer starts being throttled. It
+// is called once until the peer is served again, and must not block.
+type ThrottleHook func(ThrottleEvent)
+
+type throttleHooks struct {
+	mu    sync.RWMutex
+	hooks []ThrottleHook
+}
+
+func (h *throttleHooks) add(hook ThrottleHook) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.hooks = append(h.hooks, hook)
+}
+
+func (h *throttleHooks) run(ev ThrottleEvent) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for _, hook := range h.hooks {
+		hook(ev)
+	}
+}
+
+// AddThrottleHook registers a hook which is called when a peer exceeds the
+// bitswap serving quotas (see Config.ServeDailyQuota and
+// Config.ServeRateLimit).
+func (p *Peer) AddThrottleHook(hook ThrottleHook) {
+	p.throttle.add(hook)
+}
+
+// serveQuotaState is the usage of a peer.
+type serveQuotaState struct {
+	windowStart time.Time
+	served      int64
+	// tokens is the rate limiting bucket, in bytes. It goes negative
+	// when a large block is served.
+	tokens    float64
+	lastSent  time.Time
+	throttled bool
+}
+
+// serveQuotas limits the bytes served to each peer with bitswap. Served
+// blocks are counted as a bitswap tracer, and the wants of peers over
+// their quota are denied with a bitswap request filter.
+type serveQuotas struct {
+	daily int64
+	rate  int64
+	hooks *throttleHooks
+
+	mu        sync.Mutex
+	peers     map[peer.ID]*serveQuotaState
+	lastPrune time.Time
+}
+
+func newServeQuotas(daily, rate int64, hooks *throttleHooks) *serveQuotas {
+	return &serveQuotas{
+		daily:     daily,
+		rate:      rate,
+		hooks:     hooks,
+		peers:     make(map[peer.ID]*serveQuotaState),
+		lastPrune: time.Now(),
+	}
+}
+
+// state returns the state of the peer, refreshed to the given time. It must
+// be called with the lock held.
+func (q *serveQuotas) state(pid peer.ID, now time.Time) *serveQuotaState {
+	s, ok := q.peers[pid]
+	if !ok {
+		s = &serveQuotaState{windowStart: now, tokens: float64(q.rate), lastSent: now}
+		q.peers[pid] = s
+	}
+	if now.Sub(s.windowStart) >= serveQuotaWindow {
+		s.windowStart = now
+		s.served = 0
+	}
+	if q.rate > 0 {
+		s.tokens += now.Sub(s.lastSent).Seconds() * float64(q.rate)
+		if s.tokens > float64(q.rate) {
+			s.tokens = float64(q.rate)
+		}
+		s.lastSent = now
+	}
+	return s
+}
+
+// allow is the bitswap request filter.
+func (q *serveQuotas) allow(pid peer.ID, _ cid.Cid) bool {
+	now := time.Now()
+	q.mu.Lock()
+	q.prune(now)
+	s := q.state(pid, now)
+	var reason ThrottleReason
+	switch {
+	case q.daily > 0 && s.served >= q.daily:
+		reason = ThrottleDaily
+	case q.rate > 0 && s.tokens <= 0:
+		reason = ThrottleRate
+	default:
+		s.throttled = false
+		q.mu.Unlock()
+		return true
+	}
+	notify := !s.throttled
+	s.throttled = true
+	ev := ThrottleEvent{Peer: pid, Reason: reason, Served: s.served}
+	q.mu.Unlock()
+
+	if notify {
+		logger.Infof("throttling %s: %s exceeded", pid, reason)
+		q.hooks.run(ev)
+	}
+	return false
+}
+
+// prune drops the state of the peers which would not be throttled anymore.
+// It must be called with the lock held.
+func (q *serveQuotas) prune(now time.Time) {
+	if now.Sub(q.lastPrune) < serveQuotaPruneInterval {
+		return
+	}
+	q.lastPrune = now
+	for pid, s := range q.peers {
+		if now.Sub(s.windowStart) >= serveQuotaWindow && now.Sub(s.lastSent) >= time.Second {
+			delete(q.peers, pid)
+		}
+	}
+}
+
+func (q *serveQuotas) MessageReceived(peer.ID, bsmsg.BitSwapMessage) {}
+
+// MessageSent implements the bitswap tracer interface, counting the served
+// blocks.
+func (q *serveQuotas) MessageSent(pid peer.ID, msg bsmsg.BitSwapMessage) {
+	var size int64
+	for _, blk := range msg.Blocks() {
+		size += int64(len(blk.RawData()))
+	}
+	if size == 0 {
+		return
+	}
+	now := time.Now()
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	s := q.state(pid, now)
+	s.served += size
+	s.tokens -= float64(size)
+}
+
+// multiTracer passes bitswap messages to several tracers.
+type multiTracer []tracer.Tracer
+
+func (t multiTracer) MessageReceived(pid peer.ID, msg bsmsg.BitSwapMessage) {
+	for _, tr := range t {
+		tr.MessageReceived(pid, msg)
+	}
+}
+
+func (t multiTracer) MessageSent(pid peer.ID, msg bsmsg.BitSwapMessage) {
+	for _, tr := range t {
+		tr.MessageSent(pid, msg)
+	}
+}
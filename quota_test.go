@@ -0,0 +1,98 @@
+package ipfslite
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"testing"
+	"time"
+
+	bsmsg "github.com/ipfs/boxo/bitswap/message"
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+func TestServeQuotas(t *testing.T) {
+	var events []ThrottleEvent
+	hooks := &throttleHooks{}
+	hooks.add(func(ev ThrottleEvent) {
+		events = append(events, ev)
+	})
+	pid := peer.ID("peer")
+	c := cid.Undef
+
+	q := newServeQuotas(100, 0, hooks)
+	if !q.allow(pid, c) {
+		t.Fatal("the peer should be served")
+	}
+	msg := bsmsg.New(false)
+	msg.AddBlock(blocks.NewBlock(bytes.Repeat([]byte("a"), 150)))
+	q.MessageSent(pid, msg)
+	if q.allow(pid, c) || q.allow(pid, c) {
+		t.Fatal("the peer should be throttled")
+	}
+	if len(events) != 1 || events[0].Reason != ThrottleDaily || events[0].Served != 150 {
+		t.Fatalf("unexpected events: %+v", events)
+	}
+	if !q.allow(peer.ID("other"), c) {
+		t.Error("other peers should be served")
+	}
+
+	q = newServeQuotas(0, 1000, hooks)
+	msg = bsmsg.New(false)
+	msg.AddBlock(blocks.NewBlock(bytes.Repeat([]byte("a"), 1100)))
+	q.MessageSent(pid, msg)
+	if q.allow(pid, c) {
+		t.Fatal("the peer should be rate limited")
+	}
+	if len(events) != 2 || events[1].Reason != ThrottleRate {
+		t.Fatalf("unexpected events: %+v", events)
+	}
+	time.Sleep(200 * time.Millisecond)
+	if !q.allow(pid, c) {
+		t.Error("the peer should be served again")
+	}
+}
+
+func TestServeDailyQuota(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	p1 := setupPeer(t, ctx, &Config{ServeDailyQuota: 1000})
+	p2 := setupPeer(t, ctx, nil)
+	throttled := make(chan ThrottleEvent, 1)
+	p1.AddThrottleHook(func(ev ThrottleEvent) {
+		select {
+		case throttled <- ev:
+		default:
+		}
+	})
+
+	var nodes []cid.Cid
+	for i := 0; i < 2; i++ {
+		content := make([]byte, 2000)
+		rand.Read(content)
+		n, err := p1.AddFile(ctx, bytes.NewReader(content), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		nodes = append(nodes, n.Cid())
+	}
+	providers := []peer.AddrInfo{{ID: p1.host.ID(), Addrs: p1.host.Addrs()}}
+	_, err := p2.Fetch(ctx, nodes[0], WithProviders(providers))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = p2.Fetch(ctx, nodes[1], WithProviders(providers), WithTimeout(time.Second))
+	if err == nil {
+		t.Fatal("the second fetch should fail")
+	}
+	select {
+	case ev := <-throttled:
+		if ev.Peer != p2.host.ID() {
+			t.Errorf("unexpected throttled peer %s", ev.Peer)
+		}
+	default:
+		t.Error("the throttle hook was not called")
+	}
+}
@@ -0,0 +1,171 @@
+package ipfslite
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/ipfs/boxo/provider"
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/routing"
+	"github.com/multiformats/go-multiaddr"
+	"github.com/multiformats/go-multihash"
+)
+
+// tokenBucket is a token bucket rate limiter: tokens are added at rate per
+// second, up to burst, and every operation takes one.
+type tokenBucket struct {
+	rate  float64
+	burst float64
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// newTokenBucket returns a full token bucket. When burst is not positive,
+// it defaults to the rate, and to at least one token.
+func newTokenBucket(rate float64, burst int) *tokenBucket {
+	b := float64(burst)
+	if burst <= 0 {
+		b = math.Max(1, math.Ceil(rate))
+	}
+	return &tokenBucket{rate: rate, burst: b, tokens: b, last: time.Now()}
+}
+
+// reserve takes a token and returns how long to wait before using it.
+func (b *tokenBucket) reserve() time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+	b.tokens--
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// cancel gives back a reserved token which was not used.
+func (b *tokenBucket) cancel() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.tokens = math.Min(b.burst, b.tokens+1)
+}
+
+// wait takes a token, waiting until it can be used or ctx is cancelled.
+func (b *tokenBucket) wait(ctx context.Context) error {
+	d := b.reserve()
+	if d == 0 {
+		return nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		b.cancel()
+		return ctx.Err()
+	}
+}
+
+// rateLimitedDialRanker delays the dials planned by ranker so that peers
+// are dialed at the rate allowed by the bucket.
+func rateLimitedDialRanker(ranker network.DialRanker, b *tokenBucket) network.DialRanker {
+	return func(addrs []multiaddr.Multiaddr) []network.AddrDelay {
+		ranked := ranker(addrs)
+		if len(ranked) == 0 {
+			return ranked
+		}
+		wait := b.reserve()
+		for i := range ranked {
+			ranked[i].Delay += wait
+		}
+		return ranked
+	}
+}
+
+// rateLimitedRouting makes the queries of a routing.Routing wait for a
+// token of the bucket.
+type rateLimitedRouting struct {
+	routing.Routing
+	bucket *tokenBucket
+}
+
+// rateLimitedManyRouting is a rateLimitedRouting for routers which provide
+// many CIDs at once.
+type rateLimitedManyRouting struct {
+	*rateLimitedRouting
+}
+
+func newRateLimitedRouting(r routing.Routing, bucket *tokenBucket) routing.Routing {
+	rr := &rateLimitedRouting{Routing: r, bucket: bucket}
+	if _, ok := r.(provider.ProvideMany); ok {
+		return &rateLimitedManyRouting{rr}
+	}
+	return rr
+}
+
+func (r *rateLimitedRouting) Provide(ctx context.Context, c cid.Cid, announce bool) error {
+	if err := r.bucket.wait(ctx); err != nil {
+		return err
+	}
+	return r.Routing.Provide(ctx, c, announce)
+}
+
+func (r *rateLimitedRouting) FindProvidersAsync(ctx context.Context, c cid.Cid, count int) <-chan peer.AddrInfo {
+	if err := r.bucket.wait(ctx); err != nil {
+		out := make(chan peer.AddrInfo)
+		close(out)
+		return out
+	}
+	return r.Routing.FindProvidersAsync(ctx, c, count)
+}
+
+func (r *rateLimitedRouting) FindPeer(ctx context.Context, id peer.ID) (peer.AddrInfo, error) {
+	if err := r.bucket.wait(ctx); err != nil {
+		return peer.AddrInfo{}, err
+	}
+	return r.Routing.FindPeer(ctx, id)
+}
+
+func (r *rateLimitedRouting) PutValue(ctx context.Context, key string, value []byte, opts ...routing.Option) error {
+	if err := r.bucket.wait(ctx); err != nil {
+		return err
+	}
+	return r.Routing.PutValue(ctx, key, value, opts...)
+}
+
+func (r *rateLimitedRouting) GetValue(ctx context.Context, key string, opts ...routing.Option) ([]byte, error) {
+	if err := r.bucket.wait(ctx); err != nil {
+		return nil, err
+	}
+	return r.Routing.GetValue(ctx, key, opts...)
+}
+
+func (r *rateLimitedRouting) SearchValue(ctx context.Context, key string, opts ...routing.Option) (<-chan []byte, error) {
+	if err := r.bucket.wait(ctx); err != nil {
+		return nil, err
+	}
+	return r.Routing.SearchValue(ctx, key, opts...)
+}
+
+func (r *rateLimitedManyRouting) ProvideMany(ctx context.Context, keys []multihash.Multihash) error {
+	if err := r.bucket.wait(ctx); err != nil {
+		return err
+	}
+	return r.Routing.(provider.ProvideMany).ProvideMany(ctx, keys)
+}
+
+// Ready tells whether the underlying router is ready, when it tells.
+func (r *rateLimitedManyRouting) Ready() bool {
+	if ready, ok := r.Routing.(provider.Ready); ok {
+		return ready.Ready()
+	}
+	return true
+}
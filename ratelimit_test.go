@@ -0,0 +1,85 @@
+package ipfslite
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/routing"
+	"github.com/libp2p/go-libp2p/p2p/net/swarm"
+	"github.com/multiformats/go-multiaddr"
+)
+
+func TestTokenBucket(t *testing.T) {
+	b := newTokenBucket(10, 2)
+	if b.reserve() != 0 || b.reserve() != 0 {
+		t.Fatal("the burst should be available at once")
+	}
+	if d := b.reserve(); d <= 0 || d > 100*time.Millisecond {
+		t.Errorf("unexpected wait: %s", d)
+	}
+	b.cancel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := b.wait(ctx); err == nil {
+		t.Error("waiting should fail with a cancelled context")
+	}
+	start := time.Now()
+	if err := b.wait(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if time.Since(start) < 50*time.Millisecond {
+		t.Error("the wait should last until a token is available")
+	}
+}
+
+func TestRateLimitedDialRanker(t *testing.T) {
+	addrs := []multiaddr.Multiaddr{multiaddr.StringCast("/ip4/1.2.3.4/tcp/4001")}
+	ranker := rateLimitedDialRanker(swarm.NoDelayDialRanker, newTokenBucket(1, 1))
+	if res := ranker(addrs); res[0].Delay != 0 {
+		t.Errorf("the first dial should not be delayed: %s", res[0].Delay)
+	}
+	if res := ranker(addrs); res[0].Delay < 900*time.Millisecond {
+		t.Errorf("the second dial should be delayed: %s", res[0].Delay)
+	}
+
+	cfg := &Config{DialsPerSecond: -1}
+	if _, err := cfg.Libp2pOptions(); err == nil {
+		t.Error("expected an error for a negative rate")
+	}
+}
+
+// countingRouter counts the FindPeer queries.
+type countingRouter struct {
+	routing.Routing
+	queries int
+}
+
+func (r *countingRouter) FindPeer(ctx context.Context, id peer.ID) (peer.AddrInfo, error) {
+	r.queries++
+	return peer.AddrInfo{ID: id}, nil
+}
+
+func TestDHTQueriesPerSecond(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	router := &countingRouter{}
+	p, err := New(ctx, NewInMemoryDatastore(), nil, nil, router, &Config{Offline: true, DHTQueriesPerSecond: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := p.dht.FindPeer(ctx, "peer"); err != nil {
+		t.Fatal(err)
+	}
+	qctx, qcancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer qcancel()
+	if _, err := p.dht.FindPeer(qctx, "peer"); err == nil {
+		t.Error("the second query should wait beyond its deadline")
+	}
+	if router.queries != 1 {
+		t.Errorf("unexpected number of queries: %d", router.queries)
+	}
+}
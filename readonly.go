@@ -0,0 +1,35 @@
+package ipfslite
+
+import (
+	"context"
+	"errors"
+
+	blockstore "github.com/ipfs/boxo/blockstore"
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+)
+
+// ErrReadOnly is returned by the APIs which modify a read-only Peer (see
+// Config.ReadOnly).
+var ErrReadOnly = errors.New("peer is read-only")
+
+// readOnlyBlockstore rejects all the writes to a blockstore.
+type readOnlyBlockstore struct {
+	blockstore.Blockstore
+}
+
+func (bs *readOnlyBlockstore) View(ctx context.Context, c cid.Cid, f func([]byte) error) error {
+	return viewBlock(ctx, bs.Blockstore, c, f)
+}
+
+func (bs *readOnlyBlockstore) Put(context.Context, blocks.Block) error {
+	return ErrReadOnly
+}
+
+func (bs *readOnlyBlockstore) PutMany(context.Context, []blocks.Block) error {
+	return ErrReadOnly
+}
+
+func (bs *readOnlyBlockstore) DeleteBlock(context.Context, cid.Cid) error {
+	return ErrReadOnly
+}
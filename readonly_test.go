@@ -0,0 +1,81 @@
+package ipfslite
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	blocks "github.com/ipfs/go-block-format"
+	ipld "github.com/ipfs/go-ipld-format"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+func TestReadOnly(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Prepare some content, then serve it read-only.
+	ds := NewInMemoryDatastore()
+	writer, err := New(ctx, ds, nil, nil, nil, &Config{Offline: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	content := []byte("canned content")
+	n, err := writer.AddFile(ctx, bytes.NewReader(content), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ro, err := New(ctx, ds, nil, nil, nil, &Config{Offline: true, ReadOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ro.AddFile(ctx, bytes.NewReader(content), nil); err != ErrReadOnly {
+		t.Errorf("AddFile: expected ErrReadOnly, got %v", err)
+	}
+	if err := ro.Pin(ctx, n.Cid(), true); err != ErrReadOnly {
+		t.Errorf("Pin: expected ErrReadOnly, got %v", err)
+	}
+	if err := ro.BlockStore().Put(ctx, blocks.NewBlock([]byte("new"))); err != ErrReadOnly {
+		t.Errorf("Put: expected ErrReadOnly, got %v", err)
+	}
+	if err := ro.Remove(ctx, n.Cid()); err != ErrReadOnly {
+		t.Errorf("Remove: expected ErrReadOnly, got %v", err)
+	}
+	if has, _ := ro.HasBlock(ctx, n.Cid()); !has {
+		t.Error("content should not have been removed")
+	}
+
+	// Content is served to other peers.
+	server := setupPeerWithDatastore(t, ctx, ds, &Config{ReadOnly: true})
+	client := setupPeer(t, ctx, nil)
+	err = client.host.Connect(ctx, peer.AddrInfo{ID: server.host.ID(), Addrs: server.host.Addrs()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rc, err := client.GetFile(ctx, n.Cid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := io.ReadAll(rc)
+	rc.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, content) {
+		t.Error("unexpected content")
+	}
+
+	// But nothing is fetched.
+	blk := blocks.NewBlock([]byte("only the client has this"))
+	if err := client.BlockStore().Put(ctx, blk); err != nil {
+		t.Fatal(err)
+	}
+	fetchCtx, fetchCancel := context.WithTimeout(ctx, 5*time.Second)
+	defer fetchCancel()
+	if _, err := server.GetBlock(fetchCtx, blk.Cid()); !ipld.IsNotFound(err) {
+		t.Errorf("a read-only peer should not fetch blocks, got %v", err)
+	}
+}
@@ -0,0 +1,360 @@
+package ipfslite
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/peerstore"
+)
+
+var (
+	defaultReconnectMinBackoff = time.Second
+	defaultReconnectMaxBackoff = 5 * time.Minute
+	// reconnectDialTimeout bounds each reconnection attempt, including
+	// the search of the peer addresses in the DHT.
+	reconnectDialTimeout = time.Minute
+)
+
+// reconnectProtectTag protects the connections to the watched peers from
+// the connection manager.
+const reconnectProtectTag = "ipfs-lite-reconnect"
+
+// ErrReconnectDisabled is returned by Peer.KeepConnected when
+// Config.Reconnect is not set, or when the Peer is offline.
+var ErrReconnectDisabled = errors.New("reconnection is not enabled")
+
+// ReconnectConfig enables the reconnection manager, which watches the
+// connections to important peers and redials them, with a jittered
+// exponential backoff, when they are lost. The state of the connections is
+// reported to the hooks registered with Peer.AddReconnectHook.
+type ReconnectConfig struct {
+	// Peers lists the peers to stay connected to (i.e. a peering list).
+	// More can be added with Peer.KeepConnected.
+	Peers []peer.AddrInfo
+	// Bootstrap keeps the peers given to Peer.Bootstrap connected too.
+	Bootstrap bool
+	// Partners keeps the providers connected by a fetch (see
+	// connectProviders) connected until the fetch ends.
+	Partners bool
+	// MinBackoff is the delay before the first reconnection attempt. It
+	// doubles after each failed attempt, up to MaxBackoff. Actual delays
+	// vary randomly between half and one and a half times the backoff,
+	// so that peers which lost a common connection do not all redial at
+	// once. They default to 1 second and 5 minutes.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+}
+
+// ReconnectState is the state of the connection to a watched peer.
+type ReconnectState int
+
+const (
+	// ReconnectDisconnected reports that the peer is not connected,
+	// i.e. its last connection was closed: reconnection attempts start.
+	ReconnectDisconnected ReconnectState = iota
+	// ReconnectFailed reports a failed reconnection attempt. The next one
+	// happens after ReconnectEvent.Backoff.
+	ReconnectFailed
+	// ReconnectRestored reports that the peer is connected again.
+	ReconnectRestored
+)
+
+func (s ReconnectState) String() string {
+	switch s {
+	case ReconnectDisconnected:
+		return "disconnected"
+	case ReconnectFailed:
+		return "failed"
+	case ReconnectRestored:
+		return "restored"
+	default:
+		return "unknown"
+	}
+}
+
+// ReconnectEvent reports a change in the connection to a peer watched by
+// the reconnection manager.
+type ReconnectEvent struct {
+	Peer  peer.ID
+	State ReconnectState
+	// Attempt is the number of reconnection attempts made so far.
+	Attempt int
+	// Err is the error of the failed attempt, and Backoff the delay
+	// before the next one, with ReconnectFailed.
+	Err     error
+	Backoff time.Duration
+	Time    time.Time
+}
+
+// ReconnectHook is a function called with the reconnection events of the
+// Peer. Events of different peers may be reported concurrently, so hooks
+// must be safe for concurrent use, and should return quickly.
+type ReconnectHook func(ReconnectEvent)
+
+type reconnectHooks struct {
+	mu    sync.RWMutex
+	hooks []ReconnectHook
+}
+
+func (h *reconnectHooks) add(hook ReconnectHook) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.hooks = append(h.hooks, hook)
+}
+
+func (h *reconnectHooks) run(e ReconnectEvent) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for _, hook := range h.hooks {
+		hook(e)
+	}
+}
+
+// AddReconnectHook registers a hook which is called when the connection to
+// a peer watched by the reconnection manager (see Config.Reconnect) is
+// lost, restored, or when an attempt to restore it fails.
+func (p *Peer) AddReconnectHook(hook ReconnectHook) {
+	p.reconnects.add(hook)
+}
+
+// watchedPeer is a peer watched by the reconnection manager.
+type watchedPeer struct {
+	info peer.AddrInfo
+	// kept is set for the peers kept connected until told otherwise,
+	// partners counts the running fetches the peer is a provider of.
+	kept     bool
+	partners int
+	// reconnecting is set while a reconnection loop runs for the peer.
+	reconnecting bool
+}
+
+// reconnectManager redials the watched peers when they get disconnected.
+type reconnectManager struct {
+	p        *Peer
+	cfg      *ReconnectConfig
+	notifiee network.Notifiee
+
+	mu    sync.Mutex
+	peers map[peer.ID]*watchedPeer
+}
+
+func (p *Peer) setupReconnect() {
+	cfg := p.cfg.Reconnect
+	if cfg.MinBackoff <= 0 {
+		cfg.MinBackoff = defaultReconnectMinBackoff
+	}
+	if cfg.MaxBackoff < cfg.MinBackoff {
+		cfg.MaxBackoff = defaultReconnectMaxBackoff
+		if cfg.MaxBackoff < cfg.MinBackoff {
+			cfg.MaxBackoff = cfg.MinBackoff
+		}
+	}
+	m := &reconnectManager{
+		p:     p,
+		cfg:   cfg,
+		peers: make(map[peer.ID]*watchedPeer),
+	}
+	m.notifiee = &network.NotifyBundle{
+		DisconnectedF: func(_ network.Network, c network.Conn) {
+			go m.check(c.RemotePeer())
+		},
+	}
+	p.host.Network().Notify(m.notifiee)
+	p.reconnect = m
+	for _, pi := range cfg.Peers {
+		m.watch(pi, true)
+	}
+}
+
+// watch starts watching the given peer, permanently when kept is set, or
+// as a fetch partner otherwise. The peer is dialed if not connected.
+func (m *reconnectManager) watch(pi peer.AddrInfo, kept bool) {
+	if pi.ID == m.p.host.ID() {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	w, ok := m.peers[pi.ID]
+	if !ok {
+		w = &watchedPeer{info: peer.AddrInfo{ID: pi.ID}}
+		m.peers[pi.ID] = w
+		m.p.host.ConnManager().Protect(pi.ID, reconnectProtectTag)
+	}
+	if len(pi.Addrs) > 0 {
+		w.info.Addrs = pi.Addrs
+	}
+	if kept {
+		w.kept = true
+		m.p.host.Peerstore().AddAddrs(pi.ID, pi.Addrs, peerstore.PermanentAddrTTL)
+	} else {
+		w.partners++
+	}
+	m.start(w)
+}
+
+// unwatch stops watching the given peer as a kept peer or as a fetch
+// partner. It is forgotten once neither.
+func (m *reconnectManager) unwatch(pid peer.ID, kept bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	w, ok := m.peers[pid]
+	if !ok {
+		return
+	}
+	if kept {
+		w.kept = false
+	} else if w.partners > 0 {
+		w.partners--
+	}
+	if !w.kept && w.partners == 0 {
+		delete(m.peers, pid)
+		m.p.host.ConnManager().Unprotect(pid, reconnectProtectTag)
+	}
+}
+
+// watchWhile watches the given fetch partner until ctx is done.
+func (m *reconnectManager) watchWhile(ctx context.Context, pi peer.AddrInfo) {
+	m.watch(pi, false)
+	go func() {
+		<-ctx.Done()
+		m.unwatch(pi.ID, false)
+	}()
+}
+
+// check starts reconnecting to the given peer if it is watched and no
+// longer connected.
+func (m *reconnectManager) check(pid peer.ID) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if w, ok := m.peers[pid]; ok {
+		m.start(w)
+	}
+}
+
+// start runs a reconnection loop for the given peer unless it is
+// connected or one already runs. It must be called with the lock held.
+func (m *reconnectManager) start(w *watchedPeer) {
+	if w.reconnecting || m.p.ctx.Err() != nil {
+		return
+	}
+	if m.p.host.Network().Connectedness(w.info.ID) == network.Connected {
+		return
+	}
+	w.reconnecting = true
+	go m.reconnect(w.info.ID)
+}
+
+// watched returns the addresses of the given peer, and whether it is
+// still watched.
+func (m *reconnectManager) watched(pid peer.ID) (peer.AddrInfo, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	w, ok := m.peers[pid]
+	if !ok {
+		return peer.AddrInfo{}, false
+	}
+	return w.info, true
+}
+
+// finish marks the reconnection loop of the given peer as done, and starts
+// another one if the peer was disconnected again meanwhile.
+func (m *reconnectManager) finish(pid peer.ID) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if w, ok := m.peers[pid]; ok {
+		w.reconnecting = false
+		m.start(w)
+	}
+}
+
+// reconnect redials the given peer until it is connected, or no longer
+// watched.
+func (m *reconnectManager) reconnect(pid peer.ID) {
+	defer m.finish(pid)
+	m.p.reconnects.run(ReconnectEvent{
+		Peer:  pid,
+		State: ReconnectDisconnected,
+		Time:  time.Now(),
+	})
+
+	backoff := m.cfg.MinBackoff
+	delay := jitter(backoff)
+	for attempt := 1; ; attempt++ {
+		timer := time.NewTimer(delay)
+		select {
+		case <-m.p.ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		pi, ok := m.watched(pid)
+		if !ok {
+			return
+		}
+		var err error
+		if m.p.host.Network().Connectedness(pid) != network.Connected {
+			ctx, cancel := context.WithTimeout(m.p.ctx, reconnectDialTimeout)
+			err = m.p.host.Connect(ctx, pi)
+			cancel()
+		}
+		if err == nil {
+			m.p.reconnects.run(ReconnectEvent{
+				Peer:    pid,
+				State:   ReconnectRestored,
+				Attempt: attempt,
+				Time:    time.Now(),
+			})
+			return
+		}
+		if m.p.ctx.Err() != nil {
+			return
+		}
+
+		backoff *= 2
+		if backoff > m.cfg.MaxBackoff {
+			backoff = m.cfg.MaxBackoff
+		}
+		delay = jitter(backoff)
+		logger.Debugf("error reconnecting to %s (attempt %d, next in %s): %s", pid, attempt, delay, err)
+		m.p.reconnects.run(ReconnectEvent{
+			Peer:    pid,
+			State:   ReconnectFailed,
+			Attempt: attempt,
+			Err:     err,
+			Backoff: delay,
+			Time:    time.Now(),
+		})
+	}
+}
+
+// jitter returns a random delay between half and one and a half times d.
+func jitter(d time.Duration) time.Duration {
+	return d/2 + time.Duration(rand.Int63n(int64(d)))
+}
+
+// KeepConnected adds a peer to the peers watched by the reconnection
+// manager (see Config.Reconnect), dialing it if needed. It returns
+// ErrReconnectDisabled when the manager is not enabled.
+func (p *Peer) KeepConnected(pi peer.AddrInfo) error {
+	if p.reconnect == nil {
+		return ErrReconnectDisabled
+	}
+	p.reconnect.watch(pi, true)
+	return nil
+}
+
+// StopKeepingConnected removes a peer added with KeepConnected or
+// Config.Reconnect from the watched peers. The peer is not disconnected,
+// but is no longer redialed (unless it is the provider of a running fetch,
+// with ReconnectConfig.Partners).
+func (p *Peer) StopKeepingConnected(pid peer.ID) {
+	if p.reconnect == nil {
+		return
+	}
+	p.reconnect.unwatch(pid, true)
+}
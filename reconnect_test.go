@@ -0,0 +1,103 @@
+package ipfslite
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multiaddr"
+)
+
+// waitReconnectEvent returns the next event of the given peer with the
+// given state.
+func waitReconnectEvent(t *testing.T, events <-chan ReconnectEvent, pid peer.ID, state ReconnectState) ReconnectEvent {
+	t.Helper()
+	timeout := time.After(10 * time.Second)
+	for {
+		select {
+		case e := <-events:
+			if e.Peer == pid && e.State == state {
+				return e
+			}
+		case <-timeout:
+			t.Fatalf("no %s event for %s", state, pid)
+		}
+	}
+}
+
+func TestReconnect(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	p1 := setupPeer(t, ctx, nil)
+
+	if err := p1.KeepConnected(peer.AddrInfo{ID: p1.host.ID()}); !errors.Is(err, ErrReconnectDisabled) {
+		t.Errorf("unexpected error without Config.Reconnect: %v", err)
+	}
+
+	p2 := setupPeer(t, ctx, &Config{
+		Reconnect: &ReconnectConfig{
+			Peers:      []peer.AddrInfo{{ID: p1.host.ID(), Addrs: p1.host.Addrs()}},
+			MinBackoff: 50 * time.Millisecond,
+		},
+	})
+	events := make(chan ReconnectEvent, 16)
+	p2.AddReconnectHook(func(e ReconnectEvent) {
+		events <- e
+	})
+
+	// The configured peer is dialed, and redialed when disconnected.
+	deadline := time.Now().Add(10 * time.Second)
+	for p2.host.Network().Connectedness(p1.host.ID()) != network.Connected {
+		if time.Now().After(deadline) {
+			t.Fatal("the configured peer was not dialed")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	for len(events) > 0 {
+		<-events
+	}
+	if err := p2.host.Network().ClosePeer(p1.host.ID()); err != nil {
+		t.Fatal(err)
+	}
+	waitReconnectEvent(t, events, p1.host.ID(), ReconnectDisconnected)
+	e := waitReconnectEvent(t, events, p1.host.ID(), ReconnectRestored)
+	if e.Attempt != 1 {
+		t.Errorf("unexpected attempts: %d", e.Attempt)
+	}
+	if p2.host.Network().Connectedness(p1.host.ID()) != network.Connected {
+		t.Error("the peer should be connected again")
+	}
+
+	// Unreachable peers are retried with a growing backoff.
+	priv, _, err := crypto.GenerateEd25519Key(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unreachable, err := peer.IDFromPrivateKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = p2.KeepConnected(peer.AddrInfo{
+		ID:    unreachable,
+		Addrs: []multiaddr.Multiaddr{multiaddr.StringCast("/ip4/127.0.0.1/tcp/1")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := waitReconnectEvent(t, events, unreachable, ReconnectFailed)
+	second := waitReconnectEvent(t, events, unreachable, ReconnectFailed)
+	if first.Err == nil || second.Attempt != first.Attempt+1 {
+		t.Errorf("unexpected failures: %+v %+v", first, second)
+	}
+	if second.Backoff < 100*time.Millisecond || second.Backoff >= 300*time.Millisecond {
+		t.Errorf("unexpected backoff: %s", second.Backoff)
+	}
+	p2.StopKeepingConnected(unreachable)
+	if _, ok := p2.reconnect.watched(unreachable); ok {
+		t.Error("the peer should no longer be watched")
+	}
+}
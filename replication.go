@@ -0,0 +1,319 @@
+package ipfslite
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"hash/fnv"
+	"sync"
+	"time"
+
+	pin "github.com/ipfs/boxo/pinning/pinner"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+var (
+	defaultReplicationTopic            = "/ipfs-lite/pins/1.0.0"
+	defaultReplicationAnnounceInterval = 10 * time.Minute
+	defaultReplicationWorkers          = 4
+	defaultReplicationTombstoneTTL     = 7 * 24 * time.Hour
+
+	// replicationTombstonePrefix holds the CIDs unpinned through the
+	// Replicator, with the time of the unpin.
+	replicationTombstonePrefix = datastore.NewKey("/replication/unpinned")
+)
+
+// ReplicationConfig configures a Replicator.
+type ReplicationConfig struct {
+	// Topic is the pubsub topic on which pin announcements are sent and
+	// received.
+	Topic string
+	// Mirror is the list of peers whose pinset is mirrored by this
+	// node. Announcements from any other peer are ignored.
+	Mirror []peer.ID
+	// AnnounceInterval sets how often the full local pinset, and the
+	// recent unpins, are re-announced, so that mirrors joining later or
+	// missing announcements catch up. A negative value disables periodic
+	// announcements.
+	AnnounceInterval time.Duration
+	// TombstoneTTL is how long unpins are remembered and re-announced.
+	// Mirrors offline for longer may keep the unpinned content. Defaults
+	// to 7 days.
+	TombstoneTTL time.Duration
+	// Workers is the number of pins that can be processed concurrently.
+	Workers int
+}
+
+func (cfg *ReplicationConfig) setDefaults() {
+	if cfg.Topic == "" {
+		cfg.Topic = defaultReplicationTopic
+	}
+	if cfg.AnnounceInterval == 0 {
+		cfg.AnnounceInterval = defaultReplicationAnnounceInterval
+	}
+	if cfg.Workers <= 0 {
+		cfg.Workers = defaultReplicationWorkers
+	}
+	if cfg.TombstoneTTL <= 0 {
+		cfg.TombstoneTTL = defaultReplicationTombstoneTTL
+	}
+}
+
+type pinOp string
+
+const (
+	pinOpPin   pinOp = "pin"
+	pinOpUnpin pinOp = "unpin"
+)
+
+type pinAnnouncement struct {
+	Op  pinOp   `json:"op"`
+	Cid cid.Cid `json:"cid"`
+}
+
+// Replicator mirrors the pinset of designated peers. Pins and unpins made
+// through the Replicator are announced over pubsub, and announcements from
+// mirrored peers are applied locally, so that a small set of peers can keep
+// several copies of the same content.
+type Replicator struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	cfg    *ReplicationConfig
+	peer   *Peer
+	topic  *pubsub.Topic
+	sub    *pubsub.Subscription
+	mirror map[peer.ID]struct{}
+
+	// ops has a queue per worker. The announcements of a CID always go
+	// to the same worker, so that they are applied in order.
+	ops []chan pinAnnouncement
+	wg  sync.WaitGroup
+}
+
+// NewReplicator creates a Replicator for the given Peer using the given
+// PubSub instance. It joins the configured topic and starts applying
+// announcements from mirrored peers right away.
+func NewReplicator(ctx context.Context, p *Peer, ps *pubsub.PubSub, cfg *ReplicationConfig) (*Replicator, error) {
+	if ps == nil {
+		return nil, errors.New("pubsub is required for replication")
+	}
+	if cfg == nil {
+		cfg = &ReplicationConfig{}
+	}
+	cfg.setDefaults()
+
+	topic, err := ps.Join(cfg.Topic)
+	if err != nil {
+		return nil, err
+	}
+	sub, err := topic.Subscribe()
+	if err != nil {
+		topic.Close()
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	r := &Replicator{
+		ctx:    ctx,
+		cancel: cancel,
+		cfg:    cfg,
+		peer:   p,
+		topic:  topic,
+		sub:    sub,
+		mirror: make(map[peer.ID]struct{}),
+		ops:    make([]chan pinAnnouncement, cfg.Workers),
+	}
+	for _, pid := range cfg.Mirror {
+		r.mirror[pid] = struct{}{}
+	}
+
+	for i := range r.ops {
+		r.ops[i] = make(chan pinAnnouncement, 1)
+		r.wg.Add(1)
+		go r.worker(r.ops[i])
+	}
+	r.wg.Add(1)
+	go r.readLoop()
+	if cfg.AnnounceInterval > 0 {
+		r.wg.Add(1)
+		go r.announceLoop()
+	}
+	return r, nil
+}
+
+// Pin pins the given CID recursively and announces it to mirrors.
+func (r *Replicator) Pin(ctx context.Context, c cid.Cid) error {
+	err := r.peer.Pin(ctx, c, true)
+	if err != nil {
+		return err
+	}
+	err = r.peer.datastore(MetaNamespace).Delete(ctx, tombstoneKey(c))
+	if err != nil {
+		return err
+	}
+	return r.publish(ctx, pinAnnouncement{Op: pinOpPin, Cid: c})
+}
+
+// Unpin removes the recursive pin for the given CID and announces it to
+// mirrors. The unpin is re-announced with the pinset for
+// ReplicationConfig.TombstoneTTL.
+func (r *Replicator) Unpin(ctx context.Context, c cid.Cid) error {
+	err := r.peer.Unpin(ctx, c, true)
+	if err != nil {
+		return err
+	}
+	buf := make([]byte, binary.MaxVarintLen64)
+	err = r.peer.datastore(MetaNamespace).Put(ctx, tombstoneKey(c), buf[:binary.PutVarint(buf, time.Now().UnixNano())])
+	if err != nil {
+		return err
+	}
+	return r.publish(ctx, pinAnnouncement{Op: pinOpUnpin, Cid: c})
+}
+
+// Close stops the Replicator and leaves the topic.
+func (r *Replicator) Close() error {
+	r.cancel()
+	r.sub.Cancel()
+	r.wg.Wait()
+	return r.topic.Close()
+}
+
+func (r *Replicator) publish(ctx context.Context, ann pinAnnouncement) error {
+	data, err := json.Marshal(ann)
+	if err != nil {
+		return err
+	}
+	return r.topic.Publish(ctx, data)
+}
+
+func (r *Replicator) readLoop() {
+	defer r.wg.Done()
+	self := r.peer.host.ID()
+	for {
+		msg, err := r.sub.Next(r.ctx)
+		if err != nil {
+			return
+		}
+		from := msg.GetFrom()
+		if from == self {
+			continue
+		}
+		if _, ok := r.mirror[from]; !ok {
+			continue
+		}
+		var ann pinAnnouncement
+		if err := json.Unmarshal(msg.Data, &ann); err != nil {
+			logger.Warnf("bad pin announcement from %s: %s", from, err)
+			continue
+		}
+		h := fnv.New32a()
+		h.Write(ann.Cid.Bytes())
+		select {
+		case r.ops[h.Sum32()%uint32(len(r.ops))] <- ann:
+		case <-r.ctx.Done():
+			return
+		}
+	}
+}
+
+func (r *Replicator) worker(ops <-chan pinAnnouncement) {
+	defer r.wg.Done()
+	for {
+		select {
+		case <-r.ctx.Done():
+			return
+		case ann := <-ops:
+			var err error
+			switch ann.Op {
+			case pinOpPin:
+				err = r.peer.Pin(r.ctx, ann.Cid, true)
+			case pinOpUnpin:
+				err = r.peer.Unpin(r.ctx, ann.Cid, true)
+				if errors.Is(err, pin.ErrNotPinned) {
+					err = nil
+				}
+			default:
+				logger.Warnf("unknown pin operation: %s", ann.Op)
+				continue
+			}
+			if err != nil {
+				logger.Errorf("replicating %s %s: %s", ann.Op, ann.Cid, err)
+			}
+		}
+	}
+}
+
+func (r *Replicator) announceLoop() {
+	defer r.wg.Done()
+	ticker := time.NewTicker(r.cfg.AnnounceInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.ctx.Done():
+			return
+		case <-ticker.C:
+			r.announce()
+		}
+	}
+}
+
+// announce publishes the full local pinset, then the unpins made in the
+// last TombstoneTTL, so that mirrors which missed an unpin drop it too.
+// Older tombstones are removed.
+func (r *Replicator) announce() {
+	for sc := range r.peer.pinner.RecursiveKeys(r.ctx) {
+		if sc.Err != nil {
+			logger.Error(sc.Err)
+			break
+		}
+		err := r.publish(r.ctx, pinAnnouncement{Op: pinOpPin, Cid: sc.C})
+		if err != nil {
+			logger.Warn(err)
+		}
+	}
+
+	ds := r.peer.datastore(MetaNamespace)
+	res, err := ds.Query(r.ctx, query.Query{Prefix: replicationTombstonePrefix.String()})
+	if err != nil {
+		logger.Error(err)
+		return
+	}
+	var expired []datastore.Key
+	for e := range res.Next() {
+		if e.Error != nil {
+			logger.Error(e.Error)
+			break
+		}
+		key := datastore.RawKey(e.Key)
+		c, err := cid.Decode(key.BaseNamespace())
+		if err != nil {
+			logger.Warnf("bad replication tombstone %s: %s", e.Key, err)
+			continue
+		}
+		unpinned, n := binary.Varint(e.Value)
+		if n <= 0 || time.Since(time.Unix(0, unpinned)) > r.cfg.TombstoneTTL {
+			expired = append(expired, key)
+			continue
+		}
+		err = r.publish(r.ctx, pinAnnouncement{Op: pinOpUnpin, Cid: c})
+		if err != nil {
+			logger.Warn(err)
+		}
+	}
+	res.Close()
+	for _, key := range expired {
+		if err := ds.Delete(r.ctx, key); err != nil {
+			logger.Warn(err)
+		}
+	}
+}
+
+func tombstoneKey(c cid.Cid) datastore.Key {
+	return replicationTombstonePrefix.ChildString(c.String())
+}
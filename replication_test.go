@@ -0,0 +1,192 @@
+package ipfslite
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	cbor "github.com/ipfs/go-ipld-cbor"
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	"github.com/libp2p/go-libp2p/core/peer"
+	multihash "github.com/multiformats/go-multihash"
+)
+
+func TestReplicator(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	p1, p2, closer := setupPeers(t)
+	defer closer(t)
+
+	ps1, err := pubsub.NewGossipSub(ctx, p1.host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ps2, err := pubsub.NewGossipSub(ctx, p2.host)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r1, err := NewReplicator(ctx, p1, ps1, &ReplicationConfig{
+		AnnounceInterval: 200 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r1.Close()
+
+	r2, err := NewReplicator(ctx, p2, ps2, &ReplicationConfig{
+		Mirror: []peer.ID{p1.host.ID()},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	codec := uint64(multihash.SHA2_256)
+	node, err := cbor.WrapObject(map[string]string{"akey": "avalue"}, codec, multihash.DefaultLengths[codec])
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = p1.Add(ctx, node)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = r1.Pin(ctx, node.Cid())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		pinned, err := p2.IsPinned(ctx, node.Cid())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if pinned {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("pin was not replicated")
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	// A mirror which misses the unpin catches up with the re-announced
+	// tombstone.
+	if err := r2.Close(); err != nil {
+		t.Fatal(err)
+	}
+	err = r1.Unpin(ctx, node.Cid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	r2, err = NewReplicator(ctx, p2, ps2, &ReplicationConfig{
+		Mirror: []peer.ID{p1.host.ID()},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r2.Close()
+
+	deadline = time.Now().Add(10 * time.Second)
+	for {
+		pinned, err := p2.IsPinned(ctx, node.Cid())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !pinned {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("unpin was not replicated")
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+func TestReplicatorOrdering(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	p1, p2, closer := setupPeers(t)
+	defer closer(t)
+
+	ps1, err := pubsub.NewGossipSub(ctx, p1.host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ps2, err := pubsub.NewGossipSub(ctx, p2.host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// No periodic announcements: the mirror only sees the pin and the
+	// unpin once.
+	r1, err := NewReplicator(ctx, p1, ps1, &ReplicationConfig{AnnounceInterval: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r1.Close()
+	r2, err := NewReplicator(ctx, p2, ps2, &ReplicationConfig{
+		Mirror:  []peer.ID{p1.host.ID()},
+		Workers: 4,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r2.Close()
+
+	waitPinned := func(c cid.Cid) {
+		deadline := time.Now().Add(10 * time.Second)
+		for {
+			if pinned, _ := p2.IsPinned(ctx, c); pinned {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Fatal("pin was not replicated")
+			}
+			// Announce again until the mesh is formed.
+			r1.publish(ctx, pinAnnouncement{Op: pinOpPin, Cid: c})
+			time.Sleep(100 * time.Millisecond)
+		}
+	}
+	warmup, err := p1.AddFile(ctx, strings.NewReader("warm-up"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r1.Pin(ctx, warmup.Cid()); err != nil {
+		t.Fatal(err)
+	}
+	waitPinned(warmup.Cid())
+
+	// The mirror fetches the content to pin it, while the unpin arrives
+	// right away. It must be applied after the pin.
+	n, err := p1.AddFile(ctx, bytes.NewReader(make([]byte, 1<<20)), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r1.Pin(ctx, n.Cid()); err != nil {
+		t.Fatal(err)
+	}
+	if err := r1.Unpin(ctx, n.Cid()); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		has, _ := p2.HasBlock(ctx, n.Cid())
+		pinned, _ := p2.IsPinned(ctx, n.Cid())
+		if has && !pinned {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("the pin was not applied before the unpin (fetched: %t, pinned: %t)", has, pinned)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	// Nothing left to apply pins it again.
+	time.Sleep(500 * time.Millisecond)
+	if pinned, _ := p2.IsPinned(ctx, n.Cid()); pinned {
+		t.Error("the mirror should not stay pinned")
+	}
+}
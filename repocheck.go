@@ -0,0 +1,139 @@
+package ipfslite
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	blockstore "github.com/ipfs/boxo/blockstore"
+	dshelp "github.com/ipfs/boxo/datastore/dshelp"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	ipld "github.com/ipfs/go-ipld-format"
+)
+
+var quarantinePrefix = datastore.NewKey("/quarantine")
+
+// RepoCheckReport is the result of CheckRepo.
+type RepoCheckReport struct {
+	// Checked is the number of blocks which were verified.
+	Checked int
+	// Corrupted lists the blocks (as raw CIDs, like the blockstore keys)
+	// whose data does not match their CID. They were removed from the
+	// blockstore, so that they can be fetched again, and their data was
+	// moved to the quarantine.
+	Corrupted []cid.Cid
+	// Unreadable lists the blocks which could not be read, with the
+	// errors. They are kept, as the errors may be transient.
+	Unreadable map[cid.Cid]error
+	// PinErrors lists the errors found when reading the pinset.
+	PinErrors []error
+}
+
+// CheckRepo verifies the integrity of the blocks and of the pinset. Blocks
+// whose data does not match their CID, i.e. truncated or corrupted
+// datastore entries, are quarantined: their data is moved to the
+// /quarantine namespace of the metadata datastore, keyed by multihash,
+// where it can be inspected, and they are deleted from the blockstore.
+// Blocks which cannot be read are only reported. The blocks are read from
+// the underlying blockstore, so that the check neither counts as an access
+// (see Config.TrackAccessTime and Config.CacheSize) nor is subject to
+// Config.AllowedCodecs. It can run at startup with Config.CheckRepo, so that
+// corruption is detected early rather than when the content is read.
+// Checking reads every block, which may take long on large repositories.
+// Read-only peers only report corrupted blocks.
+func (p *Peer) CheckRepo(ctx context.Context) (*RepoCheckReport, error) {
+	report := &RepoCheckReport{}
+
+	keys, err := p.baseBstore.AllKeysChan(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for c := range keys {
+		data, corrupted, err := p.checkBlock(ctx, c)
+		if ipld.IsNotFound(err) {
+			// Deleted meanwhile.
+			continue
+		}
+		report.Checked++
+		if err != nil {
+			if ctx.Err() != nil {
+				break
+			}
+			logger.Errorf("error reading block %s: %s", c, err)
+			if report.Unreadable == nil {
+				report.Unreadable = make(map[cid.Cid]error)
+			}
+			report.Unreadable[c] = err
+			continue
+		}
+		if !corrupted {
+			continue
+		}
+		logger.Errorf("corrupted block %s: data does not match the hash", c)
+		report.Corrupted = append(report.Corrupted, c)
+		if p.cfg.ReadOnly {
+			continue
+		}
+		if err := p.quarantine(ctx, c, data); err != nil {
+			return report, err
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return report, err
+	}
+
+	for sc := range p.pinner.RecursiveKeys(ctx) {
+		if sc.Err != nil {
+			report.PinErrors = append(report.PinErrors, fmt.Errorf("recursive pins: %w", sc.Err))
+			break
+		}
+	}
+	for sc := range p.pinner.DirectKeys(ctx) {
+		if sc.Err != nil {
+			report.PinErrors = append(report.PinErrors, fmt.Errorf("direct pins: %w", sc.Err))
+			break
+		}
+	}
+	return report, ctx.Err()
+}
+
+// checkBlock reads a block and verifies its hash. It returns the data of a
+// corrupted block (nil when detected by Config.HashOnRead), or the error
+// reading it.
+func (p *Peer) checkBlock(ctx context.Context, c cid.Cid) ([]byte, bool, error) {
+	blk, err := p.baseBstore.Get(ctx, c)
+	if errors.Is(err, blockstore.ErrHashMismatch) {
+		return nil, true, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	sum, err := c.Prefix().Sum(blk.RawData())
+	if err != nil {
+		return nil, false, err
+	}
+	if !sum.Equals(c) {
+		return blk.RawData(), true, nil
+	}
+	return nil, false, nil
+}
+
+// quarantineKey returns the key of the quarantined data of a block. Like in
+// the blockstore, blocks are keyed by multihash.
+func quarantineKey(c cid.Cid) datastore.Key {
+	return quarantinePrefix.Child(dshelp.MultihashToDsKey(c.Hash()))
+}
+
+func (p *Peer) quarantine(ctx context.Context, c cid.Cid, data []byte) error {
+	if data != nil {
+		if err := p.datastore(MetaNamespace).Put(ctx, quarantineKey(c), data); err != nil {
+			return err
+		}
+	}
+	err := p.trackedBstore.DeleteBlock(ctx, c)
+	if err != nil && !ipld.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
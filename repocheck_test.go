@@ -0,0 +1,102 @@
+package ipfslite
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	dshelp "github.com/ipfs/boxo/datastore/dshelp"
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+)
+
+func TestCheckRepo(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ds := NewInMemoryDatastore()
+	p, err := New(ctx, ds, nil, nil, nil, &Config{Offline: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	good := blocks.NewBlock([]byte("good block"))
+	bad := blocks.NewBlock([]byte("bad block"))
+	for _, blk := range []blocks.Block{good, bad} {
+		if err := p.BlockStore().Put(ctx, blk); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Corrupt the stored data of one of the blocks.
+	key := BlocksNamespace.Child(dshelp.MultihashToDsKey(bad.Cid().Hash()))
+	if err := ds.Put(ctx, key, []byte("bad bl")); err != nil {
+		t.Fatal(err)
+	}
+
+	p2, err := New(ctx, ds, nil, nil, nil, &Config{Offline: true, CheckRepo: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if has, _ := p2.HasBlock(ctx, bad.Cid()); has {
+		t.Error("corrupted block should have been removed")
+	}
+	if has, _ := p2.HasBlock(ctx, good.Cid()); !has {
+		t.Error("good block should be kept")
+	}
+	data, err := p2.datastore(MetaNamespace).Get(ctx, quarantineKey(bad.Cid()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "bad bl" {
+		t.Errorf("unexpected quarantined data: %q", data)
+	}
+
+	report, err := p2.CheckRepo(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Checked != 1 || len(report.Corrupted) != 0 || len(report.PinErrors) != 0 {
+		t.Errorf("unexpected report: %+v", report)
+	}
+}
+
+func TestCheckRepoWrappers(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ds := NewInMemoryDatastore()
+	p, err := New(ctx, ds, nil, nil, nil, &Config{Offline: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err := p.AddFile(ctx, strings.NewReader("checked"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The CID policy, access times and cache tracker do not apply to
+	// the check.
+	p2, err := New(ctx, ds, nil, nil, nil, &Config{
+		Offline:         true,
+		CheckRepo:       true,
+		AllowedCodecs:   []uint64{cid.DagProtobuf},
+		TrackAccessTime: true,
+		CacheSize:       1 << 20,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if has, _ := p2.HasBlock(ctx, n.Cid()); !has {
+		t.Fatal("the block should be kept")
+	}
+	report, err := p2.CheckRepo(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Checked != 1 || len(report.Corrupted) != 0 || len(report.Unreadable) != 0 {
+		t.Errorf("unexpected report: %+v", report)
+	}
+	if _, err := p2.datastore(MetaNamespace).Get(ctx, accessTimePrefix.Child(dshelp.MultihashToDsKey(n.Cid().Hash()))); err == nil {
+		t.Error("the check should not record access times")
+	}
+}
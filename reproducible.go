@@ -0,0 +1,70 @@
+package ipfslite
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ErrNotReproducible is returned when adding content with
+// AddParams.Reproducible and parameters which do not pin down the CIDs of
+// the result.
+var ErrNotReproducible = errors.New("add parameters are not reproducible")
+
+// reproducibleMaxLinks is the maximum number of links per node of the DAGs
+// built with AddParams.Reproducible. It is fixed, rather than following the
+// UnixFS default, which may change.
+const reproducibleMaxLinks = 174
+
+// ReproducibleAddParams returns AddParams which give the same content the
+// same CIDs, across versions: a balanced layout of 1MiB chunks with raw
+// leaves, hashed with sha2-256 into CIDv1 blocks, without any metadata
+// (mode, modification time). Other fields, like Path or Stats, can be set
+// on the result.
+func ReproducibleAddParams() *AddParams {
+	return &AddParams{
+		Reproducible: true,
+		Layout:       "balanced",
+		Chunker:      "size-1048576",
+		RawLeaves:    true,
+		HashFun:      "sha2-256",
+	}
+}
+
+// checkReproducible checks that the parameters pin down the DAG built from
+// the content: no default may be relied upon, since defaults may change.
+func (params *AddParams) checkReproducible() error {
+	if params.Layout != "balanced" && params.Layout != "trickle" {
+		return fmt.Errorf("%w: the layout must be balanced or trickle", ErrNotReproducible)
+	}
+	if params.HashFun == "" {
+		return fmt.Errorf("%w: the hash function must be set", ErrNotReproducible)
+	}
+	if !reproducibleChunker(params.Chunker) {
+		return fmt.Errorf("%w: the chunker must be size-{size} or rabin-{min}-{avg}-{max}", ErrNotReproducible)
+	}
+	return nil
+}
+
+// reproducibleChunker tells whether the chunker is fully specified by its
+// name: "size-{size}", or "rabin-{min}-{avg}-{max}".
+func reproducibleChunker(name string) bool {
+	if size, ok := strings.CutPrefix(name, "size-"); ok {
+		_, err := strconv.ParseUint(size, 10, 64)
+		return err == nil
+	}
+	if sizes, ok := strings.CutPrefix(name, "rabin-"); ok {
+		parts := strings.Split(sizes, "-")
+		if len(parts) != 3 {
+			return false
+		}
+		for _, s := range parts {
+			if _, err := strconv.ParseUint(s, 10, 64); err != nil {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
@@ -0,0 +1,56 @@
+package ipfslite
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"testing"
+)
+
+// reproducibleContent returns 3MiB of deterministic content.
+func reproducibleContent() []byte {
+	data := make([]byte, 3<<20)
+	for i := range data {
+		data[i] = byte(i % 251)
+	}
+	return data
+}
+
+func TestReproducibleAddParams(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	p, err := New(ctx, NewInMemoryDatastore(), nil, nil, nil, &Config{Offline: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := reproducibleContent()
+	// The CID must never change: update it only along with the
+	// documented behavior of ReproducibleAddParams.
+	const expected = "bafybeihzpjrbcpjpzcx4yxh3hasnjidsikmwmsrli7hhamhny5iueulu5a"
+	// Both the sequential and the parallel (io.ReaderAt) adds must give
+	// the same CID.
+	readers := []io.Reader{struct{ io.Reader }{bytes.NewReader(data)}, bytes.NewReader(data)}
+	for _, r := range readers {
+		n, err := p.AddFile(ctx, r, ReproducibleAddParams())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n.Cid().String() != expected {
+			t.Errorf("unexpected CID: %s", n.Cid())
+		}
+	}
+
+	for _, params := range []*AddParams{
+		{Reproducible: true, Chunker: "size-1048576", HashFun: "sha2-256"},
+		{Reproducible: true, Layout: "balanced", HashFun: "sha2-256"},
+		{Reproducible: true, Layout: "balanced", Chunker: "rabin", HashFun: "sha2-256"},
+		{Reproducible: true, Layout: "balanced", Chunker: "size-1048576"},
+	} {
+		_, err := p.AddFile(ctx, bytes.NewReader(data), params)
+		if !errors.Is(err, ErrNotReproducible) {
+			t.Errorf("%+v: expected ErrNotReproducible, got %v", params, err)
+		}
+	}
+}
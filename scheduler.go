@@ -0,0 +1,130 @@
+package ipfslite
+
+import (
+	"container/heap"
+	"context"
+	"sync"
+)
+
+// Fetch priorities. Any int can be used as a priority: fetches with higher
+// values are started first when the number of parallel fetches is limited
+// (see Config.MaxParallelFetches).
+const (
+	FetchPriorityBackground = -10
+	FetchPriorityNormal     = 0
+	FetchPriorityForeground = 10
+)
+
+// fetchScheduler limits the number of fetches running in parallel. Waiting
+// fetches are started by priority, and in order of arrival among those with
+// the same priority.
+type fetchScheduler struct {
+	mu      sync.Mutex
+	max     int
+	running int
+	waiting fetchWaitQueue
+	seq     uint64
+}
+
+func newFetchScheduler(max int) *fetchScheduler {
+	return &fetchScheduler{max: max}
+}
+
+// acquire blocks until the fetch can start or the context is cancelled. The
+// returned function must be called when the fetch finishes.
+func (s *fetchScheduler) acquire(ctx context.Context, priority int) (func(), error) {
+	if s.max <= 0 {
+		return func() {}, nil
+	}
+
+	s.mu.Lock()
+	if s.running < s.max && len(s.waiting) == 0 {
+		s.running++
+		s.mu.Unlock()
+		return s.releaseFunc(), nil
+	}
+	w := &fetchWaiter{
+		priority: priority,
+		seq:      s.seq,
+		ready:    make(chan struct{}),
+	}
+	s.seq++
+	heap.Push(&s.waiting, w)
+	s.mu.Unlock()
+
+	select {
+	case <-w.ready:
+		return s.releaseFunc(), nil
+	case <-ctx.Done():
+		s.mu.Lock()
+		if w.index >= 0 {
+			heap.Remove(&s.waiting, w.index)
+			s.mu.Unlock()
+			return nil, ctx.Err()
+		}
+		s.mu.Unlock()
+		// The slot was handed to us meanwhile: give it back.
+		s.release()
+		return nil, ctx.Err()
+	}
+}
+
+func (s *fetchScheduler) releaseFunc() func() {
+	var once sync.Once
+	return func() {
+		once.Do(s.release)
+	}
+}
+
+// release hands the slot of a finished fetch to the next waiting one.
+func (s *fetchScheduler) release() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.waiting) > 0 {
+		w := heap.Pop(&s.waiting).(*fetchWaiter)
+		close(w.ready)
+		return
+	}
+	s.running--
+}
+
+type fetchWaiter struct {
+	priority int
+	seq      uint64
+	ready    chan struct{}
+	index    int
+}
+
+// fetchWaitQueue implements heap.Interface.
+type fetchWaitQueue []*fetchWaiter
+
+func (q fetchWaitQueue) Len() int { return len(q) }
+
+func (q fetchWaitQueue) Less(i, j int) bool {
+	if q[i].priority != q[j].priority {
+		return q[i].priority > q[j].priority
+	}
+	return q[i].seq < q[j].seq
+}
+
+func (q fetchWaitQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].index = i
+	q[j].index = j
+}
+
+func (q *fetchWaitQueue) Push(x any) {
+	w := x.(*fetchWaiter)
+	w.index = len(*q)
+	*q = append(*q, w)
+}
+
+func (q *fetchWaitQueue) Pop() any {
+	old := *q
+	n := len(old)
+	w := old[n-1]
+	old[n-1] = nil
+	w.index = -1
+	*q = old[:n-1]
+	return w
+}
@@ -0,0 +1,61 @@
+package ipfslite
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestFetchSchedulerPriority(t *testing.T) {
+	ctx := context.Background()
+	s := newFetchScheduler(1)
+
+	release, err := s.acquire(ctx, FetchPriorityNormal)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	order := make(chan int, 2)
+	var wg sync.WaitGroup
+	start := func(priority int) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rel, err := s.acquire(ctx, priority)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			order <- priority
+			rel()
+		}()
+	}
+	start(FetchPriorityBackground)
+	time.Sleep(50 * time.Millisecond)
+	start(FetchPriorityForeground)
+	time.Sleep(50 * time.Millisecond)
+
+	// A cancelled waiter gives up its place in the queue.
+	cctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	if _, err := s.acquire(cctx, FetchPriorityForeground); err == nil {
+		t.Error("expected the acquire to time out")
+	}
+
+	release()
+	release() // releasing twice is a no-op
+
+	if p := <-order; p != FetchPriorityForeground {
+		t.Errorf("expected the foreground fetch to run first, got %d", p)
+	}
+	if p := <-order; p != FetchPriorityBackground {
+		t.Errorf("expected the background fetch to run second, got %d", p)
+	}
+	wg.Wait()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.running != 0 || len(s.waiting) != 0 {
+		t.Errorf("scheduler not empty: %d running, %d waiting", s.running, len(s.waiting))
+	}
+}
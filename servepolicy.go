@@ -0,0 +1,150 @@
+package ipfslite
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/ipfs/boxo/blockservice"
+	offline "github.com/ipfs/boxo/exchange/offline"
+	"github.com/ipfs/boxo/ipld/merkledag"
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// servePinnedRefreshInterval is how often the set of pinned blocks served
+// with Config.ServePinnedOnly is recomputed, to account for the pins not
+// made with Peer.Pin and Peer.Unpin.
+var servePinnedRefreshInterval = 10 * time.Minute
+
+// ServePolicy decides whether a block requested by a peer is served (see
+// Config.ServePolicy). It is called for every requested block, and should
+// return quickly.
+type ServePolicy func(pid peer.ID, c cid.Cid) bool
+
+// pinnedServeSet holds the multihashes of the pinned blocks, which are the
+// only ones served with Config.ServePinnedOnly.
+type pinnedServeSet struct {
+	mu  sync.RWMutex
+	set map[string]struct{}
+	// added records the keys added while the set is recomputed, so that
+	// they are not lost when it is replaced.
+	added map[string]struct{}
+	stale chan struct{}
+}
+
+func newPinnedServeSet() *pinnedServeSet {
+	return &pinnedServeSet{stale: make(chan struct{}, 1)}
+}
+
+func (s *pinnedServeSet) has(c cid.Cid) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	_, ok := s.set[string(c.Hash())]
+	return ok
+}
+
+func (s *pinnedServeSet) add(keys []cid.Cid) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.set == nil {
+		s.set = make(map[string]struct{})
+	}
+	for _, c := range keys {
+		s.set[string(c.Hash())] = struct{}{}
+		if s.added != nil {
+			s.added[string(c.Hash())] = struct{}{}
+		}
+	}
+}
+
+// begin starts recording the added keys until the set is replaced.
+func (s *pinnedServeSet) begin() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.added = make(map[string]struct{})
+}
+
+func (s *pinnedServeSet) replace(set map[string]struct{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for k := range s.added {
+		set[k] = struct{}{}
+	}
+	s.added = nil
+	s.set = set
+}
+
+// invalidate requests the set to be recomputed, i.e. after unpinning.
+func (s *pinnedServeSet) invalidate() {
+	select {
+	case s.stale <- struct{}{}:
+	default:
+	}
+}
+
+// allowServe tells whether the given block may be served to the given
+// peer, according to Config.ServePinnedOnly and Config.ServePolicy.
+func (p *Peer) allowServe(pid peer.ID, c cid.Cid) bool {
+	if p.servePinned != nil && !p.servePinned.has(c) {
+		return false
+	}
+	if p.cfg.ServePolicy != nil && !p.cfg.ServePolicy(pid, c) {
+		return false
+	}
+	return true
+}
+
+// hasServePolicy tells whether the blocks served are restricted by
+// allowServe.
+func (p *Peer) hasServePolicy() bool {
+	return p.servePinned != nil || p.cfg.ServePolicy != nil
+}
+
+// addServedPins adds a newly pinned DAG, available locally, to the blocks
+// served with Config.ServePinnedOnly.
+func (p *Peer) addServedPins(ctx context.Context, root cid.Cid, recursive bool) {
+	if p.servePinned == nil {
+		return
+	}
+	if !recursive {
+		p.servePinned.add([]cid.Cid{root})
+		return
+	}
+	var keys []cid.Cid
+	offlineDAG := merkledag.NewDAGService(blockservice.New(p.bstore, offline.Exchange(p.bstore)))
+	err := merkledag.Walk(ctx, merkledag.GetLinksWithDAG(offlineDAG), root, func(c cid.Cid) bool {
+		keys = append(keys, c)
+		return true
+	})
+	if err != nil {
+		logger.Warnf("error walking pinned DAG %s: %s", root, err)
+	}
+	p.servePinned.add(keys)
+}
+
+// servePinnedLoop recomputes the pinned blocks served with
+// Config.ServePinnedOnly when the Peer starts, after unpinning, and
+// periodically.
+func (p *Peer) servePinnedLoop() {
+	ticker := time.NewTicker(servePinnedRefreshInterval)
+	defer ticker.Stop()
+	for {
+		p.servePinned.begin()
+		set, err := p.pinnedSet(p.ctx)
+		if err != nil {
+			if p.ctx.Err() != nil {
+				return
+			}
+			logger.Errorf("error listing the pinned blocks to serve: %s", err)
+		} else {
+			p.servePinned.replace(set)
+		}
+		select {
+		case <-p.ctx.Done():
+			return
+		case <-ticker.C:
+		case <-p.servePinned.stale:
+		}
+	}
+}
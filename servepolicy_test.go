@@ -0,0 +1,67 @@
+package ipfslite
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	cbor "github.com/ipfs/go-ipld-cbor"
+	ipld "github.com/ipfs/go-ipld-format"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multihash"
+)
+
+func TestServePolicy(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	nodes := make([]ipld.Node, 3)
+	for i := range nodes {
+		n, err := cbor.WrapObject(map[string]int{"n": i}, multihash.SHA2_256, -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		nodes[i] = n
+	}
+	denied := nodes[2].Cid()
+	p1 := setupPeer(t, ctx, &Config{
+		ServePinnedOnly: true,
+		ServePolicy: func(_ peer.ID, c cid.Cid) bool {
+			return !c.Equals(denied)
+		},
+	})
+	p2 := setupPeer(t, ctx, nil)
+	for _, n := range nodes {
+		if err := p1.Add(ctx, n); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, n := range []ipld.Node{nodes[0], nodes[2]} {
+		if err := p1.Pin(ctx, n.Cid(), true); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	providers := WithProviders([]peer.AddrInfo{{ID: p1.ID(), Addrs: p1.Addrs()}})
+	if _, err := p2.Fetch(ctx, nodes[0].Cid(), providers); err != nil {
+		t.Fatalf("the pinned block should be served: %s", err)
+	}
+	for _, c := range []cid.Cid{nodes[1].Cid(), denied} {
+		if _, err := p2.Fetch(ctx, c, providers, WithTimeout(time.Second)); err == nil {
+			t.Errorf("%s should not be served", c)
+		}
+	}
+
+	// Unpinned blocks stop being served.
+	if err := p1.Unpin(ctx, nodes[0].Cid(), true); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for p1.allowServe(p2.ID(), nodes[0].Cid()) {
+		if time.Now().After(deadline) {
+			t.Fatal("the unpinned block is still served")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
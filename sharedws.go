@@ -0,0 +1,214 @@
+package ipfslite
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+
+	ws "github.com/gorilla/websocket"
+	libp2p "github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/transport"
+	"github.com/libp2p/go-libp2p/p2p/transport/tcp"
+	"github.com/libp2p/go-libp2p/p2p/transport/websocket"
+	ma "github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
+)
+
+var errNoSharedWebSocketListener = errors.New("the libp2p host is not listening on the shared WebSocket")
+
+var sharedWebSocketUpgrader = ws.Upgrader{
+	// Like the libp2p WebSocket transport, accept connections from any
+	// origin: libp2p connections are authenticated by the peers.
+	CheckOrigin: func(r *http.Request) bool {
+		return true
+	},
+}
+
+// SharedWebSocket is a libp2p WebSocket transport which does not listen on
+// a port of its own. Instead, its connections are accepted by an HTTP
+// handler (see Handler), so that the libp2p host shares an HTTP server,
+// and a single port, with the gateway and any other HTTP handlers of the
+// application. This suits deployments behind a single ingress holding the
+// TLS certificate for port 443.
+//
+// The host must listen on WebSocket addresses with the port of the HTTP
+// server, as reachable by other peers, i.e. "/ip4/0.0.0.0/tcp/8080/ws", or
+// "/dns4/example.com/tcp/443/wss" when TLS is terminated by the ingress.
+type SharedWebSocket struct {
+	mu        sync.Mutex
+	listeners []*sharedWebSocketListener
+}
+
+// NewSharedWebSocket returns a SharedWebSocket, to be enabled on a libp2p
+// host with Option.
+func NewSharedWebSocket() *SharedWebSocket {
+	return &SharedWebSocket{}
+}
+
+// Option returns a libp2p option which enables the shared WebSocket
+// transport. Like SecureWebSocket, it replaces the default transports with
+// TCP and WebSocket, and must be passed after any other transport options.
+// Dialing WebSocket addresses works as usual.
+func (s *SharedWebSocket) Option() libp2p.Option {
+	return libp2p.ChainOptions(
+		libp2p.NoTransports,
+		libp2p.Transport(tcp.NewTCPTransport),
+		libp2p.Transport(func(u transport.Upgrader, rcmgr network.ResourceManager) (*sharedWebSocketTransport, error) {
+			t, err := websocket.New(u, rcmgr)
+			if err != nil {
+				return nil, err
+			}
+			return &sharedWebSocketTransport{WebsocketTransport: t, upgrader: u, shared: s}, nil
+		}),
+	)
+}
+
+// Handler returns an HTTP handler passing WebSocket upgrade requests to the
+// libp2p host, and other requests to next, i.e. a mux serving the gateway
+// on /ipfs/ and /ipns/ and an API on /api/.
+func (s *SharedWebSocket) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !ws.IsWebSocketUpgrade(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		s.ServeHTTP(w, r)
+	})
+}
+
+// ServeHTTP hands the WebSocket connection requested by r to the libp2p
+// host.
+func (s *SharedWebSocket) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	l := s.listener()
+	if l == nil {
+		http.Error(w, errNoSharedWebSocketListener.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	c, err := sharedWebSocketUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// The upgrader writes a response for us.
+		return
+	}
+	secure := r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https"
+	select {
+	case l.incoming <- websocket.NewConn(c, secure):
+	case <-l.closed:
+		c.Close()
+	}
+}
+
+// listener returns an open listener, or nil.
+func (s *SharedWebSocket) listener() *sharedWebSocketListener {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.listeners) == 0 {
+		return nil
+	}
+	return s.listeners[0]
+}
+
+func (s *SharedWebSocket) addListener(l *sharedWebSocketListener) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.listeners = append(s.listeners, l)
+}
+
+func (s *SharedWebSocket) removeListener(l *sharedWebSocketListener) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, other := range s.listeners {
+		if other == l {
+			s.listeners = append(s.listeners[:i], s.listeners[i+1:]...)
+			return
+		}
+	}
+}
+
+// sharedWebSocketTransport dials like the libp2p WebSocket transport, and
+// listens through a SharedWebSocket.
+type sharedWebSocketTransport struct {
+	*websocket.WebsocketTransport
+	upgrader transport.Upgrader
+	shared   *SharedWebSocket
+}
+
+func (t *sharedWebSocketTransport) Listen(a ma.Multiaddr) (transport.Listener, error) {
+	addr, err := websocket.ConvertWebsocketMultiaddrToNetAddr(a)
+	if err != nil {
+		return nil, err
+	}
+	l := &sharedWebSocketListener{
+		shared:   t.shared,
+		laddr:    a,
+		addr:     addr,
+		incoming: make(chan *websocket.Conn),
+		closed:   make(chan struct{}),
+	}
+	t.shared.addListener(l)
+	return &sharedWebSocketTransportListener{Listener: t.upgrader.UpgradeListener(t, l)}, nil
+}
+
+// sharedWebSocketListener accepts the connections handed by a
+// SharedWebSocket.
+type sharedWebSocketListener struct {
+	shared    *SharedWebSocket
+	laddr     ma.Multiaddr
+	addr      net.Addr
+	incoming  chan *websocket.Conn
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func (l *sharedWebSocketListener) Accept() (manet.Conn, error) {
+	select {
+	case c := <-l.incoming:
+		mnc, err := manet.WrapNetConn(c)
+		if err != nil {
+			c.Close()
+			return nil, err
+		}
+		return mnc, nil
+	case <-l.closed:
+		return nil, transport.ErrListenerClosed
+	}
+}
+
+func (l *sharedWebSocketListener) Close() error {
+	l.closeOnce.Do(func() {
+		l.shared.removeListener(l)
+		close(l.closed)
+	})
+	return nil
+}
+
+func (l *sharedWebSocketListener) Addr() net.Addr {
+	return l.addr
+}
+
+func (l *sharedWebSocketListener) Multiaddr() ma.Multiaddr {
+	return l.laddr
+}
+
+type sharedWebSocketTransportListener struct {
+	transport.Listener
+}
+
+func (l *sharedWebSocketTransportListener) Accept() (transport.CapableConn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &sharedWebSocketConn{CapableConn: conn}, nil
+}
+
+type sharedWebSocketConn struct {
+	transport.CapableConn
+}
+
+func (c *sharedWebSocketConn) ConnState() network.ConnectionState {
+	cs := c.CapableConn.ConnState()
+	cs.Transport = "websocket"
+	return cs
+}
@@ -0,0 +1,79 @@
+package ipfslite
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	cbor "github.com/ipfs/go-ipld-cbor"
+	dht "github.com/libp2p/go-libp2p-kad-dht"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multiaddr"
+	multihash "github.com/multiformats/go-multihash"
+)
+
+func TestSharedWebSocket(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	shared := NewSharedWebSocket()
+	mux := http.NewServeMux()
+	srv := httptest.NewServer(shared.Handler(mux))
+	defer srv.Close()
+	u, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	priv, _, err := crypto.GenerateKeyPair(crypto.Ed25519, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	listen := multiaddr.StringCast("/ip4/127.0.0.1/tcp/" + u.Port() + "/ws")
+	h, d, err := SetupLibp2p(ctx, priv, nil, []multiaddr.Multiaddr{listen}, nil, dht.ModeServer, shared.Option())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+	defer d.Close()
+	server, err := New(ctx, NewInMemoryDatastore(), nil, h, d, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gw, err := server.Gateway(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux.Handle("/ipfs/", gw)
+
+	node, _ := cbor.WrapObject(map[string]string{"shared": "port"}, multihash.SHA2_256, -1)
+	if err := server.Add(ctx, node); err != nil {
+		t.Fatal(err)
+	}
+
+	// libp2p over the shared port.
+	client := setupPeer(t, ctx, nil)
+	err = client.host.Connect(ctx, peer.AddrInfo{ID: h.ID(), Addrs: []multiaddr.Multiaddr{listen}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	getCtx, getCancel := context.WithTimeout(ctx, 10*time.Second)
+	defer getCancel()
+	if _, err := client.Get(getCtx, node.Cid()); err != nil {
+		t.Fatal(err)
+	}
+
+	// The gateway over the shared port.
+	resp, err := http.Get(srv.URL + "/ipfs/" + node.Cid().String() + "?format=raw")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("gateway request failed: %s", resp.Status)
+	}
+}
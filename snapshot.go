@@ -0,0 +1,166 @@
+package ipfslite
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/ipfs/boxo/ipld/merkledag"
+	"github.com/ipfs/boxo/ipld/unixfs"
+	ufsio "github.com/ipfs/boxo/ipld/unixfs/io"
+	"github.com/ipfs/go-cid"
+	cbor "github.com/ipfs/go-ipld-cbor"
+	ipld "github.com/ipfs/go-ipld-format"
+	"github.com/multiformats/go-multihash"
+)
+
+// Snapshot is a version of a directory in a snapshot history. Snapshots are
+// stored as dag-cbor nodes linking to the directory root and to the
+// previous snapshot, so a whole history is identified by the CID of its
+// latest snapshot.
+type Snapshot struct {
+	// Cid identifies the snapshot node.
+	Cid cid.Cid
+	// Root is the UnixFS directory of this version.
+	Root cid.Cid
+	// Parent is the previous snapshot, or cid.Undef for the first one.
+	Parent  cid.Cid
+	Time    time.Time
+	Message string
+}
+
+// CommitSnapshot records the UnixFS directory root (i.e. returned by Sync)
+// as a new version on top of the parent snapshot (cid.Undef to start a new
+// history), and returns the CID of the new snapshot.
+func (p *Peer) CommitSnapshot(ctx context.Context, parent, root cid.Cid, message string) (cid.Cid, error) {
+	obj := map[string]interface{}{
+		"root":    root,
+		"time":    time.Now().UnixNano(),
+		"message": message,
+	}
+	if parent.Defined() {
+		obj["parent"] = parent
+	}
+	n, err := cbor.WrapObject(obj, multihash.SHA2_256, -1)
+	if err != nil {
+		return cid.Undef, err
+	}
+	err = p.Add(ctx, n)
+	if err != nil {
+		return cid.Undef, err
+	}
+	return n.Cid(), nil
+}
+
+// GetSnapshot returns the snapshot with the given CID.
+func (p *Peer) GetSnapshot(ctx context.Context, c cid.Cid) (*Snapshot, error) {
+	n, err := p.Get(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	var m map[string]interface{}
+	err = cbor.DecodeInto(n.RawData(), &m)
+	if err != nil {
+		return nil, fmt.Errorf("%s is not a snapshot: %w", c, err)
+	}
+	root, ok := m["root"].(cid.Cid)
+	if !ok {
+		return nil, fmt.Errorf("%s is not a snapshot", c)
+	}
+	s := &Snapshot{Cid: c, Root: root}
+	s.Parent, _ = m["parent"].(cid.Cid)
+	s.Message, _ = m["message"].(string)
+	switch t := m["time"].(type) {
+	case int:
+		s.Time = time.Unix(0, int64(t))
+	case int64:
+		s.Time = time.Unix(0, t)
+	case uint64:
+		s.Time = time.Unix(0, int64(t))
+	}
+	return s, nil
+}
+
+// Snapshots lists the versions in the history ending at head, newest
+// first.
+func (p *Peer) Snapshots(ctx context.Context, head cid.Cid) ([]*Snapshot, error) {
+	var history []*Snapshot
+	for c := head; c.Defined(); {
+		s, err := p.GetSnapshot(ctx, c)
+		if err != nil {
+			return history, err
+		}
+		history = append(history, s)
+		c = s.Parent
+	}
+	return history, nil
+}
+
+// RestoreSnapshot writes the directory of the given snapshot to localDir.
+// Existing files with the same names are overwritten, other files are left
+// untouched.
+func (p *Peer) RestoreSnapshot(ctx context.Context, snapshot cid.Cid, localDir string) error {
+	s, err := p.GetSnapshot(ctx, snapshot)
+	if err != nil {
+		return err
+	}
+	n, err := p.Get(ctx, s.Root)
+	if err != nil {
+		return err
+	}
+	return p.writeTree(ctx, n, localDir)
+}
+
+// writeTree writes a UnixFS node (file or directory) to the given local
+// path.
+func (p *Peer) writeTree(ctx context.Context, n ipld.Node, path string) error {
+	if pn, ok := n.(*merkledag.ProtoNode); ok {
+		fsn, err := unixfs.ExtractFSNode(pn)
+		if err != nil {
+			return err
+		}
+		if fsn.IsDir() {
+			return p.writeDir(ctx, n, path)
+		}
+	}
+
+	r, err := ufsio.NewDagReader(ctx, n, p)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, r)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func (p *Peer) writeDir(ctx context.Context, n ipld.Node, path string) error {
+	dir, err := ufsio.NewDirectoryFromNode(p, n)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(path, 0755)
+	if err != nil {
+		return err
+	}
+	return dir.ForEachLink(ctx, func(l *ipld.Link) error {
+		if l.Name == "" || l.Name == "." || l.Name == ".." || l.Name != filepath.Base(l.Name) {
+			return errors.New("invalid directory entry name: " + l.Name)
+		}
+		child, err := l.GetNode(ctx, p)
+		if err != nil {
+			return err
+		}
+		return p.writeTree(ctx, child, filepath.Join(path, l.Name))
+	})
+}
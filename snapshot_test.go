@@ -0,0 +1,75 @@
+package ipfslite
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+)
+
+func TestSnapshots(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	p, err := New(ctx, NewInMemoryDatastore(), nil, nil, nil, &Config{Offline: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "sub", "file.txt")
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte("v1"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	root1, err := p.Sync(ctx, dir, cid.Undef)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s1, err := p.CommitSnapshot(ctx, cid.Undef, root1, "first")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(path, []byte("version 2"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	root2, err := p.Sync(ctx, dir, root1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s2, err := p.CommitSnapshot(ctx, s1, root2, "second")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	history, err := p.Snapshots(ctx, s2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 2 {
+		t.Fatalf("expected 2 snapshots, got %d", len(history))
+	}
+	if !history[0].Cid.Equals(s2) || !history[0].Root.Equals(root2) || history[0].Message != "second" || !history[0].Parent.Equals(s1) {
+		t.Errorf("unexpected snapshot: %+v", history[0])
+	}
+	if !history[1].Root.Equals(root1) || history[1].Parent.Defined() || history[1].Time.IsZero() || history[1].Time.After(history[0].Time) {
+		t.Errorf("unexpected snapshot: %+v", history[1])
+	}
+
+	out := t.TempDir()
+	if err := p.RestoreSnapshot(ctx, s1, out); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(filepath.Join(out, "sub", "file.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "v1" {
+		t.Errorf("unexpected restored content %q", data)
+	}
+}
@@ -0,0 +1,329 @@
+package ipfslite
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/ipfs/boxo/blockservice"
+	blockstore "github.com/ipfs/boxo/blockstore"
+	offline "github.com/ipfs/boxo/exchange/offline"
+	"github.com/ipfs/boxo/ipld/merkledag"
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	leveldb "github.com/ipfs/go-ds-leveldb"
+	ipld "github.com/ipfs/go-ipld-format"
+)
+
+// stagingCommitBatch is the number of blocks written at once when a
+// Staging store is committed.
+var stagingCommitBatch = 256
+
+var (
+	// ErrStagingFull is returned when adding blocks to a Staging store
+	// beyond StagingOptions.MaxSize.
+	ErrStagingFull = errors.New("staging store is full")
+	// ErrStagingClosed is returned when using a Staging store which was
+	// committed or discarded.
+	ErrStagingClosed = errors.New("staging store is closed")
+)
+
+// StagingOptions configures a Staging store.
+type StagingOptions struct {
+	// Dir, when set, keeps the staged blocks on disk, in a temporary
+	// datastore created below Dir, instead of in memory.
+	Dir string
+	// MaxSize bounds the total size of the staged blocks, in bytes.
+	// Adding more fails with ErrStagingFull. Zero means no bound.
+	MaxSize int64
+}
+
+// Staging is a temporary store to build DAGs before they are committed to
+// the blockstore of a Peer, so that failed or aborted adds do not leave
+// orphan blocks behind. It implements ipld.DAGService: added nodes are
+// staged, and nodes which are not staged are read from the Peer, so that
+// new DAGs can link to existing content.
+type Staging struct {
+	p       *Peer
+	bs      blockstore.Blockstore
+	dag     ipld.DAGService
+	ds      datastore.Batching
+	dir     string
+	maxSize int64
+
+	mu   sync.Mutex
+	size int64
+	// cids are the CIDs of the staged nodes. The blockstore only keeps
+	// their multihashes, which lose the codec.
+	cids   map[cid.Cid]struct{}
+	closed bool
+	done   chan struct{}
+}
+
+// NewStaging returns a new Staging store. It is discarded when ctx is
+// cancelled, unless it was committed before.
+func (p *Peer) NewStaging(ctx context.Context, opts StagingOptions) (*Staging, error) {
+	if p.cfg.ReadOnly {
+		return nil, ErrReadOnly
+	}
+	s := &Staging{
+		p:       p,
+		maxSize: opts.MaxSize,
+		cids:    make(map[cid.Cid]struct{}),
+		done:    make(chan struct{}),
+	}
+	if opts.Dir != "" {
+		dir, err := os.MkdirTemp(opts.Dir, "ipfs-lite-staging-")
+		if err != nil {
+			return nil, err
+		}
+		ds, err := leveldb.NewDatastore(dir, nil)
+		if err != nil {
+			os.RemoveAll(dir)
+			return nil, fmt.Errorf("opening the staging datastore: %w", err)
+		}
+		s.ds = ds
+		s.dir = dir
+	} else {
+		s.ds = NewInMemoryDatastore()
+	}
+	s.bs = blockstore.NewBlockstore(s.ds)
+	s.dag = merkledag.NewDAGService(blockservice.New(s.bs, offline.Exchange(s.bs)))
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			if err := s.Discard(); err != nil && !errors.Is(err, ErrStagingClosed) {
+				logger.Warnf("error discarding staging store: %s", err)
+			}
+		case <-s.done:
+		}
+	}()
+	return s, nil
+}
+
+// Size returns the total size of the staged blocks.
+func (s *Staging) Size() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.size
+}
+
+// AddFile chunks and adds content to the Staging store, like Peer.AddFile.
+// The file is neither stored in the Peer nor provided until the store is
+// committed.
+func (s *Staging) AddFile(ctx context.Context, r io.Reader, params *AddParams) (ipld.Node, error) {
+	if params == nil {
+		params = &AddParams{}
+	}
+	r, err := s.p.checkAddLimits(r, params, 0)
+	if err != nil {
+		return nil, err
+	}
+	n, _, err := buildFile(r, params, s)
+	return n, err
+}
+
+func (s *Staging) Add(ctx context.Context, n ipld.Node) error {
+	return s.AddMany(ctx, []ipld.Node{n})
+}
+
+func (s *Staging) AddMany(ctx context.Context, nds []ipld.Node) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return ErrStagingClosed
+	}
+	blks := make([]blocks.Block, 0, len(nds))
+	cids := make([]cid.Cid, 0, len(nds))
+	size := s.size
+	for _, n := range nds {
+		if _, ok := s.cids[n.Cid()]; ok {
+			continue
+		}
+		cids = append(cids, n.Cid())
+		if has, err := s.bs.Has(ctx, n.Cid()); err != nil {
+			return err
+		} else if has {
+			continue
+		}
+		size += int64(len(n.RawData()))
+		if s.maxSize > 0 && size > s.maxSize {
+			return fmt.Errorf("%w: %d bytes staged, the limit is %d", ErrStagingFull, size, s.maxSize)
+		}
+		blks = append(blks, n)
+	}
+	if err := s.bs.PutMany(ctx, blks); err != nil {
+		return err
+	}
+	for _, c := range cids {
+		s.cids[c] = struct{}{}
+	}
+	s.size = size
+	return nil
+}
+
+func (s *Staging) Get(ctx context.Context, c cid.Cid) (ipld.Node, error) {
+	s.mu.Lock()
+	closed := s.closed
+	s.mu.Unlock()
+	if closed {
+		return nil, ErrStagingClosed
+	}
+	n, err := s.dag.Get(ctx, c)
+	if ipld.IsNotFound(err) {
+		return s.p.Get(ctx, c)
+	}
+	return n, err
+}
+
+func (s *Staging) GetMany(ctx context.Context, cids []cid.Cid) <-chan *ipld.NodeOption {
+	out := make(chan *ipld.NodeOption, len(cids))
+	go func() {
+		defer close(out)
+		for _, c := range cids {
+			n, err := s.Get(ctx, c)
+			select {
+			case out <- &ipld.NodeOption{Node: n, Err: err}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out
+}
+
+// Remove removes a staged node. Nodes of the Peer are not removed.
+func (s *Staging) Remove(ctx context.Context, c cid.Cid) error {
+	return s.RemoveMany(ctx, []cid.Cid{c})
+}
+
+func (s *Staging) RemoveMany(ctx context.Context, cids []cid.Cid) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return ErrStagingClosed
+	}
+	for _, c := range cids {
+		if _, ok := s.cids[c]; !ok {
+			continue
+		}
+		delete(s.cids, c)
+		size, err := s.bs.GetSize(ctx, c)
+		if ipld.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if err := s.bs.DeleteBlock(ctx, c); err != nil {
+			return err
+		}
+		s.size -= int64(size)
+	}
+	return nil
+}
+
+// Commit writes all the staged blocks to the blockstore of the Peer, and
+// announces the given roots to the network, like the roots of added files.
+// When writing fails, the blocks written so far are removed, so that none
+// of the staged blocks are left in the Peer. The Staging store is closed
+// once committed, whether it succeeded or not.
+func (s *Staging) Commit(ctx context.Context, roots ...cid.Cid) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return ErrStagingClosed
+	}
+	defer s.close()
+
+	var written []cid.Cid
+	rollback := func() {
+		for _, c := range written {
+			if err := s.p.bstore.DeleteBlock(context.Background(), c); err != nil {
+				logger.Warnf("error removing committed block %s: %s", c, err)
+			}
+		}
+	}
+	var batch []blocks.Block
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		if err := s.p.bserv.AddBlocks(ctx, batch); err != nil {
+			return err
+		}
+		for _, blk := range batch {
+			written = append(written, blk.Cid())
+		}
+		batch = batch[:0]
+		return nil
+	}
+	for c := range s.cids {
+		if err := ctx.Err(); err != nil {
+			rollback()
+			return err
+		}
+		// Blocks already in the Peer are left out, so that they are
+		// not removed on rollback.
+		has, err := s.p.bstore.Has(ctx, c)
+		if err != nil {
+			rollback()
+			return err
+		}
+		if has {
+			continue
+		}
+		blk, err := s.bs.Get(ctx, c)
+		if err != nil {
+			rollback()
+			return err
+		}
+		batch = append(batch, blk)
+		if len(batch) == stagingCommitBatch {
+			if err := flush(); err != nil {
+				rollback()
+				return err
+			}
+		}
+	}
+	if err := flush(); err != nil {
+		rollback()
+		return err
+	}
+
+	for _, root := range roots {
+		if err := s.p.provide(ctx, root); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Discard drops all the staged blocks and closes the Staging store.
+func (s *Staging) Discard() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return ErrStagingClosed
+	}
+	return s.close()
+}
+
+// close releases the staging datastore. It must be called with the lock
+// held.
+func (s *Staging) close() error {
+	s.closed = true
+	close(s.done)
+	err := s.ds.Close()
+	if s.dir != "" {
+		if rerr := os.RemoveAll(s.dir); err == nil {
+			err = rerr
+		}
+	}
+	return err
+}
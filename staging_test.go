@@ -0,0 +1,112 @@
+package ipfslite
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-cid"
+)
+
+func TestStaging(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	// The staged blocks are committed with their CIDs, which a codec
+	// policy checks.
+	p, err := New(ctx, NewInMemoryDatastore(), nil, nil, nil, &Config{
+		Offline:       true,
+		AllowedCodecs: []uint64{cid.DagProtobuf},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var codecs []uint64
+	p.AddIngestHook(func(ctx context.Context, ev IngestEvent) {
+		if ev.Kind == IngestBlock {
+			codecs = append(codecs, ev.Codec)
+		}
+	})
+
+	s, err := p.NewStaging(ctx, StagingOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err := s.AddFile(ctx, strings.NewReader("staged content"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if has, _ := p.HasBlock(ctx, n.Cid()); has {
+		t.Fatal("staged blocks should not be stored before the commit")
+	}
+	if _, err := s.Get(ctx, n.Cid()); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Commit(ctx, n.Cid()); err != nil {
+		t.Fatal(err)
+	}
+	if got := readAll(t, ctx, p, n.Cid()); got != "staged content" {
+		t.Errorf("unexpected committed content: %q", got)
+	}
+	if len(codecs) != 1 || codecs[0] != cid.DagProtobuf {
+		t.Errorf("unexpected codecs of the committed blocks: %v", codecs)
+	}
+	if err := s.Discard(); !errors.Is(err, ErrStagingClosed) {
+		t.Errorf("expected ErrStagingClosed, got %v", err)
+	}
+}
+
+func TestStagingDiscard(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	p, err := New(ctx, NewInMemoryDatastore(), nil, nil, nil, &Config{Offline: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	sctx, scancel := context.WithCancel(ctx)
+	s, err := p.NewStaging(sctx, StagingOptions{Dir: dir, MaxSize: 1 << 20})
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err := s.AddFile(ctx, strings.NewReader("small"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Size() == 0 {
+		t.Error("the staged size should be tracked")
+	}
+	data := make([]byte, 2<<20)
+	rand.Read(data)
+	_, err = s.AddFile(ctx, bytes.NewReader(data), nil)
+	if !errors.Is(err, ErrStagingFull) {
+		t.Errorf("expected ErrStagingFull, got %v", err)
+	}
+
+	// Cancelling the context discards the staged blocks.
+	scancel()
+	for deadline := time.Now().Add(5 * time.Second); ; {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(entries) == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the staging datastore should be removed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := s.Commit(ctx, n.Cid()); !errors.Is(err, ErrStagingClosed) {
+		t.Errorf("expected ErrStagingClosed, got %v", err)
+	}
+	if has, _ := p.HasBlock(ctx, n.Cid()); has {
+		t.Error("discarded blocks should not be stored")
+	}
+}
@@ -0,0 +1,74 @@
+package ipfslite
+
+import (
+	"context"
+
+	blockstore "github.com/ipfs/boxo/blockstore"
+	"github.com/ipfs/go-cid"
+	ipld "github.com/ipfs/go-ipld-format"
+)
+
+// AddStats contains deduplication statistics for an added file (see
+// AddParams.Stats).
+type AddStats struct {
+	// NewBlocks is the number of blocks which were written.
+	NewBlocks int
+	// ExistingBlocks is the number of blocks which were already stored.
+	ExistingBlocks int
+	// LogicalBytes is the total size of the blocks of the file DAG.
+	LogicalBytes int64
+	// PhysicalBytes is the size of the blocks which were written.
+	PhysicalBytes int64
+}
+
+// DedupRatio returns the ratio of logical to physical bytes, which is 1
+// when nothing was deduplicated. It returns 0 when no blocks were written,
+// that is, when all the content was already stored.
+func (s AddStats) DedupRatio() float64 {
+	if s.PhysicalBytes == 0 {
+		return 0
+	}
+	return float64(s.LogicalBytes) / float64(s.PhysicalBytes)
+}
+
+// statsDAGService records AddStats for the nodes added through it. Nodes
+// added earlier through it count as existing even when they have not been
+// written yet.
+type statsDAGService struct {
+	ipld.DAGService
+	bs    blockstore.Blockstore
+	stats *AddStats
+	seen  map[cid.Cid]struct{}
+}
+
+func (ds *statsDAGService) record(ctx context.Context, nds ...ipld.Node) {
+	if ds.seen == nil {
+		ds.seen = make(map[cid.Cid]struct{})
+	}
+	for _, n := range nds {
+		size := int64(len(n.RawData()))
+		ds.stats.LogicalBytes += size
+		if _, ok := ds.seen[n.Cid()]; ok {
+			ds.stats.ExistingBlocks++
+			continue
+		}
+		ds.seen[n.Cid()] = struct{}{}
+		has, err := ds.bs.Has(ctx, n.Cid())
+		if err == nil && has {
+			ds.stats.ExistingBlocks++
+			continue
+		}
+		ds.stats.NewBlocks++
+		ds.stats.PhysicalBytes += size
+	}
+}
+
+func (ds *statsDAGService) Add(ctx context.Context, n ipld.Node) error {
+	ds.record(ctx, n)
+	return ds.DAGService.Add(ctx, n)
+}
+
+func (ds *statsDAGService) AddMany(ctx context.Context, nds []ipld.Node) error {
+	ds.record(ctx, nds...)
+	return ds.DAGService.AddMany(ctx, nds)
+}
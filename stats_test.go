@@ -0,0 +1,50 @@
+package ipfslite
+
+import (
+	"bytes"
+	"context"
+	"testing"
+)
+
+func TestAddStats(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	p, err := New(ctx, NewInMemoryDatastore(), nil, nil, nil, &Config{Offline: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	chunk := func(b byte) []byte { return bytes.Repeat([]byte{b}, 1024) }
+	content := bytes.Join([][]byte{chunk('a'), chunk('b'), chunk('c')}, nil)
+	params := &AddParams{Chunker: "size-1024", RawLeaves: true, Stats: &AddStats{}}
+
+	_, err = p.AddFile(ctx, bytes.NewReader(content), params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := *params.Stats
+	if s.NewBlocks != 4 || s.ExistingBlocks != 0 || s.LogicalBytes != s.PhysicalBytes {
+		t.Errorf("unexpected stats for new file: %+v", s)
+	}
+
+	_, err = p.AddFile(ctx, bytes.NewReader(content), params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s = *params.Stats
+	if s.NewBlocks != 0 || s.ExistingBlocks != 4 || s.PhysicalBytes != 0 || s.DedupRatio() != 0 {
+		t.Errorf("unexpected stats for re-added file: %+v", s)
+	}
+
+	// Two known chunks, one new chunk and a new root.
+	content = bytes.Join([][]byte{chunk('a'), chunk('b'), chunk('d')}, nil)
+	_, err = p.AddFile(ctx, bytes.NewReader(content), params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s = *params.Stats
+	if s.NewBlocks != 2 || s.ExistingBlocks != 2 || s.DedupRatio() <= 1 {
+		t.Errorf("unexpected stats for modified file: %+v", s)
+	}
+}
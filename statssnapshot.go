@@ -0,0 +1,140 @@
+package ipfslite
+
+import (
+	"time"
+
+	"github.com/ipfs/boxo/bitswap"
+	"github.com/ipfs/go-datastore"
+	dht "github.com/libp2p/go-libp2p-kad-dht"
+	dualdht "github.com/libp2p/go-libp2p-kad-dht/dual"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// StatsSnapshot aggregates the statistics of a Peer at a point in time. It
+// can be serialized to JSON, i.e. to be logged periodically or uploaded to
+// a telemetry service.
+type StatsSnapshot struct {
+	Time time.Time
+	// Peer is the ID of the Peer, empty when it is offline.
+	Peer         peer.ID
+	Repo         RepoStats
+	Bitswap      BitswapStats
+	DHT          DHTStats
+	Conns        ConnStats
+	ProvideQueue ProvideQueueStats
+	// Bandwidth is only set with Config.BandwidthReporter.
+	Bandwidth *BandwidthStats `json:",omitempty"`
+}
+
+// RepoStats describe the storage of a Peer.
+type RepoStats struct {
+	// DiskUsage is the disk space used by the datastore, when it reports
+	// it (see datastore.PersistentDatastore).
+	DiskUsage uint64
+	// CacheSize is the size of the cached blocks when Config.CacheSize is
+	// set.
+	CacheSize int64
+	// Blockstore is only set with Config.BlockstoreMetrics.
+	Blockstore *BlockstoreMetrics `json:",omitempty"`
+}
+
+// BitswapStats are the counters of the bitswap exchange since the Peer
+// started.
+type BitswapStats struct {
+	// Wantlist is the number of blocks wanted from other peers, and
+	// Partners the number of peers exchanging blocks with the Peer.
+	Wantlist         int
+	Partners         int
+	BlocksReceived   uint64
+	DataReceived     uint64
+	DupBlksReceived  uint64
+	DupDataReceived  uint64
+	MessagesReceived uint64
+	BlocksSent       uint64
+	DataSent         uint64
+}
+
+// DHTStats describe the DHT of a Peer.
+type DHTStats struct {
+	// RoutingTable is the number of peers in the routing tables of the
+	// DHT. With a dual DHT, LAN and WAN tell the numbers of peers in
+	// each of them.
+	RoutingTable int
+	LAN          int `json:",omitempty"`
+	WAN          int `json:",omitempty"`
+}
+
+// BandwidthStats are the bytes transferred by the libp2p host since it
+// started, and the current rates in bytes per second.
+type BandwidthStats struct {
+	TotalIn  int64
+	TotalOut int64
+	RateIn   float64
+	RateOut  float64
+}
+
+// StatsSnapshot returns the current statistics of the Peer. The statistics
+// of disabled components are left empty.
+func (p *Peer) StatsSnapshot() StatsSnapshot {
+	s := StatsSnapshot{
+		Time:         time.Now(),
+		Conns:        p.ConnStats(),
+		ProvideQueue: p.ProvideQueueStats(),
+	}
+	if p.host != nil {
+		s.Peer = p.host.ID()
+	}
+
+	if pds, ok := p.store.(datastore.PersistentDatastore); ok {
+		usage, err := pds.DiskUsage(p.ctx)
+		if err != nil {
+			logger.Warnf("error reading the datastore disk usage: %s", err)
+		}
+		s.Repo.DiskUsage = usage
+	}
+	if p.lru != nil {
+		s.Repo.CacheSize = p.lru.Size()
+	}
+	if m, ok := p.BlockstoreMetrics(); ok {
+		s.Repo.Blockstore = &m
+	}
+
+	if bs, ok := p.exch.(*bitswap.Bitswap); ok {
+		st, err := bs.Stat()
+		if err != nil {
+			logger.Warnf("error reading bitswap stats: %s", err)
+		} else {
+			s.Bitswap = BitswapStats{
+				Wantlist:         len(st.Wantlist),
+				Partners:         len(st.Peers),
+				BlocksReceived:   st.BlocksReceived,
+				DataReceived:     st.DataReceived,
+				DupBlksReceived:  st.DupBlksReceived,
+				DupDataReceived:  st.DupDataReceived,
+				MessagesReceived: st.MessagesReceived,
+				BlocksSent:       st.BlocksSent,
+				DataSent:         st.DataSent,
+			}
+		}
+	}
+
+	switch d := p.baseDHT.(type) {
+	case *dualdht.DHT:
+		s.DHT.LAN = d.LAN.RoutingTable().Size()
+		s.DHT.WAN = d.WAN.RoutingTable().Size()
+		s.DHT.RoutingTable = s.DHT.LAN + s.DHT.WAN
+	case *dht.IpfsDHT:
+		s.DHT.RoutingTable = d.RoutingTable().Size()
+	}
+
+	if p.cfg.BandwidthReporter != nil {
+		bw := p.cfg.BandwidthReporter.GetBandwidthTotals()
+		s.Bandwidth = &BandwidthStats{
+			TotalIn:  bw.TotalIn,
+			TotalOut: bw.TotalOut,
+			RateIn:   bw.RateIn,
+			RateOut:  bw.RateOut,
+		}
+	}
+	return s
+}
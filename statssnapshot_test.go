@@ -0,0 +1,53 @@
+package ipfslite
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	cbor "github.com/ipfs/go-ipld-cbor"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multihash"
+)
+
+func TestStatsSnapshot(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	p1 := setupPeer(t, ctx, &Config{BlockstoreMetrics: true})
+	p2 := setupPeer(t, ctx, nil)
+
+	node, _ := cbor.WrapObject(map[string]string{"stats": "snapshot"}, multihash.SHA2_256, -1)
+	if err := p2.Add(ctx, node); err != nil {
+		t.Fatal(err)
+	}
+	providers := []peer.AddrInfo{{ID: p2.host.ID(), Addrs: p2.host.Addrs()}}
+	if _, err := p1.Fetch(ctx, node.Cid(), WithProviders(providers)); err != nil {
+		t.Fatal(err)
+	}
+
+	s := p1.StatsSnapshot()
+	if s.Peer != p1.host.ID() || s.Conns.Total == 0 {
+		t.Errorf("unexpected snapshot: %+v", s)
+	}
+	if s.Bitswap.BlocksReceived != 1 || s.Bitswap.DataReceived != uint64(len(node.RawData())) {
+		t.Errorf("unexpected bitswap stats: %+v", s.Bitswap)
+	}
+	if s.Repo.Blockstore == nil || s.Repo.Blockstore.Put.Count == 0 {
+		t.Errorf("unexpected repo stats: %+v", s.Repo)
+	}
+	if s.Bandwidth != nil {
+		t.Error("the bandwidth should only be reported with a reporter")
+	}
+
+	data, err := json.Marshal(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded StatsSnapshot
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Peer != s.Peer || decoded.Bitswap != s.Bitswap {
+		t.Errorf("the snapshot should survive a JSON round-trip: %s", data)
+	}
+}
@@ -0,0 +1,43 @@
+package ipfslite
+
+import (
+	"context"
+	"errors"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+)
+
+var errStreamsOffline = errors.New("streams are not available offline")
+
+// SetStreamHandler registers a handler for the given protocol on the Peer's
+// host, so that applications can run their own protocols next to bitswap
+// and the DHT. The protocol ID should not be one used by the Peer itself,
+// as its handler would be replaced. It fails when the Peer has no host.
+func (p *Peer) SetStreamHandler(pid protocol.ID, handler network.StreamHandler) error {
+	if p.host == nil {
+		return errStreamsOffline
+	}
+	p.host.SetStreamHandler(pid, handler)
+	return nil
+}
+
+// RemoveStreamHandler removes the handler registered with SetStreamHandler
+// for the given protocol.
+func (p *Peer) RemoveStreamHandler(pid protocol.ID) {
+	if p.host == nil {
+		return
+	}
+	p.host.RemoveStreamHandler(pid)
+}
+
+// NewStream opens a stream to the given peer with the first of the given
+// protocols it supports, connecting to it first when needed (with the
+// addresses known to the peerstore). It fails when the Peer has no host.
+func (p *Peer) NewStream(ctx context.Context, to peer.ID, pids ...protocol.ID) (network.Stream, error) {
+	if p.host == nil {
+		return nil, errStreamsOffline
+	}
+	return p.host.NewStream(ctx, to, pids...)
+}
@@ -0,0 +1,65 @@
+package ipfslite
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+func TestStreamHandler(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	p1 := setupPeer(t, ctx, nil)
+	p2 := setupPeer(t, ctx, nil)
+
+	const proto = "/test/echo/1.0.0"
+	err := p1.SetStreamHandler(proto, func(s network.Stream) {
+		defer s.Close()
+		io.Copy(s, s)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p2.host.Peerstore().AddAddrs(p1.ID(), p1.Addrs(), time.Minute)
+
+	s, err := p2.NewStream(ctx, p1.ID(), proto)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	s.CloseWrite()
+	data, err := io.ReadAll(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "hello" {
+		t.Errorf("unexpected echo: %q", data)
+	}
+
+	p1.RemoveStreamHandler(proto)
+	s, err = p2.NewStream(ctx, p1.ID(), proto)
+	if err == nil {
+		// The protocol is negotiated lazily.
+		_, err = s.Read(make([]byte, 1))
+	}
+	if err == nil {
+		t.Error("the protocol should not be supported anymore")
+	}
+
+	offline, err := New(ctx, NewInMemoryDatastore(), nil, nil, nil, &Config{Offline: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := offline.SetStreamHandler(proto, nil); err == nil {
+		t.Error("an offline peer should not register handlers")
+	}
+	if _, err := offline.NewStream(ctx, peer.ID("x"), proto); err == nil {
+		t.Error("an offline peer should not open streams")
+	}
+}
@@ -0,0 +1,179 @@
+package ipfslite
+
+import (
+	"context"
+	"encoding/json"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	ufsio "github.com/ipfs/boxo/ipld/unixfs/io"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	ipld "github.com/ipfs/go-ipld-format"
+)
+
+var syncManifestPrefix = datastore.NewKey("/sync/manifest")
+
+// syncEntry records the state of a file when it was added by Sync.
+type syncEntry struct {
+	Size    int64   `json:"size"`
+	ModTime int64   `json:"mtime"`
+	Cid     cid.Cid `json:"cid"`
+}
+
+// syncManifest maps file paths (relative to the synced directory, with
+// "/" separators) to their state.
+type syncManifest map[string]syncEntry
+
+func syncManifestKey(root cid.Cid) datastore.Key {
+	return syncManifestPrefix.ChildString(root.String())
+}
+
+func (p *Peer) loadSyncManifest(ctx context.Context, root cid.Cid) (syncManifest, error) {
+	m := make(syncManifest)
+	if !root.Defined() {
+		return m, nil
+	}
+	data, err := p.datastore(MetaNamespace).Get(ctx, syncManifestKey(root))
+	if err == datastore.ErrNotFound {
+		logger.Warnf("no sync manifest for %s: all files will be re-added", root)
+		return m, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return m, json.Unmarshal(data, &m)
+}
+
+func (p *Peer) storeSyncManifest(ctx context.Context, root cid.Cid, m syncManifest) error {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	return p.datastore(MetaNamespace).Put(ctx, syncManifestKey(root), data)
+}
+
+// Sync adds the local directory as a UnixFS directory and returns its root
+// CID. When previousRoot is the result of an earlier Sync of the same
+// directory, only the files whose size or modification time have changed
+// since are read and chunked again; the others reuse the DAGs from the
+// previous snapshot. This makes periodic snapshots of large directories
+// cheap. Symbolic links and other special files are skipped.
+func (p *Peer) Sync(ctx context.Context, localDir string, previousRoot cid.Cid) (cid.Cid, error) {
+	prev, err := p.loadSyncManifest(ctx, previousRoot)
+	if err != nil {
+		return cid.Undef, err
+	}
+	manifest := make(syncManifest)
+	dirs := map[string]ufsio.Directory{".": ufsio.NewDirectory(p)}
+
+	err = filepath.WalkDir(localDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(localDir, path)
+		if err != nil {
+			return err
+		}
+		if rel == "." {
+			return nil
+		}
+		rel = filepath.ToSlash(rel)
+
+		switch {
+		case d.IsDir():
+			dirs[rel] = ufsio.NewDirectory(p)
+			return nil
+		case !d.Type().IsRegular():
+			logger.Warnf("sync: skipping special file %s", path)
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		entry := syncEntry{Size: info.Size(), ModTime: info.ModTime().UnixNano()}
+		old, ok := prev[rel]
+		var n ipld.Node
+		if ok && old.Size == entry.Size && old.ModTime == entry.ModTime {
+			// Only reuse DAGs which are still available locally.
+			if has, _ := p.HasBlock(ctx, old.Cid); has {
+				n, err = p.Get(ctx, old.Cid)
+				if err != nil {
+					return err
+				}
+			}
+		}
+		if n == nil {
+			n, err = p.syncFile(ctx, path, rel)
+			if err != nil {
+				return err
+			}
+		}
+		entry.Cid = n.Cid()
+		manifest[rel] = entry
+		return dirs[syncParent(rel)].AddChild(ctx, syncBase(rel), n)
+	})
+	if err != nil {
+		return cid.Undef, err
+	}
+
+	// Link directories into their parents, deepest first.
+	paths := make([]string, 0, len(dirs))
+	for path := range dirs {
+		if path != "." {
+			paths = append(paths, path)
+		}
+	}
+	sort.Slice(paths, func(i, j int) bool {
+		return strings.Count(paths[i], "/") > strings.Count(paths[j], "/")
+	})
+	for _, path := range paths {
+		n, err := p.storeDirectory(ctx, dirs[path])
+		if err != nil {
+			return cid.Undef, err
+		}
+		err = dirs[syncParent(path)].AddChild(ctx, syncBase(path), n)
+		if err != nil {
+			return cid.Undef, err
+		}
+	}
+	root, err := p.storeDirectory(ctx, dirs["."])
+	if err != nil {
+		return cid.Undef, err
+	}
+
+	err = p.storeSyncManifest(ctx, root.Cid(), manifest)
+	if err != nil {
+		return cid.Undef, err
+	}
+	return root.Cid(), nil
+}
+
+func (p *Peer) syncFile(ctx context.Context, path, rel string) (ipld.Node, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return p.AddFile(ctx, f, &AddParams{Path: rel})
+}
+
+func syncParent(rel string) string {
+	i := strings.LastIndex(rel, "/")
+	if i < 0 {
+		return "."
+	}
+	return rel[:i]
+}
+
+func syncBase(rel string) string {
+	return rel[strings.LastIndex(rel, "/")+1:]
+}
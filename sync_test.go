@@ -0,0 +1,85 @@
+package ipfslite
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-cid"
+)
+
+func TestSync(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	p, err := New(ctx, NewInMemoryDatastore(), nil, nil, nil, &Config{Offline: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var mu sync.Mutex
+	var added []string
+	p.AddIngestHook(func(ctx context.Context, ev IngestEvent) {
+		if ev.Kind == IngestFileAdded {
+			mu.Lock()
+			added = append(added, ev.Path)
+			mu.Unlock()
+		}
+	})
+
+	dir := t.TempDir()
+	write := func(name, content string) {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("a.txt", "a")
+	write("sub/b.txt", "b")
+	write("sub/deep/c.txt", "c")
+
+	root1, err := p.Sync(ctx, dir, cid.Undef)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(added) != 3 {
+		t.Fatalf("expected 3 added files, got %v", added)
+	}
+	if got := readAtPath(t, ctx, p, root1, "sub/deep/c.txt"); got != "c" {
+		t.Errorf("unexpected content %q", got)
+	}
+
+	added = nil
+	write("sub/b.txt", "bb")
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(filepath.Join(dir, "sub", "b.txt"), later, later); err != nil {
+		t.Fatal(err)
+	}
+	root2, err := p.Sync(ctx, dir, root1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(added) != 1 || added[0] != "sub/b.txt" {
+		t.Errorf("expected only sub/b.txt to be re-added, got %v", added)
+	}
+	if got := readAtPath(t, ctx, p, root2, "sub/b.txt"); got != "bb" {
+		t.Errorf("unexpected content %q", got)
+	}
+	if got := readAtPath(t, ctx, p, root2, "a.txt"); got != "a" {
+		t.Errorf("unexpected content %q", got)
+	}
+
+	added = nil
+	root3, err := p.Sync(ctx, dir, root2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(added) != 0 || !root3.Equals(root2) {
+		t.Errorf("unchanged directory should not be re-added: %v", added)
+	}
+}
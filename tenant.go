@@ -0,0 +1,48 @@
+package ipfslite
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/libp2p/go-libp2p/core/protocol"
+)
+
+// tenantProtocolPrefix prefixes the protocols of Peers with a Config.Tenant.
+const tenantProtocolPrefix = "/ipfs-lite/tenant/"
+
+func validateTenant(tenant string) error {
+	if strings.ContainsAny(tenant, "/ \t\n") {
+		return errors.New("invalid tenant name: " + tenant)
+	}
+	return nil
+}
+
+func validateProtocolPrefix(prefix string) error {
+	if prefix == "" {
+		return nil
+	}
+	if !strings.HasPrefix(prefix, "/") || strings.HasSuffix(prefix, "/") {
+		return errors.New("invalid bitswap protocol prefix: " + prefix)
+	}
+	return nil
+}
+
+// protocolPrefix returns the prefix of the bitswap protocols of the Peer,
+// made of Config.BitswapProtocolPrefix and of the tenant prefix.
+func (p *Peer) protocolPrefix() protocol.ID {
+	prefix := protocol.ID(p.cfg.BitswapProtocolPrefix)
+	if p.cfg.Tenant != "" {
+		prefix += protocol.ID(tenantProtocolPrefix + p.cfg.Tenant)
+	}
+	return prefix
+}
+
+// compressedProtocol returns the compressed blocks protocol of the Peer.
+func (p *Peer) compressedProtocol() protocol.ID {
+	return p.protocolPrefix() + CompressedBlocksProtocol
+}
+
+// metadataProtocol returns the peer metadata protocol of the Peer.
+func (p *Peer) metadataProtocol() protocol.ID {
+	return p.protocolPrefix() + PeerMetadataProtocol
+}
@@ -0,0 +1,109 @@
+package ipfslite
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	cbor "github.com/ipfs/go-ipld-cbor"
+	dht "github.com/libp2p/go-libp2p-kad-dht"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multiaddr"
+	"github.com/multiformats/go-multihash"
+)
+
+func TestTenants(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	priv, _, err := crypto.GenerateKeyPair(crypto.Ed25519, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	listen := multiaddr.StringCast("/ip4/127.0.0.1/tcp/0")
+	h, d, err := SetupLibp2p(ctx, priv, nil, []multiaddr.Multiaddr{listen}, nil, dht.ModeServer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+	defer d.Close()
+
+	a, err := New(ctx, NewInMemoryDatastore(), nil, h, d, &Config{Tenant: "a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := New(ctx, NewInMemoryDatastore(), nil, h, d, &Config{Tenant: "b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := New(ctx, NewInMemoryDatastore(), nil, h, d, &Config{Tenant: "a/b"}); err == nil {
+		t.Error("tenant names with slashes should be rejected")
+	}
+
+	nodeA, _ := cbor.WrapObject(map[string]string{"tenant": "a"}, multihash.SHA2_256, -1)
+	nodeB, _ := cbor.WrapObject(map[string]string{"tenant": "b"}, multihash.SHA2_256, -1)
+	if err := a.Add(ctx, nodeA); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Add(ctx, nodeB); err != nil {
+		t.Fatal(err)
+	}
+	if has, _ := b.HasBlock(ctx, nodeA.Cid()); has {
+		t.Error("tenants should have separate blockstores")
+	}
+
+	remote := setupPeer(t, ctx, &Config{Tenant: "a"})
+	err = remote.host.Connect(ctx, peer.AddrInfo{ID: h.ID(), Addrs: h.Addrs()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	getCtx, getCancel := context.WithTimeout(ctx, 10*time.Second)
+	defer getCancel()
+	if _, err := remote.Get(getCtx, nodeA.Cid()); err != nil {
+		t.Fatal(err)
+	}
+
+	getCtx, getCancel = context.WithTimeout(ctx, time.Second)
+	defer getCancel()
+	if _, err := remote.Get(getCtx, nodeB.Cid()); err == nil {
+		t.Error("blocks of another tenant should not be served")
+	}
+}
+
+func TestBitswapProtocolPrefix(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if _, err := New(ctx, NewInMemoryDatastore(), nil, nil, nil, &Config{Offline: true, BitswapProtocolPrefix: "myapp"}); err == nil {
+		t.Error("prefixes should start with a slash")
+	}
+
+	server := setupPeer(t, ctx, &Config{BitswapProtocolPrefix: "/myapp"})
+	private := setupPeer(t, ctx, &Config{BitswapProtocolPrefix: "/myapp"})
+	public := setupPeer(t, ctx, nil)
+	for _, p := range []*Peer{private, public} {
+		err := p.host.Connect(ctx, peer.AddrInfo{ID: server.host.ID(), Addrs: server.host.Addrs()})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !hasProtocol(server.host, "/myapp/ipfs/bitswap/1.2.0") {
+		t.Error("the prefixed bitswap protocol should be supported")
+	}
+
+	node, _ := cbor.WrapObject(map[string]string{"app": "myapp"}, multihash.SHA2_256, -1)
+	if err := server.Add(ctx, node); err != nil {
+		t.Fatal(err)
+	}
+	getCtx, getCancel := context.WithTimeout(ctx, 10*time.Second)
+	defer getCancel()
+	if _, err := private.Get(getCtx, node.Cid()); err != nil {
+		t.Fatal(err)
+	}
+	getCtx, getCancel = context.WithTimeout(ctx, time.Second)
+	defer getCancel()
+	if _, err := public.Get(getCtx, node.Cid()); err == nil {
+		t.Error("public peers should not get blocks from the private swarm")
+	}
+}
@@ -0,0 +1,151 @@
+package ipfslite
+
+import (
+	"context"
+
+	blockstore "github.com/ipfs/boxo/blockstore"
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	ipld "github.com/ipfs/go-ipld-format"
+)
+
+// TieredOptions configures a tiered blockstore.
+type TieredOptions struct {
+	// Promote copies blocks read from the remote tier to the local
+	// tier, so that they are served locally from then on.
+	Promote bool
+	// WriteThrough writes new blocks to the remote tier too, instead of
+	// only to the local one.
+	WriteThrough bool
+}
+
+// tieredBlockstore serves blocks from a fast local tier, falling back to a
+// slower remote tier.
+type tieredBlockstore struct {
+	local  blockstore.Blockstore
+	remote blockstore.Blockstore
+	opts   TieredOptions
+}
+
+// NewTieredBlockstore returns a blockstore made of a fast local tier and a
+// slower remote one (i.e. a blockstore backed by a cloud object store or by
+// a shared network filesystem). Reads are served by the local tier when
+// possible, and fall through to the remote tier otherwise. When given to
+// New, blocks are only requested from the network (bitswap) when missing
+// in both tiers. New blocks are written to the local tier and, with
+// WriteThrough, to the remote one. Deletions apply to both tiers.
+func NewTieredBlockstore(local, remote blockstore.Blockstore, opts TieredOptions) blockstore.Blockstore {
+	return &tieredBlockstore{
+		local:  local,
+		remote: remote,
+		opts:   opts,
+	}
+}
+
+func (bs *tieredBlockstore) DeleteBlock(ctx context.Context, c cid.Cid) error {
+	err := bs.local.DeleteBlock(ctx, c)
+	if err != nil && !ipld.IsNotFound(err) {
+		return err
+	}
+	err = bs.remote.DeleteBlock(ctx, c)
+	if err != nil && !ipld.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+func (bs *tieredBlockstore) Has(ctx context.Context, c cid.Cid) (bool, error) {
+	has, err := bs.local.Has(ctx, c)
+	if err != nil || has {
+		return has, err
+	}
+	return bs.remote.Has(ctx, c)
+}
+
+func (bs *tieredBlockstore) Get(ctx context.Context, c cid.Cid) (blocks.Block, error) {
+	blk, err := bs.local.Get(ctx, c)
+	if !ipld.IsNotFound(err) {
+		return blk, err
+	}
+	blk, err = bs.remote.Get(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	if bs.opts.Promote {
+		if err := bs.local.Put(ctx, blk); err != nil {
+			logger.Warnf("error promoting %s to the local tier: %s", c, err)
+		}
+	}
+	return blk, nil
+}
+
+func (bs *tieredBlockstore) GetSize(ctx context.Context, c cid.Cid) (int, error) {
+	size, err := bs.local.GetSize(ctx, c)
+	if !ipld.IsNotFound(err) {
+		return size, err
+	}
+	return bs.remote.GetSize(ctx, c)
+}
+
+func (bs *tieredBlockstore) Put(ctx context.Context, blk blocks.Block) error {
+	err := bs.local.Put(ctx, blk)
+	if err != nil || !bs.opts.WriteThrough {
+		return err
+	}
+	return bs.remote.Put(ctx, blk)
+}
+
+func (bs *tieredBlockstore) PutMany(ctx context.Context, blks []blocks.Block) error {
+	err := bs.local.PutMany(ctx, blks)
+	if err != nil || !bs.opts.WriteThrough {
+		return err
+	}
+	return bs.remote.PutMany(ctx, blks)
+}
+
+// AllKeysChan returns the keys in both tiers. Keys stored in both tiers
+// are only returned once.
+func (bs *tieredBlockstore) AllKeysChan(ctx context.Context) (<-chan cid.Cid, error) {
+	localKeys, err := bs.local.AllKeysChan(ctx)
+	if err != nil {
+		return nil, err
+	}
+	remoteKeys, err := bs.remote.AllKeysChan(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make(chan cid.Cid)
+	go func() {
+		defer close(out)
+		seen := make(map[string]struct{})
+		send := func(c cid.Cid) bool {
+			select {
+			case out <- c:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+		for c := range localKeys {
+			seen[string(c.Hash())] = struct{}{}
+			if !send(c) {
+				return
+			}
+		}
+		for c := range remoteKeys {
+			if _, ok := seen[string(c.Hash())]; ok {
+				continue
+			}
+			if !send(c) {
+				return
+			}
+		}
+	}()
+	return out, nil
+}
+
+func (bs *tieredBlockstore) HashOnRead(enabled bool) {
+	bs.local.HashOnRead(enabled)
+	bs.remote.HashOnRead(enabled)
+}
@@ -0,0 +1,70 @@
+package ipfslite
+
+import (
+	"context"
+	"testing"
+
+	blockstore "github.com/ipfs/boxo/blockstore"
+	blocks "github.com/ipfs/go-block-format"
+)
+
+func TestTieredBlockstore(t *testing.T) {
+	ctx := context.Background()
+	local := blockstore.NewBlockstore(NewInMemoryDatastore())
+	remote := blockstore.NewBlockstore(NewInMemoryDatastore())
+	bs := NewTieredBlockstore(local, remote, TieredOptions{Promote: true})
+
+	remoteBlk := blocks.NewBlock([]byte("remote"))
+	if err := remote.Put(ctx, remoteBlk); err != nil {
+		t.Fatal(err)
+	}
+	localBlk := blocks.NewBlock([]byte("local"))
+	if err := bs.Put(ctx, localBlk); err != nil {
+		t.Fatal(err)
+	}
+	if has, _ := remote.Has(ctx, localBlk.Cid()); has {
+		t.Error("block written to remote tier without WriteThrough")
+	}
+
+	has, err := bs.Has(ctx, remoteBlk.Cid())
+	if err != nil || !has {
+		t.Fatalf("remote block not found: %v", err)
+	}
+	blk, err := bs.Get(ctx, remoteBlk.Cid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(blk.RawData()) != "remote" {
+		t.Errorf("unexpected block data %q", blk.RawData())
+	}
+	if has, _ := local.Has(ctx, remoteBlk.Cid()); !has {
+		t.Error("remote block not promoted to the local tier")
+	}
+
+	keys, err := bs.AllKeysChan(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := 0
+	for range keys {
+		n++
+	}
+	if n != 2 {
+		t.Errorf("expected 2 keys, got %d", n)
+	}
+
+	if err := bs.DeleteBlock(ctx, remoteBlk.Cid()); err != nil {
+		t.Fatal(err)
+	}
+	if has, _ := bs.Has(ctx, remoteBlk.Cid()); has {
+		t.Error("block not deleted from both tiers")
+	}
+
+	wt := NewTieredBlockstore(local, remote, TieredOptions{WriteThrough: true})
+	if err := wt.Put(ctx, remoteBlk); err != nil {
+		t.Fatal(err)
+	}
+	if has, _ := remote.Has(ctx, remoteBlk.Cid()); !has {
+		t.Error("block not written through to the remote tier")
+	}
+}
@@ -0,0 +1,54 @@
+package ipfslite
+
+import (
+	"crypto/tls"
+
+	libp2p "github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/p2p/transport/tcp"
+	"github.com/libp2p/go-libp2p/p2p/transport/websocket"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// SecureWebSocket returns a libp2p option which enables listening on secure
+// WebSocket addresses (/tls/ws or /wss) using the given TLS configuration,
+// so that the host is dialable from browsers without a reverse proxy.
+//
+// libp2p does not allow registering the same transport twice, so this
+// option replaces the default transports with TCP and WebSocket, which are
+// also the transports used with private networks. It must be passed after
+// any other transport options.
+func SecureWebSocket(tlsConf *tls.Config) libp2p.Option {
+	return libp2p.ChainOptions(
+		libp2p.NoTransports,
+		libp2p.Transport(tcp.NewTCPTransport),
+		libp2p.Transport(websocket.New, websocket.WithTLSConfig(tlsConf)),
+	)
+}
+
+// TLSConfigFromFiles returns a TLS configuration using the given PEM-encoded
+// certificate and key files, for use with SecureWebSocket.
+func TLSConfigFromFiles(certFile, keyFile string) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
+
+// AutocertTLSConfig returns a TLS configuration which obtains and renews
+// certificates for the given domains automatically from Let's Encrypt using
+// ACME, for use with SecureWebSocket. Certificates are cached in cacheDir.
+// The TLS-ALPN-01 challenge is used, which requires the secure WebSocket
+// listener to be reachable on port 443 of the given domains.
+func AutocertTLSConfig(cacheDir, email string, domains ...string) *tls.Config {
+	m := &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		Cache:      autocert.DirCache(cacheDir),
+		HostPolicy: autocert.HostWhitelist(domains...),
+		Email:      email,
+	}
+	return m.TLSConfig()
+}
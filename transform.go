@@ -0,0 +1,141 @@
+package ipfslite
+
+import (
+	"context"
+	"crypto/cipher"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/ipfs/boxo/ipld/merkledag"
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	cbor "github.com/ipfs/go-ipld-cbor"
+	ipld "github.com/ipfs/go-ipld-format"
+	"github.com/multiformats/go-multihash"
+)
+
+var errNoBlockTransformer = errors.New("no BlockTransformer configured")
+
+// BlockTransformer transforms blocks before they are stored and exchanged,
+// and reverses the transformation when they are read. It allows storing
+// end-to-end encrypted content: only the transformed blocks (ciphertext)
+// leave the application, and they are stored, provided and exchanged with
+// other peers under their own CIDs, like any other block.
+type BlockTransformer interface {
+	// Encode transforms a block. The returned block must have a CID
+	// matching its data, and must carry what Decode needs to restore
+	// the original block, including its CID.
+	Encode(ctx context.Context, blk blocks.Block) (blocks.Block, error)
+	// Decode restores the original block from a transformed one.
+	Decode(ctx context.Context, blk blocks.Block) (blocks.Block, error)
+}
+
+// transformRegistry decodes the original blocks into nodes.
+var transformRegistry ipld.Registry
+
+func init() {
+	transformRegistry.Register(cid.DagProtobuf, merkledag.DecodeProtobufBlock)
+	transformRegistry.Register(cid.Raw, merkledag.DecodeRawBlock)
+	transformRegistry.Register(cid.DagCBOR, cbor.DecodeBlock)
+}
+
+// AddTransformed transforms the given node with Config.BlockTransformer and
+// adds the result to the blockstore, from where it is provided and served
+// to other peers. It returns the CID of the transformed block, which is
+// the one to use with GetTransformed. To transform a whole DAG, nodes
+// should link to the transformed CIDs of their children, so that they can
+// be retrieved from the network.
+func (p *Peer) AddTransformed(ctx context.Context, n ipld.Node) (cid.Cid, error) {
+	if p.cfg.BlockTransformer == nil {
+		return cid.Undef, errNoBlockTransformer
+	}
+	blk, err := p.cfg.BlockTransformer.Encode(ctx, n)
+	if err != nil {
+		return cid.Undef, err
+	}
+	err = p.bserv.AddBlock(ctx, blk)
+	if err != nil {
+		return cid.Undef, err
+	}
+	return blk.Cid(), nil
+}
+
+// GetTransformed retrieves the transformed block with the given CID, locally
+// or from the network, and returns the original node, restored with
+// Config.BlockTransformer.
+func (p *Peer) GetTransformed(ctx context.Context, c cid.Cid) (ipld.Node, error) {
+	if p.cfg.BlockTransformer == nil {
+		return nil, errNoBlockTransformer
+	}
+	return p.getTransformed(ctx, p.cfg.BlockTransformer, c)
+}
+
+func (p *Peer) getTransformed(ctx context.Context, tr BlockTransformer, c cid.Cid) (ipld.Node, error) {
+	blk, err := p.bserv.GetBlock(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	orig, err := tr.Decode(ctx, blk)
+	if err != nil {
+		return nil, err
+	}
+	sum, err := orig.Cid().Prefix().Sum(orig.RawData())
+	if err != nil {
+		return nil, err
+	}
+	if !sum.Equals(orig.Cid()) {
+		return nil, fmt.Errorf("%s: decoded block does not match %s", c, orig.Cid())
+	}
+	return transformRegistry.Decode(orig)
+}
+
+// aesTransformer encrypts blocks with AES-GCM.
+type aesTransformer struct {
+	aead cipher.AEAD
+}
+
+// NewAESTransformer returns a BlockTransformer which encrypts blocks with
+// AES-GCM using the given 16, 24 or 32 bytes key. Encrypted blocks are raw
+// blocks which embed the CID of the original block, and a random nonce, so
+// encrypting the same block twice gives different CIDs.
+func NewAESTransformer(key []byte) (BlockTransformer, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	return &aesTransformer{aead: aead}, nil
+}
+
+func (t *aesTransformer) Encode(ctx context.Context, blk blocks.Block) (blocks.Block, error) {
+	c := blk.Cid().Bytes()
+	plain := binary.AppendUvarint(nil, uint64(len(c)))
+	plain = append(plain, c...)
+	plain = append(plain, blk.RawData()...)
+
+	data, err := sealGCM(t.aead, plain)
+	if err != nil {
+		return nil, err
+	}
+	mh, err := multihash.Sum(data, multihash.SHA2_256, -1)
+	if err != nil {
+		return nil, err
+	}
+	return blocks.NewBlockWithCid(data, cid.NewCidV1(cid.Raw, mh))
+}
+
+func (t *aesTransformer) Decode(ctx context.Context, blk blocks.Block) (blocks.Block, error) {
+	plain, err := openGCM(t.aead, blk.RawData())
+	if err != nil {
+		return nil, err
+	}
+	l, n := binary.Uvarint(plain)
+	if n <= 0 || uint64(len(plain)-n) < l {
+		return nil, errors.New("invalid encrypted block")
+	}
+	c, err := cid.Cast(plain[n : n+int(l)])
+	if err != nil {
+		return nil, err
+	}
+	return blocks.NewBlockWithCid(plain[n+int(l):], c)
+}
@@ -0,0 +1,70 @@
+package ipfslite
+
+import (
+	"context"
+	"testing"
+
+	cbor "github.com/ipfs/go-ipld-cbor"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multihash"
+)
+
+func TestTransformedBlocks(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	key := []byte("0123456789abcdef0123456789abcdef")
+	tr, err := NewAESTransformer(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p1 := setupPeer(t, ctx, &Config{BlockTransformer: tr})
+	p2 := setupPeer(t, ctx, &Config{BlockTransformer: tr})
+	err = p2.host.Connect(ctx, peer.AddrInfo{ID: p1.host.ID(), Addrs: p1.host.Addrs()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	node, err := cbor.WrapObject(map[string]string{"secret": "value"}, multihash.SHA2_256, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := p1.AddTransformed(ctx, node)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if has, _ := p1.HasBlock(ctx, node.Cid()); has {
+		t.Error("the original block should not be stored")
+	}
+
+	n, err := p2.GetTransformed(ctx, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !n.Cid().Equals(node.Cid()) {
+		t.Errorf("expected %s, got %s", node.Cid(), n.Cid())
+	}
+	if has, _ := p2.HasBlock(ctx, c); !has {
+		t.Error("the transformed block should have been fetched")
+	}
+
+	other, err := NewAESTransformer([]byte("fedcba9876543210fedcba9876543210"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	blk, err := p2.GetBlock(ctx, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := other.Decode(ctx, blk); err == nil {
+		t.Error("decoding with the wrong key should fail")
+	}
+
+	offline, err := New(ctx, NewInMemoryDatastore(), nil, nil, nil, &Config{Offline: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := offline.AddTransformed(ctx, node); err != errNoBlockTransformer {
+		t.Errorf("expected errNoBlockTransformer, got %v", err)
+	}
+}
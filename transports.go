@@ -0,0 +1,57 @@
+package ipfslite
+
+import (
+	"fmt"
+
+	libp2p "github.com/libp2p/go-libp2p"
+	mplex "github.com/libp2p/go-libp2p-mplex"
+	"github.com/libp2p/go-libp2p/p2p/muxer/yamux"
+	"github.com/libp2p/go-libp2p/p2p/security/noise"
+	tls "github.com/libp2p/go-libp2p/p2p/security/tls"
+)
+
+// Names of the stream multiplexers that can be used in Config.Muxers.
+const (
+	MuxerYamux = "yamux"
+	MuxerMplex = "mplex"
+)
+
+// Names of the security transports that can be used in Config.Security.
+const (
+	SecurityTLS   = "tls"
+	SecurityNoise = "noise"
+)
+
+// muxersOption returns a libp2p option enabling only the given stream
+// multiplexers, in order of preference.
+func muxersOption(names []string) (libp2p.Option, error) {
+	opts := make([]libp2p.Option, 0, len(names))
+	for _, name := range names {
+		switch name {
+		case MuxerYamux:
+			opts = append(opts, libp2p.Muxer(yamux.ID, yamux.DefaultTransport))
+		case MuxerMplex:
+			opts = append(opts, libp2p.Muxer(mplex.ID, mplex.DefaultTransport))
+		default:
+			return nil, fmt.Errorf("unknown muxer: %s", name)
+		}
+	}
+	return libp2p.ChainOptions(opts...), nil
+}
+
+// securityOption returns a libp2p option enabling only the given security
+// transports, in order of preference.
+func securityOption(names []string) (libp2p.Option, error) {
+	opts := make([]libp2p.Option, 0, len(names))
+	for _, name := range names {
+		switch name {
+		case SecurityTLS:
+			opts = append(opts, libp2p.Security(tls.ID, tls.New))
+		case SecurityNoise:
+			opts = append(opts, libp2p.Security(noise.ID, noise.New))
+		default:
+			return nil, fmt.Errorf("unknown security transport: %s", name)
+		}
+	}
+	return libp2p.ChainOptions(opts...), nil
+}
@@ -0,0 +1,60 @@
+package ipfslite
+
+import (
+	"context"
+	"testing"
+
+	dht "github.com/libp2p/go-libp2p-kad-dht"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multiaddr"
+)
+
+func TestMuxersAndSecurity(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cfg := &Config{
+		Muxers:   []string{MuxerMplex},
+		Security: []string{SecurityNoise},
+	}
+	opts, err := cfg.Libp2pOptions()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	listen := multiaddr.StringCast("/ip4/127.0.0.1/tcp/0")
+	var hosts []peer.AddrInfo
+	for i := 0; i < 2; i++ {
+		priv, _, err := crypto.GenerateKeyPair(crypto.Ed25519, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		h, d, err := SetupLibp2p(ctx, priv, nil, []multiaddr.Multiaddr{listen}, nil, dht.ModeClient, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer h.Close()
+		defer d.Close()
+		hosts = append(hosts, peer.AddrInfo{ID: h.ID(), Addrs: h.Addrs()})
+		if i == 1 {
+			err = h.Connect(ctx, hosts[0])
+			if err != nil {
+				t.Fatal(err)
+			}
+			conns := h.Network().ConnsToPeer(hosts[0].ID)
+			if len(conns) == 0 {
+				t.Fatal("not connected")
+			}
+			state := conns[0].ConnState()
+			if state.StreamMultiplexer != "/mplex/6.7.0" || state.Security != "/noise" {
+				t.Errorf("unexpected connection state: %+v", state)
+			}
+		}
+	}
+
+	cfg = &Config{Muxers: []string{"foo"}}
+	if _, err := cfg.Libp2pOptions(); err == nil {
+		t.Error("expected an error for an unknown muxer")
+	}
+}
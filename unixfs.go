@@ -0,0 +1,435 @@
+package ipfslite
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	chunker "github.com/ipfs/boxo/chunker"
+	"github.com/ipfs/boxo/ipld/merkledag"
+	"github.com/ipfs/boxo/ipld/unixfs"
+	"github.com/ipfs/boxo/ipld/unixfs/hamt"
+	ufsio "github.com/ipfs/boxo/ipld/unixfs/io"
+	"github.com/ipfs/boxo/ipld/unixfs/mod"
+	"github.com/ipfs/go-cid"
+	ipld "github.com/ipfs/go-ipld-format"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// unixfsMtimeField is the number of the mtime field of UnixFS 1.5 nodes,
+// which is not known to the UnixFS implementation.
+const unixfsMtimeField = 8
+
+// unixfsModTime returns the modification time recorded in a UnixFS node, if
+// any.
+func unixfsModTime(n *merkledag.ProtoNode) (time.Time, bool) {
+	data := n.Data()
+	for len(data) > 0 {
+		num, typ, l := protowire.ConsumeTag(data)
+		if l < 0 {
+			return time.Time{}, false
+		}
+		data = data[l:]
+		if num == unixfsMtimeField && typ == protowire.BytesType {
+			mtime, l := protowire.ConsumeBytes(data)
+			if l < 0 {
+				return time.Time{}, false
+			}
+			return decodeUnixTime(mtime)
+		}
+		l = protowire.ConsumeFieldValue(num, typ, data)
+		if l < 0 {
+			return time.Time{}, false
+		}
+		data = data[l:]
+	}
+	return time.Time{}, false
+}
+
+// decodeUnixTime decodes a UnixFS UnixTime message: seconds (field 1) and
+// fractional nanoseconds (field 2).
+func decodeUnixTime(data []byte) (time.Time, bool) {
+	var secs int64
+	var nsecs uint32
+	var hasSecs bool
+	for len(data) > 0 {
+		num, typ, l := protowire.ConsumeTag(data)
+		if l < 0 {
+			return time.Time{}, false
+		}
+		data = data[l:]
+		switch {
+		case num == 1 && typ == protowire.VarintType:
+			v, l := protowire.ConsumeVarint(data)
+			if l < 0 {
+				return time.Time{}, false
+			}
+			secs, hasSecs = int64(v), true
+			data = data[l:]
+		case num == 2 && typ == protowire.Fixed32Type:
+			v, l := protowire.ConsumeFixed32(data)
+			if l < 0 {
+				return time.Time{}, false
+			}
+			nsecs = v
+			data = data[l:]
+		default:
+			l = protowire.ConsumeFieldValue(num, typ, data)
+			if l < 0 {
+				return time.Time{}, false
+			}
+			data = data[l:]
+		}
+	}
+	if !hasSecs || nsecs >= 1e9 {
+		return time.Time{}, false
+	}
+	return time.Unix(secs, int64(nsecs)), true
+}
+
+// splitUnixFSPath splits a path relative to a UnixFS directory into its
+// components.
+func splitUnixFSPath(path string) ([]string, error) {
+	path = strings.Trim(path, "/")
+	if path == "" {
+		return nil, errors.New("empty path")
+	}
+	parts := strings.Split(path, "/")
+	for _, part := range parts {
+		if part == "" || part == "." || part == ".." {
+			return nil, fmt.Errorf("invalid path: %s", path)
+		}
+	}
+	return parts, nil
+}
+
+// loadDirectory returns the UnixFS directory for the given node, or a new
+// empty directory if the node is nil.
+func (p *Peer) loadDirectory(n ipld.Node) (ufsio.Directory, error) {
+	if n == nil {
+		return ufsio.NewDirectory(p), nil
+	}
+	return ufsio.NewDirectoryFromNode(p, n)
+}
+
+// storeDirectory adds the node of the given directory to the DAGService.
+func (p *Peer) storeDirectory(ctx context.Context, dir ufsio.Directory) (ipld.Node, error) {
+	n, err := dir.GetNode()
+	if err != nil {
+		return nil, err
+	}
+	return n, p.Add(ctx, n)
+}
+
+// errStopListing stops the enumeration of directory entries.
+var errStopListing = errors.New("stop listing")
+
+// ListDirectory returns the entries of the UnixFS directory dir, skipping
+// the first offset ones and returning at most limit (all the remaining
+// ones when limit is zero or negative). Entries are returned in the same
+// order for a given directory, so that large directories can be listed
+// page by page. For sharded (HAMT) directories, only the shards holding
+// the requested entries and the ones before them are fetched.
+func (p *Peer) ListDirectory(ctx context.Context, dir cid.Cid, offset, limit int) ([]*ipld.Link, error) {
+	n, err := p.Get(ctx, dir)
+	if err != nil {
+		return nil, err
+	}
+	d, err := ufsio.NewDirectoryFromNode(p, n)
+	if err != nil {
+		return nil, err
+	}
+	var links []*ipld.Link
+	i := 0
+	err = d.ForEachLink(ctx, func(l *ipld.Link) error {
+		if i++; i <= offset {
+			return nil
+		}
+		links = append(links, l)
+		if limit > 0 && len(links) == limit {
+			return errStopListing
+		}
+		return nil
+	})
+	if err != nil && err != errStopListing {
+		return nil, err
+	}
+	return links, nil
+}
+
+// LookupEntry returns the link to the entry with the given name in the
+// UnixFS directory dir, or an error wrapping os.ErrNotExist when there is
+// none. The entry itself is not fetched, and for sharded (HAMT)
+// directories only the shards on the way to the entry are.
+func (p *Peer) LookupEntry(ctx context.Context, dir cid.Cid, name string) (*ipld.Link, error) {
+	n, err := p.Get(ctx, dir)
+	if err != nil {
+		return nil, err
+	}
+	pn, ok := n.(*merkledag.ProtoNode)
+	if !ok {
+		return nil, ufsio.ErrNotADir
+	}
+	fsn, err := unixfs.FSNodeFromBytes(pn.Data())
+	if err != nil {
+		return nil, err
+	}
+	var l *ipld.Link
+	switch fsn.Type() {
+	case unixfs.TDirectory:
+		l, err = pn.GetNodeLink(name)
+		if err == merkledag.ErrLinkNotFound {
+			err = os.ErrNotExist
+		}
+	case unixfs.THAMTShard:
+		var shard *hamt.Shard
+		shard, err = hamt.NewHamtFromDag(p, pn)
+		if err == nil {
+			l, err = shard.Find(ctx, name)
+		}
+		if err == nil {
+			// Shard links are named with their hash prefix.
+			l = &ipld.Link{Name: name, Size: l.Size, Cid: l.Cid}
+		}
+	default:
+		return nil, ufsio.ErrNotADir
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return l, nil
+}
+
+// SetAtPath sets the entry at the given path (i.e. "a/b/file.txt") below the
+// UnixFS directory root to the given node, which is added to the
+// DAGService, and returns the CID of the new root. Missing intermediate
+// directories are created and an existing entry is replaced. When root is
+// cid.Undef, a new directory is used as root. Blocks which are not
+// available locally are fetched from the network.
+func (p *Peer) SetAtPath(ctx context.Context, root cid.Cid, path string, node ipld.Node) (cid.Cid, error) {
+	parts, err := splitUnixFSPath(path)
+	if err != nil {
+		return cid.Undef, err
+	}
+	err = p.Add(ctx, node)
+	if err != nil {
+		return cid.Undef, err
+	}
+	return p.updateAtPath(ctx, root, parts, true, func(dir ufsio.Directory, name string) error {
+		return dir.AddChild(ctx, name, node)
+	})
+}
+
+// RemoveAtPath removes the entry at the given path below the UnixFS
+// directory root and returns the CID of the new root.
+func (p *Peer) RemoveAtPath(ctx context.Context, root cid.Cid, path string) (cid.Cid, error) {
+	parts, err := splitUnixFSPath(path)
+	if err != nil {
+		return cid.Undef, err
+	}
+	return p.updateAtPath(ctx, root, parts, false, func(dir ufsio.Directory, name string) error {
+		return dir.RemoveChild(ctx, name)
+	})
+}
+
+// RenameAtPath moves the entry at the given path below the UnixFS directory
+// root to a new path, replacing any existing entry there, and returns the
+// CID of the new root. Missing intermediate directories of the destination
+// are created.
+func (p *Peer) RenameAtPath(ctx context.Context, root cid.Cid, from, to string) (cid.Cid, error) {
+	fromParts, err := splitUnixFSPath(from)
+	if err != nil {
+		return cid.Undef, err
+	}
+	toParts, err := splitUnixFSPath(to)
+	if err != nil {
+		return cid.Undef, err
+	}
+	if len(toParts) > len(fromParts) && strings.Join(toParts[:len(fromParts)], "/") == strings.Join(fromParts, "/") {
+		return cid.Undef, fmt.Errorf("cannot move %s into itself", from)
+	}
+
+	var node ipld.Node
+	newRoot, err := p.updateAtPath(ctx, root, fromParts, false, func(dir ufsio.Directory, name string) error {
+		n, err := dir.Find(ctx, name)
+		if err != nil {
+			return err
+		}
+		node = n
+		return dir.RemoveChild(ctx, name)
+	})
+	if err != nil {
+		return cid.Undef, err
+	}
+	return p.updateAtPath(ctx, newRoot, toParts, true, func(dir ufsio.Directory, name string) error {
+		return dir.AddChild(ctx, name, node)
+	})
+}
+
+// updateAtPath applies fn to the directory containing the last component of
+// the given path below root, and stores the modified directories up to the
+// new root, whose CID is returned. When create is set, missing
+// intermediate directories are created, and a cid.Undef root is
+// considered an empty directory.
+func (p *Peer) updateAtPath(ctx context.Context, root cid.Cid, parts []string, create bool, fn func(dir ufsio.Directory, name string) error) (cid.Cid, error) {
+	var rootNode ipld.Node
+	if root.Defined() {
+		n, err := p.Get(ctx, root)
+		if err != nil {
+			return cid.Undef, err
+		}
+		rootNode = n
+	} else if !create {
+		return cid.Undef, errors.New("undefined root")
+	}
+
+	n, err := p.updateDir(ctx, rootNode, parts, create, fn)
+	if err != nil {
+		return cid.Undef, err
+	}
+	return n.Cid(), nil
+}
+
+func (p *Peer) updateDir(ctx context.Context, dirNode ipld.Node, parts []string, create bool, fn func(dir ufsio.Directory, name string) error) (ipld.Node, error) {
+	dir, err := p.loadDirectory(dirNode)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(parts) == 1 {
+		err = fn(dir, parts[0])
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", parts[0], err)
+		}
+		return p.storeDirectory(ctx, dir)
+	}
+
+	child, err := dir.Find(ctx, parts[0])
+	if create && errors.Is(err, os.ErrNotExist) {
+		child = nil
+	} else if err != nil {
+		return nil, fmt.Errorf("%s: %w", parts[0], err)
+	}
+	child, err = p.updateDir(ctx, child, parts[1:], create, fn)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", parts[0], err)
+	}
+	err = dir.AddChild(ctx, parts[0], child)
+	if err != nil {
+		return nil, err
+	}
+	return p.storeDirectory(ctx, dir)
+}
+
+// AppendFile appends the content of the reader to the UnixFS file with the
+// given CID and returns the root node of the new file. The blocks of the
+// existing content are reused, so that appending to log-style data does not
+// require adding it again. Only the Chunker, RawLeaves and Path parameters
+// are used; new blocks use the CID prefix (i.e. the hash function) of the
+// existing file, and are linked following the trickle layout, which suits
+// files that grow by appends.
+func (p *Peer) AppendFile(ctx context.Context, file cid.Cid, r io.Reader, params *AddParams) (ipld.Node, error) {
+	if p.cfg.ReadOnly {
+		return nil, ErrReadOnly
+	}
+	if params == nil {
+		params = &AddParams{}
+	}
+	dm, err := p.modifyFile(ctx, file, params.Chunker)
+	if err != nil {
+		return nil, err
+	}
+	dm.RawLeaves = params.RawLeaves
+	size, err := dm.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, err
+	}
+	r, err = p.checkAddLimits(r, params, size)
+	if err != nil {
+		return nil, err
+	}
+	_, err = io.Copy(dm, r)
+	if err != nil {
+		return nil, err
+	}
+	n, err := p.storeModifiedFile(ctx, dm)
+	if err != nil {
+		return nil, err
+	}
+	size, _ = dm.Size()
+	p.ingestFile(ctx, IngestFileAdded, n.Cid(), size, params.Path)
+	return n, nil
+}
+
+// TruncateFile truncates the UnixFS file with the given CID to the given
+// size and returns the root node of the new file. The blocks before the
+// truncation point are reused. When size is larger than the file, it is
+// extended with zeros.
+func (p *Peer) TruncateFile(ctx context.Context, file cid.Cid, size int64) (ipld.Node, error) {
+	if p.cfg.ReadOnly {
+		return nil, ErrReadOnly
+	}
+	if size < 0 {
+		return nil, fmt.Errorf("invalid size: %d", size)
+	}
+	if max := p.cfg.MaxFileSize; max > 0 && size > max {
+		return nil, fmt.Errorf("%w: the limit is %d bytes", ErrFileTooLarge, max)
+	}
+	dm, err := p.modifyFile(ctx, file, "")
+	if err != nil {
+		return nil, err
+	}
+	err = dm.Truncate(size)
+	if err != nil {
+		return nil, err
+	}
+	n, err := p.storeModifiedFile(ctx, dm)
+	if err != nil {
+		return nil, err
+	}
+	p.ingestFile(ctx, IngestFileAdded, n.Cid(), size, "")
+	return n, nil
+}
+
+// modifyFile returns a DagModifier for the UnixFS file with the given CID,
+// chunking new content with the given chunker.
+func (p *Peer) modifyFile(ctx context.Context, file cid.Cid, chunkerSpec string) (*mod.DagModifier, error) {
+	// Check the chunker specification once, as the splitter generator
+	// cannot return errors.
+	if _, err := chunker.FromString(nil, chunkerSpec); err != nil {
+		return nil, err
+	}
+	n, err := p.Get(ctx, file)
+	if err != nil {
+		return nil, err
+	}
+	return mod.NewDagModifier(ctx, n, p, func(r io.Reader) chunker.Splitter {
+		spl, _ := chunker.FromString(r, chunkerSpec)
+		return spl
+	})
+}
+
+// storeModifiedFile writes the pending changes of the DagModifier, and
+// provides the new root.
+func (p *Peer) storeModifiedFile(ctx context.Context, dm *mod.DagModifier) (ipld.Node, error) {
+	err := dm.Sync()
+	if err != nil {
+		return nil, err
+	}
+	n, err := dm.GetNode()
+	if err != nil {
+		return nil, err
+	}
+	err = p.Add(ctx, n)
+	if err != nil {
+		return nil, err
+	}
+	if err := p.provide(ctx, n.Cid()); err != nil {
+		return nil, err
+	}
+	return n, nil
+}
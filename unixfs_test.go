@@ -0,0 +1,308 @@
+package ipfslite
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/ipfs/boxo/ipld/merkledag"
+	"github.com/ipfs/boxo/ipld/unixfs"
+	ufsio "github.com/ipfs/boxo/ipld/unixfs/io"
+	"github.com/ipfs/go-cid"
+	ipld "github.com/ipfs/go-ipld-format"
+)
+
+func readAtPath(t *testing.T, ctx context.Context, p *Peer, root cid.Cid, path string) string {
+	t.Helper()
+	n, err := p.Get(ctx, root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, part := range strings.Split(path, "/") {
+		l, _, err := n.ResolveLink([]string{part})
+		if err != nil {
+			t.Fatalf("%s: %s", path, err)
+		}
+		n, err = l.GetNode(ctx, p)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return readAll(t, ctx, p, n.Cid())
+}
+
+func TestSetAtPath(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	p, err := New(ctx, NewInMemoryDatastore(), nil, nil, nil, &Config{Offline: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f1, err := p.AddFile(ctx, strings.NewReader("one"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f2, err := p.AddFile(ctx, strings.NewReader("two"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	root, err := p.SetAtPath(ctx, cid.Undef, "a/b/file.txt", f1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	root2, err := p.SetAtPath(ctx, root, "/a/other.txt", f2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := readAtPath(t, ctx, p, root2, "a/b/file.txt"); got != "one" {
+		t.Errorf("unexpected content %q", got)
+	}
+	if got := readAtPath(t, ctx, p, root2, "a/other.txt"); got != "two" {
+		t.Errorf("unexpected content %q", got)
+	}
+
+	// Replace an existing entry.
+	root3, err := p.SetAtPath(ctx, root2, "a/b/file.txt", f2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := readAtPath(t, ctx, p, root3, "a/b/file.txt"); got != "two" {
+		t.Errorf("unexpected content %q", got)
+	}
+	// The previous root is unchanged.
+	if got := readAtPath(t, ctx, p, root2, "a/b/file.txt"); got != "one" {
+		t.Errorf("unexpected content %q", got)
+	}
+
+	if _, err := p.SetAtPath(ctx, root3, "a/other.txt/x", f1); err == nil {
+		t.Error("expected error when a file is used as a directory")
+	}
+	if _, err := p.SetAtPath(ctx, root3, "a/../x", f1); err == nil {
+		t.Error("expected error for invalid path")
+	}
+}
+
+func TestRemoveAndRenameAtPath(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	p, err := New(ctx, NewInMemoryDatastore(), nil, nil, nil, &Config{Offline: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := p.AddFile(ctx, strings.NewReader("content"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	root, err := p.SetAtPath(ctx, cid.Undef, "a/b/file.txt", f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	root, err = p.SetAtPath(ctx, root, "a/keep.txt", f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	renamed, err := p.RenameAtPath(ctx, root, "a/b/file.txt", "c/moved.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := readAtPath(t, ctx, p, renamed, "c/moved.txt"); got != "content" {
+		t.Errorf("unexpected content %q", got)
+	}
+	if _, err := p.RemoveAtPath(ctx, renamed, "a/b/file.txt"); err == nil {
+		t.Error("expected error removing the old path")
+	}
+
+	removed, err := p.RemoveAtPath(ctx, renamed, "a/b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := readAtPath(t, ctx, p, removed, "a/keep.txt"); got != "content" {
+		t.Errorf("unexpected content %q", got)
+	}
+	n, err := p.Get(ctx, removed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, _, err := n.ResolveLink([]string{"a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, err := l.GetNode(ctx, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := a.ResolveLink([]string{"b"}); err == nil {
+		t.Error("a/b should have been removed")
+	}
+
+	if _, err := p.RenameAtPath(ctx, root, "a", "a/b/c"); err == nil {
+		t.Error("expected error moving a directory into itself")
+	}
+	if _, err := p.RemoveAtPath(ctx, root, "missing/x"); err == nil {
+		t.Error("expected error removing a missing path")
+	}
+}
+
+func TestListDirectory(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	p, err := New(ctx, NewInMemoryDatastore(), nil, nil, nil, &Config{Offline: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := p.AddFile(ctx, strings.NewReader("content"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, sharded := range []bool{false, true} {
+		if sharded {
+			// Force sharding of the directory.
+			defer func(size int) { ufsio.HAMTShardingSize = size }(ufsio.HAMTShardingSize)
+			ufsio.HAMTShardingSize = 1
+		}
+		root := cid.Undef
+		for i := 0; i < 50; i++ {
+			root, err = p.SetAtPath(ctx, root, fmt.Sprintf("file%02d", i), f)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		n, err := p.Get(ctx, root)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fsn, err := unixfs.ExtractFSNode(n.(*merkledag.ProtoNode))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if (fsn.Type() == unixfs.THAMTShard) != sharded {
+			t.Fatalf("unexpected directory type %s", fsn.Type())
+		}
+
+		all, err := p.ListDirectory(ctx, root, 0, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(all) != 50 {
+			t.Fatalf("expected 50 entries, got %d", len(all))
+		}
+		var paged []*ipld.Link
+		for offset := 0; ; offset += 15 {
+			page, err := p.ListDirectory(ctx, root, offset, 15)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(page) == 0 {
+				break
+			}
+			paged = append(paged, page...)
+		}
+		if len(paged) != len(all) {
+			t.Fatalf("expected %d paged entries, got %d", len(all), len(paged))
+		}
+		for i := range all {
+			if paged[i].Name != all[i].Name {
+				t.Errorf("entry %d: %s != %s", i, paged[i].Name, all[i].Name)
+			}
+		}
+
+		l, err := p.LookupEntry(ctx, root, "file42")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if l.Name != "file42" || !l.Cid.Equals(f.Cid()) {
+			t.Errorf("wrong entry %s", l.Name)
+		}
+		_, err = p.LookupEntry(ctx, root, "missing")
+		if !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("expected a not exist error, got %v", err)
+		}
+	}
+}
+
+func countBlocks(t *testing.T, ctx context.Context, p *Peer) int {
+	t.Helper()
+	keys, err := p.BlockStore().AllKeysChan(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	count := 0
+	for range keys {
+		count++
+	}
+	return count
+}
+
+func TestAppendTruncateFile(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	p, err := New(ctx, NewInMemoryDatastore(), nil, nil, nil, &Config{Offline: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	content := make([]byte, 10*1024)
+	rand.Read(content)
+	params := &AddParams{Chunker: "size-1024", RawLeaves: true}
+	f, err := p.AddFile(ctx, bytes.NewReader(content), params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := countBlocks(t, ctx, p)
+
+	appended, err := p.AppendFile(ctx, f.Cid(), strings.NewReader("more lines\n"), params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := readAll(t, ctx, p, appended.Cid()); got != string(content)+"more lines\n" {
+		t.Error("unexpected appended content")
+	}
+	// The leaves of the original content are reused.
+	if added := countBlocks(t, ctx, p) - before; added > 3 {
+		t.Errorf("expected few new blocks, got %d", added)
+	}
+
+	var added []IngestEvent
+	p.AddIngestHook(func(ctx context.Context, ev IngestEvent) {
+		if ev.Kind == IngestFileAdded {
+			added = append(added, ev)
+		}
+	})
+	truncated, err := p.TruncateFile(ctx, appended.Cid(), 5000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := readAll(t, ctx, p, truncated.Cid()); got != string(content[:5000]) {
+		t.Error("unexpected truncated content")
+	}
+	if len(added) != 1 || !added[0].Cid.Equals(truncated.Cid()) || added[0].Size != 5000 {
+		t.Errorf("unexpected file events: %+v", added)
+	}
+}
+
+func readAll(t *testing.T, ctx context.Context, p *Peer, c cid.Cid) string {
+	t.Helper()
+	r, err := p.GetFile(ctx, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	data, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
@@ -0,0 +1,288 @@
+package ipfslite
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ipfs/boxo/ipld/merkledag"
+	"github.com/ipfs/boxo/ipld/unixfs"
+	ufsio "github.com/ipfs/boxo/ipld/unixfs/io"
+	"github.com/ipfs/go-cid"
+	ipld "github.com/ipfs/go-ipld-format"
+)
+
+// ReadOnlyFS is a read-only view of a pinned UnixFS directory as an
+// io/fs file system, backed by seekable UnixFS readers. It can be handed to
+// anything consuming an fs.FS (i.e. http.FS), and is mounted with FUSE by
+// the Mount helper of the optional github.com/dcnetio/ipfs-lite/fuse module,
+// so that existing tools can browse the content. Files also implement
+// io.Seeker and io.ReaderAt.
+type ReadOnlyFS struct {
+	p    *Peer
+	ctx  context.Context
+	root cid.Cid
+}
+
+// ReadOnlyFS returns a file system exposing the UnixFS directory root,
+// which must be pinned recursively, so that its content is read from the
+// local blockstore. The context bounds all the operations on the file
+// system.
+func (p *Peer) ReadOnlyFS(ctx context.Context, root cid.Cid) (*ReadOnlyFS, error) {
+	pinned, err := p.IsPinned(ctx, root)
+	if err != nil {
+		return nil, err
+	}
+	if !pinned {
+		return nil, fmt.Errorf("%s is not pinned", root)
+	}
+	return &ReadOnlyFS{p: p, ctx: ctx, root: root}, nil
+}
+
+// resolve returns the node at the given valid path.
+func (fsys *ReadOnlyFS) resolve(op, name string) (ipld.Node, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	c := fsys.root
+	if name != "." {
+		for _, elem := range strings.Split(name, "/") {
+			l, err := fsys.p.LookupEntry(fsys.ctx, c, elem)
+			if err != nil {
+				if !errors.Is(err, fs.ErrNotExist) {
+					err = fmt.Errorf("%w: %s", fs.ErrNotExist, err)
+				}
+				return nil, &fs.PathError{Op: op, Path: name, Err: err}
+			}
+			c = l.Cid
+		}
+	}
+	n, err := fsys.p.Get(fsys.ctx, c)
+	if err != nil {
+		return nil, &fs.PathError{Op: op, Path: name, Err: err}
+	}
+	return n, nil
+}
+
+// Open opens the named file or directory.
+func (fsys *ReadOnlyFS) Open(name string) (fs.File, error) {
+	n, err := fsys.resolve("open", name)
+	if err != nil {
+		return nil, err
+	}
+	info, err := newUnixFSFileInfo(path.Base(name), n)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	if info.IsDir() {
+		return &readOnlyDir{fsys: fsys, info: info, c: n.Cid(), path: name}, nil
+	}
+	if info.mode&fs.ModeSymlink != 0 {
+		return &readOnlyFile{info: info, r: strings.NewReader(info.target)}, nil
+	}
+	dr, err := ufsio.NewDagReader(fsys.ctx, n, fsys.p)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	return &readOnlyFile{info: info, r: dr, closer: dr}, nil
+}
+
+// Stat returns the description of the named file or directory.
+func (fsys *ReadOnlyFS) Stat(name string) (fs.FileInfo, error) {
+	n, err := fsys.resolve("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	info, err := newUnixFSFileInfo(path.Base(name), n)
+	if err != nil {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: err}
+	}
+	return info, nil
+}
+
+// ReadDir returns the entries of the named directory, sorted by name.
+func (fsys *ReadOnlyFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	n, err := fsys.resolve("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := fsys.readDir(n.Cid())
+	if err != nil {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: err}
+	}
+	return entries, nil
+}
+
+func (fsys *ReadOnlyFS) readDir(dir cid.Cid) ([]fs.DirEntry, error) {
+	links, err := fsys.p.ListDirectory(fsys.ctx, dir, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]fs.DirEntry, 0, len(links))
+	for _, l := range links {
+		n, err := fsys.p.Get(fsys.ctx, l.Cid)
+		if err != nil {
+			return nil, err
+		}
+		info, err := newUnixFSFileInfo(l.Name, n)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, fs.FileInfoToDirEntry(info))
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name() < entries[j].Name()
+	})
+	return entries, nil
+}
+
+// unixFSFileInfo describes a UnixFS node.
+type unixFSFileInfo struct {
+	name    string
+	size    int64
+	mode    fs.FileMode
+	modTime time.Time
+	// target is the target of symbolic links.
+	target string
+}
+
+func newUnixFSFileInfo(name string, n ipld.Node) (*unixFSFileInfo, error) {
+	info := &unixFSFileInfo{name: name, mode: 0444}
+	switch n := n.(type) {
+	case *merkledag.RawNode:
+		info.size = int64(len(n.RawData()))
+	case *merkledag.ProtoNode:
+		fsn, err := unixfs.FSNodeFromBytes(n.Data())
+		if err != nil {
+			return nil, err
+		}
+		switch fsn.Type() {
+		case unixfs.TDirectory, unixfs.THAMTShard:
+			info.mode = fs.ModeDir | 0555
+		case unixfs.TSymlink:
+			info.mode = fs.ModeSymlink | 0444
+			info.target = string(fsn.Data())
+			info.size = int64(len(info.target))
+		case unixfs.TFile, unixfs.TRaw:
+			info.size = int64(fsn.FileSize())
+		default:
+			return nil, fmt.Errorf("unsupported UnixFS node type: %s", fsn.Type())
+		}
+		info.modTime, _ = unixfsModTime(n)
+	default:
+		return nil, fmt.Errorf("%s is not a UnixFS node", n.Cid())
+	}
+	return info, nil
+}
+
+func (fi *unixFSFileInfo) Name() string       { return fi.name }
+func (fi *unixFSFileInfo) Size() int64        { return fi.size }
+func (fi *unixFSFileInfo) Mode() fs.FileMode  { return fi.mode }
+func (fi *unixFSFileInfo) ModTime() time.Time { return fi.modTime }
+func (fi *unixFSFileInfo) IsDir() bool        { return fi.mode.IsDir() }
+func (fi *unixFSFileInfo) Sys() interface{}   { return nil }
+
+// readOnlyFile is a file of a ReadOnlyFS.
+type readOnlyFile struct {
+	info   *unixFSFileInfo
+	mu     sync.Mutex
+	r      io.ReadSeeker
+	closer io.Closer
+}
+
+func (f *readOnlyFile) Stat() (fs.FileInfo, error) {
+	return f.info, nil
+}
+
+func (f *readOnlyFile) Read(b []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.r.Read(b)
+}
+
+func (f *readOnlyFile) Seek(offset int64, whence int) (int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.r.Seek(offset, whence)
+}
+
+// ReadAt reads at the given offset, without changing the offset used by
+// Read.
+func (f *readOnlyFile) ReadAt(b []byte, off int64) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	cur, err := f.r.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, err
+	}
+	defer f.r.Seek(cur, io.SeekStart)
+	if _, err := f.r.Seek(off, io.SeekStart); err != nil {
+		return 0, err
+	}
+	n, err := io.ReadFull(f.r, b)
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+	return n, err
+}
+
+func (f *readOnlyFile) Close() error {
+	if f.closer == nil {
+		return nil
+	}
+	return f.closer.Close()
+}
+
+// readOnlyDir is a directory of a ReadOnlyFS.
+type readOnlyDir struct {
+	fsys    *ReadOnlyFS
+	info    *unixFSFileInfo
+	c       cid.Cid
+	path    string
+	entries []fs.DirEntry
+	loaded  bool
+}
+
+func (d *readOnlyDir) Stat() (fs.FileInfo, error) {
+	return d.info, nil
+}
+
+func (d *readOnlyDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.path, Err: errors.New("is a directory")}
+}
+
+func (d *readOnlyDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	if !d.loaded {
+		entries, err := d.fsys.readDir(d.c)
+		if err != nil {
+			return nil, &fs.PathError{Op: "readdir", Path: d.path, Err: err}
+		}
+		d.entries = entries
+		d.loaded = true
+	}
+	if n <= 0 {
+		entries := d.entries
+		d.entries = nil
+		return entries, nil
+	}
+	if len(d.entries) == 0 {
+		return nil, io.EOF
+	}
+	if n > len(d.entries) {
+		n = len(d.entries)
+	}
+	entries := d.entries[:n]
+	d.entries = d.entries[n:]
+	return entries, nil
+}
+
+func (d *readOnlyDir) Close() error {
+	return nil
+}
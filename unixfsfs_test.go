@@ -0,0 +1,73 @@
+package ipfslite
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+
+	"github.com/ipfs/go-cid"
+)
+
+func TestReadOnlyFS(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	p, err := New(ctx, NewInMemoryDatastore(), nil, nil, nil, &Config{Offline: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "a.txt"), []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "sub", "b.txt"), []byte("hello world"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	root, err := p.Sync(ctx, dir, cid.Undef)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := p.ReadOnlyFS(ctx, root); err == nil {
+		t.Error("roots which are not pinned should be rejected")
+	}
+	if err := p.Pin(ctx, root, true); err != nil {
+		t.Fatal(err)
+	}
+	fsys, err := p.ReadOnlyFS(ctx, root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fstest.TestFS(fsys, "a.txt", "sub/b.txt"); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := fs.ReadFile(fsys, "sub/b.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "hello world" {
+		t.Errorf("unexpected content: %q", data)
+	}
+	f, err := fsys.Open("sub/b.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	buf := make([]byte, 5)
+	if _, err := f.(io.ReaderAt).ReadAt(buf, 6); err != nil || string(buf) != "world" {
+		t.Errorf("unexpected ReadAt result: %q, %v", buf, err)
+	}
+	if _, err := fsys.Open("missing"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected fs.ErrNotExist, got %v", err)
+	}
+}
@@ -2,6 +2,8 @@ package ipfslite
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"time"
 
 	ipns "github.com/ipfs/boxo/ipns"
@@ -20,6 +22,7 @@ import (
 	"github.com/libp2p/go-libp2p/p2p/transport/tcp"
 	"github.com/libp2p/go-libp2p/p2p/transport/websocket"
 	"github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
 )
 
 // DefaultBootstrapPeers returns the default bootstrap peers (for use
@@ -41,7 +44,7 @@ var connMgr, _ = connmgr.NewConnManager(100, 600, connmgr.WithGracePeriod(time.M
 // to create a fully featured libp2p host. It can be used with
 // SetupLibp2p.
 var Libp2pOptionsExtra = []libp2p.Option{
-	libp2p.NATPortMap(),
+	NATPortMap(),
 	libp2p.ConnectionManager(connMgr),
 	libp2p.EnableAutoRelayWithPeerSource(func(_ context.Context, num int) <-chan peer.AddrInfo {
 		peerChan := make(chan peer.AddrInfo, num)
@@ -56,11 +59,72 @@ var Libp2pOptionsExtra = []libp2p.Option{
 	libp2p.EnableNATService(),
 }
 
+// Libp2pOptions returns the libp2p options corresponding to the host-related
+// settings in the Config. They can be passed to SetupLibp2p along with any
+// other options.
+func (cfg *Config) Libp2pOptions() ([]libp2p.Option, error) {
+	var opts []libp2p.Option
+
+	if len(cfg.Announce) > 0 || len(cfg.AppendAnnounce) > 0 || len(cfg.NoAnnounce) > 0 {
+		factory, err := newAnnounceFactory(cfg.Announce, cfg.AppendAnnounce, cfg.NoAnnounce)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, libp2p.AddrsFactory(factory))
+	}
+
+	if len(cfg.Muxers) > 0 {
+		opt, err := muxersOption(cfg.Muxers)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, opt)
+	}
+
+	if len(cfg.Security) > 0 {
+		opt, err := securityOption(cfg.Security)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, opt)
+	}
+
+	if cfg.UserAgent != "" {
+		opts = append(opts, libp2p.UserAgent(cfg.UserAgent))
+	}
+	if cfg.ProtocolVersion != "" {
+		opts = append(opts, libp2p.ProtocolVersion(cfg.ProtocolVersion))
+	}
+
+	if cfg.PeerstoreMaxAddrTTL > 0 {
+		ps, err := newTTLCappedPeerstore(cfg.PeerstoreMaxAddrTTL)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, libp2p.Peerstore(ps))
+	}
+
+	if cfg.BandwidthReporter != nil {
+		opts = append(opts, libp2p.BandwidthReporter(cfg.BandwidthReporter))
+	}
+
+	dialOpts, err := cfg.dialOptions()
+	if err != nil {
+		return nil, err
+	}
+	opts = append(opts, dialOpts...)
+	return opts, nil
+}
+
 // SetupLibp2p returns a routed host and DHT instances that can be used to
 // easily create a ipfslite Peer. You may consider to use Peer.Bootstrap()
 // after creating the IPFS-Lite Peer to connect to other peers. When the
 // datastore parameter is nil, the DHT will use an in-memory datastore, so all
-// provider records are lost on program shutdown.
+// provider records are lost on program shutdown. When the same datastore
+// backs the Peer, it can be wrapped with NamespacedDatastore(ds,
+// DHTNamespace) to avoid key collisions. In DHT server mode, DHTDatastore
+// can be used to store the provider records of other peers separately,
+// within size and time bounds.
 //
 // Additional libp2p options can be passed. Note that the Identity,
 // ListenAddrs and PrivateNetwork options will be setup automatically.
@@ -78,8 +142,104 @@ func SetupLibp2p(
 	dhtMode dht.ModeOpt,
 	opts ...libp2p.Option,
 ) (host.Host, *dualdht.DHT, error) {
+	return setupDualLibp2p(ctx, hostKey, secret, listenAddrs, ds, dhtMode, false, nil, opts...)
+}
+
+// SetupIsolatedLibp2p is like SetupLibp2p, for isolated swarms which must
+// never touch the public IPFS network. The WAN half of the dual DHT is
+// disabled, and the LAN half accepts any peer, whatever its addresses, so
+// that the DHT only spans the peers of the swarm. Peers should be joined
+// with Peer.Bootstrap, using the swarm's own bootstrap peers, with
+// Config.Isolated set. Libp2pOptionsExtra should not be used, as it
+// relies on the public bootstrap peers for relays.
+func SetupIsolatedLibp2p(
+	ctx context.Context,
+	hostKey crypto.PrivKey,
+	secret pnet.PSK,
+	listenAddrs []multiaddr.Multiaddr,
+	ds datastore.Batching,
+	dhtMode dht.ModeOpt,
+	opts ...libp2p.Option,
+) (host.Host, *dualdht.DHT, error) {
+	return setupDualLibp2p(ctx, hostKey, secret, listenAddrs, ds, dhtMode, true, nil, opts...)
+}
+
+// SetupLibp2p is like the SetupLibp2p function, but the host is set up
+// with the Config's host options (see Libp2pOptions) in addition to the
+// given ones, and the DHT is the one selected by Config.DHT, in an
+// isolated swarm when Config.Isolated is set (see SetupIsolatedLibp2p).
+// With Config.NamespacedDatastore, the DHT stores its records under
+// DHTNamespace, so ds can be the datastore given to New. The returned
+// routing can be given to New along with the host.
+func (cfg *Config) SetupLibp2p(
+	ctx context.Context,
+	hostKey crypto.PrivKey,
+	secret pnet.PSK,
+	listenAddrs []multiaddr.Multiaddr,
+	ds datastore.Batching,
+	dhtMode dht.ModeOpt,
+	opts ...libp2p.Option,
+) (host.Host, routing.Routing, error) {
+	cfgOpts, err := cfg.Libp2pOptions()
+	if err != nil {
+		return nil, nil, err
+	}
+	opts = append(cfgOpts, opts...)
+	ds = cfg.dhtDatastore(ds)
+
+	dhtOpts, err := cfg.dhtOptions()
+	if err != nil {
+		return nil, nil, err
+	}
 
+	switch cfg.DHT {
+	case DHTDual:
+		return setupDualLibp2p(ctx, hostKey, secret, listenAddrs, ds, dhtMode, cfg.Isolated, dhtOpts, opts...)
+	case DHTLANOnly, DHTWANOnly:
+		if cfg.DHT == DHTWANOnly && cfg.Isolated {
+			return nil, nil, errors.New("an isolated swarm cannot use the WAN DHT only")
+		}
+		return setupLibp2p(ctx, hostKey, secret, listenAddrs, func(h host.Host) (routing.Routing, error) {
+			return newSingleDHT(ctx, h, ds, dhtMode, cfg.DHT == DHTLANOnly, cfg.Isolated, dhtOpts)
+		}, opts...)
+	default:
+		return nil, nil, fmt.Errorf("unknown DHT type: %d", cfg.DHT)
+	}
+}
+
+func setupDualLibp2p(
+	ctx context.Context,
+	hostKey crypto.PrivKey,
+	secret pnet.PSK,
+	listenAddrs []multiaddr.Multiaddr,
+	ds datastore.Batching,
+	dhtMode dht.ModeOpt,
+	isolated bool,
+	dhtOpts []dht.Option,
+	opts ...libp2p.Option,
+) (host.Host, *dualdht.DHT, error) {
 	var ddht *dualdht.DHT
+	h, _, err := setupLibp2p(ctx, hostKey, secret, listenAddrs, func(h host.Host) (routing.Routing, error) {
+		var err error
+		ddht, err = newDHT(ctx, h, ds, dhtMode, isolated, dhtOpts)
+		return ddht, err
+	}, opts...)
+	if err != nil {
+		return nil, nil, err
+	}
+	return h, ddht, nil
+}
+
+func setupLibp2p(
+	ctx context.Context,
+	hostKey crypto.PrivKey,
+	secret pnet.PSK,
+	listenAddrs []multiaddr.Multiaddr,
+	newRouting func(h host.Host) (routing.Routing, error),
+	opts ...libp2p.Option,
+) (host.Host, routing.Routing, error) {
+
+	var r routing.Routing
 	var err error
 	var transports = libp2p.DefaultTransports
 
@@ -97,8 +257,8 @@ func SetupLibp2p(
 		libp2p.PrivateNetwork(secret),
 		transports,
 		libp2p.Routing(func(h host.Host) (routing.PeerRouting, error) {
-			ddht, err = newDHT(ctx, h, ds, dhtMode)
-			return ddht, err
+			r, err = newRouting(h)
+			return r, err
 		}),
 	}
 	finalOpts = append(finalOpts, opts...)
@@ -110,15 +270,24 @@ func SetupLibp2p(
 		return nil, nil, err
 	}
 
-	return h, ddht, nil
+	return h, r, nil
+}
+
+func dhtValidatorOptions(h host.Host) []dht.Option {
+	return []dht.Option{
+		dht.NamespacedValidator("pk", record.PublicKeyValidator{}),
+		dht.NamespacedValidator("ipns", ipns.Validator{KeyBook: h.Peerstore()}),
+	}
 }
 
-func newDHT(ctx context.Context, h host.Host, ds datastore.Batching, dhtMode dht.ModeOpt) (*dualdht.DHT, error) {
+func newDHT(ctx context.Context, h host.Host, ds datastore.Batching, dhtMode dht.ModeOpt, isolated bool, extra []dht.Option) (*dualdht.DHT, error) {
 	dhtOpts := []dualdht.Option{
-		dualdht.DHTOption(dht.NamespacedValidator("pk", record.PublicKeyValidator{})),
-		dualdht.DHTOption(dht.NamespacedValidator("ipns", ipns.Validator{KeyBook: h.Peerstore()})),
-		dualdht.DHTOption(dht.Concurrency(10)),
+		dualdht.DHTOption(dhtValidatorOptions(h)...),
 		dualdht.DHTOption(dht.Mode(dhtMode)),
+		dualdht.DHTOption(extra...),
+	}
+	if isolated {
+		dhtOpts = append(dhtOpts, isolatedDHTOptions(dhtMode)...)
 	}
 	if ds != nil {
 		dhtOpts = append(dhtOpts, dualdht.DHTOption(dht.Datastore(ds)))
@@ -126,3 +295,82 @@ func newDHT(ctx context.Context, h host.Host, ds datastore.Batching, dhtMode dht
 	return dualdht.New(ctx, h, dhtOpts...)
 
 }
+
+// newSingleDHT returns a DHT configured like the LAN or the WAN half of the
+// dual DHT, so that it interoperates with the corresponding half of other
+// peers.
+func newSingleDHT(ctx context.Context, h host.Host, ds datastore.Batching, dhtMode dht.ModeOpt, lan, isolated bool, extra []dht.Option) (*dht.IpfsDHT, error) {
+	if lan && dhtMode != dht.ModeClient {
+		// Like the LAN half of the dual DHT.
+		dhtMode = dht.ModeServer
+	}
+	dhtOpts := append(dhtValidatorOptions(h), dht.Mode(dhtMode))
+	switch {
+	case lan && isolated:
+		dhtOpts = append(dhtOpts, dht.ProtocolExtension(dualdht.LanExtension))
+	case lan:
+		dhtOpts = append(dhtOpts,
+			dht.ProtocolExtension(dualdht.LanExtension),
+			dht.QueryFilter(dht.PrivateQueryFilter),
+			dht.RoutingTableFilter(dht.PrivateRoutingTableFilter),
+			dht.AddressFilter(func(addrs []multiaddr.Multiaddr) []multiaddr.Multiaddr {
+				return multiaddr.FilterAddrs(addrs, func(a multiaddr.Multiaddr) bool { return !manet.IsIPLoopback(a) })
+			}),
+		)
+	default:
+		dhtOpts = append(dhtOpts,
+			dht.QueryFilter(dht.PublicQueryFilter),
+			dht.RoutingTableFilter(dht.PublicRoutingTableFilter),
+			dht.AddressFilter(func(addrs []multiaddr.Multiaddr) []multiaddr.Multiaddr {
+				return multiaddr.FilterAddrs(addrs, manet.IsPublicAddr)
+			}),
+		)
+	}
+	dhtOpts = append(dhtOpts, extra...)
+	if ds != nil {
+		dhtOpts = append(dhtOpts, dht.Datastore(ds))
+	}
+	return dht.New(ctx, h, dhtOpts...)
+}
+
+// dhtOptions returns the DHT options corresponding to the DHT tuning
+// settings in the Config.
+func (cfg *Config) dhtOptions() ([]dht.Option, error) {
+	if cfg.DHTConcurrency < 0 || cfg.DHTResiliency < 0 || cfg.DHTRefreshInterval < 0 {
+		return nil, errors.New("the DHT concurrency, resiliency and refresh interval cannot be negative")
+	}
+	concurrency := cfg.DHTConcurrency
+	if concurrency == 0 {
+		concurrency = 10
+	}
+	opts := []dht.Option{dht.Concurrency(concurrency)}
+	if cfg.DHTResiliency > 0 {
+		opts = append(opts, dht.Resiliency(cfg.DHTResiliency))
+	}
+	if cfg.DHTRefreshInterval > 0 {
+		opts = append(opts, dht.RoutingTableRefreshPeriod(cfg.DHTRefreshInterval))
+	}
+	return opts, nil
+}
+
+// isolatedDHTOptions disable the WAN DHT, by keeping its routing table
+// empty, and let the LAN DHT use all peers.
+func isolatedDHTOptions(dhtMode dht.ModeOpt) []dualdht.Option {
+	lanMode := dht.ModeServer
+	if dhtMode == dht.ModeClient {
+		lanMode = dht.ModeClient
+	}
+	return []dualdht.Option{
+		dualdht.WanDHTOption(
+			dht.Mode(dht.ModeClient),
+			dht.QueryFilter(func(interface{}, peer.AddrInfo) bool { return false }),
+			dht.RoutingTableFilter(func(interface{}, peer.ID) bool { return false }),
+		),
+		dualdht.LanDHTOption(
+			dht.Mode(lanMode),
+			dht.QueryFilter(func(interface{}, peer.AddrInfo) bool { return true }),
+			dht.RoutingTableFilter(func(interface{}, peer.ID) bool { return true }),
+			dht.AddressFilter(nil),
+		),
+	}
+}
@@ -0,0 +1,163 @@
+package ipfslite
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	dht "github.com/libp2p/go-libp2p-kad-dht"
+	dualdht "github.com/libp2p/go-libp2p-kad-dht/dual"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multiaddr"
+)
+
+func setupIsolatedHost(t *testing.T, ctx context.Context) (host.Host, *dualdht.DHT) {
+	priv, _, err := crypto.GenerateKeyPair(crypto.Ed25519, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	listen := multiaddr.StringCast("/ip4/127.0.0.1/tcp/0")
+	h, d, err := SetupIsolatedLibp2p(ctx, priv, nil, []multiaddr.Multiaddr{listen}, nil, dht.ModeServer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		d.Close()
+		h.Close()
+	})
+	return h, d
+}
+
+func TestIsolatedSwarm(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	h1, d1 := setupIsolatedHost(t, ctx)
+	h2, _ := setupIsolatedHost(t, ctx)
+
+	p1, err := New(ctx, NewInMemoryDatastore(), nil, h1, d1, &Config{Isolated: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	peers := append(DefaultBootstrapPeers(), peer.AddrInfo{ID: h2.ID(), Addrs: h2.Addrs()})
+	p1.Bootstrap(peers)
+
+	// The LAN DHT accepts the loopback peer, the WAN DHT accepts nobody.
+	deadline := time.Now().Add(5 * time.Second)
+	for d1.LAN.RoutingTable().Size() == 0 && time.Now().Before(deadline) {
+		time.Sleep(50 * time.Millisecond)
+	}
+	if d1.LAN.RoutingTable().Size() != 1 {
+		t.Errorf("expected one peer in the LAN DHT, got %d", d1.LAN.RoutingTable().Size())
+	}
+	if d1.WAN.RoutingTable().Size() != 0 {
+		t.Errorf("expected an empty WAN DHT, got %d peers", d1.WAN.RoutingTable().Size())
+	}
+	for _, pi := range DefaultBootstrapPeers() {
+		if len(h1.Network().ConnsToPeer(pi.ID)) > 0 {
+			t.Errorf("connected to public bootstrap peer %s", pi.ID)
+		}
+	}
+}
+
+func TestConfigSetupLibp2pDHT(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	priv, _, err := crypto.GenerateKeyPair(crypto.Ed25519, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	listen := []multiaddr.Multiaddr{multiaddr.StringCast("/ip4/127.0.0.1/tcp/0")}
+
+	for _, typ := range []DHTType{DHTDual, DHTLANOnly, DHTWANOnly} {
+		cfg := &Config{DHT: typ}
+		h, r, err := cfg.SetupLibp2p(ctx, priv, nil, listen, nil, dht.ModeServer)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, isDual := r.(*dualdht.DHT)
+		_, isSingle := r.(*dht.IpfsDHT)
+		if isDual != (typ == DHTDual) || isSingle != (typ != DHTDual) {
+			t.Errorf("unexpected routing %T for DHT type %d", r, typ)
+		}
+		if typ == DHTLANOnly && !hasProtocol(h, "/ipfs/lan/kad/1.0.0") {
+			t.Error("LAN DHT protocol not registered")
+		}
+		r.(interface{ Close() error }).Close()
+		h.Close()
+	}
+
+	cfg := &Config{DHT: DHTWANOnly, Isolated: true}
+	if _, _, err := cfg.SetupLibp2p(ctx, priv, nil, listen, nil, dht.ModeServer); err == nil {
+		t.Error("expected an error for an isolated WAN DHT")
+	}
+}
+
+func TestConfigSetupLibp2pDHTTuning(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	priv, _, err := crypto.GenerateKeyPair(crypto.Ed25519, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	listen := []multiaddr.Multiaddr{multiaddr.StringCast("/ip4/127.0.0.1/tcp/0")}
+
+	for _, typ := range []DHTType{DHTDual, DHTWANOnly} {
+		cfg := &Config{DHT: typ, DHTConcurrency: 3, DHTResiliency: 2, DHTRefreshInterval: time.Hour}
+		h, r, err := cfg.SetupLibp2p(ctx, priv, nil, listen, nil, dht.ModeServer)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.(interface{ Close() error }).Close()
+		h.Close()
+	}
+
+	cfg := &Config{DHTConcurrency: -1}
+	if _, _, err := cfg.SetupLibp2p(ctx, priv, nil, listen, nil, dht.ModeServer); err == nil {
+		t.Error("expected an error for a negative DHT concurrency")
+	}
+}
+
+func TestConfigSetupLibp2pIdentify(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	priv, _, err := crypto.GenerateKeyPair(crypto.Ed25519, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	listen := []multiaddr.Multiaddr{multiaddr.StringCast("/ip4/127.0.0.1/tcp/0")}
+	cfg := &Config{UserAgent: "myapp/1.2.3 ipfs-lite", ProtocolVersion: "myapp/1.0.0"}
+	h, r, err := cfg.SetupLibp2p(ctx, priv, nil, listen, nil, dht.ModeServer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+	defer r.(interface{ Close() error }).Close()
+
+	other, _ := setupHost(t, ctx)
+	if err := other.Connect(ctx, peer.AddrInfo{ID: h.ID(), Addrs: h.Addrs()}); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		agent, _ := other.Peerstore().Get(h.ID(), "AgentVersion")
+		version, _ := other.Peerstore().Get(h.ID(), "ProtocolVersion")
+		if agent == cfg.UserAgent && version == cfg.ProtocolVersion {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("identify announced %v and %v", agent, version)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func hasProtocol(h host.Host, proto string) bool {
+	for _, p := range h.Mux().Protocols() {
+		if string(p) == proto {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,173 @@
+package ipfslite
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+
+	"github.com/ipfs/go-cid"
+	ipld "github.com/ipfs/go-ipld-format"
+	"golang.org/x/sync/errgroup"
+)
+
+// SkipLinks can be returned by a WalkFunc to skip the links of the node
+// being visited. It does not stop the walk.
+var SkipLinks = errors.New("skip links")
+
+// WalkFunc is called by Walk for every node in a DAG. The path is made of
+// the link names (or link indexes, for unnamed links) from the root to the
+// node, separated by "/", and is empty for the root. Returning an error
+// other than SkipLinks stops the walk.
+type WalkFunc func(n ipld.Node, path string) error
+
+// WalkOption configures Walk.
+type WalkOption func(*walkOptions)
+
+type walkOptions struct {
+	concurrency int
+	maxDepth    int
+	fetch       []FetchOption
+}
+
+// WithWalkConcurrency sets how many nodes are fetched and visited in
+// parallel. Defaults to 1, in which case nodes are visited in depth-first
+// order. Otherwise, the WalkFunc is called concurrently.
+func WithWalkConcurrency(n int) WalkOption {
+	return func(o *walkOptions) {
+		o.concurrency = n
+	}
+}
+
+// WithMaxDepth limits the walk to nodes at most depth links away from the
+// root. Negative values (the default) mean no limit.
+func WithMaxDepth(depth int) WalkOption {
+	return func(o *walkOptions) {
+		o.maxDepth = depth
+	}
+}
+
+// WithWalkFetchOptions sets the options used to fetch missing blocks.
+func WithWalkFetchOptions(opts ...FetchOption) WalkOption {
+	return func(o *walkOptions) {
+		o.fetch = append(o.fetch, opts...)
+	}
+}
+
+// Walk traverses the DAG below root, fetching missing blocks from the
+// network, and calls fn for every node. Nodes reachable through several
+// paths are only visited once. With WithMaxDepth, the links of a node are
+// followed as deep as its shortest path from the root allows.
+func (p *Peer) Walk(ctx context.Context, root cid.Cid, fn WalkFunc, opts ...WalkOption) error {
+	wopts := &walkOptions{
+		concurrency: 1,
+		maxDepth:    -1,
+	}
+	for _, o := range opts {
+		o(wopts)
+	}
+	if wopts.concurrency < 1 {
+		wopts.concurrency = 1
+	}
+
+	fopts := newFetchOptions(wopts.fetch)
+	ctx, cancel := p.fetchContext(ctx, fopts)
+	defer cancel()
+	release, err := p.scheduler.acquire(ctx, fopts.priority)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	g, ctx := errgroup.WithContext(ctx)
+	w := &walker{
+		ctx:  ctx,
+		ng:   p.fetchSession(ctx, root, fopts),
+		fn:   fn,
+		opts: wopts,
+		g:    g,
+		seen: make(map[string]int),
+	}
+	// Workers beyond the first one are spawned when there are free
+	// slots. Otherwise links are visited in the current goroutine.
+	g.SetLimit(wopts.concurrency - 1)
+	err = w.visit(root, "", 0)
+	if gErr := g.Wait(); err == nil {
+		err = gErr
+	}
+	return err
+}
+
+type walker struct {
+	ctx  context.Context
+	ng   ipld.NodeGetter
+	fn   WalkFunc
+	opts *walkOptions
+	g    *errgroup.Group
+
+	mu sync.Mutex
+	// seen holds the smallest depth each node was reached at, or
+	// skippedDepth when its links are skipped.
+	seen map[string]int
+}
+
+// skippedDepth marks the nodes whose links are never followed.
+const skippedDepth = -1
+
+func (w *walker) visit(c cid.Cid, path string, depth int) error {
+	w.mu.Lock()
+	prev, seen := w.seen[c.KeyString()]
+	if seen && (w.opts.maxDepth < 0 || prev <= depth) {
+		w.mu.Unlock()
+		return nil
+	}
+	w.seen[c.KeyString()] = depth
+	w.mu.Unlock()
+
+	if err := w.ctx.Err(); err != nil {
+		return err
+	}
+	n, err := w.ng.Get(w.ctx, c)
+	if err != nil {
+		return err
+	}
+	// A node reached again through a shorter path is not passed to fn
+	// again, only its links are followed further.
+	if !seen {
+		err = w.fn(n, path)
+		if errors.Is(err, SkipLinks) {
+			w.mu.Lock()
+			w.seen[c.KeyString()] = skippedDepth
+			w.mu.Unlock()
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if w.opts.maxDepth >= 0 && depth >= w.opts.maxDepth {
+		return nil
+	}
+
+	for i, l := range n.Links() {
+		name := l.Name
+		if name == "" {
+			name = strconv.Itoa(i)
+		}
+		childPath := name
+		if path != "" {
+			childPath = path + "/" + name
+		}
+		lc := l.Cid
+		ok := w.g.TryGo(func() error {
+			return w.visit(lc, childPath, depth+1)
+		})
+		if ok {
+			continue
+		}
+		if err := w.visit(lc, childPath, depth+1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,123 @@
+package ipfslite
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/ipfs/boxo/ipld/merkledag"
+	ipld "github.com/ipfs/go-ipld-format"
+)
+
+func TestWalk(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	p, err := New(ctx, NewInMemoryDatastore(), nil, nil, nil, &Config{Offline: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// root -> a -> c, root -> b -> c
+	c := merkledag.NodeWithData([]byte("c"))
+	a := merkledag.NodeWithData([]byte("a"))
+	b := merkledag.NodeWithData([]byte("b"))
+	root := merkledag.NodeWithData([]byte("root"))
+	for _, l := range []struct {
+		from *merkledag.ProtoNode
+		name string
+		to   *merkledag.ProtoNode
+	}{{a, "c", c}, {b, "c", c}, {root, "a", a}, {root, "b", b}} {
+		if err := l.from.AddNodeLink(l.name, l.to); err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = p.AddMany(ctx, []ipld.Node{c, a, b, root})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	walk := func(opts ...WalkOption) []string {
+		var mu sync.Mutex
+		var paths []string
+		err := p.Walk(ctx, root.Cid(), func(n ipld.Node, path string) error {
+			mu.Lock()
+			defer mu.Unlock()
+			paths = append(paths, path)
+			if path == "b" {
+				return SkipLinks
+			}
+			return nil
+		}, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sort.Strings(paths)
+		return paths
+	}
+
+	expect := func(got []string, want ...string) {
+		t.Helper()
+		if len(got) != len(want) {
+			t.Fatalf("expected paths %q, got %q", want, got)
+		}
+		for i := range want {
+			if got[i] != want[i] {
+				t.Fatalf("expected paths %q, got %q", want, got)
+			}
+		}
+	}
+
+	expect(walk(), "", "a", "a/c", "b")
+	expect(walk(WithWalkConcurrency(4)), "", "a", "a/c", "b")
+	expect(walk(WithMaxDepth(1)), "", "a", "b")
+	expect(walk(WithMaxDepth(0)), "")
+}
+
+func TestWalkMaxDepthShortestPath(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	p, err := New(ctx, NewInMemoryDatastore(), nil, nil, nil, &Config{Offline: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// root -> a -> x -> y, root -> x: x is first reached at depth 2,
+	// then at depth 1.
+	y := merkledag.NodeWithData([]byte("y"))
+	x := merkledag.NodeWithData([]byte("x"))
+	a := merkledag.NodeWithData([]byte("a"))
+	root := merkledag.NodeWithData([]byte("root"))
+	for _, l := range []struct {
+		from *merkledag.ProtoNode
+		name string
+		to   *merkledag.ProtoNode
+	}{{x, "y", y}, {a, "x", x}, {root, "a", a}, {root, "x", x}} {
+		if err := l.from.AddNodeLink(l.name, l.to); err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = p.AddMany(ctx, []ipld.Node{y, x, a, root})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var mu sync.Mutex
+	visits := make(map[string]int)
+	err = p.Walk(ctx, root.Cid(), func(n ipld.Node, path string) error {
+		mu.Lock()
+		defer mu.Unlock()
+		visits[n.Cid().String()]++
+		return nil
+	}, WithMaxDepth(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, n := range map[string]ipld.Node{"root": root, "a": a, "x": x, "y": y} {
+		if visits[n.Cid().String()] != 1 {
+			t.Errorf("%s visited %d times", name, visits[n.Cid().String()])
+		}
+	}
+}